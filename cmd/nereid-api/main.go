@@ -15,10 +15,12 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/yuiseki/NEREID/internal/mapstyle"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -137,6 +139,9 @@ func (s *server) handle(w http.ResponseWriter, r *http.Request) {
 	case (strings.HasPrefix(r.URL.Path, "/api/status/") || strings.HasPrefix(r.URL.Path, "/status/")) && r.Method == http.MethodGet:
 		s.handleStatus(w, r)
 		return
+	case strings.HasPrefix(r.URL.Path, "/api/works/") && strings.HasSuffix(r.URL.Path, "/cancel") && r.Method == http.MethodPost:
+		s.handleCancel(w, r)
+		return
 	case (r.URL.Path == "/api" || r.URL.Path == "/api/" || r.URL.Path == "/") && r.Method == http.MethodGet:
 		writeJSON(w, http.StatusOK, map[string]interface{}{
 			"ok":      true,
@@ -783,21 +788,80 @@ func (s *server) handleStatus(w http.ResponseWriter, r *http.Request) {
 	}
 
 	phase, _, _ := unstructured.NestedString(obj.Object, "status", "phase")
+	reason, _, _ := unstructured.NestedString(obj.Object, "status", "reason")
 	message, _, _ := unstructured.NestedString(obj.Object, "status", "message")
+	queue, _, _ := unstructured.NestedMap(obj.Object, "status", "queue")
 	artifactURLStatus, _, _ := unstructured.NestedString(obj.Object, "status", "artifactUrl")
 	if artifactURLStatus == "" {
 		artifactURLStatus = artifactURL(s.artifactBaseURL, workName)
 	}
 
-	writeJSON(w, http.StatusOK, map[string]interface{}{
+	resp := map[string]interface{}{
 		"name":        workName,
 		"namespace":   ns,
 		"phase":       phase,
+		"reason":      reason,
 		"message":     message,
 		"artifactUrl": artifactURLStatus,
+	}
+	// status.queue explains why a queued Work has not started yet.
+	if queue != nil {
+		resp["queue"] = queue
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *server) handleCancel(w http.ResponseWriter, r *http.Request) {
+	workName := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/works/"), "/cancel")
+	workName = strings.TrimSpace(workName)
+	if workName == "" || strings.Contains(workName, "/") {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "work name is required"})
+		return
+	}
+	ns := resolveNamespace(r.URL.Query().Get("namespace"), s.workNamespace)
+
+	obj, err := s.dynamic.Resource(workGVR).Namespace(ns).Get(r.Context(), workName, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			writeJSON(w, http.StatusNotFound, map[string]interface{}{"error": "work not found"})
+			return
+		}
+		writeJSON(w, http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
+		return
+	}
+	phase, _, _ := unstructured.NestedString(obj.Object, "status", "phase")
+	if isTerminalWorkPhase(phase) {
+		writeJSON(w, http.StatusConflict, map[string]interface{}{
+			"error": fmt.Sprintf("work %q already finished", workName),
+			"phase": phase,
+		})
+		return
+	}
+
+	patch := []byte(`{"spec":{"cancel":true}}`)
+	if _, err := s.dynamic.Resource(workGVR).Namespace(ns).Patch(r.Context(), workName, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]interface{}{"error": fmt.Sprintf("cancel work failed: %v", err)})
+		return
+	}
+	s.logger.Info("work cancel requested", "work", workName, "namespace", ns)
+
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{
+		"name":            workName,
+		"namespace":       ns,
+		"phase":           phase,
+		"cancelRequested": true,
 	})
 }
 
+func isTerminalWorkPhase(phase string) bool {
+	switch strings.TrimSpace(phase) {
+	case "Succeeded", "Failed", "Error", "Canceled", "Cancelled":
+		return true
+	default:
+		return false
+	}
+}
+
 func writeJSON(w http.ResponseWriter, status int, v interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Cache-Control", "no-store, no-cache, must-revalidate, max-age=0")
@@ -1348,6 +1412,11 @@ func validatePlannedSpec(spec map[string]interface{}) error {
 			if strings.TrimSpace(js) == "" {
 				return errors.New(`spec.style.sourceStyle.json is required when mode=inline`)
 			}
+			if validate, _ := style["validate"].(bool); validate {
+				if err := mapstyle.Check([]byte(js)); err != nil {
+					return fmt.Errorf("spec.style.sourceStyle.json: %w", err)
+				}
+			}
 		case "url":
 			u, _ := sourceStyle["url"].(string)
 			if strings.TrimSpace(u) == "" {
@@ -1,11 +1,21 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 
 	"github.com/google/uuid"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
 )
 
 func TestPlannerCredentialsFromEnvPrefersOpenAI(t *testing.T) {
@@ -94,6 +104,38 @@ func TestValidatePlannedSpecRejectsAgentCLIWithoutScriptOrCommand(t *testing.T)
 	}
 }
 
+func TestValidatePlannedSpecValidatesInlineStyle(t *testing.T) {
+	for _, line := range []string{"国の名前を青、川の名前を黄色にして", "日本から一番近い国はどこ"} {
+		plan, err := planWorkFromInstructionLine(line)
+		if err != nil {
+			t.Fatalf("planWorkFromInstructionLine(%q) error = %v", line, err)
+		}
+		if err := validatePlannedSpec(plan.spec); err != nil {
+			t.Fatalf("validatePlannedSpec(%q) error = %v", line, err)
+		}
+	}
+
+	spec := map[string]interface{}{
+		"kind":  "maplibre.style.v1",
+		"title": "broken",
+		"style": map[string]interface{}{
+			"sourceStyle": map[string]interface{}{
+				"mode": "inline",
+				"json": `{"version":8,"sources":{},"layers":[{"id":"roads","type":"line","source":"osm"}]}`,
+			},
+			"validate": true,
+		},
+	}
+	err := validatePlannedSpec(spec)
+	if err == nil || err.Error() != `spec.style.sourceStyle.json: layer "roads" references missing source "osm"` {
+		t.Fatalf("validatePlannedSpec() error = %v", err)
+	}
+	spec["style"].(map[string]interface{})["validate"] = false
+	if err := validatePlannedSpec(spec); err != nil {
+		t.Fatalf("validatePlannedSpec() without validate error = %v", err)
+	}
+}
+
 func TestNormalizePlannedSpecConvertsAgentCommandFromString(t *testing.T) {
 	spec := map[string]interface{}{
 		"kind":  "agent.cli.v1",
@@ -239,3 +281,76 @@ func TestGenerateWorkIDv7ReturnsErrorWhenGeneratorFails(t *testing.T) {
 		t.Fatal("generateWorkIDv7() expected error, got nil")
 	}
 }
+
+func TestHandleCancelSetsSpecCancel(t *testing.T) {
+	dc := newFakeWorkClient(sampleWork("running-work", "Running"))
+	s := &server{dynamic: dc, workNamespace: "nereid", logger: slog.Default()}
+
+	rec := httptest.NewRecorder()
+	s.handle(rec, httptest.NewRequest(http.MethodPost, "/api/works/running-work/cancel", nil))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status got=%d want=%d body=%s", rec.Code, http.StatusAccepted, rec.Body.String())
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode body: %v", err)
+	}
+	if body["cancelRequested"] != true {
+		t.Fatalf("cancelRequested got=%v", body["cancelRequested"])
+	}
+
+	obj, err := dc.Resource(workGVR).Namespace("nereid").Get(context.Background(), "running-work", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get work: %v", err)
+	}
+	cancel, _, _ := unstructured.NestedBool(obj.Object, "spec", "cancel")
+	if !cancel {
+		t.Fatal("spec.cancel should be true after cancel request")
+	}
+}
+
+func TestHandleCancelRejectsFinishedWork(t *testing.T) {
+	dc := newFakeWorkClient(sampleWork("done-work", "Succeeded"))
+	s := &server{dynamic: dc, workNamespace: "nereid", logger: slog.Default()}
+
+	rec := httptest.NewRecorder()
+	s.handle(rec, httptest.NewRequest(http.MethodPost, "/api/works/done-work/cancel", nil))
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("status got=%d want=%d body=%s", rec.Code, http.StatusConflict, rec.Body.String())
+	}
+}
+
+func TestHandleCancelReturnsNotFound(t *testing.T) {
+	s := &server{dynamic: newFakeWorkClient(), workNamespace: "nereid", logger: slog.Default()}
+
+	rec := httptest.NewRecorder()
+	s.handle(rec, httptest.NewRequest(http.MethodPost, "/api/works/missing/cancel", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status got=%d want=%d body=%s", rec.Code, http.StatusNotFound, rec.Body.String())
+	}
+}
+
+func newFakeWorkClient(objects ...runtime.Object) *dynamicfake.FakeDynamicClient {
+	return dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		workGVR:  "WorkList",
+		grantGVR: "GrantList",
+	}, objects...)
+}
+
+func sampleWork(name, phase string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "nereid.yuiseki.net/v1alpha1",
+		"kind":       "Work",
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": "nereid",
+		},
+		"spec": map[string]interface{}{
+			"kind":  "agent.cli.v1",
+			"title": name,
+		},
+		"status": map[string]interface{}{
+			"phase": phase,
+		},
+	}}
+}
@@ -2,44 +2,112 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/yuiseki/NEREID/internal/controller"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
 	"k8s.io/client-go/util/homedir"
 )
 
+type leaderElectionConfig struct {
+	Enabled       bool
+	LeaseName     string
+	Namespace     string
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+}
+
 func main() {
 	cfg := controller.Config{}
+	le := leaderElectionConfig{}
 	var resync time.Duration
+	var diskBudget string
 	var kubeconfig string
+	var metricsAddr string
+	var probeAddr string
 
 	flag.StringVar(&cfg.WorkNamespace, "work-namespace", "nereid", "Namespace containing Work resources. Use empty string for all namespaces.")
 	flag.StringVar(&cfg.JobNamespace, "job-namespace", "nereid-work", "Namespace where Jobs are created.")
 	flag.StringVar(&cfg.LocalQueueName, "local-queue-name", "nereid-localq", "Kueue LocalQueue name added to Job labels.")
 	flag.StringVar(&cfg.RuntimeClassName, "runtime-class-name", "gvisor", "runtimeClassName for Job Pods.")
 	flag.StringVar(&cfg.ArtifactsHostPath, "artifacts-host-path", "/var/lib/nereid/artifacts", "Host path mounted for artifacts.")
+	flag.StringVar(&cfg.ArtifactStorage, "artifact-storage", controller.ArtifactStorageHostPath, "Artifact storage backend: hostPath, pvc or s3.")
+	flag.StringVar(&cfg.ArtifactPVCName, "artifact-pvc", "", "PVC mounted into Job pods for pvc artifact storage. The controller must mount it at artifacts-host-path.")
+	flag.StringVar(&cfg.ArtifactS3.Endpoint, "artifact-s3-endpoint", "", "S3-compatible endpoint URL for s3 artifact storage.")
+	flag.StringVar(&cfg.ArtifactS3.Region, "artifact-s3-region", "us-east-1", "S3 region for s3 artifact storage.")
+	flag.StringVar(&cfg.ArtifactS3.Bucket, "artifact-s3-bucket", "", "S3 bucket for s3 artifact storage.")
+	flag.StringVar(&cfg.ArtifactS3.Prefix, "artifact-s3-prefix", "", "Key prefix for Work artifacts, e.g. works/.")
+	flag.StringVar(&cfg.ArtifactS3.CredentialsSecret, "artifact-s3-credentials-secret", "", "Secret in job-namespace with AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY for the uploader sidecar.")
+	flag.StringVar(&cfg.ArtifactS3.UploaderImage, "artifact-s3-uploader-image", "", "Image with the aws CLI used to restore and upload artifacts.")
 	flag.StringVar(&cfg.ArtifactBaseURL, "artifact-base-url", "http://nereid-artifacts.yuiseki.com", "Base URL used for Work.status.artifactUrl.")
-	flag.DurationVar(&cfg.ArtifactRetention, "artifact-retention", 30*24*time.Hour, "Retention window for entries under artifacts-host-path.")
-	flag.DurationVar(&resync, "resync-interval", 1*time.Second, "Reconcile interval.")
+	flag.DurationVar(&cfg.ArtifactRetention, "artifact-retention", 30*24*time.Hour, "Default retention window for Work artifacts; Works may override it with spec.artifacts.retention.")
+	flag.DurationVar(&cfg.FailedArtifactRetention, "failed-artifact-retention", 7*24*time.Hour, "Retention cap for artifacts of Failed and Error Works.")
+	flag.StringVar(&diskBudget, "artifact-disk-budget", "", "Total artifact size (e.g. 200Gi) above which the oldest unpinned Works are pruned. Empty disables the budget.")
+	flag.Int64Var(&cfg.ArtifactsFSGroup, "artifacts-fs-group", 1000, "fsGroup of Job pods; Work artifact directories are group-writable for it. Must be non-zero.")
+	flag.StringVar(&cfg.EgressDNSNamespace, "egress-dns-namespace", "kube-system", "Namespace of the cluster DNS pods that egress-restricted Jobs may query.")
+	flag.StringVar(&cfg.EgressDNSPodSelector, "egress-dns-pod-selector", "k8s-app=kube-dns", "Label selector of the cluster DNS pods that egress-restricted Jobs may query.")
+	flag.StringVar(&cfg.ArtifactArchiveDir, "artifact-archive-dir", "", "Directory that receives artifacts of deleted Works. Empty removes them.")
+	flag.StringVar(&cfg.SignatureRulesConfigMap, "signature-rules-configmap", "", "ConfigMap whose rules.yaml holds runtime error signature rules. Empty uses the built-in rules.")
+	flag.StringVar(&cfg.SignatureRulesNamespace, "signature-rules-namespace", os.Getenv("POD_NAMESPACE"), "Namespace of the signature rules ConfigMap. Defaults to $POD_NAMESPACE, then work-namespace.")
+	flag.DurationVar(&resync, "resync-interval", 5*time.Minute, "Safety resync interval; Works are otherwise reconciled from watch events.")
+	flag.IntVar(&cfg.Workers, "workers", 2, "Number of concurrent Work reconcile workers.")
+	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "Address the Prometheus /metrics endpoint listens on. Empty disables it.")
+	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "Address the /healthz and /readyz probe endpoints listen on. Empty disables them.")
 	flag.StringVar(&kubeconfig, "kubeconfig", "", "Path to kubeconfig file (for local execution).")
+	flag.BoolVar(&le.Enabled, "leader-elect", false, "Acquire a Lease before reconciling so that only one replica is active.")
+	flag.StringVar(&le.LeaseName, "leader-election-lease-name", "nereid-controller", "Name of the leader election Lease.")
+	flag.StringVar(&le.Namespace, "leader-election-namespace", os.Getenv("POD_NAMESPACE"), "Namespace of the leader election Lease. Defaults to $POD_NAMESPACE, then work-namespace.")
+	flag.DurationVar(&le.LeaseDuration, "leader-election-lease-duration", 15*time.Second, "Duration non-leader replicas wait before trying to take over the Lease.")
+	flag.DurationVar(&le.RenewDeadline, "leader-election-renew-deadline", 10*time.Second, "Duration the leader retries renewing the Lease before giving it up.")
+	flag.DurationVar(&le.RetryPeriod, "leader-election-retry-period", 2*time.Second, "Interval between Lease acquire/renew attempts.")
 	flag.Parse()
 
+	cfg.ArtifactS3.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+	cfg.ArtifactS3.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
 	if cfg.WorkNamespace == metav1.NamespaceAll {
 		cfg.WorkNamespace = ""
 	}
 	cfg.ResyncInterval = resync
+	if strings.TrimSpace(diskBudget) != "" {
+		q, err := resource.ParseQuantity(strings.TrimSpace(diskBudget))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, fmt.Errorf("invalid --artifact-disk-budget: %w", err))
+			os.Exit(2)
+		}
+		cfg.ArtifactDiskBudget = q.Value()
+	}
+	if cfg.ArtifactsFSGroup <= 0 {
+		fmt.Fprintln(os.Stderr, "invalid --artifacts-fs-group: must be a non-zero gid")
+		os.Exit(2)
+	}
+	if strings.TrimSpace(le.Namespace) == "" {
+		le.Namespace = cfg.WorkNamespace
+	}
+	if le.Enabled {
+		if err := le.validate(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
 
 	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}))
 
@@ -64,12 +132,149 @@ func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
-	if err := ctrl.Run(ctx); err != nil && err != context.Canceled {
+	if metricsAddr != "" {
+		// Standby replicas serve metrics too; their Work gauges stay empty
+		// until they acquire the Lease.
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", ctrl.MetricsHandler())
+		go serveHTTP(ctx, "metrics", metricsAddr, mux, logger)
+	}
+	if probeAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/healthz", ctrl.LivenessHandler())
+		mux.Handle("/readyz", ctrl.ReadinessHandler())
+		go serveHTTP(ctx, "health probes", probeAddr, mux, logger)
+	}
+
+	if !le.Enabled {
+		if err := ctrl.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := runWithLeaderElection(ctx, kc, le, logger, ctrl.Run); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 }
 
+func serveHTTP(ctx context.Context, name, addr string, handler http.Handler, logger *slog.Logger) {
+	srv := &http.Server{Addr: addr, Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+	logger.Info("serving "+name, "addr", addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Error(name+" server failed", "addr", addr, "error", err)
+	}
+}
+
+func (le leaderElectionConfig) validate() error {
+	if strings.TrimSpace(le.LeaseName) == "" {
+		return errors.New("--leader-election-lease-name is required when --leader-elect is set")
+	}
+	if strings.TrimSpace(le.Namespace) == "" {
+		return errors.New("--leader-election-namespace is required when --leader-elect is set and --work-namespace is empty")
+	}
+	if le.RetryPeriod <= 0 {
+		return errors.New("--leader-election-retry-period must be positive")
+	}
+	if le.RenewDeadline <= le.RetryPeriod {
+		return fmt.Errorf("--leader-election-renew-deadline (%s) must be greater than --leader-election-retry-period (%s)", le.RenewDeadline, le.RetryPeriod)
+	}
+	if le.LeaseDuration <= le.RenewDeadline {
+		return fmt.Errorf("--leader-election-lease-duration (%s) must be greater than --leader-election-renew-deadline (%s)", le.LeaseDuration, le.RenewDeadline)
+	}
+	return nil
+}
+
+// runWithLeaderElection blocks until ctx is canceled, running fn only while
+// this replica holds the Lease. On shutdown the Lease is released so a
+// standby replica can take over without waiting for it to expire.
+func runWithLeaderElection(ctx context.Context, kc kubernetes.Interface, le leaderElectionConfig, logger *slog.Logger, fn func(context.Context) error) error {
+	identity, err := leaderElectionIdentity()
+	if err != nil {
+		return err
+	}
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      le.LeaseName,
+			Namespace: le.Namespace,
+		},
+		Client: kc.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: identity,
+		},
+	}
+
+	electionCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	runErrCh := make(chan error, 1)
+	lostLease := false
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   le.LeaseDuration,
+		RenewDeadline:   le.RenewDeadline,
+		RetryPeriod:     le.RetryPeriod,
+		ReleaseOnCancel: true,
+		Name:            le.LeaseName,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(leaderCtx context.Context) {
+				logger.Info("acquired leader lease", "lease", le.LeaseName, "namespace", le.Namespace, "identity", identity)
+				if err := fn(leaderCtx); err != nil && !errors.Is(err, context.Canceled) {
+					runErrCh <- err
+					// Step down so the error surfaces and another replica can take over.
+					cancel()
+				}
+			},
+			OnStoppedLeading: func() {
+				if electionCtx.Err() != nil {
+					logger.Info("released leader lease", "lease", le.LeaseName, "namespace", le.Namespace, "identity", identity)
+					return
+				}
+				lostLease = true
+				logger.Error("lost leader lease", "lease", le.LeaseName, "namespace", le.Namespace, "identity", identity)
+			},
+			OnNewLeader: func(current string) {
+				if current != identity {
+					logger.Info("observed leader", "lease", le.LeaseName, "namespace", le.Namespace, "leader", current)
+				}
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("create leader elector: %w", err)
+	}
+
+	logger.Info("waiting for leader lease", "lease", le.LeaseName, "namespace", le.Namespace, "identity", identity)
+	elector.Run(electionCtx)
+
+	select {
+	case err := <-runErrCh:
+		return err
+	default:
+	}
+	if lostLease {
+		return fmt.Errorf("lost leader lease %s/%s", le.Namespace, le.LeaseName)
+	}
+	return nil
+}
+
+func leaderElectionIdentity() (string, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return "", fmt.Errorf("resolve hostname for leader election identity: %w", err)
+	}
+	return host + "_" + uuid.NewString(), nil
+}
+
 func buildRESTConfig(explicitPath string) (*rest.Config, error) {
 	if explicitPath != "" {
 		return clientcmd.BuildConfigFromFlags("", explicitPath)
@@ -1,9 +1,16 @@
 package main
 
 import (
+	"context"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
 func TestBuildRESTConfigExplicitPath(t *testing.T) {
@@ -39,6 +46,94 @@ func TestBuildRESTConfigExplicitPathInvalid(t *testing.T) {
 	}
 }
 
+func TestLeaderElectionConfigValidate(t *testing.T) {
+	valid := leaderElectionConfig{
+		Enabled:       true,
+		LeaseName:     "nereid-controller",
+		Namespace:     "nereid",
+		LeaseDuration: 15 * time.Second,
+		RenewDeadline: 10 * time.Second,
+		RetryPeriod:   2 * time.Second,
+	}
+	if err := valid.validate(); err != nil {
+		t.Fatalf("validate(valid) error = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(*leaderElectionConfig)
+		want   string
+	}{
+		{name: "missing lease name", mutate: func(c *leaderElectionConfig) { c.LeaseName = "" }, want: "lease-name"},
+		{name: "missing namespace", mutate: func(c *leaderElectionConfig) { c.Namespace = "" }, want: "leader-election-namespace"},
+		{name: "renew deadline not above retry", mutate: func(c *leaderElectionConfig) { c.RenewDeadline = 2 * time.Second }, want: "renew-deadline"},
+		{name: "lease duration not above renew", mutate: func(c *leaderElectionConfig) { c.LeaseDuration = 10 * time.Second }, want: "lease-duration"},
+	}
+	for _, tt := range tests {
+		cfg := valid
+		tt.mutate(&cfg)
+		err := cfg.validate()
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Fatalf("%s: validate() err=%v want contains %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestRunWithLeaderElectionReleasesLeaseOnShutdown(t *testing.T) {
+	kc := fake.NewSimpleClientset()
+	le := leaderElectionConfig{
+		Enabled:       true,
+		LeaseName:     "nereid-controller",
+		Namespace:     "nereid",
+		LeaseDuration: 3 * time.Second,
+		RenewDeadline: 2 * time.Second,
+		RetryPeriod:   100 * time.Millisecond,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	started := make(chan struct{})
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- runWithLeaderElection(ctx, kc, le, slog.Default(), func(leaderCtx context.Context) error {
+			close(started)
+			<-leaderCtx.Done()
+			return leaderCtx.Err()
+		})
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for leadership")
+	}
+	lease, err := kc.CoordinationV1().Leases("nereid").Get(context.Background(), "nereid-controller", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get lease: %v", err)
+	}
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity == "" {
+		t.Fatalf("lease holder not set: %+v", lease.Spec)
+	}
+
+	cancel()
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("runWithLeaderElection() error = %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for shutdown")
+	}
+
+	lease, err = kc.CoordinationV1().Leases("nereid").Get(context.Background(), "nereid-controller", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get lease after shutdown: %v", err)
+	}
+	if lease.Spec.HolderIdentity != nil && *lease.Spec.HolderIdentity != "" {
+		t.Fatalf("lease should be released on shutdown, holder=%q", *lease.Spec.HolderIdentity)
+	}
+}
+
 func writeKubeconfig(t *testing.T, server string) string {
 	t.Helper()
 
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/yuiseki/NEREID/internal/runner"
+)
+
+func main() {
+	var specPath, terminationLog string
+	r := runner.Runner{}
+
+	flag.StringVar(&specPath, "spec", "", "Path to the Work spec as JSON.")
+	flag.StringVar(&r.OutDir, "out", os.Getenv("NEREID_ARTIFACT_DIR"), "Directory that receives the artifacts. Defaults to $NEREID_ARTIFACT_DIR.")
+	flag.IntVar(&r.MaxAttempts, "max-attempts", 4, "Attempts for each remote fetch before giving up.")
+	flag.DurationVar(&r.Backoff, "backoff", 2*time.Second, "Initial delay between fetch attempts; doubles per attempt.")
+	flag.StringVar(&r.DuckDB, "duckdb", "duckdb", "duckdb CLI used by duckdb.map.v1.")
+	flag.StringVar(&terminationLog, "termination-log", "/dev/termination-log", "File that receives the failure message so it shows up in Work.status.message. Empty disables it.")
+	flag.Parse()
+
+	if specPath == "" {
+		fmt.Fprintln(os.Stderr, "--spec is required")
+		os.Exit(2)
+	}
+	spec, err := runner.ReadSpec(specPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, fmt.Errorf("read spec: %w", err))
+		os.Exit(2)
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	r.Logger = logger
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := r.Run(ctx, spec); err != nil {
+		logger.Error("native executor failed", "kind", spec.Kind, "error", err)
+		writeTerminationMessage(terminationLog, err)
+		os.Exit(1)
+	}
+	logger.Info("native executor finished", "kind", spec.Kind, "out", r.OutDir)
+}
+
+// writeTerminationMessage records err where the kubelet picks it up as the
+// container's termination message. Kubernetes keeps at most 4096 bytes.
+func writeTerminationMessage(path string, err error) {
+	if path == "" {
+		return
+	}
+	msg := err.Error()
+	if len(msg) > 4000 {
+		msg = msg[:4000] + "..."
+	}
+	_ = os.WriteFile(path, []byte(msg), 0o644)
+}
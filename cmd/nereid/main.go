@@ -15,6 +15,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/yuiseki/NEREID/internal/mapstyle"
 	"sigs.k8s.io/yaml"
 )
 
@@ -815,6 +816,11 @@ func validatePlannedSpec(spec map[string]interface{}) error {
 			if strings.TrimSpace(js) == "" {
 				return errors.New(`spec.style.sourceStyle.json is required when mode=inline`)
 			}
+			if validate, _ := style["validate"].(bool); validate {
+				if err := mapstyle.Check([]byte(js)); err != nil {
+					return fmt.Errorf("spec.style.sourceStyle.json: %w", err)
+				}
+			}
 		case "url":
 			u, _ := sourceStyle["url"].(string)
 			if strings.TrimSpace(u) == "" {
@@ -0,0 +1,480 @@
+package controller
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	ArtifactStorageHostPath = "hostPath"
+	ArtifactStoragePVC      = "pvc"
+	ArtifactStorageS3       = "s3"
+
+	defaultS3UploaderImage = "amazon/aws-cli:2.22.35"
+	s3SyncIntervalSeconds  = 15
+)
+
+type S3Config struct {
+	Endpoint        string
+	Region          string
+	Bucket          string
+	Prefix          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// CredentialsSecret names a Secret in the job namespace with
+	// AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY for the uploader.
+	CredentialsSecret string
+	UploaderImage     string
+}
+
+// artifactStore is where Work artifacts live. Every backend exposes the Work's
+// directory to the Job pod at workArtifactDir(workName).
+type artifactStore interface {
+	mountJob(job *batchv1.Job, workName string)
+	// readFile and open return an error wrapping fs.ErrNotExist for missing
+	// files.
+	readFile(ctx context.Context, workName, name string) ([]byte, error)
+	open(ctx context.Context, workName, name string) (io.ReadCloser, error)
+	writeFile(ctx context.Context, workName, name string, data []byte) error
+	// files lists the Work's files by slash-separated relative path.
+	files(ctx context.Context, workName string) ([]artifactFile, error)
+	list(ctx context.Context) ([]artifactEntry, error)
+	remove(ctx context.Context, workName string) error
+	// localDir is the Work's directory on the controller's filesystem, for
+	// backends that have one.
+	localDir(workName string) (string, bool)
+}
+
+type artifactFile struct {
+	name string
+	size int64
+}
+
+type artifactEntry struct {
+	workName string
+	modTime  time.Time
+	size     int64
+}
+
+func newArtifactStore(cfg Config) (artifactStore, error) {
+	root := strings.TrimSpace(cfg.ArtifactsHostPath)
+	switch strings.TrimSpace(cfg.ArtifactStorage) {
+	case "", ArtifactStorageHostPath:
+		hostPathType := corev1.HostPathDirectory
+		return &localArtifactStore{root: root, volume: corev1.VolumeSource{
+			HostPath: &corev1.HostPathVolumeSource{Path: root, Type: &hostPathType},
+		}}, nil
+	case ArtifactStoragePVC:
+		claim := strings.TrimSpace(cfg.ArtifactPVCName)
+		if claim == "" {
+			return nil, fmt.Errorf("artifact storage %q requires a PVC name", ArtifactStoragePVC)
+		}
+		// The controller mounts the same claim at ArtifactsHostPath.
+		return &localArtifactStore{root: root, volume: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claim},
+		}}, nil
+	case ArtifactStorageS3:
+		client, err := newS3Client(cfg.ArtifactS3)
+		if err != nil {
+			return nil, err
+		}
+		return &s3ArtifactStore{client: client, cfg: cfg.ArtifactS3}, nil
+	default:
+		return nil, fmt.Errorf("unsupported artifact storage %q", cfg.ArtifactStorage)
+	}
+}
+
+// artifacts returns the configured store. Run sets it up; a Controller built
+// without Run falls back to the hostPath store.
+func (c *Controller) artifacts() artifactStore {
+	if c.store != nil {
+		return c.store
+	}
+	store, _ := newArtifactStore(Config{ArtifactsHostPath: c.cfg.ArtifactsHostPath})
+	return store
+}
+
+// localArtifactStore keeps artifacts on a filesystem shared by the controller
+// and the Job pods: a node hostPath or a ReadWriteMany PVC.
+type localArtifactStore struct {
+	root   string
+	volume corev1.VolumeSource
+}
+
+func (s *localArtifactStore) mountJob(job *batchv1.Job, workName string) {
+	spec := &job.Spec.Template.Spec
+	spec.Volumes = append(spec.Volumes, corev1.Volume{Name: "artifacts", VolumeSource: s.volume})
+	// Only the Work's own directory is visible to the pod.
+	spec.Containers[0].VolumeMounts = append(spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+		Name:      "artifacts",
+		MountPath: workArtifactDir(workName),
+		SubPath:   workName,
+	})
+}
+
+func (s *localArtifactStore) readFile(_ context.Context, workName, name string) ([]byte, error) {
+	root, err := s.openWorkRoot(workName, false)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+	name = filepath.FromSlash(name)
+	info, err := root.Lstat(name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, nil
+	}
+	f, err := openRegular(root, name, info)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func (s *localArtifactStore) open(_ context.Context, workName, name string) (io.ReadCloser, error) {
+	root, err := s.openWorkRoot(workName, false)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+	name = filepath.FromSlash(name)
+	info, err := root.Lstat(name)
+	if err != nil {
+		return nil, err
+	}
+	return openRegular(root, name, info)
+}
+
+// writeFile replaces name through a randomly named temp file. The Work's
+// directory is writable by the Job pod, so nothing is written through a
+// symlink or outside the directory.
+func (s *localArtifactStore) writeFile(_ context.Context, workName, name string, data []byte) error {
+	root, err := s.openWorkRoot(workName, true)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+	name = filepath.FromSlash(name)
+	dir := filepath.Dir(name)
+	if err := root.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, "."+filepath.Base(name)+"."+rand.Text()+".tmp")
+	f, err := root.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = root.Rename(tmp, name)
+	}
+	if err != nil {
+		_ = root.Remove(tmp)
+	}
+	return err
+}
+
+// openWorkRoot opens the Work's directory as an os.Root, so symlinks and
+// ".." written by the Job pod cannot lead the controller outside of it.
+func (s *localArtifactStore) openWorkRoot(workName string, create bool) (*os.Root, error) {
+	if s.root == "" {
+		return nil, fs.ErrNotExist
+	}
+	top, err := os.OpenRoot(s.root)
+	if err != nil {
+		return nil, err
+	}
+	defer top.Close()
+	if create {
+		if err := top.MkdirAll(workName, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	return top.OpenRoot(workName)
+}
+
+// openRegular opens the file lstat'ed as info and refuses symlinks, FIFOs,
+// devices and files swapped in between.
+func openRegular(root *os.Root, name string, info fs.FileInfo) (*os.File, error) {
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", name)
+	}
+	f, err := root.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if got, err := f.Stat(); err != nil || !os.SameFile(info, got) {
+		f.Close()
+		return nil, fmt.Errorf("%s changed while it was opened", name)
+	}
+	return f, nil
+}
+
+func (s *localArtifactStore) files(_ context.Context, workName string) ([]artifactFile, error) {
+	if s.root == "" {
+		return nil, nil
+	}
+	dir := filepath.Join(s.root, workName)
+	var out []artifactFile
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		out = append(out, artifactFile{name: filepath.ToSlash(rel), size: info.Size()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walk artifacts of %q: %w", workName, err)
+	}
+	return out, nil
+}
+
+func (s *localArtifactStore) list(context.Context) ([]artifactEntry, error) {
+	if s.root == "" {
+		return nil, nil
+	}
+	entries, err := os.ReadDir(s.root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read artifacts root %q: %w", s.root, err)
+	}
+	out := make([]artifactEntry, 0, len(entries))
+	for _, entry := range entries {
+		info, infoErr := entry.Info()
+		if infoErr != nil {
+			continue
+		}
+		size := info.Size()
+		if entry.IsDir() {
+			size = dirSize(filepath.Join(s.root, entry.Name()))
+		}
+		out = append(out, artifactEntry{workName: entry.Name(), modTime: info.ModTime(), size: size})
+	}
+	return out, nil
+}
+
+// dirSize is the total size of the regular files under dir. Unreadable
+// entries are skipped.
+func dirSize(dir string) int64 {
+	var total int64
+	_ = filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		if info, infoErr := d.Info(); infoErr == nil {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total
+}
+
+func (s *localArtifactStore) remove(_ context.Context, workName string) error {
+	if s.root == "" {
+		return nil
+	}
+	return os.RemoveAll(filepath.Join(s.root, workName))
+}
+
+func (s *localArtifactStore) localDir(workName string) (string, bool) {
+	if s.root == "" {
+		return "", false
+	}
+	return filepath.Join(s.root, workName), true
+}
+
+// s3ArtifactStore keeps artifacts under <prefix><work>/ in a bucket. The Job
+// pod writes to an emptyDir that is restored from the bucket before the task
+// starts and synced back by an uploader sidecar, periodically and once more
+// when the task exits.
+type s3ArtifactStore struct {
+	client *s3Client
+	cfg    S3Config
+}
+
+func (s *s3ArtifactStore) key(workName string) string {
+	return s.cfg.Prefix + workName + "/"
+}
+
+func (s *s3ArtifactStore) mountJob(job *batchv1.Job, workName string) {
+	dir := workArtifactDir(workName)
+	target := fmt.Sprintf("s3://%s/%s", s.client.bucket, s.key(workName))
+	image := strings.TrimSpace(s.cfg.UploaderImage)
+	if image == "" {
+		image = defaultS3UploaderImage
+	}
+	env := []corev1.EnvVar{
+		{Name: "AWS_DEFAULT_REGION", Value: s.client.region},
+		{Name: "NEREID_S3_ENDPOINT", Value: s.client.endpoint.String()},
+		{Name: "NEREID_S3_TARGET", Value: target},
+		{Name: "NEREID_ARTIFACT_DIR", Value: dir},
+	}
+	if secret := strings.TrimSpace(s.cfg.CredentialsSecret); secret != "" {
+		for _, key := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY"} {
+			env = append(env, corev1.EnvVar{Name: key, ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: secret},
+					Key:                  key,
+				},
+			}})
+		}
+	}
+	mounts := []corev1.VolumeMount{{Name: "artifacts", MountPath: dir}}
+	resources := corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    mustParseQuantity("50m"),
+			corev1.ResourceMemory: mustParseQuantity("64Mi"),
+		},
+		Limits: corev1.ResourceList{
+			corev1.ResourceCPU:    mustParseQuantity("200m"),
+			corev1.ResourceMemory: mustParseQuantity("256Mi"),
+		},
+	}
+	always := corev1.ContainerRestartPolicyAlways
+
+	spec := &job.Spec.Template.Spec
+	spec.Volumes = append(spec.Volumes, corev1.Volume{
+		Name:         "artifacts",
+		VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+	})
+	spec.Containers[0].VolumeMounts = append(spec.Containers[0].VolumeMounts, mounts[0])
+	spec.InitContainers = append(spec.InitContainers,
+		corev1.Container{
+			Name:         "artifact-restore",
+			Image:        image,
+			Command:      []string{"sh", "-c"},
+			Args:         []string{`aws s3 sync "${NEREID_S3_TARGET}" "${NEREID_ARTIFACT_DIR}" --endpoint-url "${NEREID_S3_ENDPOINT}" --only-show-errors || true`},
+			Env:          env,
+			VolumeMounts: mounts,
+			Resources:    resources,
+		},
+		corev1.Container{
+			Name:          "artifact-uploader",
+			Image:         image,
+			Command:       []string{"sh", "-c"},
+			Args:          []string{s3UploaderScript},
+			Env:           env,
+			VolumeMounts:  mounts,
+			Resources:     resources,
+			RestartPolicy: &always,
+		},
+	)
+}
+
+// s3UploaderScript never follows symlinks: the uploader holds the S3
+// credentials, and a link to /proc/self/environ would publish them.
+var s3UploaderScript = fmt.Sprintf(`upload() {
+  aws s3 sync "${NEREID_ARTIFACT_DIR}" "${NEREID_S3_TARGET}" --endpoint-url "${NEREID_S3_ENDPOINT}" --no-follow-symlinks --only-show-errors
+}
+trap 'upload; exit 0' TERM INT
+while true; do
+  sleep %d &
+  wait $!
+  upload || true
+done
+`, s3SyncIntervalSeconds)
+
+func (s *s3ArtifactStore) readFile(ctx context.Context, workName, name string) ([]byte, error) {
+	return s.client.getObject(ctx, s.key(workName)+path.Clean(name))
+}
+
+func (s *s3ArtifactStore) open(ctx context.Context, workName, name string) (io.ReadCloser, error) {
+	return s.client.openObject(ctx, s.key(workName)+path.Clean(name))
+}
+
+func (s *s3ArtifactStore) writeFile(ctx context.Context, workName, name string, data []byte) error {
+	return s.client.putObject(ctx, s.key(workName)+path.Clean(name), data, artifactContentType(name, data))
+}
+
+func (s *s3ArtifactStore) files(ctx context.Context, workName string) ([]artifactFile, error) {
+	prefix := s.key(workName)
+	objects, err := s.client.listObjects(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]artifactFile, 0, len(objects))
+	for _, obj := range objects {
+		if name := strings.TrimPrefix(obj.key, prefix); name != "" && !strings.HasSuffix(name, "/") {
+			out = append(out, artifactFile{name: name, size: obj.size})
+		}
+	}
+	return out, nil
+}
+
+func (s *s3ArtifactStore) list(ctx context.Context) ([]artifactEntry, error) {
+	objects, err := s.client.listObjects(ctx, s.cfg.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	byWork := map[string]*artifactEntry{}
+	var order []string
+	for _, obj := range objects {
+		rest := strings.TrimPrefix(obj.key, s.cfg.Prefix)
+		workName, _, ok := strings.Cut(rest, "/")
+		if !ok || workName == "" {
+			continue
+		}
+		e := byWork[workName]
+		if e == nil {
+			e = &artifactEntry{workName: workName}
+			byWork[workName] = e
+			order = append(order, workName)
+		}
+		e.size += obj.size
+		if obj.lastModified.After(e.modTime) {
+			e.modTime = obj.lastModified
+		}
+	}
+	out := make([]artifactEntry, 0, len(order))
+	for _, name := range order {
+		out = append(out, *byWork[name])
+	}
+	return out, nil
+}
+
+func (s *s3ArtifactStore) remove(ctx context.Context, workName string) error {
+	objects, err := s.client.listObjects(ctx, s.key(workName))
+	if err != nil {
+		return err
+	}
+	for _, obj := range objects {
+		if err := s.client.deleteObject(ctx, obj.key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *s3ArtifactStore) localDir(string) (string, bool) {
+	return "", false
+}
@@ -0,0 +1,228 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	cleanupFinalizer = "nereid.yuiseki.net/cleanup"
+
+	// keepArtifactsAnnotationKey set to "true" leaves the artifact directory in
+	// place when the Work is deleted.
+	keepArtifactsAnnotationKey = "nereid.yuiseki.net/keep-artifacts"
+)
+
+func hasFinalizer(obj *unstructured.Unstructured, name string) bool {
+	for _, f := range obj.GetFinalizers() {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Controller) ensureCleanupFinalizer(ctx context.Context, work *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	if hasFinalizer(work, cleanupFinalizer) {
+		return work, nil
+	}
+	updated := work.DeepCopy()
+	updated.SetFinalizers(append(updated.GetFinalizers(), cleanupFinalizer))
+	out, err := c.dynamic.Resource(workGVR).Namespace(work.GetNamespace()).Update(ctx, updated, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("add finalizer to work %s/%s: %w", work.GetNamespace(), work.GetName(), err)
+	}
+	return out, nil
+}
+
+// finalizeWork runs when a Work with the cleanup finalizer is being deleted.
+// Jobs live in the job namespace and cannot be garbage collected through an
+// ownerRef, so they are deleted here first (pods follow via foreground
+// propagation). Once no Job is left, the artifact directory is removed or
+// archived and the finalizer is dropped.
+func (c *Controller) finalizeWork(ctx context.Context, work *unstructured.Unstructured) error {
+	if !hasFinalizer(work, cleanupFinalizer) {
+		return nil
+	}
+
+	jobs, err := c.kube.BatchV1().Jobs(c.cfg.JobNamespace).List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", workLabelKey, work.GetName()),
+	})
+	if err != nil {
+		return fmt.Errorf("list jobs for deleted work %s/%s: %w", work.GetNamespace(), work.GetName(), err)
+	}
+	remaining := 0
+	for i := range jobs.Items {
+		job := &jobs.Items[i]
+		if ns := job.Annotations[workNamespaceAnnotationKey]; ns != "" && ns != work.GetNamespace() {
+			continue
+		}
+		remaining++
+		if job.DeletionTimestamp != nil {
+			continue
+		}
+		if err := c.recordGrantJobUsage(ctx, work.GetNamespace(), job.Name); err != nil {
+			return err
+		}
+		propagation := metav1.DeletePropagationForeground
+		delErr := c.kube.BatchV1().Jobs(c.cfg.JobNamespace).Delete(ctx, job.Name, metav1.DeleteOptions{PropagationPolicy: &propagation})
+		if delErr != nil && !apierrors.IsNotFound(delErr) {
+			return fmt.Errorf("delete job %s/%s for deleted work: %w", c.cfg.JobNamespace, job.Name, delErr)
+		}
+		c.logger.Info("deleting job for deleted work",
+			"work", work.GetName(),
+			"workNamespace", work.GetNamespace(),
+			"job", job.Name,
+			"jobNamespace", c.cfg.JobNamespace,
+		)
+	}
+	if remaining > 0 {
+		// Job delete events requeue the Work once the pods are gone.
+		return nil
+	}
+
+	if grantName, _, _ := unstructured.NestedString(work.Object, "spec", "grantRef", "name"); strings.TrimSpace(grantName) != "" {
+		c.releaseGrantSlot(ctx, work.GetNamespace(), strings.TrimSpace(grantName))
+	}
+	if err := c.cleanupArtifacts(ctx, work); err != nil {
+		return err
+	}
+	return c.removeCleanupFinalizer(ctx, work)
+}
+
+func (c *Controller) cleanupArtifacts(ctx context.Context, work *unstructured.Unstructured) error {
+	store := c.artifacts()
+	workDir, local := store.localDir(work.GetName())
+	if strings.EqualFold(strings.TrimSpace(work.GetAnnotations()[keepArtifactsAnnotationKey]), "true") {
+		c.logger.Info("keeping artifacts for deleted work", "work", work.GetName(), "path", workDir)
+		return nil
+	}
+	if !local {
+		if err := store.remove(ctx, work.GetName()); err != nil {
+			return fmt.Errorf("remove artifacts for deleted work %q: %w", work.GetName(), err)
+		}
+		c.logger.Info("removed artifacts for deleted work", "work", work.GetName())
+		return nil
+	}
+	if _, err := os.Stat(workDir); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("stat artifacts for deleted work %q: %w", work.GetName(), err)
+	}
+
+	if archiveDir := strings.TrimSpace(c.cfg.ArtifactArchiveDir); archiveDir != "" {
+		if err := os.MkdirAll(archiveDir, 0o755); err != nil {
+			return fmt.Errorf("create artifact archive dir %q: %w", archiveDir, err)
+		}
+		dest := filepath.Join(archiveDir, work.GetName()+"-"+c.nowFunc().UTC().Format("20060102T150405Z"))
+		if err := moveDir(workDir, dest); err != nil {
+			return fmt.Errorf("archive artifacts for deleted work %q: %w", work.GetName(), err)
+		}
+		c.logger.Info("archived artifacts for deleted work", "work", work.GetName(), "path", dest)
+		return nil
+	}
+
+	if err := store.remove(ctx, work.GetName()); err != nil {
+		return fmt.Errorf("remove artifacts for deleted work %q: %w", work.GetName(), err)
+	}
+	c.logger.Info("removed artifacts for deleted work", "work", work.GetName(), "path", workDir)
+	return nil
+}
+
+// moveDir renames src to dst, falling back to copy and remove when they are on
+// different mounts.
+func moveDir(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm()|0o700)
+		case info.Mode().IsRegular():
+			return copyFile(path, target, info.Mode().Perm())
+		default:
+			// Symlinks and special files are not meaningful outside the work dir.
+			return nil
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(src)
+}
+
+func copyFile(src, dst string, perm fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func (c *Controller) removeCleanupFinalizer(ctx context.Context, work *unstructured.Unstructured) error {
+	latest := work.DeepCopy()
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		if latest == nil {
+			obj, err := c.dynamic.Resource(workGVR).Namespace(work.GetNamespace()).Get(ctx, work.GetName(), metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			latest = obj
+		}
+		kept := make([]string, 0, len(latest.GetFinalizers()))
+		for _, f := range latest.GetFinalizers() {
+			if f != cleanupFinalizer {
+				kept = append(kept, f)
+			}
+		}
+		if len(kept) == len(latest.GetFinalizers()) {
+			return nil
+		}
+		updated := latest.DeepCopy()
+		updated.SetFinalizers(kept)
+		_, err := c.dynamic.Resource(workGVR).Namespace(work.GetNamespace()).Update(ctx, updated, metav1.UpdateOptions{})
+		if apierrors.IsConflict(err) {
+			latest = nil
+		}
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	})
+}
@@ -0,0 +1,195 @@
+package controller
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestFinalizeWorkDeletesJobsThenArtifacts(t *testing.T) {
+	root := t.TempDir()
+	workDir := filepath.Join(root, "gone")
+	if err := os.MkdirAll(workDir, 0o755); err != nil {
+		t.Fatalf("mkdir work dir: %v", err)
+	}
+
+	work := deletedWork("gone", nil)
+	dc := newFakeDynamicClient(work)
+	kc := fake.NewSimpleClientset(
+		&batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "work-gone", Namespace: "nereid-work", Labels: map[string]string{workLabelKey: "gone"}}},
+		&batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "work-gone-a2", Namespace: "nereid-work", Labels: map[string]string{workLabelKey: "gone"}}},
+		&batchv1.Job{ObjectMeta: metav1.ObjectMeta{
+			Name:        "work-gone-other",
+			Namespace:   "nereid-work",
+			Labels:      map[string]string{workLabelKey: "gone"},
+			Annotations: map[string]string{workNamespaceAnnotationKey: "other"},
+		}},
+	)
+	c := &Controller{
+		dynamic: dc,
+		kube:    kc,
+		cfg:     Config{JobNamespace: "nereid-work", ArtifactsHostPath: root},
+		logger:  slog.Default(),
+		nowFunc: time.Now,
+	}
+
+	ctx := context.Background()
+	if err := c.finalizeWork(ctx, work); err != nil {
+		t.Fatalf("finalizeWork(first) error = %v", err)
+	}
+	jobs, err := kc.BatchV1().Jobs("nereid-work").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("list jobs: %v", err)
+	}
+	if len(jobs.Items) != 1 || jobs.Items[0].Name != "work-gone-other" {
+		t.Fatalf("only the other namespace's job should remain, got %d jobs", len(jobs.Items))
+	}
+	if _, err := os.Stat(workDir); err != nil {
+		t.Fatalf("artifacts must stay until jobs are gone: %v", err)
+	}
+
+	if err := c.finalizeWork(ctx, work); err != nil {
+		t.Fatalf("finalizeWork(second) error = %v", err)
+	}
+	if _, err := os.Stat(workDir); !os.IsNotExist(err) {
+		t.Fatalf("artifact dir should be removed, stat err=%v", err)
+	}
+	latest, err := dc.Resource(workGVR).Namespace("nereid").Get(ctx, "gone", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get work: %v", err)
+	}
+	if hasFinalizer(latest, cleanupFinalizer) {
+		t.Fatalf("cleanup finalizer should be removed, got %v", latest.GetFinalizers())
+	}
+}
+
+func TestFinalizeWorkKeepsOrArchivesArtifacts(t *testing.T) {
+	root := t.TempDir()
+	archive := filepath.Join(t.TempDir(), "archive")
+	for _, name := range []string{"kept", "archived"} {
+		if err := os.MkdirAll(filepath.Join(root, name), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(root, name, "index.html"), []byte("ok"), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	kept := deletedWork("kept", map[string]string{keepArtifactsAnnotationKey: "true"})
+	archived := deletedWork("archived", nil)
+	now := time.Date(2026, 2, 15, 12, 0, 0, 0, time.UTC)
+	c := &Controller{
+		dynamic: newFakeDynamicClient(kept, archived),
+		kube:    fake.NewSimpleClientset(),
+		cfg:     Config{JobNamespace: "nereid-work", ArtifactsHostPath: root, ArtifactArchiveDir: archive},
+		logger:  slog.Default(),
+		nowFunc: func() time.Time { return now },
+	}
+
+	ctx := context.Background()
+	for _, w := range []*unstructured.Unstructured{kept, archived} {
+		if err := c.finalizeWork(ctx, w); err != nil {
+			t.Fatalf("finalizeWork(%s) error = %v", w.GetName(), err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "kept", "index.html")); err != nil {
+		t.Fatalf("annotated work should keep artifacts: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "archived")); !os.IsNotExist(err) {
+		t.Fatalf("archived work dir should leave the artifacts root, stat err=%v", err)
+	}
+	if _, err := os.Stat(filepath.Join(archive, "archived-20260215T120000Z", "index.html")); err != nil {
+		t.Fatalf("archived artifacts missing: %v", err)
+	}
+}
+
+func TestBuildScriptJobSetsOwnerOnlyInWorkNamespace(t *testing.T) {
+	work := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "nereid.yuiseki.net/v1alpha1",
+		"kind":       "Work",
+		"metadata":   map[string]interface{}{"name": "owned", "namespace": "nereid", "uid": "1234"},
+	}}
+	c := &Controller{cfg: Config{JobNamespace: "nereid-work"}}
+	if job := c.buildScriptJob(work, "work-owned", "busybox", "true"); len(job.OwnerReferences) != 0 {
+		t.Fatalf("cross-namespace job must not have ownerRefs: %#v", job.OwnerReferences)
+	}
+
+	c.cfg.JobNamespace = "nereid"
+	job := c.buildScriptJob(work, "work-owned", "busybox", "true")
+	if len(job.OwnerReferences) != 1 || job.OwnerReferences[0].UID != "1234" || job.OwnerReferences[0].Kind != "Work" {
+		t.Fatalf("same-namespace job ownerRef mismatch: %#v", job.OwnerReferences)
+	}
+}
+
+func deletedWork(name string, annotations map[string]string) *unstructured.Unstructured {
+	work := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "nereid.yuiseki.net/v1alpha1",
+		"kind":       "Work",
+		"metadata":   map[string]interface{}{"name": name, "namespace": "nereid"},
+		"spec":       map[string]interface{}{"kind": "agent.cli.v1", "title": name},
+	}}
+	work.SetFinalizers([]string{cleanupFinalizer})
+	work.SetAnnotations(annotations)
+	deletedAt := metav1.NewTime(time.Date(2026, 2, 15, 11, 0, 0, 0, time.UTC))
+	work.SetDeletionTimestamp(&deletedAt)
+	return work
+}
+
+func TestSyncWorkAddsCleanupFinalizerOnlyToUnfinishedWorks(t *testing.T) {
+	newWork := func(name, phase string) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "nereid.yuiseki.net/v1alpha1",
+			"kind":       "Work",
+			"metadata":   map[string]interface{}{"name": name, "namespace": "nereid"},
+			"spec":       map[string]interface{}{"kind": "agent.cli.v1"},
+			"status":     map[string]interface{}{"phase": phase, "artifacts": map[string]interface{}{}},
+		}}
+	}
+	finished := newWork("finished", "Succeeded")
+	running := newWork("running", "Running")
+	dc := newFakeDynamicClient(finished, running)
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, w := range []*unstructured.Unstructured{finished, running} {
+		if err := indexer.Add(w); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := &Controller{
+		dynamic:     dc,
+		kube:        fake.NewSimpleClientset(),
+		cfg:         Config{JobNamespace: "nereid-work"},
+		logger:      slog.Default(),
+		nowFunc:     time.Now,
+		workIndexer: indexer,
+	}
+
+	ctx := context.Background()
+	if err := c.syncWork(ctx, "nereid/finished"); err != nil {
+		t.Fatalf("syncWork(finished) error = %v", err)
+	}
+	for _, action := range dc.Actions() {
+		if action.GetVerb() == "update" {
+			t.Fatalf("finished work must not be updated, got %v", action)
+		}
+	}
+	_ = c.syncWork(ctx, "nereid/running")
+
+	for name, want := range map[string]bool{"finished": false, "running": true} {
+		latest, err := dc.Resource(workGVR).Namespace("nereid").Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("get work %s: %v", name, err)
+		}
+		if got := hasFinalizer(latest, cleanupFinalizer); got != want {
+			t.Fatalf("work %s has cleanup finalizer=%v want=%v", name, got, want)
+		}
+	}
+}
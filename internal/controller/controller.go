@@ -6,13 +6,17 @@ import (
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log/slog"
+	"net"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	batchv1 "k8s.io/api/batch/v1"
@@ -21,10 +25,21 @@ import (
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/util/retry"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	batchv1listers "k8s.io/client-go/listers/batch/v1"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 )
 
 var workGVR = schema.GroupVersionResource{
@@ -40,9 +55,17 @@ var grantGVR = schema.GroupVersionResource{
 }
 
 const (
-	userPromptAnnotationKey = "nereid.yuiseki.net/user-prompt"
+	userPromptAnnotationKey    = "nereid.yuiseki.net/user-prompt"
+	workLabelKey               = "nereid.yuiseki.net/work"
+	workNamespaceAnnotationKey = "nereid.yuiseki.net/work-namespace"
+
+	workGrantIndex = "grant"
 
 	legacyKindAgentImage = "node:22-bookworm-slim"
+
+	// defaultArtifactsFSGroup is the gid of the node user in the agent
+	// runtime image.
+	defaultArtifactsFSGroup = 1000
 )
 
 func legacyKindAgentImageForJob() string {
@@ -63,7 +86,35 @@ type Config struct {
 	ArtifactsHostPath string
 	ArtifactBaseURL   string
 	ArtifactRetention time.Duration
-	ResyncInterval    time.Duration
+	// FailedArtifactRetention caps the retention of Failed and Error Works.
+	// ArtifactDiskBudget, when positive, evicts the oldest unpinned finished
+	// Works while the store holds more bytes than the budget.
+	FailedArtifactRetention time.Duration
+	ArtifactDiskBudget      int64
+	// ArtifactStorage is "hostPath" (default), "pvc" or "s3". For pvc the
+	// controller mounts the same claim at ArtifactsHostPath.
+	ArtifactStorage string
+	ArtifactPVCName string
+	ArtifactS3      S3Config
+	// ArtifactArchiveDir, when set, receives the artifact directory of a
+	// deleted Work instead of removing it.
+	ArtifactArchiveDir string
+	// ArtifactsFSGroup is the fsGroup of Job pods. Artifact directories are
+	// group-writable for it, so images running as any non-root user can
+	// write them. Zero uses defaultArtifactsFSGroup.
+	ArtifactsFSGroup int64
+	// EgressDNSNamespace and EgressDNSPodSelector pick the cluster DNS pods
+	// that egress-restricted Jobs may query. Empty values use kube-system
+	// and k8s-app=kube-dns.
+	EgressDNSNamespace   string
+	EgressDNSPodSelector string
+	// SignatureRulesConfigMap, when set, names a ConfigMap in
+	// SignatureRulesNamespace whose rules.yaml replaces the built-in runtime
+	// error signature rules. It is watched and reloaded on change.
+	SignatureRulesConfigMap string
+	SignatureRulesNamespace string
+	ResyncInterval          time.Duration
+	Workers                 int
 }
 
 type Controller struct {
@@ -72,6 +123,34 @@ type Controller struct {
 	cfg     Config
 	logger  *slog.Logger
 	nowFunc func() time.Time
+	// lookupIP resolves egress allowlist hostnames; nil uses the default resolver.
+	lookupIP func(ctx context.Context, host string) ([]net.IP, error)
+	store    artifactStore
+	recorder record.EventRecorder
+	metrics  *controllerMetrics
+	health   controllerHealth
+
+	// signatureRules replace defaultSignatureRules once the rules ConfigMap
+	// is loaded.
+	signatureMu    sync.RWMutex
+	signatureRules []signatureRule
+
+	manifestWriters manifestWriters
+
+	// pendingGrantJobs keeps Grant reservations counted until the Job
+	// informer shows their Jobs.
+	pendingGrantJobs pendingGrantJobs
+
+	queue       workqueue.TypedRateLimitingInterface[string]
+	workIndexer cache.Indexer
+	grantLister cache.GenericLister
+	jobLister   batchv1listers.JobLister
+	podLister   corev1listers.PodLister
+	// workloadIndexer caches Kueue Workloads by job UID; nil when Kueue is
+	// not installed.
+	workloadIndexer cache.Indexer
+	localQueues     localQueueCache
+	cachesSynced    []cache.InformerSynced
 }
 
 func New(dynamicClient dynamic.Interface, kubeClient kubernetes.Interface, cfg Config, logger *slog.Logger) *Controller {
@@ -81,12 +160,40 @@ func New(dynamicClient dynamic.Interface, kubeClient kubernetes.Interface, cfg C
 	if cfg.ArtifactRetention <= 0 {
 		cfg.ArtifactRetention = 30 * 24 * time.Hour
 	}
+	if cfg.FailedArtifactRetention <= 0 {
+		cfg.FailedArtifactRetention = 7 * 24 * time.Hour
+	}
+	if cfg.ResyncInterval <= 0 {
+		cfg.ResyncInterval = 5 * time.Minute
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = 2
+	}
+	if cfg.ArtifactsFSGroup <= 0 {
+		cfg.ArtifactsFSGroup = defaultArtifactsFSGroup
+	}
+	if cfg.SignatureRulesNamespace == "" {
+		cfg.SignatureRulesNamespace = cfg.WorkNamespace
+	}
+	// Object keys are <prefix><work>/..., so the prefix is "" or ends in "/".
+	cfg.ArtifactS3.Prefix = strings.Trim(strings.TrimSpace(cfg.ArtifactS3.Prefix), "/")
+	if cfg.ArtifactS3.Prefix != "" {
+		cfg.ArtifactS3.Prefix += "/"
+	}
+	if cfg.ArtifactStorage == ArtifactStorageS3 && strings.TrimSpace(cfg.ArtifactBaseURL) == "" {
+		cfg.ArtifactBaseURL = strings.TrimRight(cfg.ArtifactS3.Endpoint, "/") + "/" + cfg.ArtifactS3.Bucket + "/" + cfg.ArtifactS3.Prefix
+	}
 	return &Controller{
 		dynamic: dynamicClient,
 		kube:    kubeClient,
 		cfg:     cfg,
 		logger:  logger,
 		nowFunc: time.Now,
+		metrics: newControllerMetrics(),
+		queue: workqueue.NewTypedRateLimitingQueueWithConfig(
+			workqueue.DefaultTypedControllerRateLimiter[string](),
+			workqueue.TypedRateLimitingQueueConfig[string]{Name: "works"},
+		),
 	}
 }
 
@@ -95,52 +202,221 @@ func (c *Controller) Run(ctx context.Context) error {
 		"workNamespace", c.cfg.WorkNamespace,
 		"jobNamespace", c.cfg.JobNamespace,
 		"localQueueName", c.cfg.LocalQueueName,
+		"workers", c.cfg.Workers,
+		"resyncInterval", c.cfg.ResyncInterval.String(),
 	)
+	defer c.queue.ShutDown()
+	c.markRunning(c.nowFunc())
+	defer c.markStopped()
+
+	store, err := newArtifactStore(c.cfg)
+	if err != nil {
+		return fmt.Errorf("artifact storage: %w", err)
+	}
+	c.store = store
 
-	if err := c.reconcileAll(ctx); err != nil {
-		c.logger.Error("initial reconcile failed", "error", err)
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: c.kube.CoreV1().Events("")})
+	defer broadcaster.Shutdown()
+	c.recorder = broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "nereid-controller"})
+
+	if err := c.startInformers(ctx); err != nil {
+		return err
+	}
+
+	for i := 0; i < c.cfg.Workers; i++ {
+		go wait.UntilWithContext(ctx, c.runWorker, time.Second)
 	}
 
+	c.resync(ctx)
+
 	ticker := time.NewTicker(c.cfg.ResyncInterval)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
+			c.manifestWriters.wait()
 			c.logger.Info("controller stopped")
 			return ctx.Err()
 		case <-ticker.C:
-			if err := c.reconcileAll(ctx); err != nil {
-				c.logger.Error("reconcile loop failed", "error", err)
-			}
+			c.resync(ctx)
 		}
 	}
 }
 
-func (c *Controller) reconcileAll(ctx context.Context) error {
-	started := time.Now()
+// startInformers wires shared informers for Works, Grants and Jobs into the
+// work queue and blocks until their caches are synced.
+func (c *Controller) startInformers(ctx context.Context) error {
+	dynamicFactory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(c.dynamic, 0, c.cfg.WorkNamespace, nil)
+	workInformer := dynamicFactory.ForResource(workGVR).Informer()
+	grantInformer := dynamicFactory.ForResource(grantGVR)
 
-	if err := c.pruneArtifacts(); err != nil {
-		c.logger.Error("artifact prune failed", "error", err)
+	if err := workInformer.AddIndexers(cache.Indexers{workGrantIndex: indexWorkByGrant}); err != nil {
+		return fmt.Errorf("add work indexers: %w", err)
 	}
 
-	ns := c.cfg.WorkNamespace
-	if ns == "" {
-		ns = metav1.NamespaceAll
+	kubeFactory := informers.NewSharedInformerFactoryWithOptions(c.kube, 0,
+		informers.WithNamespace(c.cfg.JobNamespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.LabelSelector = workLabelKey
+		}),
+	)
+	jobInformer := kubeFactory.Batch().V1().Jobs()
+	// Job pods carry the Work label too; their events drive diagnoses of
+	// pods that cannot start, which do not show up on the Job.
+	podInformer := kubeFactory.Core().V1().Pods()
+
+	if _, err := workInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.enqueueWork,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			c.enqueueWork(newObj)
+		},
+		DeleteFunc: c.enqueueWork,
+	}); err != nil {
+		return fmt.Errorf("add work event handler: %w", err)
+	}
+	if _, err := grantInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.enqueueWorksForGrant,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			// Skip status-only updates such as usage accounting.
+			oldGrant, okOld := oldObj.(*unstructured.Unstructured)
+			newGrant, okNew := newObj.(*unstructured.Unstructured)
+			if okOld && okNew && oldGrant.GetGeneration() == newGrant.GetGeneration() {
+				return
+			}
+			c.enqueueWorksForGrant(newObj)
+		},
+	}); err != nil {
+		return fmt.Errorf("add grant event handler: %w", err)
+	}
+	if _, err := jobInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.enqueueWorkForJob,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			c.enqueueWorkForJob(newObj)
+		},
+		DeleteFunc: c.enqueueWorkForJob,
+	}); err != nil {
+		return fmt.Errorf("add job event handler: %w", err)
+	}
+	if _, err := podInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.enqueueWorkForPod,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			c.enqueueWorkForPod(newObj)
+		},
+		DeleteFunc: c.enqueueWorkForPod,
+	}); err != nil {
+		return fmt.Errorf("add pod event handler: %w", err)
+	}
+
+	var signatureFactory informers.SharedInformerFactory
+	if name := c.cfg.SignatureRulesConfigMap; name != "" {
+		signatureFactory = informers.NewSharedInformerFactoryWithOptions(c.kube, 0,
+			informers.WithNamespace(c.cfg.SignatureRulesNamespace),
+			informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+				opts.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+			}),
+		)
+		configMapInformer := signatureFactory.Core().V1().ConfigMaps().Informer()
+		if _, err := configMapInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				if cm, ok := obj.(*corev1.ConfigMap); ok {
+					c.setSignatureRulesFromConfigMap(cm)
+				}
+			},
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				if cm, ok := newObj.(*corev1.ConfigMap); ok {
+					c.setSignatureRulesFromConfigMap(cm)
+				}
+			},
+			DeleteFunc: func(obj interface{}) {
+				c.setSignatureRulesFromConfigMap(nil)
+			},
+		}); err != nil {
+			return fmt.Errorf("add signature rules event handler: %w", err)
+		}
+		c.cachesSynced = append(c.cachesSynced, configMapInformer.HasSynced)
 	}
 
-	list, err := c.dynamic.Resource(workGVR).Namespace(ns).List(ctx, metav1.ListOptions{})
-	if err != nil {
-		return fmt.Errorf("list works: %w", err)
+	// Workloads are only watched when Kueue serves them; otherwise the
+	// informer would never sync.
+	var workloadFactory dynamicinformer.DynamicSharedInformerFactory
+	if c.kueueWorkloadsServed() {
+		workloadFactory = dynamicinformer.NewFilteredDynamicSharedInformerFactory(c.dynamic, 0, c.cfg.JobNamespace, func(opts *metav1.ListOptions) {
+			opts.LabelSelector = kueueJobUIDLabelKey
+		})
+		workloadInformer := workloadFactory.ForResource(kueueWorkloadGVR).Informer()
+		if err := workloadInformer.AddIndexers(cache.Indexers{workloadJobUIDIndex: indexWorkloadByJobUID}); err != nil {
+			return fmt.Errorf("add workload indexers: %w", err)
+		}
+		if _, err := workloadInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: c.enqueueWorkForWorkload,
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				c.enqueueWorkForWorkload(newObj)
+			},
+			DeleteFunc: c.enqueueWorkForWorkload,
+		}); err != nil {
+			return fmt.Errorf("add workload event handler: %w", err)
+		}
+		c.workloadIndexer = workloadInformer.GetIndexer()
+		c.cachesSynced = append(c.cachesSynced, workloadInformer.HasSynced)
+	}
+
+	c.workIndexer = workInformer.GetIndexer()
+	c.grantLister = grantInformer.Lister()
+	c.jobLister = jobInformer.Lister()
+	c.podLister = podInformer.Lister()
+	c.cachesSynced = append(c.cachesSynced,
+		workInformer.HasSynced,
+		grantInformer.Informer().HasSynced,
+		jobInformer.Informer().HasSynced,
+		podInformer.Informer().HasSynced,
+	)
+
+	dynamicFactory.Start(ctx.Done())
+	kubeFactory.Start(ctx.Done())
+	if signatureFactory != nil {
+		signatureFactory.Start(ctx.Done())
+	}
+	if workloadFactory != nil {
+		workloadFactory.Start(ctx.Done())
 	}
+	if !cache.WaitForCacheSync(ctx.Done(), c.cachesSynced...) {
+		return fmt.Errorf("wait for informer caches: %w", ctx.Err())
+	}
+	if err := c.metrics.registerCacheMetrics(c.workIndexer, c.grantLister); err != nil {
+		return fmt.Errorf("register cache metrics: %w", err)
+	}
+	c.markSynced()
+	c.logger.Info("informer caches synced")
+	return nil
+}
+
+// resync prunes expired artifacts and re-enqueues every non-terminal Work,
+// and every succeeded Work still missing its manifest, as a safety net for
+// missed watch events.
+func (c *Controller) resync(ctx context.Context) {
+	started := time.Now()
 
-	activeWorks := make([]*unstructured.Unstructured, 0, len(list.Items))
+	if err := c.pruneArtifacts(ctx); err != nil {
+		c.logger.Error("artifact prune failed", "error", err)
+	}
+	c.syncGrantStatuses(ctx)
+
+	items := c.workIndexer.List()
+	activeWorks := make([]*unstructured.Unstructured, 0, len(items))
 	skippedTerminal := 0
-	for i := range list.Items {
-		work := &list.Items[i]
+	for _, obj := range items {
+		work, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
 		phase, _, _ := unstructured.NestedString(work.Object, "status", "phase")
 		if isTerminalWorkPhase(phase) {
 			skippedTerminal++
+			if needsArtifactManifest(work) {
+				c.enqueueWork(work)
+			}
 			continue
 		}
 		activeWorks = append(activeWorks, work)
@@ -148,94 +424,608 @@ func (c *Controller) reconcileAll(ctx context.Context) error {
 	sort.SliceStable(activeWorks, func(i, j int) bool {
 		return activeWorks[i].GetCreationTimestamp().Time.After(activeWorks[j].GetCreationTimestamp().Time)
 	})
-
 	for _, work := range activeWorks {
-		if err := c.reconcileWork(ctx, work); err != nil {
-			c.logger.Error("reconcile work failed",
-				"work", work.GetName(),
-				"namespace", work.GetNamespace(),
-				"error", err,
-			)
+		c.enqueueWork(work)
+	}
+
+	c.markResynced(c.nowFunc())
+	c.logger.Info("resync completed",
+		"workTotal", len(items),
+		"workActive", len(activeWorks),
+		"workSkippedTerminal", skippedTerminal,
+		"duration", time.Since(started).String(),
+	)
+}
+
+// recordEvent emits an Event on obj; Controllers built without Run have no
+// recorder.
+func (c *Controller) recordEvent(obj runtime.Object, eventType, reason, messageFmt string, args ...interface{}) {
+	if c.recorder == nil {
+		return
+	}
+	c.recorder.Eventf(obj, eventType, reason, messageFmt, args...)
+}
+
+func (c *Controller) runWorker(ctx context.Context) {
+	for c.processNextWorkItem(ctx) {
+	}
+}
+
+func (c *Controller) processNextWorkItem(ctx context.Context) bool {
+	key, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.queue.Done(key)
+
+	started := time.Now()
+	err := c.syncWork(ctx, key)
+	c.metrics.observeReconcile(time.Since(started), err)
+	if err != nil {
+		c.logger.Error("reconcile work failed", "key", key, "error", err)
+		c.queue.AddRateLimited(key)
+		return true
+	}
+	c.markReconciled(c.nowFunc())
+	c.queue.Forget(key)
+	return true
+}
+
+func (c *Controller) syncWork(ctx context.Context, key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		c.logger.Warn("drop malformed work key", "key", key, "error", err)
+		return nil
+	}
+	obj, exists, err := c.workIndexer.GetByKey(key)
+	if err != nil {
+		return fmt.Errorf("get work %s/%s from cache: %w", namespace, name, err)
+	}
+	if !exists {
+		return nil
+	}
+	cached, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil
+	}
+
+	if cached.GetDeletionTimestamp() != nil {
+		return c.finalizeWork(ctx, cached.DeepCopy())
+	}
+
+	// Finished Works are left alone, so the finalizer is only added to Works
+	// that may still create a Job.
+	phase, _, _ := unstructured.NestedString(cached.Object, "status", "phase")
+	if isTerminalWorkPhase(phase) {
+		if needsArtifactManifest(cached) {
+			c.writeArtifactManifestAsync(ctx, cached.DeepCopy())
+		}
+		return nil
+	}
+	work, err := c.ensureCleanupFinalizer(ctx, cached.DeepCopy())
+	if err != nil {
+		return err
+	}
+	return c.reconcileWork(ctx, work)
+}
+
+func (c *Controller) enqueueWork(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		c.logger.Warn("failed to build work key", "error", err)
+		return
+	}
+	c.queue.Add(key)
+}
+
+func (c *Controller) enqueueWorkAfter(work *unstructured.Unstructured, delay time.Duration) {
+	if c.queue == nil {
+		return
+	}
+	c.queue.AddAfter(work.GetNamespace()+"/"+work.GetName(), delay)
+}
+
+func (c *Controller) enqueueWorkForJob(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	job, ok := obj.(*batchv1.Job)
+	if !ok {
+		return
+	}
+	c.pendingGrantJobs.remove(job.Name)
+	if key := workKeyForJob(job, c.cfg.WorkNamespace); key != "" {
+		c.queue.Add(key)
+	}
+}
+
+// enqueueWorkForPod maps a Job pod to its Work through the cached Job, whose
+// annotations carry the Work namespace.
+func (c *Controller) enqueueWorkForPod(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	pod, ok := obj.(*corev1.Pod)
+	if !ok || c.jobLister == nil {
+		return
+	}
+	jobName := pod.Labels[jobNameLabelKey]
+	if jobName == "" {
+		return
+	}
+	job, err := c.jobLister.Jobs(pod.Namespace).Get(jobName)
+	if err != nil {
+		return
+	}
+	if key := workKeyForJob(job, c.cfg.WorkNamespace); key != "" {
+		c.queue.Add(key)
+	}
+}
+
+func (c *Controller) enqueueWorksForGrant(obj interface{}) {
+	grant, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	works, err := c.workIndexer.ByIndex(workGrantIndex, grant.GetNamespace()+"/"+grant.GetName())
+	if err != nil {
+		c.logger.Warn("failed to look up works for grant", "grant", grant.GetName(), "namespace", grant.GetNamespace(), "error", err)
+		return
+	}
+	for _, w := range works {
+		work, ok := w.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		phase, _, _ := unstructured.NestedString(work.Object, "status", "phase")
+		if isTerminalWorkPhase(phase) {
+			continue
 		}
+		c.enqueueWork(work)
 	}
+}
 
-	duration := time.Since(started)
-	if duration > 2*time.Second || skippedTerminal > 0 {
-		c.logger.Info("reconcile cycle completed",
-			"workTotal", len(list.Items),
-			"workActive", len(activeWorks),
-			"workSkippedTerminal", skippedTerminal,
-			"duration", duration.String(),
-		)
+// workKeyForJob resolves the owning Work's queue key from the Job's work label
+// and work-namespace annotation.
+func workKeyForJob(job *batchv1.Job, fallbackNamespace string) string {
+	if job == nil {
+		return ""
 	}
-	return nil
+	name := strings.TrimSpace(job.Labels[workLabelKey])
+	if name == "" {
+		return ""
+	}
+	namespace := strings.TrimSpace(job.Annotations[workNamespaceAnnotationKey])
+	if namespace == "" {
+		namespace = fallbackNamespace
+	}
+	if namespace == "" {
+		return ""
+	}
+	return namespace + "/" + name
+}
+
+func indexWorkByGrant(obj interface{}) ([]string, error) {
+	work, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, nil
+	}
+	grantName, _, _ := unstructured.NestedString(work.Object, "spec", "grantRef", "name")
+	grantName = strings.TrimSpace(grantName)
+	if grantName == "" {
+		return nil, nil
+	}
+	return []string{work.GetNamespace() + "/" + grantName}, nil
+}
+
+func (c *Controller) getJob(ctx context.Context, name string) (*batchv1.Job, error) {
+	if c.jobLister != nil {
+		return c.jobLister.Jobs(c.cfg.JobNamespace).Get(name)
+	}
+	return c.kube.BatchV1().Jobs(c.cfg.JobNamespace).Get(ctx, name, metav1.GetOptions{})
+}
+
+func (c *Controller) getGrant(ctx context.Context, namespace, name string) (*unstructured.Unstructured, error) {
+	if c.grantLister != nil {
+		obj, err := c.grantLister.ByNamespace(namespace).Get(name)
+		if err != nil {
+			return nil, err
+		}
+		grant, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			return nil, fmt.Errorf("unexpected grant object type %T", obj)
+		}
+		return grant.DeepCopy(), nil
+	}
+	return c.dynamic.Resource(grantGVR).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
 }
 
 func (c *Controller) reconcileWork(ctx context.Context, work *unstructured.Unstructured) error {
+	if cancel, _, _ := unstructured.NestedBool(work.Object, "spec", "cancel"); cancel {
+		return c.cancelWork(ctx, work)
+	}
+
 	kind, _, err := unstructured.NestedString(work.Object, "spec", "kind")
 	if err != nil {
-		return c.updateWorkStatus(ctx, work, "Error", fmt.Sprintf("failed to read spec.kind: %v", err), "")
+		return c.failWork(ctx, work, reasonInvalidSpec, fmt.Sprintf("failed to read spec.kind: %v", err))
 	}
 
 	grantName, _, err := unstructured.NestedString(work.Object, "spec", "grantRef", "name")
 	if err != nil {
-		return c.updateWorkStatus(ctx, work, "Error", fmt.Sprintf("failed to read spec.grantRef.name: %v", err), "")
+		return c.failWork(ctx, work, reasonInvalidSpec, fmt.Sprintf("failed to read spec.grantRef.name: %v", err))
 	}
 	grantName = strings.TrimSpace(grantName)
 
-	jobName := makeJobName(work.GetName())
-	job, err := c.kube.BatchV1().Jobs(c.cfg.JobNamespace).Get(ctx, jobName, metav1.GetOptions{})
+	attempt := workAttempt(work)
+	jobName := makeAttemptJobName(work.GetName(), attempt)
+	job, err := c.getJob(ctx, jobName)
 	if apierrors.IsNotFound(err) {
+		return c.createWorkJob(ctx, work, kind, grantName, attempt)
+	}
+	if err != nil {
+		return fmt.Errorf("get job %s/%s: %w", c.cfg.JobNamespace, jobName, err)
+	}
+
+	st := workStatus{
+		artifact: artifactURL(c.cfg.ArtifactBaseURL, work.GetName()),
+		job:      job,
+		attempt:  attempt,
+
+		grantReservation: job.Labels[grantLabelKey],
+	}
+	st.phase, st.reason, st.message = phaseFromJob(job)
+	if err := c.diagnoseWorkJob(ctx, work, job, &st); err != nil {
+		return err
+	}
+	c.updateQueueStatus(ctx, work, job, &st)
+	if st.phase == "Succeeded" {
+		if validation, validationErr := c.validateSucceededWorkArtifacts(ctx, work); validationErr != nil {
+			c.logger.Warn("artifact validation skipped due error", "work", work.GetName(), "error", validationErr)
+		} else if failure := validation.failure(); failure != nil {
+			st.phase = "Failed"
+			st.reason = failure.Reason
+			st.message = validation.message()
+			st.validated = boolPtr(false)
+			st.validation = &validation
+		} else {
+			st.validation = &validation
+			st.validated = boolPtr(true)
+		}
+	}
+	if st.phase == "Succeeded" || st.phase == "Failed" {
+		st.ended = finishedAttempt(job, attempt, st.reason, st.message, c.nowFunc())
+		if err := c.deleteGrantEnvSecret(ctx, jobName); err != nil {
+			return err
+		}
+		if job.Labels[grantLabelKey] != "" {
+			if err := c.recordGrantJobUsage(ctx, work.GetNamespace(), jobName); err != nil {
+				return err
+			}
+			c.releaseGrantSlot(ctx, work.GetNamespace(), job.Labels[grantLabelKey])
+		}
+	}
+	if st.phase == "Failed" {
 		var grant *unstructured.Unstructured
 		if grantName != "" {
-			obj, getErr := c.dynamic.Resource(grantGVR).Namespace(work.GetNamespace()).Get(ctx, grantName, metav1.GetOptions{})
-			if apierrors.IsNotFound(getErr) {
-				return c.updateWorkStatus(ctx, work, "Error", fmt.Sprintf("grant %q not found", grantName), "")
-			}
-			if getErr != nil {
-				return c.updateWorkStatus(ctx, work, "Error", fmt.Sprintf("failed to get grant %q: %v", grantName, getErr), "")
-			}
-			grant = obj
-			if validateErr := c.validateGrantForWork(ctx, work, kind, grant); validateErr != nil {
-				return c.updateWorkStatus(ctx, work, "Error", validateErr.Error(), "")
+			// A missing Grant disables retries; createWorkJob would reject the next attempt anyway.
+			grant, _ = c.getGrant(ctx, work.GetNamespace(), grantName)
+		}
+		if maxAttempts := maxWorkAttempts(work, grant); attempt < maxAttempts {
+			return c.retryWork(ctx, work, kind, grantName, st, maxAttempts)
+		}
+	}
+	if err := c.updateWorkStatus(ctx, work, st); err != nil {
+		return err
+	}
+	if st.phase == "Succeeded" && st.validated != nil && *st.validated {
+		c.writeArtifactManifestAsync(ctx, work)
+	}
+	return nil
+}
+
+// createWorkJob validates the Grant and creates the Job for the given attempt.
+func (c *Controller) createWorkJob(ctx context.Context, work *unstructured.Unstructured, kind, grantName string, attempt int) error {
+	jobName := makeAttemptJobName(work.GetName(), attempt)
+
+	// The Job informer may not have observed an earlier create yet. Check the
+	// API server so the Grant is only reserved for a Job this call creates.
+	existing, getErr := c.kube.BatchV1().Jobs(c.cfg.JobNamespace).Get(ctx, jobName, metav1.GetOptions{})
+	if getErr == nil {
+		return c.updateWorkStatus(ctx, work, submittedWorkStatus(c.cfg.ArtifactBaseURL, work, existing, attempt))
+	}
+	if !apierrors.IsNotFound(getErr) {
+		return fmt.Errorf("get job %s/%s: %w", c.cfg.JobNamespace, jobName, getErr)
+	}
+
+	var grant *unstructured.Unstructured
+	if grantName != "" {
+		obj, getErr := c.getGrant(ctx, work.GetNamespace(), grantName)
+		if apierrors.IsNotFound(getErr) {
+			return c.failWork(ctx, work, reasonGrantNotFound, fmt.Sprintf("grant %q not found", grantName))
+		}
+		if getErr != nil {
+			return c.failWork(ctx, work, reasonGrantNotFound, fmt.Sprintf("failed to get grant %q: %v", grantName, getErr))
+		}
+		grant = obj
+		if validateErr := c.validateGrantForWork(ctx, work, kind, grant); validateErr != nil {
+			return c.failWork(ctx, work, reasonGrantRejected, validateErr.Error())
+		}
+	}
+
+	if styleErr := validateWorkStyle(work, kind); styleErr != nil {
+		return c.failWork(ctx, work, reasonStyleInvalid, styleErr.Error())
+	}
+	executor, executorErr := c.workExecutor(ctx, work, kind, grant)
+	if executorErr != nil {
+		return c.failWork(ctx, work, reasonInvalidSpec, executorErr.Error())
+	}
+	newJob, buildErr := c.buildJob(work, jobName, kind, executor)
+	if buildErr != nil {
+		return c.failWork(ctx, work, reasonInvalidSpec, buildErr.Error())
+	}
+	setJobAttempt(newJob, attempt)
+	var envSecret *corev1.Secret
+	if grant != nil {
+		secret, applyErr := c.applyGrantToJob(ctx, newJob, grant)
+		if applyErr != nil {
+			return c.failWork(ctx, work, reasonGrantRejected, applyErr.Error())
+		}
+		envSecret = secret
+	}
+	if executor == executorNative && kind == "laz.3dtiles.v1" {
+		if err := scalePointcloudCPU(newJob, extractPointcloudJobs(work), grant); err != nil {
+			return c.failWork(ctx, work, reasonGrantRejected, err.Error())
+		}
+	}
+	egress, egressErr := workEgress(work, grant)
+	if errors.Is(egressErr, errEgressNotAllowed) {
+		return c.failWork(ctx, work, reasonGrantRejected, egressErr.Error())
+	}
+	if egressErr != nil {
+		return c.failWork(ctx, work, reasonInvalidSpec, egressErr.Error())
+	}
+	egressPolicy, egressErr := c.buildEgressPolicy(ctx, newJob, egress)
+	if egressErr != nil {
+		var dnsErr *net.DNSError
+		if errors.As(egressErr, &dnsErr) && dnsErr.IsNotFound {
+			return c.failWork(ctx, work, reasonInvalidSpec, egressErr.Error())
+		}
+		return egressErr
+	}
+	consumeUse := grantReservation(work) != grantName
+	if grant != nil {
+		if err := c.reserveGrant(ctx, work, grant, newJob, consumeUse); err != nil {
+			switch {
+			case errors.Is(err, errGrantConcurrency):
+				c.enqueueWorkAfter(work, grantPendingRequeue)
+				return c.updateWorkStatus(ctx, work, workStatus{
+					phase:   "Pending",
+					reason:  reasonGrantLimited,
+					message: err.Error() + "; waiting for running Works to finish",
+				})
+			case errors.Is(err, errGrantBudget):
+				// Admission uses the worst-case cost, so a Work that does not
+				// fit waits for the Grant's budget to be raised instead of
+				// failing; Grant updates requeue it.
+				c.enqueueWorkAfter(work, grantPendingRequeue)
+				return c.updateWorkStatus(ctx, work, workStatus{
+					phase:   "Pending",
+					reason:  reasonGrantLimited,
+					message: err.Error() + "; waiting for the Grant budget to be raised",
+				})
+			case errors.Is(err, errGrantExhausted):
+				return c.failWork(ctx, work, reasonGrantRejected, err.Error())
 			}
+			return fmt.Errorf("reserve grant %s/%s: %w", work.GetNamespace(), grantName, err)
+		}
+	}
+	releaseGrant := func() {
+		if grant == nil {
+			return
+		}
+		if err := c.releaseGrant(ctx, work.GetNamespace(), grantName, newJob, consumeUse); err != nil {
+			c.logger.Warn("failed to release grant reservation", "grant", grantName, "work", work.GetName(), "error", err)
 		}
+	}
 
-		newJob, buildErr := c.buildJob(work, jobName, kind)
-		if buildErr != nil {
-			return c.updateWorkStatus(ctx, work, "Error", buildErr.Error(), "")
+	c.ensureWorkArtifactDir(newJob, work.GetName())
+	if egressPolicy != nil {
+		if err := c.applyEgressPolicy(ctx, egressPolicy); err != nil {
+			releaseGrant()
+			return err
 		}
-		if grant != nil {
-			if applyErr := c.applyGrantToJob(ctx, newJob, grant); applyErr != nil {
-				return c.updateWorkStatus(ctx, work, "Error", applyErr.Error(), "")
+	}
+	// The pod cannot start without its env, so the Secret exists before the
+	// Job and is handed to it once the Job has a UID.
+	if envSecret != nil {
+		if err := c.applyGrantEnvSecret(ctx, envSecret); err != nil {
+			releaseGrant()
+			return err
+		}
+	}
+
+	created, createErr := c.kube.BatchV1().Jobs(c.cfg.JobNamespace).Create(ctx, newJob, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(createErr) {
+		// Another reconcile created the Job after the check above and holds
+		// its own reservation, so drop ours and record that Job.
+		releaseGrant()
+		created, createErr = c.kube.BatchV1().Jobs(c.cfg.JobNamespace).Get(ctx, jobName, metav1.GetOptions{})
+		if createErr != nil {
+			return fmt.Errorf("get existing job %s/%s: %w", c.cfg.JobNamespace, jobName, createErr)
+		}
+		return c.updateWorkStatus(ctx, work, submittedWorkStatus(c.cfg.ArtifactBaseURL, work, created, attempt))
+	} else if createErr != nil {
+		releaseGrant()
+		if egressPolicy != nil {
+			if err := c.deleteEgressPolicy(ctx, jobName); err != nil {
+				c.logger.Warn("failed to delete egress policy", "job", jobName, "error", err)
+			}
+		}
+		if envSecret != nil {
+			if err := c.deleteGrantEnvSecret(ctx, jobName); err != nil {
+				c.logger.Warn("failed to delete env secret", "job", jobName, "error", err)
 			}
 		}
-		if _, createErr := c.kube.BatchV1().Jobs(c.cfg.JobNamespace).Create(ctx, newJob, metav1.CreateOptions{}); createErr != nil {
-			return c.updateWorkStatus(ctx, work, "Error", fmt.Sprintf("failed to create job: %v", createErr), "")
+		return c.failWork(ctx, work, reasonJobCreateFailed, fmt.Sprintf("failed to create job: %v", createErr))
+	} else {
+		c.metrics.observeJobCreated(work, kind, attempt, c.nowFunc())
+		c.recordEvent(work, corev1.EventTypeNormal, reasonJobCreated, "Created Job %s/%s (attempt %d)", c.cfg.JobNamespace, jobName, attempt)
+		if grant != nil {
+			c.recordEvent(grant, corev1.EventTypeNormal, reasonJobCreated, "Created Job %s/%s for Work %s", c.cfg.JobNamespace, jobName, work.GetName())
 		}
 		c.logger.Info("created job for work",
 			"work", work.GetName(),
 			"workNamespace", work.GetNamespace(),
 			"job", jobName,
 			"jobNamespace", c.cfg.JobNamespace,
+			"attempt", attempt,
 		)
-		return c.updateWorkStatus(ctx, work, "Submitted", "job created", artifactURL(c.cfg.ArtifactBaseURL, work.GetName()))
 	}
-	if err != nil {
+	if egressPolicy != nil {
+		if err := c.ownEgressPolicy(ctx, created); err != nil {
+			releaseGrant()
+			return err
+		}
+	}
+	if envSecret != nil {
+		if err := c.ownGrantEnvSecret(ctx, created); err != nil {
+			releaseGrant()
+			return err
+		}
+	}
+
+	return c.updateWorkStatus(ctx, work, submittedWorkStatus(c.cfg.ArtifactBaseURL, work, created, attempt))
+}
+
+// submittedWorkStatus is the status recorded once job exists for attempt.
+func submittedWorkStatus(artifactBaseURL string, work *unstructured.Unstructured, job *batchv1.Job, attempt int) workStatus {
+	message := "job created"
+	if attempt > 1 {
+		message = fmt.Sprintf("job created for attempt %d", attempt)
+	}
+	return workStatus{
+		phase:    "Submitted",
+		reason:   reasonJobCreated,
+		message:  message,
+		artifact: artifactURL(artifactBaseURL, work.GetName()),
+		job:      job,
+		attempt:  attempt,
+
+		grantReservation: job.Labels[grantLabelKey],
+	}
+}
+
+// retryWork records the failed attempt and starts the next one once its
+// backoff has elapsed. Until then the Work stays in Retrying and is requeued.
+func (c *Controller) retryWork(ctx context.Context, work *unstructured.Unstructured, kind, grantName string, st workStatus, maxAttempts int) error {
+	failedAt := st.ended.completionTime
+	if status, _, _ := unstructured.NestedMap(work.Object, "status"); status != nil {
+		if recorded, ok := lastAttemptCompletion(status, st.attempt); ok {
+			failedAt = recorded
+		}
+	}
+	retryAt := failedAt.Add(retryBackoff(work, st.attempt))
+
+	st.message = fmt.Sprintf("attempt %d/%d failed (%s: %s); next attempt at %s", st.attempt, maxAttempts, st.ended.reason, st.ended.message, formatStatusTime(retryAt))
+	st.phase = "Retrying"
+	st.reason = reasonRetryScheduled
+	if err := c.updateWorkStatus(ctx, work, st); err != nil {
+		return err
+	}
+
+	if wait := retryAt.Sub(c.nowFunc()); wait > 0 {
+		c.enqueueWorkAfter(work, wait)
+		return nil
+	}
+	c.logger.Info("retrying work",
+		"work", work.GetName(),
+		"workNamespace", work.GetNamespace(),
+		"attempt", st.attempt+1,
+		"maxAttempts", maxAttempts,
+	)
+	return c.createWorkJob(ctx, work, kind, grantName, st.attempt+1)
+}
+
+// cancelWork deletes the Work's Job (and, via foreground propagation, its
+// pods) and moves the Work to Canceled once the Job is gone. Artifacts
+// written so far are kept.
+func (c *Controller) cancelWork(ctx context.Context, work *unstructured.Unstructured) error {
+	artifact, _, _ := unstructured.NestedString(work.Object, "status", "artifactUrl")
+	jobName := makeAttemptJobName(work.GetName(), workAttempt(work))
+	job, err := c.getJob(ctx, jobName)
+	if err != nil && !apierrors.IsNotFound(err) {
 		return fmt.Errorf("get job %s/%s: %w", c.cfg.JobNamespace, jobName, err)
 	}
+	if err == nil {
+		if job.DeletionTimestamp == nil {
+			if err := c.recordGrantJobUsage(ctx, work.GetNamespace(), jobName); err != nil {
+				return err
+			}
+			propagation := metav1.DeletePropagationForeground
+			delErr := c.kube.BatchV1().Jobs(c.cfg.JobNamespace).Delete(ctx, jobName, metav1.DeleteOptions{PropagationPolicy: &propagation})
+			if delErr != nil && !apierrors.IsNotFound(delErr) {
+				return fmt.Errorf("delete job %s/%s for canceled work: %w", c.cfg.JobNamespace, jobName, delErr)
+			}
+			c.logger.Info("deleting job for canceled work",
+				"work", work.GetName(),
+				"workNamespace", work.GetNamespace(),
+				"job", jobName,
+				"jobNamespace", c.cfg.JobNamespace,
+			)
+		}
+		// The Job delete event requeues the Work once its pods are gone.
+		return c.updateWorkStatus(ctx, work, workStatus{
+			phase:    "Canceling",
+			reason:   reasonCanceled,
+			message:  "cancel requested; waiting for job pods to terminate",
+			artifact: artifact,
+			job:      job,
+		})
+	}
 
-	phase, message := phaseFromJob(job)
-	if phase == "Succeeded" {
-		if validationMessage, validationErr := c.validateSucceededWorkArtifacts(work.GetName()); validationErr != nil {
-			c.logger.Warn("artifact validation skipped due error", "work", work.GetName(), "error", validationErr)
-		} else if validationMessage != "" {
-			phase = "Failed"
-			message = validationMessage
+	if grantName, _, _ := unstructured.NestedString(work.Object, "spec", "grantRef", "name"); strings.TrimSpace(grantName) != "" {
+		c.releaseGrantSlot(ctx, work.GetNamespace(), strings.TrimSpace(grantName))
+	}
+	if noteErr := c.appendCancellationNote(ctx, work.GetName()); noteErr != nil {
+		c.logger.Warn("failed to append cancellation note", "work", work.GetName(), "error", noteErr)
+	}
+	return c.updateWorkStatus(ctx, work, workStatus{
+		phase:    "Canceled",
+		reason:   reasonCanceled,
+		message:  "work canceled by spec.cancel",
+		artifact: artifact,
+	})
+}
+
+// appendCancellationNote appends a note to the Work's agent.log through the
+// artifact store, so S3-backed Works keep a trace of the cancellation too.
+func (c *Controller) appendCancellationNote(ctx context.Context, workName string) error {
+	store := c.artifacts()
+	if local, ok := store.(*localArtifactStore); ok && local.root == "" {
+		return nil
+	}
+	log, err := store.readFile(ctx, workName, "agent.log")
+	if errors.Is(err, fs.ErrNotExist) {
+		files, listErr := store.files(ctx, workName)
+		if listErr != nil && !errors.Is(listErr, fs.ErrNotExist) {
+			return listErr
+		}
+		if len(files) == 0 {
+			// The Job never started writing; there is nothing to annotate.
+			return nil
 		}
+		log, err = nil, nil
+	}
+	if err != nil {
+		return err
 	}
-	url := artifactURL(c.cfg.ArtifactBaseURL, work.GetName())
-	return c.updateWorkStatus(ctx, work, phase, message, url)
+	note := fmt.Sprintf("\n[NEREID] Work canceled at %s; the job was deleted and partial artifacts were kept.\n", formatStatusTime(c.nowFunc()))
+	return store.writeFile(ctx, workName, "agent.log", append(log, note...))
+}
+
+// failWork records a Work that could not be turned into a Job.
+func (c *Controller) failWork(ctx context.Context, work *unstructured.Unstructured, reason, message string) error {
+	return c.updateWorkStatus(ctx, work, workStatus{phase: "Error", reason: reason, message: message})
 }
 
 func isTerminalWorkPhase(phase string) bool {
@@ -247,7 +1037,9 @@ func isTerminalWorkPhase(phase string) bool {
 	}
 }
 
-func (c *Controller) buildJob(work *unstructured.Unstructured, jobName, kind string) (*batchv1.Job, error) {
+// buildJob builds the Job for kind. executor selects between the Gemini
+// bridge and nereid-runner for legacy kinds; empty means the agent.
+func (c *Controller) buildJob(work *unstructured.Unstructured, jobName, kind, executor string) (*batchv1.Job, error) {
 	switch kind {
 	case "overpassql.map.v1", "maplibre.style.v1", "duckdb.map.v1", "gdal.rastertile.v1", "laz.3dtiles.v1":
 		legacySpec, found, err := unstructured.NestedMap(work.Object, "spec")
@@ -257,11 +1049,20 @@ func (c *Controller) buildJob(work *unstructured.Unstructured, jobName, kind str
 		if !found || len(legacySpec) == 0 {
 			return nil, fmt.Errorf("spec is required for legacy kind bridge")
 		}
+		userPrompt := legacyKindBridgePrompt(kind, legacySpec)
+		if executor == executorNative {
+			runnerScript, err := buildNativeScript(work, kind, legacySpec)
+			if err != nil {
+				return nil, err
+			}
+			job := c.buildScriptJob(work, jobName, runnerImageForJob(), buildAgentScript(work.GetName(), runnerScript, userPrompt))
+			job.Annotations[executorAnnotationKey] = executorNative
+			return job, nil
+		}
 		bridgeScript, err := buildLegacyKindBridgeScript(kind, legacySpec)
 		if err != nil {
 			return nil, err
 		}
-		userPrompt := legacyKindBridgePrompt(kind, legacySpec)
 		return c.buildScriptJob(work, jobName, legacyKindAgentImageForJob(), buildAgentScript(work.GetName(), bridgeScript, userPrompt)), nil
 
 	case "agent.cli.v1":
@@ -307,10 +1108,16 @@ func (c *Controller) buildJob(work *unstructured.Unstructured, jobName, kind str
 
 func (c *Controller) buildScriptJob(work *unstructured.Unstructured, jobName, image, script string) *batchv1.Job {
 	suspend := true
-	hostPathType := corev1.HostPathDirectory
 	workName := work.GetName()
 	workNamespace := work.GetNamespace()
 	deadlineSeconds := extractDeadlineSeconds(work)
+	fsGroup := c.cfg.ArtifactsFSGroup
+	if fsGroup <= 0 {
+		fsGroup = defaultArtifactsFSGroup
+	}
+	// OnRootMismatch keeps the kubelet from relabelling a whole PVC on every
+	// pod start.
+	fsGroupPolicy := corev1.FSGroupChangeOnRootMismatch
 
 	job := &batchv1.Job{
 		ObjectMeta: metav1.ObjectMeta{
@@ -332,11 +1139,15 @@ func (c *Controller) buildScriptJob(work *unstructured.Unstructured, jobName, im
 			Template: corev1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
 					Labels: map[string]string{
-						"nereid.yuiseki.net/work": workName,
+						workLabelKey: workName,
 					},
 				},
 				Spec: corev1.PodSpec{
 					RestartPolicy: corev1.RestartPolicyNever,
+					SecurityContext: &corev1.PodSecurityContext{
+						FSGroup:             &fsGroup,
+						FSGroupChangePolicy: &fsGroupPolicy,
+					},
 					Containers: []corev1.Container{
 						{
 							Name:    "task",
@@ -353,22 +1164,9 @@ func (c *Controller) buildScriptJob(work *unstructured.Unstructured, jobName, im
 									corev1.ResourceMemory: mustParseQuantity("512Mi"),
 								},
 							},
-							VolumeMounts: []corev1.VolumeMount{
-								{
-									Name:      "artifacts",
-									MountPath: "/artifacts",
-								},
-							},
-						},
-					},
-					Volumes: []corev1.Volume{
-						{
-							Name: "artifacts",
-							VolumeSource: corev1.VolumeSource{
-								HostPath: &corev1.HostPathVolumeSource{
-									Path: c.cfg.ArtifactsHostPath,
-									Type: &hostPathType,
-								},
+							Env: []corev1.EnvVar{
+								{Name: "NEREID_WORK_NAME", Value: workName},
+								{Name: "NEREID_ARTIFACT_DIR", Value: workArtifactDir(workName)},
 							},
 						},
 					},
@@ -377,9 +1175,21 @@ func (c *Controller) buildScriptJob(work *unstructured.Unstructured, jobName, im
 		},
 	}
 
+	c.artifacts().mountJob(job, workName)
 	if c.cfg.RuntimeClassName != "" {
 		job.Spec.Template.Spec.RuntimeClassName = &c.cfg.RuntimeClassName
 	}
+	// Cross-namespace owners are not allowed; the cleanup finalizer covers
+	// the usual case where Jobs run in a separate namespace.
+	if workNamespace == c.cfg.JobNamespace && work.GetUID() != "" {
+		job.OwnerReferences = []metav1.OwnerReference{{
+			APIVersion: workGVR.GroupVersion().String(),
+			Kind:       "Work",
+			Name:       workName,
+			UID:        work.GetUID(),
+			Controller: boolPtr(true),
+		}}
+	}
 
 	return job
 }
@@ -626,11 +1436,14 @@ func buildAgentScript(workName, userScript, userPrompt string) string {
 	promptB64 := base64.StdEncoding.EncodeToString([]byte(userPrompt))
 	return fmt.Sprintf(`set -eu
 WORK=%q
-OUT_DIR="/artifacts/${WORK}"
+OUT_DIR="${NEREID_ARTIFACT_DIR:-/artifacts/${WORK}}"
 LOGS_DIR="${OUT_DIR}/logs"
+ATTEMPT="${NEREID_ATTEMPT:-1}"
+ATTEMPT_LOGS_DIR="${LOGS_DIR}/attempt-${ATTEMPT}"
 SPECIALS_DIR="${OUT_DIR}/specials"
 SPECIALS_SKILLS_DIR="${SPECIALS_DIR}/skills"
-mkdir -p "${OUT_DIR}" "${LOGS_DIR}" "${SPECIALS_SKILLS_DIR}"
+mkdir -p "${OUT_DIR}" "${LOGS_DIR}" "${ATTEMPT_LOGS_DIR}" "${SPECIALS_SKILLS_DIR}"
+date +%%s > "${ATTEMPT_LOGS_DIR}/start-time.txt" || true
 START_TIME_FILE="${LOGS_DIR}/start-time.txt"
 INSTRUCTIONS_CSV="${LOGS_DIR}/instructions.csv"
 if [ ! -s "${START_TIME_FILE}" ]; then
@@ -671,6 +1484,9 @@ set -e
 cp "${OUT_DIR}/agent.log" "${LOGS_DIR}/agent.log" 2>/dev/null || true
 cp "${OUT_DIR}/dialogue.txt" "${LOGS_DIR}/dialogue.txt" 2>/dev/null || true
 cp "${OUT_DIR}/user-input.txt" "${LOGS_DIR}/user-input.txt" 2>/dev/null || true
+cp "${OUT_DIR}/agent.log" "${ATTEMPT_LOGS_DIR}/agent.log" 2>/dev/null || true
+cp "${OUT_DIR}/dialogue.txt" "${ATTEMPT_LOGS_DIR}/dialogue.txt" 2>/dev/null || true
+printf '%%s\n' "${status}" > "${ATTEMPT_LOGS_DIR}/exit-code.txt" || true
 
 if [ ! -f "${OUT_DIR}/index.html" ]; then
   cat > "${OUT_DIR}/index.html" <<'HTML'
@@ -711,11 +1527,14 @@ func buildAgentCommandScript(workName string, command, args []string, userPrompt
 
 	return fmt.Sprintf(`set -eu
 WORK=%q
-OUT_DIR="/artifacts/${WORK}"
+OUT_DIR="${NEREID_ARTIFACT_DIR:-/artifacts/${WORK}}"
 LOGS_DIR="${OUT_DIR}/logs"
+ATTEMPT="${NEREID_ATTEMPT:-1}"
+ATTEMPT_LOGS_DIR="${LOGS_DIR}/attempt-${ATTEMPT}"
 SPECIALS_DIR="${OUT_DIR}/specials"
 SPECIALS_SKILLS_DIR="${SPECIALS_DIR}/skills"
-mkdir -p "${OUT_DIR}" "${LOGS_DIR}" "${SPECIALS_SKILLS_DIR}"
+mkdir -p "${OUT_DIR}" "${LOGS_DIR}" "${ATTEMPT_LOGS_DIR}" "${SPECIALS_SKILLS_DIR}"
+date +%%s > "${ATTEMPT_LOGS_DIR}/start-time.txt" || true
 START_TIME_FILE="${LOGS_DIR}/start-time.txt"
 INSTRUCTIONS_CSV="${LOGS_DIR}/instructions.csv"
 if [ ! -s "${START_TIME_FILE}" ]; then
@@ -755,6 +1574,9 @@ set -e
 cp "${OUT_DIR}/agent.log" "${LOGS_DIR}/agent.log" 2>/dev/null || true
 cp "${OUT_DIR}/dialogue.txt" "${LOGS_DIR}/dialogue.txt" 2>/dev/null || true
 cp "${OUT_DIR}/user-input.txt" "${LOGS_DIR}/user-input.txt" 2>/dev/null || true
+cp "${OUT_DIR}/agent.log" "${ATTEMPT_LOGS_DIR}/agent.log" 2>/dev/null || true
+cp "${OUT_DIR}/dialogue.txt" "${ATTEMPT_LOGS_DIR}/dialogue.txt" 2>/dev/null || true
+printf '%%s\n' "${status}" > "${ATTEMPT_LOGS_DIR}/exit-code.txt" || true
 
 if [ ! -f "${OUT_DIR}/index.html" ]; then
   cat > "${OUT_DIR}/index.html" <<'HTML'
@@ -784,123 +1606,66 @@ exit "${status}"
 `, workName, commandTextB64, promptB64, commandLine, workName)
 }
 
-func shellQuote(s string) string {
-	if s == "" {
-		return "''"
-	}
-	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
-}
-
-func (c *Controller) updateWorkStatus(ctx context.Context, work *unstructured.Unstructured, phase, message, artifact string) error {
-	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
-		latest, err := c.dynamic.Resource(workGVR).Namespace(work.GetNamespace()).Get(ctx, work.GetName(), metav1.GetOptions{})
-		if err != nil {
-			return err
-		}
-
-		currentPhase, _, _ := unstructured.NestedString(latest.Object, "status", "phase")
-		currentMessage, _, _ := unstructured.NestedString(latest.Object, "status", "message")
-		currentArtifact, _, _ := unstructured.NestedString(latest.Object, "status", "artifactUrl")
-		if currentPhase == phase && currentMessage == message && currentArtifact == artifact {
-			return nil
-		}
-
-		if err := unstructured.SetNestedField(latest.Object, phase, "status", "phase"); err != nil {
-			return err
-		}
-		if message != "" {
-			if err := unstructured.SetNestedField(latest.Object, message, "status", "message"); err != nil {
-				return err
-			}
-		} else {
-			unstructured.RemoveNestedField(latest.Object, "status", "message")
-		}
-		if artifact != "" {
-			if err := unstructured.SetNestedField(latest.Object, artifact, "status", "artifactUrl"); err != nil {
-				return err
-			}
-		} else {
-			unstructured.RemoveNestedField(latest.Object, "status", "artifactUrl")
-		}
-
-		_, err = c.dynamic.Resource(workGVR).Namespace(work.GetNamespace()).UpdateStatus(ctx, latest, metav1.UpdateOptions{})
-		return err
-	})
+func workArtifactDir(workName string) string {
+	return "/artifacts/" + workName
 }
 
-func phaseFromJob(job *batchv1.Job) (string, string) {
-	if job.Status.Succeeded > 0 {
-		return "Succeeded", "job completed"
-	}
-	if job.Status.Failed > 0 {
-		return "Failed", "job failed"
+// ensureWorkArtifactDir pre-creates the Work's artifact directory so the
+// subPath mount does not leave it to the kubelet, which creates it as root
+// with a restrictive mode. The directory is group-writable and owned by the
+// pod's runAsUser and fsGroup. It is skipped when the controller does not
+// see the artifacts root.
+func (c *Controller) ensureWorkArtifactDir(job *batchv1.Job, workName string) {
+	workDir, ok := c.artifacts().localDir(workName)
+	if !ok {
+		return
 	}
-	if job.Spec.Suspend != nil && *job.Spec.Suspend {
-		return "Queued", "waiting for kueue admission"
+	if info, err := os.Stat(filepath.Dir(workDir)); err != nil || !info.IsDir() {
+		return
 	}
-	if job.Status.Active > 0 {
-		return "Running", "job is running"
+	if err := os.MkdirAll(workDir, 0o775); err != nil {
+		c.logger.Warn("failed to create artifact dir", "work", workName, "path", workDir, "error", err)
+		return
 	}
-	return "Submitted", "job submitted"
-}
-
-func (c *Controller) validateSucceededWorkArtifacts(workName string) (string, error) {
-	root := strings.TrimSpace(c.cfg.ArtifactsHostPath)
-	if root == "" {
-		return "", nil
+	// setgid keeps files created by the pod in the directory's group.
+	if err := os.Chmod(workDir, 0o775|os.ModeSetgid); err != nil {
+		c.logger.Warn("failed to chmod artifact dir", "work", workName, "path", workDir, "error", err)
 	}
-	workDir := filepath.Join(root, workName)
-	indexPath := filepath.Join(workDir, "index.html")
-	info, err := os.Stat(indexPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return "artifact validation failed: index.html not found", nil
-		}
-		return "", fmt.Errorf("stat %q: %w", indexPath, err)
+	uid, gid := jobArtifactOwner(job)
+	if uid < 0 && gid < 0 {
+		return
 	}
-	if info.IsDir() || info.Size() == 0 {
-		return "artifact validation failed: index.html is empty", nil
+	if err := os.Chown(workDir, int(uid), int(gid)); err != nil {
+		c.logger.Warn("failed to chown artifact dir", "work", workName, "path", workDir, "uid", uid, "gid", gid, "error", err)
 	}
+}
 
-	// Detect known runtime fault signatures from agent output files.
-	logPaths := []string{
-		filepath.Join(workDir, "agent.log"),
-		filepath.Join(workDir, "gemini-output.txt"),
-		filepath.Join(workDir, "dialogue.txt"),
-		filepath.Join(workDir, "logs", "agent.log"),
-		filepath.Join(workDir, "logs", "dialogue.txt"),
-	}
-	for _, p := range logPaths {
-		b, readErr := os.ReadFile(p)
-		if readErr != nil {
-			if os.IsNotExist(readErr) {
-				continue
-			}
-			return "", fmt.Errorf("read %q: %w", p, readErr)
+// jobArtifactOwner returns the uid and gid the Job's main container writes
+// artifacts as, or -1 when the Job does not set them.
+func jobArtifactOwner(job *batchv1.Job) (uid, gid int64) {
+	uid, gid = -1, -1
+	spec := job.Spec.Template.Spec
+	if sc := spec.SecurityContext; sc != nil {
+		if sc.RunAsUser != nil {
+			uid = *sc.RunAsUser
+		}
+		if sc.FSGroup != nil {
+			gid = *sc.FSGroup
 		}
-		if signature := detectArtifactRuntimeErrorSignature(string(b)); signature != "" {
-			return "artifact runtime validation failed: " + signature, nil
+	}
+	if len(spec.Containers) > 0 {
+		if sc := spec.Containers[0].SecurityContext; sc != nil && sc.RunAsUser != nil {
+			uid = *sc.RunAsUser
 		}
 	}
-	return "", nil
+	return uid, gid
 }
 
-func detectArtifactRuntimeErrorSignature(text string) string {
-	text = strings.TrimSpace(text)
-	if text == "" {
-		return ""
-	}
-	lower := strings.ToLower(text)
-	switch {
-	case strings.Contains(lower, "cannot read properties of undefined (reading 'lon')"):
-		return "Cannot read properties of undefined (reading 'lon')"
-	case strings.Contains(lower, "cannot read properties of undefined (reading 'lat')"):
-		return "Cannot read properties of undefined (reading 'lat')"
-	case strings.Contains(lower, "typeerror: cannot read properties of undefined"):
-		return "TypeError: cannot read properties of undefined"
-	default:
-		return ""
+func shellQuote(s string) string {
+	if s == "" {
+		return "''"
 	}
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
 }
 
 func makeJobName(workName string) string {
@@ -989,14 +1754,12 @@ func (c *Controller) validateGrantForWork(ctx context.Context, work *unstructure
 	if err != nil {
 		return fmt.Errorf("failed to read grant %q spec.maxUses: %v", grantName, err)
 	}
-	if found && maxUses > 0 {
-		jobs, listErr := c.kube.BatchV1().Jobs(c.cfg.JobNamespace).List(ctx, metav1.ListOptions{
-			LabelSelector: fmt.Sprintf("nereid.yuiseki.net/grant=%s", grantName),
-		})
-		if listErr != nil {
-			return fmt.Errorf("list jobs for grant %q maxUses: %w", grantName, listErr)
+	// This is a cheap early check; reserveGrant enforces the limit.
+	if found && maxUses > 0 && grantReservation(work) != grantName {
+		used, usedErr := c.grantUsed(ctx, grant, work.GetName())
+		if usedErr != nil {
+			return usedErr
 		}
-		used := int64(len(jobs.Items))
 		if used >= maxUses {
 			return fmt.Errorf("grant %q exhausted: maxUses=%d used=%d", grantName, maxUses, used)
 		}
@@ -1016,9 +1779,12 @@ func workUserPrompt(work *unstructured.Unstructured) string {
 	return strings.TrimSpace(annotations[userPromptAnnotationKey])
 }
 
-func (c *Controller) applyGrantToJob(ctx context.Context, job *batchv1.Job, grant *unstructured.Unstructured) error {
+// applyGrantToJob applies Grant overrides to job. When the Grant injects
+// Secret values, it returns the per-Job Secret that must be created next to
+// the Job (see applyGrantEnvSecret).
+func (c *Controller) applyGrantToJob(ctx context.Context, job *batchv1.Job, grant *unstructured.Unstructured) (*corev1.Secret, error) {
 	if job == nil || grant == nil {
-		return nil
+		return nil, nil
 	}
 	grantName := strings.TrimSpace(grant.GetName())
 
@@ -1026,12 +1792,12 @@ func (c *Controller) applyGrantToJob(ctx context.Context, job *batchv1.Job, gran
 		job.Labels = map[string]string{}
 	}
 	if grantName != "" {
-		job.Labels["nereid.yuiseki.net/grant"] = grantName
+		job.Labels[grantLabelKey] = grantName
 	}
 
 	queueName, _, err := unstructured.NestedString(grant.Object, "spec", "kueue", "localQueueName")
 	if err != nil {
-		return fmt.Errorf("failed to read grant %q spec.kueue.localQueueName: %v", grantName, err)
+		return nil, fmt.Errorf("failed to read grant %q spec.kueue.localQueueName: %v", grantName, err)
 	}
 	queueName = strings.TrimSpace(queueName)
 	if queueName != "" {
@@ -1040,7 +1806,7 @@ func (c *Controller) applyGrantToJob(ctx context.Context, job *batchv1.Job, gran
 
 	runtimeClassName, _, err := unstructured.NestedString(grant.Object, "spec", "runtimeClassName")
 	if err != nil {
-		return fmt.Errorf("failed to read grant %q spec.runtimeClassName: %v", grantName, err)
+		return nil, fmt.Errorf("failed to read grant %q spec.runtimeClassName: %v", grantName, err)
 	}
 	runtimeClassName = strings.TrimSpace(runtimeClassName)
 	if runtimeClassName != "" {
@@ -1048,7 +1814,7 @@ func (c *Controller) applyGrantToJob(ctx context.Context, job *batchv1.Job, gran
 	}
 
 	if len(job.Spec.Template.Spec.Containers) == 0 {
-		return fmt.Errorf("job has no containers")
+		return nil, fmt.Errorf("job has no containers")
 	}
 	container := &job.Spec.Template.Spec.Containers[0]
 	if container.Resources.Requests == nil {
@@ -1060,53 +1826,54 @@ func (c *Controller) applyGrantToJob(ctx context.Context, job *batchv1.Job, gran
 
 	reqCPU, _, err := nestedStringAny(grant.Object, "spec", "resources", "requests", "cpu")
 	if err != nil {
-		return fmt.Errorf("failed to read grant %q spec.resources.requests.cpu: %v", grantName, err)
+		return nil, fmt.Errorf("failed to read grant %q spec.resources.requests.cpu: %v", grantName, err)
 	}
 	reqMem, _, err := nestedStringAny(grant.Object, "spec", "resources", "requests", "memory")
 	if err != nil {
-		return fmt.Errorf("failed to read grant %q spec.resources.requests.memory: %v", grantName, err)
+		return nil, fmt.Errorf("failed to read grant %q spec.resources.requests.memory: %v", grantName, err)
 	}
 	limCPU, _, err := nestedStringAny(grant.Object, "spec", "resources", "limits", "cpu")
 	if err != nil {
-		return fmt.Errorf("failed to read grant %q spec.resources.limits.cpu: %v", grantName, err)
+		return nil, fmt.Errorf("failed to read grant %q spec.resources.limits.cpu: %v", grantName, err)
 	}
 	limMem, _, err := nestedStringAny(grant.Object, "spec", "resources", "limits", "memory")
 	if err != nil {
-		return fmt.Errorf("failed to read grant %q spec.resources.limits.memory: %v", grantName, err)
+		return nil, fmt.Errorf("failed to read grant %q spec.resources.limits.memory: %v", grantName, err)
 	}
 
 	if strings.TrimSpace(reqCPU) != "" {
 		q, parseErr := resource.ParseQuantity(reqCPU)
 		if parseErr != nil {
-			return fmt.Errorf("grant %q invalid spec.resources.requests.cpu=%q: %v", grantName, reqCPU, parseErr)
+			return nil, fmt.Errorf("grant %q invalid spec.resources.requests.cpu=%q: %v", grantName, reqCPU, parseErr)
 		}
 		container.Resources.Requests[corev1.ResourceCPU] = q
 	}
 	if strings.TrimSpace(reqMem) != "" {
 		q, parseErr := resource.ParseQuantity(reqMem)
 		if parseErr != nil {
-			return fmt.Errorf("grant %q invalid spec.resources.requests.memory=%q: %v", grantName, reqMem, parseErr)
+			return nil, fmt.Errorf("grant %q invalid spec.resources.requests.memory=%q: %v", grantName, reqMem, parseErr)
 		}
 		container.Resources.Requests[corev1.ResourceMemory] = q
 	}
 	if strings.TrimSpace(limCPU) != "" {
 		q, parseErr := resource.ParseQuantity(limCPU)
 		if parseErr != nil {
-			return fmt.Errorf("grant %q invalid spec.resources.limits.cpu=%q: %v", grantName, limCPU, parseErr)
+			return nil, fmt.Errorf("grant %q invalid spec.resources.limits.cpu=%q: %v", grantName, limCPU, parseErr)
 		}
 		container.Resources.Limits[corev1.ResourceCPU] = q
 	}
 	if strings.TrimSpace(limMem) != "" {
 		q, parseErr := resource.ParseQuantity(limMem)
 		if parseErr != nil {
-			return fmt.Errorf("grant %q invalid spec.resources.limits.memory=%q: %v", grantName, limMem, parseErr)
+			return nil, fmt.Errorf("grant %q invalid spec.resources.limits.memory=%q: %v", grantName, limMem, parseErr)
 		}
 		container.Resources.Limits[corev1.ResourceMemory] = q
 	}
 
-	envVars, err := grantEnvVars(ctx, c.kube, grant)
+	secretName := grantEnvSecretName(job.Name)
+	envVars, secretData, err := grantEnvVars(ctx, c.kube, grant, secretName)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if len(envVars) > 0 {
 		// Override by name to avoid duplicates.
@@ -1123,33 +1890,111 @@ func (c *Controller) applyGrantToJob(ctx context.Context, job *batchv1.Job, gran
 		container.Env = append(existing, envVars...)
 	}
 
+	if secretData == nil {
+		return nil, nil
+	}
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        secretName,
+			Namespace:   job.Namespace,
+			Labels:      map[string]string{workLabelKey: job.Labels[workLabelKey]},
+			Annotations: map[string]string{grantLabelKey: grantName},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: secretData,
+	}, nil
+}
+
+func grantEnvSecretName(jobName string) string {
+	return jobName + "-env"
+}
+
+// applyGrantEnvSecret stores the Grant's Secret-backed env values next to
+// the Job before the Job is created. An existing Secret keeps its owner.
+func (c *Controller) applyGrantEnvSecret(ctx context.Context, secret *corev1.Secret) error {
+	secret = secret.DeepCopy()
+	secret.Namespace = c.cfg.JobNamespace
+	secrets := c.kube.CoreV1().Secrets(secret.Namespace)
+	_, err := secrets.Create(ctx, secret, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		var existing *corev1.Secret
+		existing, err = secrets.Get(ctx, secret.Name, metav1.GetOptions{})
+		if err == nil {
+			existing.Labels = secret.Labels
+			existing.Annotations = secret.Annotations
+			existing.Data = secret.Data
+			_, err = secrets.Update(ctx, existing, metav1.UpdateOptions{})
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("apply env secret %s/%s: %w", secret.Namespace, secret.Name, err)
+	}
 	return nil
 }
 
-func grantEnvVars(ctx context.Context, kube kubernetes.Interface, grant *unstructured.Unstructured) ([]corev1.EnvVar, error) {
+// ownGrantEnvSecret makes the Job own its env Secret, so the Secret goes away
+// with the Job; it is also deleted as soon as the Job finishes. A Job whose
+// Secret cannot be adopted is deleted so the next reconcile starts over.
+func (c *Controller) ownGrantEnvSecret(ctx context.Context, job *batchv1.Job) error {
+	secrets := c.kube.CoreV1().Secrets(job.Namespace)
+	secret, err := secrets.Get(ctx, grantEnvSecretName(job.Name), metav1.GetOptions{})
+	if err == nil {
+		secret.OwnerReferences = []metav1.OwnerReference{{
+			APIVersion: "batch/v1",
+			Kind:       "Job",
+			Name:       job.Name,
+			UID:        job.UID,
+			Controller: boolPtr(true),
+		}}
+		_, err = secrets.Update(ctx, secret, metav1.UpdateOptions{})
+	}
+	if err == nil {
+		return nil
+	}
+
+	propagation := metav1.DeletePropagationForeground
+	if delErr := c.kube.BatchV1().Jobs(job.Namespace).Delete(ctx, job.Name, metav1.DeleteOptions{PropagationPolicy: &propagation}); delErr != nil && !apierrors.IsNotFound(delErr) {
+		c.logger.Warn("failed to delete job after env secret error", "job", job.Name, "error", delErr)
+	}
+	return fmt.Errorf("own env secret for job %s/%s: %w", job.Namespace, job.Name, err)
+}
+
+func (c *Controller) deleteGrantEnvSecret(ctx context.Context, jobName string) error {
+	err := c.kube.CoreV1().Secrets(c.cfg.JobNamespace).Delete(ctx, grantEnvSecretName(jobName), metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("delete env secret for job %s/%s: %w", c.cfg.JobNamespace, jobName, err)
+	}
+	return nil
+}
+
+// grantEnvVars resolves Grant spec.env. Secret-backed entries are not inlined:
+// their values are returned in secretData, keyed by env name, for the mirrored
+// Secret named secretName, and the env var refers to it with valueFrom.
+func grantEnvVars(ctx context.Context, kube kubernetes.Interface, grant *unstructured.Unstructured, secretName string) ([]corev1.EnvVar, map[string][]byte, error) {
 	if grant == nil {
-		return nil, nil
+		return nil, nil, nil
 	}
 	grantName := grant.GetName()
 	grantNamespace := strings.TrimSpace(grant.GetNamespace())
 	raw, found, err := unstructured.NestedSlice(grant.Object, "spec", "env")
 	if err != nil {
-		return nil, fmt.Errorf("failed to read grant %q spec.env: %v", grantName, err)
+		return nil, nil, fmt.Errorf("failed to read grant %q spec.env: %v", grantName, err)
 	}
 	if !found || len(raw) == 0 {
-		return nil, nil
+		return nil, nil, nil
 	}
 
 	out := make([]corev1.EnvVar, 0, len(raw))
+	secretData := map[string][]byte{}
 	for i, item := range raw {
 		m, ok := item.(map[string]interface{})
 		if !ok {
-			return nil, fmt.Errorf("grant %q spec.env[%d] must be an object", grantName, i)
+			return nil, nil, fmt.Errorf("grant %q spec.env[%d] must be an object", grantName, i)
 		}
 		name, _ := m["name"].(string)
 		name = strings.TrimSpace(name)
 		if name == "" {
-			return nil, fmt.Errorf("grant %q spec.env[%d].name is required", grantName, i)
+			return nil, nil, fmt.Errorf("grant %q spec.env[%d].name is required", grantName, i)
 		}
 
 		if v, ok := m["value"].(string); ok {
@@ -1163,7 +2008,7 @@ func grantEnvVars(ctx context.Context, kube kubernetes.Interface, grant *unstruc
 			sec = strings.TrimSpace(sec)
 			key = strings.TrimSpace(key)
 			if sec == "" || key == "" {
-				return nil, fmt.Errorf("grant %q spec.env[%d].secretKeyRef.name and key are required", grantName, i)
+				return nil, nil, fmt.Errorf("grant %q spec.env[%d].secretKeyRef.name and key are required", grantName, i)
 			}
 
 			optional := false
@@ -1172,39 +2017,51 @@ func grantEnvVars(ctx context.Context, kube kubernetes.Interface, grant *unstruc
 			}
 
 			if kube == nil {
-				return nil, fmt.Errorf("grant %q requires secretKeyRef for env %q, but kube client is nil", grantName, name)
+				return nil, nil, fmt.Errorf("grant %q requires secretKeyRef for env %q, but kube client is nil", grantName, name)
 			}
 			if grantNamespace == "" {
-				return nil, fmt.Errorf("grant %q namespace is required to resolve secretKeyRef for env %q", grantName, name)
+				return nil, nil, fmt.Errorf("grant %q namespace is required to resolve secretKeyRef for env %q", grantName, name)
 			}
 			secret, getErr := kube.CoreV1().Secrets(grantNamespace).Get(ctx, sec, metav1.GetOptions{})
 			if getErr != nil {
 				if apierrors.IsNotFound(getErr) && optional {
 					continue
 				}
-				return nil, fmt.Errorf("grant %q env %q get secret %s/%s failed: %v", grantName, name, grantNamespace, sec, getErr)
+				return nil, nil, fmt.Errorf("grant %q env %q get secret %s/%s failed: %v", grantName, name, grantNamespace, sec, getErr)
 			}
 			if secret.Data == nil {
 				if optional {
 					continue
 				}
-				return nil, fmt.Errorf("grant %q env %q secret %s/%s has no data", grantName, name, grantNamespace, sec)
+				return nil, nil, fmt.Errorf("grant %q env %q secret %s/%s has no data", grantName, name, grantNamespace, sec)
 			}
 			v, ok := secret.Data[key]
 			if !ok {
 				if optional {
 					continue
 				}
-				return nil, fmt.Errorf("grant %q env %q secret %s/%s missing key %q", grantName, name, grantNamespace, sec, key)
+				return nil, nil, fmt.Errorf("grant %q env %q secret %s/%s missing key %q", grantName, name, grantNamespace, sec, key)
 			}
 
-			out = append(out, corev1.EnvVar{Name: name, Value: string(v)})
+			secretData[name] = v
+			out = append(out, corev1.EnvVar{
+				Name: name,
+				ValueFrom: &corev1.EnvVarSource{
+					SecretKeyRef: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: secretName},
+						Key:                  name,
+					},
+				},
+			})
 			continue
 		}
 
-		return nil, fmt.Errorf("grant %q spec.env[%d] must set value or secretKeyRef", grantName, i)
+		return nil, nil, fmt.Errorf("grant %q spec.env[%d] must set value or secretKeyRef", grantName, i)
+	}
+	if len(secretData) == 0 {
+		secretData = nil
 	}
-	return out, nil
+	return out, secretData, nil
 }
 
 func extractDeadlineSeconds(work *unstructured.Unstructured) int64 {
@@ -1269,6 +2126,9 @@ func extractTileZoomRange(work *unstructured.Unstructured) (minZoom, maxZoom int
 	if minZoom < 0 {
 		minZoom = 0
 	}
+	if minZoom > 24 {
+		minZoom = 24
+	}
 	if maxZoom < minZoom {
 		maxZoom = minZoom
 	}
@@ -1376,40 +2236,6 @@ func mustParseQuantity(v string) resource.Quantity {
 	return resource.MustParse(v)
 }
 
-func (c *Controller) pruneArtifacts() error {
-	if c.cfg.ArtifactsHostPath == "" || c.cfg.ArtifactRetention <= 0 {
-		return nil
-	}
-
-	entries, err := os.ReadDir(c.cfg.ArtifactsHostPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil
-		}
-		return fmt.Errorf("read artifacts root %q: %w", c.cfg.ArtifactsHostPath, err)
-	}
-
-	cutoff := c.nowFunc().Add(-c.cfg.ArtifactRetention)
-	for _, entry := range entries {
-		path := filepath.Join(c.cfg.ArtifactsHostPath, entry.Name())
-		info, infoErr := entry.Info()
-		if infoErr != nil {
-			c.logger.Warn("skip artifact entry due to stat error", "path", path, "error", infoErr)
-			continue
-		}
-		if info.ModTime().After(cutoff) {
-			continue
-		}
-
-		if removeErr := os.RemoveAll(path); removeErr != nil {
-			c.logger.Warn("failed to remove expired artifact entry", "path", path, "error", removeErr)
-			continue
-		}
-		c.logger.Info("pruned expired artifact entry", "path", path, "modTime", info.ModTime(), "retention", c.cfg.ArtifactRetention)
-	}
-	return nil
-}
-
 func sanitizeDNSLabel(v string) string {
 	v = strings.ToLower(v)
 	var b strings.Builder
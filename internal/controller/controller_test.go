@@ -3,17 +3,24 @@ package controller
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"testing"
 	"time"
 
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
 	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 )
 
 func TestMakeJobNameStableAndBounded(t *testing.T) {
@@ -102,7 +109,7 @@ func TestPruneArtifactsRemovesEntriesOlderThanRetention(t *testing.T) {
 		nowFunc: func() time.Time { return now },
 	}
 
-	if err := c.pruneArtifacts(); err != nil {
+	if err := c.pruneArtifacts(context.Background()); err != nil {
 		t.Fatalf("pruneArtifacts() error = %v", err)
 	}
 
@@ -127,10 +134,11 @@ func TestValidateSucceededWorkArtifactsFailsWhenIndexMissing(t *testing.T) {
 		},
 	}
 
-	msg, err := c.validateSucceededWorkArtifacts(workName)
+	validation, err := c.validateSucceededWorkArtifacts(context.Background(), artifactTestWork(workName, "agent.cli.v1"))
 	if err != nil {
 		t.Fatalf("validateSucceededWorkArtifacts() error = %v", err)
 	}
+	msg := validation.message()
 	if !strings.Contains(msg, "index.html not found") {
 		t.Fatalf("validateSucceededWorkArtifacts() msg=%q want contains %q", msg, "index.html not found")
 	}
@@ -156,10 +164,11 @@ func TestValidateSucceededWorkArtifactsFailsOnRuntimeSignature(t *testing.T) {
 		},
 	}
 
-	msg, err := c.validateSucceededWorkArtifacts(workName)
+	validation, err := c.validateSucceededWorkArtifacts(context.Background(), artifactTestWork(workName, "agent.cli.v1"))
 	if err != nil {
 		t.Fatalf("validateSucceededWorkArtifacts() error = %v", err)
 	}
+	msg := validation.message()
 	if !strings.Contains(msg, "reading 'lon'") {
 		t.Fatalf("validateSucceededWorkArtifacts() msg=%q want runtime signature", msg)
 	}
@@ -185,10 +194,11 @@ func TestValidateSucceededWorkArtifactsPassesWhenNoKnownRuntimeSignature(t *test
 		},
 	}
 
-	msg, err := c.validateSucceededWorkArtifacts(workName)
+	validation, err := c.validateSucceededWorkArtifacts(context.Background(), artifactTestWork(workName, "agent.cli.v1"))
 	if err != nil {
 		t.Fatalf("validateSucceededWorkArtifacts() error = %v", err)
 	}
+	msg := validation.message()
 	if msg != "" {
 		t.Fatalf("validateSucceededWorkArtifacts() msg=%q want empty", msg)
 	}
@@ -231,7 +241,7 @@ func TestBuildJobLegacyKindsBridgeToGeminiAgent(t *testing.T) {
 				},
 			}
 
-			job, err := c.buildJob(work, "work-legacy-kind-sample", legacyKind)
+			job, err := c.buildJob(work, "work-legacy-kind-sample", legacyKind, executorAgent)
 			if err != nil {
 				t.Fatalf("buildJob() error = %v", err)
 			}
@@ -371,7 +381,7 @@ func TestBuildJobAgentCLIGeneratesCommandWrapperScript(t *testing.T) {
 		},
 	}
 
-	job, err := c.buildJob(work, "work-agent-cli-sample", "agent.cli.v1")
+	job, err := c.buildJob(work, "work-agent-cli-sample", "agent.cli.v1", "")
 	if err != nil {
 		t.Fatalf("buildJob() error = %v", err)
 	}
@@ -402,6 +412,71 @@ func TestBuildJobAgentCLIGeneratesCommandWrapperScript(t *testing.T) {
 	}
 }
 
+func TestBuildScriptJobMountsOnlyWorkArtifactDir(t *testing.T) {
+	root := t.TempDir()
+	work := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "isolated", "namespace": "nereid"},
+	}}
+	c := &Controller{cfg: Config{JobNamespace: "nereid-work", ArtifactsHostPath: root}, logger: slog.Default()}
+
+	job := c.buildScriptJob(work, "work-isolated", "busybox", "true")
+	container := job.Spec.Template.Spec.Containers[0]
+	if len(container.VolumeMounts) != 1 || container.VolumeMounts[0].MountPath != "/artifacts/isolated" || container.VolumeMounts[0].SubPath != "isolated" {
+		t.Fatalf("artifact mount mismatch: %#v", container.VolumeMounts)
+	}
+	if got := job.Spec.Template.Spec.Volumes[0].HostPath.Path; got != root {
+		t.Fatalf("hostPath got=%q want=%q", got, root)
+	}
+	env := map[string]string{}
+	for _, ev := range container.Env {
+		env[ev.Name] = ev.Value
+	}
+	if env["NEREID_WORK_NAME"] != "isolated" || env["NEREID_ARTIFACT_DIR"] != "/artifacts/isolated" {
+		t.Fatalf("artifact env mismatch: %#v", container.Env)
+	}
+
+	c.ensureWorkArtifactDir(job, "isolated")
+	info, err := os.Stat(filepath.Join(root, "isolated"))
+	if err != nil || !info.IsDir() || info.Mode().Perm() != 0o775 || info.Mode()&os.ModeSetgid == 0 {
+		t.Fatalf("work artifact dir should be pre-created group-writable with setgid: info=%v err=%v", info, err)
+	}
+	sc := job.Spec.Template.Spec.SecurityContext
+	if sc == nil || sc.FSGroup == nil || *sc.FSGroup != defaultArtifactsFSGroup || sc.FSGroupChangePolicy == nil {
+		t.Fatalf("job should get the default artifacts fsGroup: %#v", sc)
+	}
+	if uid, gid := jobArtifactOwner(job); uid != -1 || gid != defaultArtifactsFSGroup {
+		t.Fatalf("jobArtifactOwner() = %d, %d", uid, gid)
+	}
+}
+
+func TestEnsureWorkArtifactDirKeepsPodUserAndFSGroup(t *testing.T) {
+	root := t.TempDir()
+	work := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "owned", "namespace": "nereid"},
+	}}
+	c := &Controller{cfg: Config{JobNamespace: "nereid-work", ArtifactsHostPath: root, ArtifactsFSGroup: 2000}, logger: slog.Default()}
+	job := c.buildScriptJob(work, "work-owned", "busybox", "true")
+	if sc := job.Spec.Template.Spec.SecurityContext; sc == nil || sc.FSGroup == nil || *sc.FSGroup != 2000 {
+		t.Fatalf("job should get the configured fsGroup: %#v", sc)
+	}
+	uid, gid := int64(os.Geteuid()), int64(os.Getegid())
+	job.Spec.Template.Spec.SecurityContext = &corev1.PodSecurityContext{FSGroup: &gid}
+	job.Spec.Template.Spec.Containers[0].SecurityContext = &corev1.SecurityContext{RunAsUser: &uid}
+
+	c.ensureWorkArtifactDir(job, "owned")
+	info, err := os.Stat(filepath.Join(root, "owned"))
+	if err != nil || info.Mode().Perm() != 0o775 {
+		t.Fatalf("work artifact dir mode: info=%v err=%v", info, err)
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok && (int64(st.Uid) != uid || int64(st.Gid) != gid) {
+		t.Fatalf("work artifact dir owner got=%d:%d want=%d:%d", st.Uid, st.Gid, uid, gid)
+	}
+	sc := job.Spec.Template.Spec.SecurityContext
+	if *sc.FSGroup != gid || sc.FSGroupChangePolicy != nil {
+		t.Fatalf("existing fsGroup should be kept: %#v", sc)
+	}
+}
+
 func TestBuildJobAgentCLIRequiresImage(t *testing.T) {
 	work := &unstructured.Unstructured{Object: map[string]interface{}{
 		"metadata": map[string]interface{}{
@@ -425,7 +500,7 @@ func TestBuildJobAgentCLIRequiresImage(t *testing.T) {
 		},
 	}
 
-	_, err := c.buildJob(work, "work-agent-cli-invalid", "agent.cli.v1")
+	_, err := c.buildJob(work, "work-agent-cli-invalid", "agent.cli.v1", "")
 	if err == nil {
 		t.Fatal("buildJob() expected error for missing image, got nil")
 	}
@@ -454,7 +529,7 @@ func TestBuildJobUnsupportedKindReturnsError(t *testing.T) {
 		},
 	}
 
-	_, err := c.buildJob(work, "work-unknown-kind", "unknown.kind.v1")
+	_, err := c.buildJob(work, "work-unknown-kind", "unknown.kind.v1", "")
 	if err == nil {
 		t.Fatal("buildJob() expected error for unsupported kind, got nil")
 	}
@@ -497,7 +572,7 @@ func TestApplyGrantToJobOverridesQueueRuntimeResourcesAndEnv(t *testing.T) {
 		}),
 	}
 
-	job, err := c.buildJob(work, "work-overpass-sample", "overpassql.map.v1")
+	job, err := c.buildJob(work, "work-overpass-sample", "overpassql.map.v1", "")
 	if err != nil {
 		t.Fatalf("buildJob() error = %v", err)
 	}
@@ -536,7 +611,8 @@ func TestApplyGrantToJobOverridesQueueRuntimeResourcesAndEnv(t *testing.T) {
 		},
 	}}
 
-	if err := c.applyGrantToJob(context.Background(), job, grant); err != nil {
+	envSecret, err := c.applyGrantToJob(context.Background(), job, grant)
+	if err != nil {
 		t.Fatalf("applyGrantToJob() error = %v", err)
 	}
 
@@ -571,16 +647,165 @@ func TestApplyGrantToJobOverridesQueueRuntimeResourcesAndEnv(t *testing.T) {
 			continue
 		}
 		found = true
-		if ev.ValueFrom != nil {
-			t.Fatalf("OPENAI_API_KEY should be resolved to a literal value, got=%+v", ev)
+		if ev.Value != "" || ev.ValueFrom == nil || ev.ValueFrom.SecretKeyRef == nil {
+			t.Fatalf("OPENAI_API_KEY should reference the mirrored secret, got=%+v", ev)
 		}
-		if ev.Value != "secret-value" {
-			t.Fatalf("OPENAI_API_KEY value mismatch got=%q want=%q", ev.Value, "secret-value")
+		if ref := ev.ValueFrom.SecretKeyRef; ref.Name != "work-overpass-sample-env" || ref.Key != "OPENAI_API_KEY" {
+			t.Fatalf("OPENAI_API_KEY secretKeyRef mismatch got=%+v", ref)
 		}
 	}
 	if !found {
 		t.Fatal("OPENAI_API_KEY env not injected")
 	}
+	if envSecret == nil || envSecret.Name != "work-overpass-sample-env" || envSecret.Namespace != "nereid-work" {
+		t.Fatalf("mirrored secret mismatch got=%+v", envSecret)
+	}
+	if got := string(envSecret.Data["OPENAI_API_KEY"]); got != "secret-value" {
+		t.Fatalf("mirrored secret value mismatch got=%q want=%q", got, "secret-value")
+	}
+}
+
+func TestReconcileWorkMirrorsGrantSecretAndDeletesItWhenJobFinishes(t *testing.T) {
+	work := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "nereid.yuiseki.net/v1alpha1",
+		"kind":       "Work",
+		"metadata":   map[string]interface{}{"name": "mirrored", "namespace": "nereid"},
+		"spec": map[string]interface{}{
+			"kind":     "agent.cli.v1",
+			"title":    "mirrored",
+			"grantRef": map[string]interface{}{"name": "g1"},
+			"agent":    map[string]interface{}{"image": "busybox", "script": "true"},
+		},
+	}}
+	grant := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "nereid.yuiseki.net/v1alpha1",
+		"kind":       "Grant",
+		"metadata":   map[string]interface{}{"name": "g1", "namespace": "nereid"},
+		"spec": map[string]interface{}{
+			"env": []interface{}{
+				map[string]interface{}{
+					"name":         "GEMINI_API_KEY",
+					"secretKeyRef": map[string]interface{}{"name": "gemini", "key": "api-key"},
+				},
+			},
+		},
+	}}
+	dc := newFakeDynamicClient(work, grant)
+	kc := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "gemini", Namespace: "nereid"},
+		Data:       map[string][]byte{"api-key": []byte("secret-value")},
+	})
+	c := &Controller{
+		dynamic: dc,
+		kube:    kc,
+		cfg:     Config{JobNamespace: "nereid-work"},
+		logger:  slog.Default(),
+		nowFunc: time.Now,
+	}
+
+	ctx := context.Background()
+	if err := c.reconcileWork(ctx, work); err != nil {
+		t.Fatalf("reconcileWork(create) error = %v", err)
+	}
+	job, err := kc.BatchV1().Jobs("nereid-work").Get(ctx, "work-mirrored", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	for _, ev := range job.Spec.Template.Spec.Containers[0].Env {
+		if ev.Name == "GEMINI_API_KEY" && ev.Value != "" {
+			t.Fatal("secret value must not be inlined into the job spec")
+		}
+	}
+	secret, err := kc.CoreV1().Secrets("nereid-work").Get(ctx, "work-mirrored-env", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("mirrored secret not created: %v", err)
+	}
+	if string(secret.Data["GEMINI_API_KEY"]) != "secret-value" {
+		t.Fatalf("mirrored secret data mismatch: %v", secret.Data)
+	}
+	if len(secret.OwnerReferences) != 1 || secret.OwnerReferences[0].Kind != "Job" || secret.OwnerReferences[0].Name != "work-mirrored" {
+		t.Fatalf("mirrored secret should be owned by the job: %#v", secret.OwnerReferences)
+	}
+	secretCreated, jobCreated := -1, -1
+	for i, action := range kc.Actions() {
+		if action.GetVerb() != "create" {
+			continue
+		}
+		switch action.GetResource().Resource {
+		case "secrets":
+			secretCreated = i
+		case "jobs":
+			jobCreated = i
+		}
+	}
+	if secretCreated < 0 || secretCreated > jobCreated {
+		t.Fatalf("mirrored secret should be created before the job: secret=%d job=%d", secretCreated, jobCreated)
+	}
+
+	job.Status.Succeeded = 1
+	if _, err := kc.BatchV1().Jobs("nereid-work").UpdateStatus(ctx, job, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("update job status: %v", err)
+	}
+	latest, err := dc.Resource(workGVR).Namespace("nereid").Get(ctx, "mirrored", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get work: %v", err)
+	}
+	if err := c.reconcileWork(ctx, latest); err != nil {
+		t.Fatalf("reconcileWork(finished) error = %v", err)
+	}
+	if _, err := kc.CoreV1().Secrets("nereid-work").Get(ctx, "work-mirrored-env", metav1.GetOptions{}); err == nil {
+		t.Fatal("mirrored secret should be deleted once the job finished")
+	}
+}
+
+func TestReconcileWorkDoesNotCreateJobWhenEnvSecretFails(t *testing.T) {
+	work := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "nereid.yuiseki.net/v1alpha1",
+		"kind":       "Work",
+		"metadata":   map[string]interface{}{"name": "mirrored", "namespace": "nereid"},
+		"spec": map[string]interface{}{
+			"kind":     "agent.cli.v1",
+			"title":    "mirrored",
+			"grantRef": map[string]interface{}{"name": "g1"},
+			"agent":    map[string]interface{}{"image": "busybox", "script": "true"},
+		},
+	}}
+	grant := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "nereid.yuiseki.net/v1alpha1",
+		"kind":       "Grant",
+		"metadata":   map[string]interface{}{"name": "g1", "namespace": "nereid"},
+		"spec": map[string]interface{}{
+			"env": []interface{}{
+				map[string]interface{}{
+					"name":         "GEMINI_API_KEY",
+					"secretKeyRef": map[string]interface{}{"name": "gemini", "key": "api-key"},
+				},
+			},
+		},
+	}}
+	dc := newFakeDynamicClient(work, grant)
+	kc := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "gemini", Namespace: "nereid"},
+		Data:       map[string][]byte{"api-key": []byte("secret-value")},
+	})
+	kc.PrependReactor("create", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("secrets are unavailable")
+	})
+	c := &Controller{
+		dynamic: dc,
+		kube:    kc,
+		cfg:     Config{JobNamespace: "nereid-work"},
+		logger:  slog.Default(),
+		nowFunc: time.Now,
+	}
+
+	ctx := context.Background()
+	if err := c.reconcileWork(ctx, work); err == nil {
+		t.Fatal("reconcileWork should fail when the env secret cannot be created")
+	}
+	if _, err := kc.BatchV1().Jobs("nereid-work").Get(ctx, "work-mirrored", metav1.GetOptions{}); err == nil {
+		t.Fatal("job must not be created without its env secret")
+	}
 }
 
 func TestExtractViewportDefaultsAndOverrides(t *testing.T) {
@@ -629,4 +854,228 @@ func TestExtractTileZoomRangeDefaultsAndBounds(t *testing.T) {
 	if minZoom != 0 || maxZoom != 24 {
 		t.Fatalf("bounds mismatch min=%d max=%d", minZoom, maxZoom)
 	}
+
+	workHighMin := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"raster": map[string]interface{}{
+				"tiles": map[string]interface{}{
+					"minZoom": 30,
+				},
+			},
+		},
+	}}
+	minZoom, maxZoom = extractTileZoomRange(workHighMin)
+	if minZoom != 24 || maxZoom != 24 {
+		t.Fatalf("minZoom above 24 should be clamped min=%d max=%d", minZoom, maxZoom)
+	}
+}
+
+func TestWorkKeyForJob(t *testing.T) {
+	tests := []struct {
+		name     string
+		job      *batchv1.Job
+		fallback string
+		want     string
+	}{
+		{
+			name: "label and namespace annotation",
+			job: &batchv1.Job{ObjectMeta: metav1.ObjectMeta{
+				Labels:      map[string]string{workLabelKey: "w1"},
+				Annotations: map[string]string{workNamespaceAnnotationKey: "team-a"},
+			}},
+			fallback: "nereid",
+			want:     "team-a/w1",
+		},
+		{
+			name: "falls back to configured work namespace",
+			job: &batchv1.Job{ObjectMeta: metav1.ObjectMeta{
+				Labels: map[string]string{workLabelKey: "w2"},
+			}},
+			fallback: "nereid",
+			want:     "nereid/w2",
+		},
+		{
+			name:     "unlabeled job",
+			job:      &batchv1.Job{},
+			fallback: "nereid",
+			want:     "",
+		},
+	}
+	for _, tt := range tests {
+		if got := workKeyForJob(tt.job, tt.fallback); got != tt.want {
+			t.Fatalf("%s: workKeyForJob()=%q want=%q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRunReconcilesWorkFromJobEvents(t *testing.T) {
+	root := t.TempDir()
+	workDir := filepath.Join(root, "watched-work")
+	if err := os.MkdirAll(workDir, 0o755); err != nil {
+		t.Fatalf("mkdir work dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(workDir, "index.html"), []byte("<!doctype html><html><body>ok</body></html>"), 0o644); err != nil {
+		t.Fatalf("write index.html: %v", err)
+	}
+
+	work := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "nereid.yuiseki.net/v1alpha1",
+		"kind":       "Work",
+		"metadata": map[string]interface{}{
+			"name":      "watched-work",
+			"namespace": "nereid",
+		},
+		"spec": map[string]interface{}{
+			"kind":  "agent.cli.v1",
+			"title": "watched",
+			"agent": map[string]interface{}{
+				"image":  "node:22-bookworm-slim",
+				"script": "echo hello",
+			},
+		},
+	}}
+	dc := newFakeDynamicClient(work)
+	kc := fake.NewSimpleClientset()
+
+	c := New(dc, kc, Config{
+		WorkNamespace:     "nereid",
+		JobNamespace:      "nereid-work",
+		LocalQueueName:    "nereid-localq",
+		ArtifactsHostPath: root,
+		ArtifactBaseURL:   "https://artifacts.example",
+		ResyncInterval:    time.Hour,
+	}, slog.Default())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = c.Run(ctx) }()
+
+	jobName := makeJobName("watched-work")
+	var job *batchv1.Job
+	waitFor(t, "job creation", func() bool {
+		got, err := kc.BatchV1().Jobs("nereid-work").Get(ctx, jobName, metav1.GetOptions{})
+		if err != nil {
+			return false
+		}
+		job = got
+		return true
+	})
+	waitFor(t, "Queued phase", func() bool {
+		return workPhase(ctx, t, dc, "nereid", "watched-work") == "Queued"
+	})
+
+	job.Status.Succeeded = 1
+	if _, err := kc.BatchV1().Jobs("nereid-work").UpdateStatus(ctx, job, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("update job status: %v", err)
+	}
+	waitFor(t, "Succeeded phase", func() bool {
+		return workPhase(ctx, t, dc, "nereid", "watched-work") == "Succeeded"
+	})
+}
+
+func newFakeDynamicClient(objects ...runtime.Object) *dynamicfake.FakeDynamicClient {
+	return dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		workGVR:            "WorkList",
+		grantGVR:           "GrantList",
+		kueueWorkloadGVR:   "WorkloadList",
+		kueueLocalQueueGVR: "LocalQueueList",
+	}, objects...)
+}
+
+func workPhase(ctx context.Context, t *testing.T, dc *dynamicfake.FakeDynamicClient, namespace, name string) string {
+	t.Helper()
+	obj, err := dc.Resource(workGVR).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get work: %v", err)
+	}
+	phase, _, _ := unstructured.NestedString(obj.Object, "status", "phase")
+	return phase
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func TestReconcileWorkCancelDeletesJobAndKeepsArtifacts(t *testing.T) {
+	root := t.TempDir()
+	workDir := filepath.Join(root, "cancel-me")
+	if err := os.MkdirAll(workDir, 0o755); err != nil {
+		t.Fatalf("mkdir work dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(workDir, "agent.log"), []byte("partial output\n"), 0o644); err != nil {
+		t.Fatalf("write agent.log: %v", err)
+	}
+
+	work := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "nereid.yuiseki.net/v1alpha1",
+		"kind":       "Work",
+		"metadata": map[string]interface{}{
+			"name":      "cancel-me",
+			"namespace": "nereid",
+		},
+		"spec": map[string]interface{}{
+			"kind":   "agent.cli.v1",
+			"title":  "cancel",
+			"cancel": true,
+		},
+		"status": map[string]interface{}{
+			"phase":       "Running",
+			"artifactUrl": "https://artifacts.example/cancel-me/",
+		},
+	}}
+	dc := newFakeDynamicClient(work)
+	kc := fake.NewSimpleClientset(&batchv1.Job{ObjectMeta: metav1.ObjectMeta{
+		Name:      makeJobName("cancel-me"),
+		Namespace: "nereid-work",
+		Labels:    map[string]string{workLabelKey: "cancel-me"},
+	}})
+	now := time.Date(2026, 2, 15, 12, 0, 0, 0, time.UTC)
+	c := &Controller{
+		dynamic: dc,
+		kube:    kc,
+		cfg: Config{
+			JobNamespace:      "nereid-work",
+			ArtifactsHostPath: root,
+		},
+		logger:  slog.Default(),
+		nowFunc: func() time.Time { return now },
+	}
+
+	ctx := context.Background()
+	if err := c.reconcileWork(ctx, work); err != nil {
+		t.Fatalf("reconcileWork(first) error = %v", err)
+	}
+	if _, err := kc.BatchV1().Jobs("nereid-work").Get(ctx, makeJobName("cancel-me"), metav1.GetOptions{}); err == nil {
+		t.Fatal("job should be deleted after cancel")
+	}
+	if got := workPhase(ctx, t, dc, "nereid", "cancel-me"); got != "Canceling" {
+		t.Fatalf("phase after job delete got=%q want=%q", got, "Canceling")
+	}
+
+	latest, err := dc.Resource(workGVR).Namespace("nereid").Get(ctx, "cancel-me", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get work: %v", err)
+	}
+	if err := c.reconcileWork(ctx, latest); err != nil {
+		t.Fatalf("reconcileWork(second) error = %v", err)
+	}
+	if got := workPhase(ctx, t, dc, "nereid", "cancel-me"); got != "Canceled" {
+		t.Fatalf("phase after job gone got=%q want=%q", got, "Canceled")
+	}
+
+	log, err := os.ReadFile(filepath.Join(workDir, "agent.log"))
+	if err != nil {
+		t.Fatalf("read agent.log: %v", err)
+	}
+	if !strings.HasPrefix(string(log), "partial output\n") || !strings.Contains(string(log), "Work canceled at 2026-02-15T12:00:00Z") {
+		t.Fatalf("agent.log should keep output and append cancellation note, got:\n%s", log)
+	}
 }
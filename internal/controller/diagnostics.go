@@ -0,0 +1,281 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Reasons derived from a failed Job's conditions and pods.
+const (
+	reasonOOMKilled            = "OOMKilled"
+	reasonDeadlineExceeded     = "DeadlineExceeded"
+	reasonImagePullFailed      = "ImagePullFailed"
+	reasonContainerConfigError = "CreateContainerConfigError"
+	reasonNonZeroExit          = "NonZeroExit"
+	reasonEvicted              = "Evicted"
+)
+
+const (
+	// The failure annotations record why the controller stopped a Job early,
+	// since its pods may be gone by the time the Job reports failure.
+	failureReasonAnnotationKey  = "nereid.yuiseki.net/failure-reason"
+	failureMessageAnnotationKey = "nereid.yuiseki.net/failure-message"
+
+	// jobNameLabelKey is set on pods by the Job controller in every
+	// supported Kubernetes version.
+	jobNameLabelKey = "job-name"
+
+	podLogTailLines = 200
+)
+
+// jobDiagnosis explains a failed or stuck Job.
+type jobDiagnosis struct {
+	reason  string
+	message string
+	// fatal diagnoses stop the Job without waiting for its deadline.
+	fatal bool
+}
+
+func (c *Controller) listJobPods(ctx context.Context, job *batchv1.Job) ([]corev1.Pod, error) {
+	var pods []corev1.Pod
+	if c.podLister != nil {
+		cached, err := c.podLister.Pods(job.Namespace).List(labels.SelectorFromSet(labels.Set{jobNameLabelKey: job.Name}))
+		if err != nil {
+			return nil, fmt.Errorf("list pods of job %s/%s: %w", job.Namespace, job.Name, err)
+		}
+		for _, pod := range cached {
+			pods = append(pods, *pod)
+		}
+	} else {
+		list, err := c.kube.CoreV1().Pods(job.Namespace).List(ctx, metav1.ListOptions{LabelSelector: jobNameLabelKey + "=" + job.Name})
+		if err != nil {
+			return nil, fmt.Errorf("list pods of job %s/%s: %w", job.Namespace, job.Name, err)
+		}
+		pods = list.Items
+	}
+	sort.SliceStable(pods, func(i, j int) bool {
+		return pods[j].CreationTimestamp.Before(&pods[i].CreationTimestamp)
+	})
+	return pods, nil
+}
+
+// diagnoseJob returns the most specific explanation for a failed Job, or for
+// an active Job whose pods cannot start. It returns nil when there is nothing
+// more specific than the Job phase.
+func diagnoseJob(job *batchv1.Job, pods []corev1.Pod) *jobDiagnosis {
+	if reason := job.Annotations[failureReasonAnnotationKey]; reason != "" {
+		return &jobDiagnosis{reason: reason, message: job.Annotations[failureMessageAnnotationKey]}
+	}
+	for _, cond := range job.Status.Conditions {
+		if cond.Type != batchv1.JobFailed || cond.Status != corev1.ConditionTrue || cond.Reason != batchv1.JobReasonDeadlineExceeded {
+			continue
+		}
+		msg := "job exceeded its active deadline"
+		if job.Spec.ActiveDeadlineSeconds != nil {
+			msg = fmt.Sprintf("job exceeded activeDeadlineSeconds (%ds)", *job.Spec.ActiveDeadlineSeconds)
+		}
+		// A pod that never started explains why the deadline was hit.
+		if d := diagnosePod(pods); d != nil && (d.reason == reasonImagePullFailed || d.reason == reasonContainerConfigError) {
+			msg += "; " + d.message
+		}
+		return &jobDiagnosis{reason: reasonDeadlineExceeded, message: msg}
+	}
+	return diagnosePod(pods)
+}
+
+// diagnosePod inspects the newest pod that explains a failure.
+func diagnosePod(pods []corev1.Pod) *jobDiagnosis {
+	for i := range pods {
+		if d := diagnoseOnePod(&pods[i]); d != nil {
+			return d
+		}
+	}
+	return nil
+}
+
+func diagnoseOnePod(pod *corev1.Pod) *jobDiagnosis {
+	if pod.Status.Reason == "Evicted" {
+		msg := fmt.Sprintf("pod %s was evicted", pod.Name)
+		if pod.Status.Message != "" {
+			msg += ": " + pod.Status.Message
+		}
+		return &jobDiagnosis{reason: reasonEvicted, message: msg}
+	}
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, cs := range statuses {
+		if t := cs.State.Terminated; t != nil && t.Reason == "OOMKilled" {
+			msg := fmt.Sprintf("container %q was killed for exceeding its memory limit", cs.Name)
+			if limit := containerMemoryLimit(pod, cs.Name); limit != "" {
+				msg += " (" + limit + ")"
+			}
+			return &jobDiagnosis{reason: reasonOOMKilled, message: msg}
+		}
+	}
+	for _, cs := range statuses {
+		if t := cs.State.Terminated; t != nil && t.ExitCode != 0 {
+			msg := fmt.Sprintf("container %q exited with code %d", cs.Name, t.ExitCode)
+			if detail := strings.TrimSpace(t.Message); detail != "" {
+				msg += ": " + detail
+			} else if t.Reason != "" && t.Reason != "Error" {
+				msg += " (" + t.Reason + ")"
+			}
+			return &jobDiagnosis{reason: reasonNonZeroExit, message: msg}
+		}
+	}
+	for _, cs := range statuses {
+		w := cs.State.Waiting
+		if w == nil {
+			continue
+		}
+		switch w.Reason {
+		case "ImagePullBackOff", "ErrImagePull", "InvalidImageName", "ErrImageNeverPull":
+			msg := fmt.Sprintf("container %q cannot pull image %q (%s)", cs.Name, cs.Image, w.Reason)
+			if w.Message != "" {
+				msg += ": " + w.Message
+			}
+			// ErrImagePull is the first, possibly transient, pull error; the
+			// kubelet moves to ImagePullBackOff when it keeps failing.
+			return &jobDiagnosis{reason: reasonImagePullFailed, message: msg, fatal: w.Reason != "ErrImagePull"}
+		case "CreateContainerConfigError":
+			msg := fmt.Sprintf("container %q cannot be created", cs.Name)
+			if w.Message != "" {
+				msg += ": " + w.Message
+			}
+			return &jobDiagnosis{reason: reasonContainerConfigError, message: msg}
+		}
+	}
+	return nil
+}
+
+func containerMemoryLimit(pod *corev1.Pod, name string) string {
+	for _, list := range [][]corev1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for _, ctr := range list {
+			if ctr.Name != name {
+				continue
+			}
+			if q, ok := ctr.Resources.Limits[corev1.ResourceMemory]; ok {
+				return "limit " + q.String()
+			}
+		}
+	}
+	return ""
+}
+
+// failJobFast records d on the Job and lets the Job controller fail it by
+// shortening activeDeadlineSeconds. Deleting the Job instead would make the
+// next reconcile recreate it.
+func (c *Controller) failJobFast(ctx context.Context, job *batchv1.Job, d *jobDiagnosis) error {
+	if job.Annotations[failureReasonAnnotationKey] != "" {
+		return nil
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{
+				failureReasonAnnotationKey:  d.reason,
+				failureMessageAnnotationKey: d.message,
+			},
+		},
+		"spec": map[string]interface{}{"activeDeadlineSeconds": 1},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := c.kube.BatchV1().Jobs(job.Namespace).Patch(ctx, job.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("stop job %s/%s: %w", job.Namespace, job.Name, err)
+	}
+	c.logger.Info("stopping job that cannot start",
+		"job", job.Name,
+		"jobNamespace", job.Namespace,
+		"reason", d.reason,
+		"message", d.message,
+	)
+	return nil
+}
+
+// copyPodLogs saves the tail of each started container's log under
+// logs/attempt-N/ when the attempt's own agent.log was never written.
+func (c *Controller) copyPodLogs(ctx context.Context, workName string, attempt int, pods []corev1.Pod) {
+	store := c.artifacts()
+	if local, ok := store.(*localArtifactStore); ok && local.root == "" {
+		return
+	}
+	if len(pods) == 0 {
+		return
+	}
+	dir := "logs/attempt-" + strconv.Itoa(attempt) + "/"
+	// open lstats the path, so a planted symlink, even a dangling one,
+	// counts as present and nothing is written next to it.
+	rc, err := store.open(ctx, workName, dir+"agent.log")
+	if !errors.Is(err, fs.ErrNotExist) {
+		if err == nil {
+			rc.Close()
+		}
+		return
+	}
+	pod := pods[0]
+	tail := int64(podLogTailLines)
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, cs := range statuses {
+		if cs.State.Terminated == nil && cs.State.Running == nil {
+			continue
+		}
+		raw, err := c.kube.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+			Container: cs.Name,
+			TailLines: &tail,
+		}).DoRaw(ctx)
+		if err != nil {
+			c.logger.Warn("failed to read container log", "work", workName, "pod", pod.Name, "container", cs.Name, "error", err)
+			continue
+		}
+		if err := store.writeFile(ctx, workName, dir+"pod-"+cs.Name+".log", raw); err != nil {
+			c.logger.Warn("failed to save container log", "work", workName, "pod", pod.Name, "container", cs.Name, "error", err)
+		}
+	}
+}
+
+// diagnoseWorkJob refines st for a failed Job, or for an active Job whose
+// pods cannot start, and stops Jobs that will never start. Pod events
+// requeue the Work, so pods that are still pending need no recheck.
+func (c *Controller) diagnoseWorkJob(ctx context.Context, work *unstructured.Unstructured, job *batchv1.Job, st *workStatus) error {
+	if st.phase != "Failed" && st.phase != "Running" {
+		return nil
+	}
+	pods, err := c.listJobPods(ctx, job)
+	if err != nil {
+		return err
+	}
+	d := diagnoseJob(job, pods)
+
+	if st.phase == "Failed" {
+		if d != nil {
+			st.reason, st.message = d.reason, d.message
+		}
+		c.copyPodLogs(ctx, work.GetName(), st.attempt, pods)
+		return nil
+	}
+
+	if d == nil || (d.reason != reasonImagePullFailed && d.reason != reasonContainerConfigError) {
+		return nil
+	}
+	st.reason, st.message = d.reason, d.message
+	if d.fatal {
+		if err := c.failJobFast(ctx, job, d); err != nil {
+			return err
+		}
+		st.message += "; stopping job"
+	}
+	return nil
+}
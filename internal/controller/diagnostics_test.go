@@ -0,0 +1,271 @@
+package controller
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes/fake"
+	batchv1listers "k8s.io/client-go/listers/batch/v1"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+func jobPod(name string, created time.Time, statuses ...corev1.ContainerStatus) corev1.Pod {
+	return corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "nereid-work", CreationTimestamp: metav1.NewTime(created)},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{
+			Name:      "task",
+			Resources: corev1.ResourceRequirements{Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("512Mi")}},
+		}}},
+		Status: corev1.PodStatus{Phase: corev1.PodFailed, ContainerStatuses: statuses},
+	}
+}
+
+func TestDiagnoseJob(t *testing.T) {
+	now := time.Date(2026, 2, 15, 12, 0, 0, 0, time.UTC)
+	deadline := int64(600)
+	failedJob := func(reason string) *batchv1.Job {
+		return &batchv1.Job{
+			Spec: batchv1.JobSpec{ActiveDeadlineSeconds: &deadline},
+			Status: batchv1.JobStatus{Failed: 1, Conditions: []batchv1.JobCondition{
+				{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Reason: reason},
+			}},
+		}
+	}
+	terminated := func(reason string, code int32) corev1.ContainerStatus {
+		return corev1.ContainerStatus{Name: "task", State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: reason, ExitCode: code}}}
+	}
+	waiting := func(reason, msg string) corev1.ContainerStatus {
+		return corev1.ContainerStatus{Name: "task", Image: "ghcr.io/x/missing:1", State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: reason, Message: msg}}}
+	}
+	evicted := jobPod("evicted", now)
+	evicted.Status.Reason = "Evicted"
+	evicted.Status.Message = "The node was low on resource: ephemeral-storage."
+
+	cases := []struct {
+		name      string
+		job       *batchv1.Job
+		pods      []corev1.Pod
+		reason    string
+		message   string
+		wantFatal bool
+	}{
+		{name: "oom", job: failedJob("BackoffLimitExceeded"), pods: []corev1.Pod{jobPod("p", now, terminated("OOMKilled", 137))},
+			reason: reasonOOMKilled, message: `container "task" was killed for exceeding its memory limit (limit 512Mi)`},
+		{name: "exit code", job: failedJob("BackoffLimitExceeded"), pods: []corev1.Pod{jobPod("p", now, terminated("Error", 3))},
+			reason: reasonNonZeroExit, message: `container "task" exited with code 3`},
+		{name: "newest pod wins", job: failedJob("BackoffLimitExceeded"), pods: []corev1.Pod{jobPod("new", now, terminated("Error", 2)), jobPod("old", now.Add(-time.Hour), terminated("OOMKilled", 137))},
+			reason: reasonNonZeroExit, message: `container "task" exited with code 2`},
+		{name: "evicted", job: failedJob("BackoffLimitExceeded"), pods: []corev1.Pod{evicted},
+			reason: reasonEvicted, message: "pod evicted was evicted: The node was low on resource: ephemeral-storage."},
+		{name: "deadline", job: failedJob(batchv1.JobReasonDeadlineExceeded), pods: []corev1.Pod{jobPod("p", now, waiting("CreateContainerConfigError", `secret "x" not found`))},
+			reason: reasonDeadlineExceeded, message: `job exceeded activeDeadlineSeconds (600s); container "task" cannot be created: secret "x" not found`},
+		{name: "image pull backoff", job: &batchv1.Job{}, pods: []corev1.Pod{jobPod("p", now, waiting("ImagePullBackOff", "Back-off pulling image"))},
+			reason: reasonImagePullFailed, message: `container "task" cannot pull image "ghcr.io/x/missing:1" (ImagePullBackOff): Back-off pulling image`, wantFatal: true},
+		{name: "first pull error", job: &batchv1.Job{}, pods: []corev1.Pod{jobPod("p", now, waiting("ErrImagePull", ""))},
+			reason: reasonImagePullFailed, message: `container "task" cannot pull image "ghcr.io/x/missing:1" (ErrImagePull)`},
+		{name: "recorded by controller", job: &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{failureReasonAnnotationKey: reasonImagePullFailed, failureMessageAnnotationKey: "gone"}}},
+			reason: reasonImagePullFailed, message: "gone"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := diagnoseJob(tc.job, tc.pods)
+			if d == nil || d.reason != tc.reason || d.message != tc.message || d.fatal != tc.wantFatal {
+				t.Fatalf("diagnoseJob() = %+v, want reason=%s message=%q fatal=%v", d, tc.reason, tc.message, tc.wantFatal)
+			}
+		})
+	}
+	if d := diagnoseJob(failedJob("BackoffLimitExceeded"), nil); d != nil {
+		t.Fatalf("diagnoseJob() without pods = %+v, want nil", d)
+	}
+}
+
+func TestReconcileWorkFailsFastOnImagePullBackOff(t *testing.T) {
+	root := t.TempDir()
+	work := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "nereid.yuiseki.net/v1alpha1",
+		"kind":       "Work",
+		"metadata":   map[string]interface{}{"name": "pull", "namespace": "nereid"},
+		"spec": map[string]interface{}{
+			"kind":  "agent.cli.v1",
+			"title": "pull",
+			"agent": map[string]interface{}{"image": "ghcr.io/x/missing:1", "script": "true"},
+		},
+	}}
+	dc := newFakeDynamicClient(work)
+	kc := fake.NewSimpleClientset()
+	c := &Controller{
+		dynamic: dc,
+		kube:    kc,
+		cfg:     Config{JobNamespace: "nereid-work", ArtifactsHostPath: root},
+		logger:  slog.Default(),
+		nowFunc: time.Now,
+	}
+	ctx := context.Background()
+	if err := c.reconcileWork(ctx, work); err != nil {
+		t.Fatalf("reconcileWork(create) error = %v", err)
+	}
+	jobs := kc.BatchV1().Jobs("nereid-work")
+	job, err := jobs.Get(ctx, "work-pull", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	job.Spec.Suspend = boolPtr(false)
+	job.Status.Active = 1
+	if _, err := jobs.Update(ctx, job, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("update job: %v", err)
+	}
+	pod := jobPod("work-pull-abcde", time.Now(), corev1.ContainerStatus{
+		Name:  "task",
+		Image: "ghcr.io/x/missing:1",
+		State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ImagePullBackOff", Message: "Back-off pulling image"}},
+	})
+	pod.Labels = map[string]string{jobNameLabelKey: "work-pull"}
+	pod.Status.Phase = corev1.PodPending
+	if _, err := kc.CoreV1().Pods("nereid-work").Create(ctx, &pod, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("create pod: %v", err)
+	}
+
+	reconcile := func() *unstructured.Unstructured {
+		t.Helper()
+		latest, err := dc.Resource(workGVR).Namespace("nereid").Get(ctx, "pull", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("get work: %v", err)
+		}
+		if err := c.reconcileWork(ctx, latest); err != nil {
+			t.Fatalf("reconcileWork() error = %v", err)
+		}
+		updated, err := dc.Resource(workGVR).Namespace("nereid").Get(ctx, "pull", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("get work: %v", err)
+		}
+		return updated
+	}
+
+	updated := reconcile()
+	if phase, _, _ := unstructured.NestedString(updated.Object, "status", "phase"); phase != "Running" {
+		t.Fatalf("phase got=%q want Running until the job stops", phase)
+	}
+	if reason, _, _ := unstructured.NestedString(updated.Object, "status", "reason"); reason != reasonImagePullFailed {
+		t.Fatalf("reason got=%q want %s", reason, reasonImagePullFailed)
+	}
+	stopped, err := jobs.Get(ctx, "work-pull", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	if stopped.Spec.ActiveDeadlineSeconds == nil || *stopped.Spec.ActiveDeadlineSeconds != 1 || stopped.Annotations[failureReasonAnnotationKey] != reasonImagePullFailed {
+		t.Fatalf("job was not stopped: deadline=%v annotations=%v", stopped.Spec.ActiveDeadlineSeconds, stopped.Annotations)
+	}
+
+	// The Job controller fails the Job at its new deadline; the recorded
+	// reason survives even though the pod's container was killed.
+	stopped.Status.Active = 0
+	stopped.Status.Failed = 1
+	stopped.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Reason: batchv1.JobReasonDeadlineExceeded}}
+	if _, err := jobs.UpdateStatus(ctx, stopped, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("update job status: %v", err)
+	}
+	pod.Status.Phase = corev1.PodFailed
+	pod.Status.ContainerStatuses[0].State = corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 137}}
+	if _, err := kc.CoreV1().Pods("nereid-work").UpdateStatus(ctx, &pod, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("update pod: %v", err)
+	}
+
+	updated = reconcile()
+	phase, _, _ := unstructured.NestedString(updated.Object, "status", "phase")
+	reason, _, _ := unstructured.NestedString(updated.Object, "status", "reason")
+	message, _, _ := unstructured.NestedString(updated.Object, "status", "message")
+	if phase != "Failed" || reason != reasonImagePullFailed || !strings.Contains(message, "ImagePullBackOff") {
+		t.Fatalf("status got phase=%q reason=%q message=%q", phase, reason, message)
+	}
+	logs, err := os.ReadFile(filepath.Join(root, "pull", "logs", "attempt-1", "pod-task.log"))
+	if err != nil || len(logs) == 0 {
+		t.Fatalf("container log tail was not copied: %q err=%v", logs, err)
+	}
+}
+
+func TestPodEventsEnqueueWorkAndFeedDiagnosis(t *testing.T) {
+	jobs := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	pods := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	c := &Controller{
+		cfg:       Config{WorkNamespace: "nereid"},
+		queue:     workqueue.NewTypedRateLimitingQueue(workqueue.DefaultTypedControllerRateLimiter[string]()),
+		jobLister: batchv1listers.NewJobLister(jobs),
+		podLister: corev1listers.NewPodLister(pods),
+	}
+	defer c.queue.ShutDown()
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{
+		Name:        "nereid-work-w",
+		Namespace:   "nereid-work",
+		Labels:      map[string]string{workLabelKey: "w"},
+		Annotations: map[string]string{workNamespaceAnnotationKey: "team-a"},
+	}}
+	if err := jobs.Add(job); err != nil {
+		t.Fatalf("add job: %v", err)
+	}
+	pod := jobPod("nereid-work-w-abcde", time.Now(), corev1.ContainerStatus{
+		Name:  "task",
+		Image: "busybox:missing",
+		State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ImagePullBackOff"}},
+	})
+	pod.Labels = map[string]string{workLabelKey: "w", jobNameLabelKey: job.Name}
+	pod.Status.Phase = corev1.PodPending
+	if err := pods.Add(&pod); err != nil {
+		t.Fatalf("add pod: %v", err)
+	}
+
+	c.enqueueWorkForPod(&pod)
+	if got, _ := c.queue.Get(); got != "team-a/w" {
+		t.Fatalf("queued key got=%q want=%q", got, "team-a/w")
+	}
+	listed, err := c.listJobPods(context.Background(), job)
+	if err != nil {
+		t.Fatalf("listJobPods() error = %v", err)
+	}
+	if d := diagnoseJob(job, listed); d == nil || d.reason != reasonImagePullFailed || !d.fatal {
+		t.Fatalf("diagnoseJob() from cached pods = %+v", d)
+	}
+}
+
+func TestCopyPodLogsDoesNotFollowSymlinks(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	for _, dir := range []string{"dangling/logs/attempt-1", "redirected"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(outside, "missing"), filepath.Join(root, "dangling", "logs", "attempt-1", "agent.log")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "redirected", "logs")); err != nil {
+		t.Fatal(err)
+	}
+	pod := jobPod("work-x-abcde", time.Now(), corev1.ContainerStatus{
+		Name:  "task",
+		State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 1}},
+	})
+	kc := fake.NewSimpleClientset(&pod)
+	c := &Controller{kube: kc, cfg: Config{ArtifactsHostPath: root}, logger: slog.Default(), nowFunc: time.Now}
+
+	ctx := context.Background()
+	c.copyPodLogs(ctx, "dangling", 1, []corev1.Pod{pod})
+	if _, err := os.Lstat(filepath.Join(root, "dangling", "logs", "attempt-1", "pod-task.log")); !os.IsNotExist(err) {
+		t.Fatalf("a dangling agent.log symlink should count as present: %v", err)
+	}
+	c.copyPodLogs(ctx, "redirected", 1, []corev1.Pod{pod})
+	if entries, _ := os.ReadDir(outside); len(entries) != 0 {
+		t.Fatalf("pod logs were written through a symlinked logs dir: %v", entries)
+	}
+}
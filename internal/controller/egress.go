@@ -0,0 +1,386 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	egressModeDeny      = "deny"
+	egressModeAllowlist = "allowlist"
+
+	defaultEgressDNSNamespace   = "kube-system"
+	defaultEgressDNSPodSelector = "k8s-app=kube-dns"
+)
+
+var errEgressNotAllowed = errors.New("egress not allowed by grant")
+
+// egressSpec is the effective egress constraint of a Work. An empty mode
+// leaves egress unrestricted and no NetworkPolicy is created.
+type egressSpec struct {
+	mode      string
+	allowlist []egressTarget
+}
+
+// egressTarget is one allowlist entry: a hostname (optionally "*.domain" in a
+// Grant), an IP or a CIDR, with an optional TCP port.
+type egressTarget struct {
+	raw  string
+	host string
+	cidr *net.IPNet
+	port int32
+}
+
+func parseEgressTarget(raw string, allowWildcard bool) (egressTarget, error) {
+	entry := strings.TrimSpace(raw)
+	t := egressTarget{raw: entry}
+	if entry == "" {
+		return t, fmt.Errorf("empty egress entry")
+	}
+
+	addr := entry
+	if _, _, err := net.ParseCIDR(entry); err != nil && net.ParseIP(entry) == nil && strings.Contains(entry, ":") {
+		host, port, splitErr := net.SplitHostPort(entry)
+		if splitErr != nil {
+			return t, fmt.Errorf("invalid egress entry %q: %v", entry, splitErr)
+		}
+		p, convErr := strconv.Atoi(port)
+		if convErr != nil || p < 1 || p > 65535 {
+			return t, fmt.Errorf("invalid port in egress entry %q", entry)
+		}
+		addr, t.port = host, int32(p)
+	}
+
+	if _, cidr, err := net.ParseCIDR(addr); err == nil {
+		t.cidr = cidr
+		return t, nil
+	}
+	if ip := net.ParseIP(addr); ip != nil {
+		t.cidr = ipNet(ip)
+		return t, nil
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(addr, "."))
+	name := host
+	if strings.HasPrefix(host, "*.") {
+		if !allowWildcard {
+			return t, fmt.Errorf("wildcard egress entry %q cannot be resolved; list hostnames explicitly", entry)
+		}
+		name = host[2:]
+	}
+	if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+		return t, fmt.Errorf("invalid hostname in egress entry %q: %s", entry, strings.Join(errs, "; "))
+	}
+	t.host = host
+	return t, nil
+}
+
+func ipNet(ip net.IP) *net.IPNet {
+	if v4 := ip.To4(); v4 != nil {
+		return &net.IPNet{IP: v4, Mask: net.CIDRMask(32, 32)}
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+}
+
+// covers reports whether t (a Grant allowedEgress entry) permits w.
+func (t egressTarget) covers(w egressTarget) bool {
+	if t.port != 0 && t.port != w.port {
+		return false
+	}
+	switch {
+	case t.cidr != nil:
+		if w.cidr == nil || !t.cidr.Contains(w.cidr.IP) {
+			return false
+		}
+		tOnes, _ := t.cidr.Mask.Size()
+		wOnes, _ := w.cidr.Mask.Size()
+		return wOnes >= tOnes
+	case strings.HasPrefix(t.host, "*."):
+		return w.host != "" && strings.HasSuffix(w.host, t.host[1:])
+	default:
+		return w.host != "" && w.host == t.host
+	}
+}
+
+// workEgress reads spec.constraints.egress and caps it with the Grant's
+// spec.allowedEgress. A Work that does not ask for an egress mode under a
+// Grant with allowedEgress is limited to that list.
+func workEgress(work, grant *unstructured.Unstructured) (egressSpec, error) {
+	var out egressSpec
+	mode, _, err := unstructured.NestedString(work.Object, "spec", "constraints", "egress", "mode")
+	if err != nil {
+		return out, fmt.Errorf("failed to read spec.constraints.egress.mode: %v", err)
+	}
+	entries, _, err := nestedStringSlice(work.Object, "spec", "constraints", "egress", "allowlist")
+	if err != nil {
+		return out, fmt.Errorf("failed to read spec.constraints.egress.allowlist: %v", err)
+	}
+	out.mode = strings.TrimSpace(mode)
+	switch out.mode {
+	case "", egressModeDeny:
+	case egressModeAllowlist:
+		for _, entry := range entries {
+			t, parseErr := parseEgressTarget(entry, false)
+			if parseErr != nil {
+				return out, fmt.Errorf("spec.constraints.egress.allowlist: %v", parseErr)
+			}
+			out.allowlist = append(out.allowlist, t)
+		}
+	default:
+		return out, fmt.Errorf("unsupported spec.constraints.egress.mode=%q", mode)
+	}
+
+	if grant == nil {
+		return out, nil
+	}
+	grantEntries, found, err := nestedStringSlice(grant.Object, "spec", "allowedEgress")
+	if err != nil {
+		return out, fmt.Errorf("failed to read grant %q spec.allowedEgress: %v", grant.GetName(), err)
+	}
+	if !found {
+		return out, nil
+	}
+	allowed := make([]egressTarget, 0, len(grantEntries))
+	for _, entry := range grantEntries {
+		t, parseErr := parseEgressTarget(entry, true)
+		if parseErr != nil {
+			return out, fmt.Errorf("grant %q spec.allowedEgress: %v", grant.GetName(), parseErr)
+		}
+		allowed = append(allowed, t)
+	}
+
+	if out.mode == "" {
+		out.mode = egressModeAllowlist
+		for _, t := range allowed {
+			if strings.HasPrefix(t.host, "*.") {
+				// Wildcards only cap what a Work may request.
+				continue
+			}
+			out.allowlist = append(out.allowlist, t)
+		}
+		return out, nil
+	}
+	for _, w := range out.allowlist {
+		ok := false
+		for _, t := range allowed {
+			if t.covers(w) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return out, fmt.Errorf("%w: grant %q does not allow egress to %q", errEgressNotAllowed, grant.GetName(), w.raw)
+		}
+	}
+	return out, nil
+}
+
+func (c *Controller) lookupEgressHost(ctx context.Context, host string) ([]net.IP, error) {
+	if c.lookupIP != nil {
+		return c.lookupIP(ctx, host)
+	}
+	return net.DefaultResolver.LookupIP(ctx, "ip", host)
+}
+
+func egressPolicyName(jobName string) string {
+	return jobName + "-egress"
+}
+
+// artifactStoreEgress returns the S3 endpoint when artifacts are stored in
+// S3. The policy also selects the artifact-restore init container and the
+// uploader sidecar, so they need it on top of the Work's allowlist.
+func (c *Controller) artifactStoreEgress() (egressTarget, bool, error) {
+	if strings.TrimSpace(c.cfg.ArtifactStorage) != ArtifactStorageS3 {
+		return egressTarget{}, false, nil
+	}
+	endpoint, err := url.Parse(strings.TrimSpace(c.cfg.ArtifactS3.Endpoint))
+	if err != nil || endpoint.Hostname() == "" {
+		return egressTarget{}, false, fmt.Errorf("invalid s3 endpoint %q", c.cfg.ArtifactS3.Endpoint)
+	}
+	port := endpoint.Port()
+	if port == "" {
+		port = "443"
+		if endpoint.Scheme == "http" {
+			port = "80"
+		}
+	}
+	t, err := parseEgressTarget(net.JoinHostPort(endpoint.Hostname(), port), false)
+	if err != nil {
+		return egressTarget{}, false, fmt.Errorf("s3 endpoint: %v", err)
+	}
+	return t, true, nil
+}
+
+// egressDNSPeer selects the cluster DNS pods, so port 53 is not open to every
+// address.
+func (c *Controller) egressDNSPeer() (networkingv1.NetworkPolicyPeer, error) {
+	namespace := strings.TrimSpace(c.cfg.EgressDNSNamespace)
+	if namespace == "" {
+		namespace = defaultEgressDNSNamespace
+	}
+	selector := strings.TrimSpace(c.cfg.EgressDNSPodSelector)
+	if selector == "" {
+		selector = defaultEgressDNSPodSelector
+	}
+	pods, err := metav1.ParseToLabelSelector(selector)
+	if err != nil {
+		return networkingv1.NetworkPolicyPeer{}, fmt.Errorf("egress DNS pod selector %q: %v", selector, err)
+	}
+	return networkingv1.NetworkPolicyPeer{
+		NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{corev1.LabelMetadataName: namespace}},
+		PodSelector:       pods,
+	}, nil
+}
+
+// buildEgressPolicy returns the NetworkPolicy for the Job's pods, or nil when
+// egress is unrestricted. The cluster DNS and the S3 artifact endpoint are
+// always allowed. Hostnames are resolved now, so the policy follows the addresses
+// they had when the Job was created.
+func (c *Controller) buildEgressPolicy(ctx context.Context, job *batchv1.Job, egress egressSpec) (*networkingv1.NetworkPolicy, error) {
+	if egress.mode == "" {
+		return nil, nil
+	}
+	targets := egress.allowlist
+	store, ok, err := c.artifactStoreEgress()
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		targets = append(append([]egressTarget(nil), targets...), store)
+	}
+	dnsPeer, err := c.egressDNSPeer()
+	if err != nil {
+		return nil, err
+	}
+	udp, tcp := corev1.ProtocolUDP, corev1.ProtocolTCP
+	dnsPort := intstr.FromInt32(53)
+	rules := []networkingv1.NetworkPolicyEgressRule{{
+		To: []networkingv1.NetworkPolicyPeer{dnsPeer},
+		Ports: []networkingv1.NetworkPolicyPort{
+			{Protocol: &udp, Port: &dnsPort},
+			{Protocol: &tcp, Port: &dnsPort},
+		},
+	}}
+
+	for _, t := range targets {
+		var cidrs []string
+		if t.cidr != nil {
+			cidrs = []string{t.cidr.String()}
+		} else {
+			ips, err := c.lookupEgressHost(ctx, t.host)
+			if err != nil {
+				return nil, fmt.Errorf("resolve egress host %q: %w", t.host, err)
+			}
+			if len(ips) == 0 {
+				// A rule without peers would allow every destination.
+				return nil, fmt.Errorf("resolve egress host %q: no addresses", t.host)
+			}
+			seen := map[string]bool{}
+			for _, ip := range ips {
+				cidr := ipNet(ip).String()
+				if !seen[cidr] {
+					seen[cidr] = true
+					cidrs = append(cidrs, cidr)
+				}
+			}
+			sort.Strings(cidrs)
+		}
+		rule := networkingv1.NetworkPolicyEgressRule{}
+		for _, cidr := range cidrs {
+			rule.To = append(rule.To, networkingv1.NetworkPolicyPeer{IPBlock: &networkingv1.IPBlock{CIDR: cidr}})
+		}
+		if t.port != 0 {
+			port := intstr.FromInt32(t.port)
+			rule.Ports = []networkingv1.NetworkPolicyPort{{Protocol: &tcp, Port: &port}}
+		}
+		rules = append(rules, rule)
+	}
+
+	workName := job.Labels[workLabelKey]
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      egressPolicyName(job.Name),
+			Namespace: job.Namespace,
+			Labels:    map[string]string{workLabelKey: workName},
+			Annotations: map[string]string{
+				workNamespaceAnnotationKey: job.Annotations[workNamespaceAnnotationKey],
+			},
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{workLabelKey: workName}},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeEgress},
+			Egress:      rules,
+		},
+	}, nil
+}
+
+// applyEgressPolicy creates or updates the policy. It runs before the Job is
+// created so its pods never start unrestricted.
+func (c *Controller) applyEgressPolicy(ctx context.Context, policy *networkingv1.NetworkPolicy) error {
+	policies := c.kube.NetworkingV1().NetworkPolicies(policy.Namespace)
+	_, err := policies.Create(ctx, policy, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		var existing *networkingv1.NetworkPolicy
+		existing, err = policies.Get(ctx, policy.Name, metav1.GetOptions{})
+		if err == nil {
+			existing.Labels = policy.Labels
+			existing.Annotations = policy.Annotations
+			existing.Spec = policy.Spec
+			_, err = policies.Update(ctx, existing, metav1.UpdateOptions{})
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("apply egress policy %s/%s: %w", policy.Namespace, policy.Name, err)
+	}
+	return nil
+}
+
+// ownEgressPolicy makes the Job own its policy so both are garbage collected
+// together. A Job whose policy cannot be adopted is deleted so the next
+// reconcile starts over.
+func (c *Controller) ownEgressPolicy(ctx context.Context, job *batchv1.Job) error {
+	policies := c.kube.NetworkingV1().NetworkPolicies(job.Namespace)
+	policy, err := policies.Get(ctx, egressPolicyName(job.Name), metav1.GetOptions{})
+	if err == nil {
+		policy.OwnerReferences = []metav1.OwnerReference{{
+			APIVersion: "batch/v1",
+			Kind:       "Job",
+			Name:       job.Name,
+			UID:        job.UID,
+			Controller: boolPtr(true),
+		}}
+		_, err = policies.Update(ctx, policy, metav1.UpdateOptions{})
+	}
+	if err == nil {
+		return nil
+	}
+
+	propagation := metav1.DeletePropagationForeground
+	if delErr := c.kube.BatchV1().Jobs(job.Namespace).Delete(ctx, job.Name, metav1.DeleteOptions{PropagationPolicy: &propagation}); delErr != nil && !apierrors.IsNotFound(delErr) {
+		c.logger.Warn("failed to delete job after egress policy error", "job", job.Name, "error", delErr)
+	}
+	return fmt.Errorf("own egress policy for job %s/%s: %w", job.Namespace, job.Name, err)
+}
+
+func (c *Controller) deleteEgressPolicy(ctx context.Context, jobName string) error {
+	err := c.kube.NetworkingV1().NetworkPolicies(c.cfg.JobNamespace).Delete(ctx, egressPolicyName(jobName), metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("delete egress policy for job %s/%s: %w", c.cfg.JobNamespace, jobName, err)
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestWorkEgressCappedByGrant(t *testing.T) {
+	grant := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "g1"},
+		"spec": map[string]interface{}{
+			"allowedEgress": []interface{}{"api.openai.com:443", "*.example.com", "10.0.0.0/8"},
+		},
+	}}
+	cases := []struct {
+		name      string
+		egress    map[string]interface{}
+		wantErr   string
+		wantHosts []string
+	}{
+		{name: "within grant", egress: map[string]interface{}{"mode": "allowlist", "allowlist": []interface{}{"api.openai.com:443", "tiles.example.com", "10.1.2.0/24"}}, wantHosts: []string{"api.openai.com:443", "tiles.example.com", "10.1.2.0/24"}},
+		{name: "wrong port", egress: map[string]interface{}{"mode": "allowlist", "allowlist": []interface{}{"api.openai.com"}}, wantErr: "not allow egress"},
+		{name: "wider cidr", egress: map[string]interface{}{"mode": "allowlist", "allowlist": []interface{}{"0.0.0.0/0"}}, wantErr: "not allow egress"},
+		{name: "apex outside wildcard", egress: map[string]interface{}{"mode": "allowlist", "allowlist": []interface{}{"example.com"}}, wantErr: "not allow egress"},
+		{name: "deny", egress: map[string]interface{}{"mode": "deny"}},
+		{name: "unset follows grant", wantHosts: []string{"api.openai.com:443", "10.0.0.0/8"}},
+		{name: "wildcard in work", egress: map[string]interface{}{"mode": "allowlist", "allowlist": []interface{}{"*.example.com"}}, wantErr: "wildcard"},
+	}
+	for _, tc := range cases {
+		work := &unstructured.Unstructured{Object: map[string]interface{}{"spec": map[string]interface{}{}}}
+		if tc.egress != nil {
+			work.Object["spec"] = map[string]interface{}{"constraints": map[string]interface{}{"egress": tc.egress}}
+		}
+		got, err := workEgress(work, grant)
+		if tc.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("%s: error got=%v want containing %q", tc.name, err, tc.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: workEgress() error = %v", tc.name, err)
+		}
+		if got.mode == "" {
+			t.Fatalf("%s: egress must be restricted under a grant with allowedEgress", tc.name)
+		}
+		var hosts []string
+		for _, target := range got.allowlist {
+			hosts = append(hosts, target.raw)
+		}
+		if strings.Join(hosts, ",") != strings.Join(tc.wantHosts, ",") {
+			t.Fatalf("%s: allowlist got=%v want=%v", tc.name, hosts, tc.wantHosts)
+		}
+	}
+
+	work := &unstructured.Unstructured{Object: map[string]interface{}{"spec": map[string]interface{}{
+		"constraints": map[string]interface{}{"egress": map[string]interface{}{"mode": "allowlist", "allowlist": []interface{}{"evil.test"}}},
+	}}}
+	if _, err := workEgress(work, grant); !errors.Is(err, errEgressNotAllowed) {
+		t.Fatalf("grant violation should wrap errEgressNotAllowed, got %v", err)
+	}
+	if got, err := workEgress(work, nil); err != nil || got.mode != egressModeAllowlist {
+		t.Fatalf("without grant got=%+v err=%v", got, err)
+	}
+}
+
+func TestCreateWorkJobCreatesEgressPolicyOwnedByJob(t *testing.T) {
+	work := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "nereid.yuiseki.net/v1alpha1",
+		"kind":       "Work",
+		"metadata":   map[string]interface{}{"name": "fenced", "namespace": "nereid"},
+		"spec": map[string]interface{}{
+			"kind":  "agent.cli.v1",
+			"title": "fenced",
+			"agent": map[string]interface{}{"image": "busybox", "script": "true"},
+			"constraints": map[string]interface{}{
+				"egress": map[string]interface{}{
+					"mode":      "allowlist",
+					"allowlist": []interface{}{"example.com:443", "192.0.2.0/24"},
+				},
+			},
+		},
+	}}
+	dc := newFakeDynamicClient(work)
+	kc := fake.NewSimpleClientset()
+	c := &Controller{
+		dynamic: dc,
+		kube:    kc,
+		cfg:     Config{JobNamespace: "nereid-work"},
+		logger:  slog.Default(),
+		nowFunc: time.Now,
+		lookupIP: func(_ context.Context, host string) ([]net.IP, error) {
+			if host != "example.com" {
+				return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+			}
+			return []net.IP{net.ParseIP("93.184.216.34"), net.ParseIP("2606:2800:220:1::1")}, nil
+		},
+	}
+
+	ctx := context.Background()
+	if err := c.reconcileWork(ctx, work); err != nil {
+		t.Fatalf("reconcileWork() error = %v", err)
+	}
+	job, err := kc.BatchV1().Jobs("nereid-work").Get(ctx, makeJobName("fenced"), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	policy, err := kc.NetworkingV1().NetworkPolicies("nereid-work").Get(ctx, egressPolicyName(job.Name), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get egress policy: %v", err)
+	}
+	if policy.Spec.PodSelector.MatchLabels[workLabelKey] != "fenced" {
+		t.Fatalf("pod selector mismatch: %#v", policy.Spec.PodSelector)
+	}
+	if len(policy.OwnerReferences) != 1 || policy.OwnerReferences[0].Kind != "Job" || policy.OwnerReferences[0].Name != job.Name {
+		t.Fatalf("policy ownerRef mismatch: %#v", policy.OwnerReferences)
+	}
+	rules := policy.Spec.Egress
+	if len(rules) != 3 {
+		t.Fatalf("egress rules got=%d want=3: %#v", len(rules), rules)
+	}
+	if len(rules[0].To) != 1 || len(rules[0].Ports) != 2 || rules[0].Ports[0].Port.IntValue() != 53 {
+		t.Fatalf("first rule should allow DNS: %#v", rules[0])
+	}
+	dns := rules[0].To[0]
+	if dns.IPBlock != nil || dns.NamespaceSelector == nil || dns.NamespaceSelector.MatchLabels[corev1.LabelMetadataName] != "kube-system" ||
+		dns.PodSelector == nil || dns.PodSelector.MatchLabels["k8s-app"] != "kube-dns" {
+		t.Fatalf("DNS rule should only reach the cluster DNS pods: %#v", dns)
+	}
+	if len(rules[1].To) != 2 || rules[1].To[0].IPBlock.CIDR != "2606:2800:220:1::1/128" || rules[1].To[1].IPBlock.CIDR != "93.184.216.34/32" || rules[1].Ports[0].Port.IntValue() != 443 {
+		t.Fatalf("resolved host rule mismatch: %#v", rules[1])
+	}
+	if len(rules[2].To) != 1 || rules[2].To[0].IPBlock.CIDR != "192.0.2.0/24" || len(rules[2].Ports) != 0 {
+		t.Fatalf("cidr rule mismatch: %#v", rules[2])
+	}
+
+	unknown := work.DeepCopy()
+	unknown.SetName("unknown")
+	if err := unstructured.SetNestedStringSlice(unknown.Object, []string{"missing.invalid"}, "spec", "constraints", "egress", "allowlist"); err != nil {
+		t.Fatalf("set allowlist: %v", err)
+	}
+	if _, err := dc.Resource(workGVR).Namespace("nereid").Create(ctx, unknown, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("create work: %v", err)
+	}
+	if err := c.reconcileWork(ctx, unknown); err != nil {
+		t.Fatalf("reconcileWork(unknown) error = %v", err)
+	}
+	if got := workPhase(ctx, t, dc, "nereid", "unknown"); got != "Error" {
+		t.Fatalf("unresolvable host phase got=%q want=%q", got, "Error")
+	}
+	if _, err := kc.BatchV1().Jobs("nereid-work").Get(ctx, makeJobName("unknown"), metav1.GetOptions{}); err == nil {
+		t.Fatal("work with an unresolvable allowlist host must not get a job")
+	}
+}
+
+func TestBuildEgressPolicyAllowsS3ArtifactEndpoint(t *testing.T) {
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "w-job", Namespace: "nereid-work", Labels: map[string]string{workLabelKey: "w"}}}
+	cases := []struct {
+		name     string
+		storage  string
+		endpoint string
+		wantCIDR string
+		wantPort int
+	}{
+		{name: "https host", storage: ArtifactStorageS3, endpoint: "https://s3.example.net", wantCIDR: "198.51.100.7/32", wantPort: 443},
+		{name: "http ip with port", storage: ArtifactStorageS3, endpoint: "http://10.0.0.5:9000", wantCIDR: "10.0.0.5/32", wantPort: 9000},
+		{name: "hostpath store", storage: ArtifactStorageHostPath, endpoint: "https://s3.example.net"},
+	}
+	for _, tc := range cases {
+		c := &Controller{
+			cfg: Config{ArtifactStorage: tc.storage, ArtifactS3: S3Config{Endpoint: tc.endpoint, Bucket: "artifacts"}},
+			lookupIP: func(_ context.Context, host string) ([]net.IP, error) {
+				if host != "s3.example.net" {
+					return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+				}
+				return []net.IP{net.ParseIP("198.51.100.7")}, nil
+			},
+		}
+		policy, err := c.buildEgressPolicy(context.Background(), job, egressSpec{mode: egressModeDeny})
+		if err != nil {
+			t.Fatalf("%s: buildEgressPolicy() error = %v", tc.name, err)
+		}
+		rules := policy.Spec.Egress
+		if tc.wantCIDR == "" {
+			if len(rules) != 1 {
+				t.Fatalf("%s: deny policy should only allow DNS: %#v", tc.name, rules)
+			}
+			continue
+		}
+		if len(rules) != 2 || len(rules[1].To) != 1 || rules[1].To[0].IPBlock.CIDR != tc.wantCIDR || len(rules[1].Ports) != 1 || rules[1].Ports[0].Port.IntValue() != tc.wantPort {
+			t.Fatalf("%s: s3 endpoint rule mismatch: %#v", tc.name, rules)
+		}
+	}
+}
+
+func TestBuildEgressPolicyLimitsDNSToConfiguredPods(t *testing.T) {
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "w-job", Namespace: "nereid-work", Labels: map[string]string{workLabelKey: "w"}}}
+	c := &Controller{cfg: Config{EgressDNSNamespace: "dns", EgressDNSPodSelector: "app=coredns,tier in (node-local)"}}
+	policy, err := c.buildEgressPolicy(context.Background(), job, egressSpec{mode: egressModeDeny})
+	if err != nil {
+		t.Fatalf("buildEgressPolicy() error = %v", err)
+	}
+	dns := policy.Spec.Egress[0].To
+	if len(dns) != 1 || dns[0].NamespaceSelector.MatchLabels[corev1.LabelMetadataName] != "dns" ||
+		dns[0].PodSelector.MatchLabels["app"] != "coredns" || len(dns[0].PodSelector.MatchExpressions) != 1 {
+		t.Fatalf("DNS peer mismatch: %#v", dns)
+	}
+
+	c.cfg.EgressDNSPodSelector = "app in ("
+	if _, err := c.buildEgressPolicy(context.Background(), job, egressSpec{mode: egressModeDeny}); err == nil {
+		t.Fatal("an invalid DNS pod selector should be rejected")
+	}
+}
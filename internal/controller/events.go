@@ -0,0 +1,92 @@
+package controller
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// Event reasons that are not already Work status reasons.
+const (
+	eventReasonAdmitted     = "Admitted"
+	eventReasonStarted      = "Started"
+	eventReasonSucceeded    = "Succeeded"
+	eventReasonFailed       = "Failed"
+	eventReasonWorkRejected = "WorkRejected"
+)
+
+// recordWorkTransition emits Events for the phase and admission changes that
+// a stored status update made, so each transition is reported once.
+func (c *Controller) recordWorkTransition(work *unstructured.Unstructured, st workStatus, before, after map[string]interface{}) {
+	if c.recorder == nil {
+		return
+	}
+	jobName := ""
+	if st.job != nil {
+		jobName = st.job.Namespace + "/" + st.job.Name
+	}
+
+	if statusConditionTrue(after, conditionAdmitted) && !statusConditionTrue(before, conditionAdmitted) {
+		if st.queue != nil && st.queue.clusterQueue != "" {
+			c.recordEvent(work, corev1.EventTypeNormal, eventReasonAdmitted, "Job %s admitted to ClusterQueue %s", jobName, st.queue.clusterQueue)
+		} else {
+			c.recordEvent(work, corev1.EventTypeNormal, eventReasonAdmitted, "Job %s admitted", jobName)
+		}
+	}
+
+	phase, _ := after["phase"].(string)
+	if previous, _ := before["phase"].(string); previous == phase {
+		return
+	}
+	switch phase {
+	case "Running":
+		c.recordEvent(work, corev1.EventTypeNormal, eventReasonStarted, "Job %s started (attempt %d)", jobName, max(st.attempt, 1))
+	case "Succeeded":
+		c.recordEvent(work, corev1.EventTypeNormal, eventReasonSucceeded, "Job %s succeeded", jobName)
+	case "Retrying":
+		c.recordEvent(work, corev1.EventTypeWarning, reasonRetryScheduled, "%s", st.message)
+	case "Failed", "Error":
+		switch {
+		case st.validation != nil && st.validation.failure() != nil:
+			c.recordEvent(work, corev1.EventTypeWarning, reasonValidationFailed, "%s", st.message)
+		case st.reason == reasonGrantRejected:
+			c.recordEvent(work, corev1.EventTypeWarning, reasonGrantRejected, "%s", st.message)
+			grantName, _, _ := unstructured.NestedString(work.Object, "spec", "grantRef", "name")
+			if grant := c.grantEventObject(work.GetNamespace(), grantName); grant != nil {
+				c.recordEvent(grant, corev1.EventTypeWarning, eventReasonWorkRejected, "Rejected Work %s: %s", work.GetName(), st.message)
+			}
+		default:
+			c.recordEvent(work, corev1.EventTypeWarning, eventReasonFailed, "%s: %s", st.reason, st.message)
+		}
+	}
+}
+
+// grantEventObject returns the Grant to attach an Event to, preferring the
+// cached object so the Event references its UID.
+func (c *Controller) grantEventObject(namespace, name string) runtime.Object {
+	if name == "" {
+		return nil
+	}
+	if c.grantLister != nil {
+		if obj, err := c.grantLister.ByNamespace(namespace).Get(name); err == nil {
+			return obj
+		}
+	}
+	grant := &unstructured.Unstructured{}
+	grant.SetAPIVersion(grantGVR.GroupVersion().String())
+	grant.SetKind("Grant")
+	grant.SetNamespace(namespace)
+	grant.SetName(name)
+	return grant
+}
+
+func statusConditionTrue(status map[string]interface{}, condType string) bool {
+	conditions, _ := status["conditions"].([]interface{})
+	for _, raw := range conditions {
+		cond, _ := raw.(map[string]interface{})
+		if cond["type"] == condType {
+			return cond["status"] == "True"
+		}
+	}
+	return false
+}
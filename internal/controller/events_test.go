@@ -0,0 +1,134 @@
+package controller
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func drainEvents(recorder *record.FakeRecorder) []string {
+	var out []string
+	for {
+		select {
+		case e := <-recorder.Events:
+			out = append(out, e)
+		default:
+			return out
+		}
+	}
+}
+
+func TestWorkLifecycleEvents(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "story"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "story", "index.html"), []byte("<html>map</html>"), 0o644); err != nil {
+		t.Fatalf("write index.html: %v", err)
+	}
+	work := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "nereid.yuiseki.net/v1alpha1",
+		"kind":       "Work",
+		"metadata":   map[string]interface{}{"name": "story", "namespace": "nereid"},
+		"spec": map[string]interface{}{
+			"kind":     "agent.cli.v1",
+			"title":    "story",
+			"agent":    map[string]interface{}{"image": "busybox", "script": "true"},
+			"grantRef": map[string]interface{}{"name": "demo"},
+		},
+	}}
+	rejected := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "nereid.yuiseki.net/v1alpha1",
+		"kind":       "Work",
+		"metadata":   map[string]interface{}{"name": "rejected", "namespace": "nereid"},
+		"spec": map[string]interface{}{
+			"kind":     "gdal.rastertile.v1",
+			"title":    "rejected",
+			"grantRef": map[string]interface{}{"name": "demo"},
+		},
+	}}
+	grant := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "nereid.yuiseki.net/v1alpha1",
+		"kind":       "Grant",
+		"metadata":   map[string]interface{}{"name": "demo", "namespace": "nereid"},
+		"spec":       map[string]interface{}{"allowedKinds": []interface{}{"agent.cli.v1"}},
+	}}
+	dc := newFakeDynamicClient(work, rejected, grant)
+	kc := fake.NewSimpleClientset()
+	recorder := record.NewFakeRecorder(50)
+	recorder.IncludeObject = true
+	c := &Controller{
+		dynamic:  dc,
+		kube:     kc,
+		cfg:      Config{JobNamespace: "nereid-work", ArtifactsHostPath: root},
+		logger:   slog.Default(),
+		nowFunc:  time.Now,
+		recorder: recorder,
+	}
+	ctx := context.Background()
+	reconcile := func(name string) []string {
+		t.Helper()
+		latest, err := dc.Resource(workGVR).Namespace("nereid").Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("get work: %v", err)
+		}
+		if err := c.reconcileWork(ctx, latest); err != nil {
+			t.Fatalf("reconcileWork() error = %v", err)
+		}
+		return drainEvents(recorder)
+	}
+	const workRef = " involvedObject{kind=Work,apiVersion=nereid.yuiseki.net/v1alpha1}"
+	const grantRef = " involvedObject{kind=Grant,apiVersion=nereid.yuiseki.net/v1alpha1}"
+	expect := func(step string, got []string, want ...string) {
+		t.Helper()
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s events:\n got  %q\n want %q", step, got, want)
+		}
+	}
+
+	expect("create", reconcile("story"),
+		"Normal JobCreated Created Job nereid-work/work-story (attempt 1)"+workRef,
+		"Normal JobCreated Created Job nereid-work/work-story for Work story"+grantRef,
+	)
+	expect("queued", reconcile("story"))
+
+	jobs := kc.BatchV1().Jobs("nereid-work")
+	job, err := jobs.Get(ctx, "work-story", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	job.Spec.Suspend = boolPtr(false)
+	job.Status.Active = 1
+	job.Status.StartTime = &metav1.Time{Time: time.Now()}
+	if job, err = jobs.Update(ctx, job, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("update job: %v", err)
+	}
+	expect("admitted", reconcile("story"),
+		"Normal Admitted Job nereid-work/work-story admitted"+workRef,
+		"Normal Started Job nereid-work/work-story started (attempt 1)"+workRef,
+	)
+	expect("still running", reconcile("story"))
+
+	job.Status.Active = 0
+	job.Status.Succeeded = 1
+	if _, err := jobs.Update(ctx, job, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("update job: %v", err)
+	}
+	expect("succeeded", reconcile("story"),
+		"Normal Succeeded Job nereid-work/work-story succeeded"+workRef,
+	)
+
+	expect("rejected", reconcile("rejected"),
+		`Warning GrantRejected grant "demo" does not allow spec.kind="gdal.rastertile.v1"`+workRef,
+		`Warning WorkRejected Rejected Work rejected: grant "demo" does not allow spec.kind="gdal.rastertile.v1"`+grantRef,
+	)
+}
@@ -0,0 +1,152 @@
+package controller
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/yuiseki/NEREID/internal/runner"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	executorAgent  = "agent"
+	executorNative = "native"
+
+	executorAnnotationKey = "nereid.yuiseki.net/executor"
+
+	// agentAPIKeyEnv is the Grant env entry the Gemini bridge needs; without
+	// it legacy kinds with a native executor skip the agent.
+	agentAPIKeyEnv = "GEMINI_API_KEY"
+
+	defaultRunnerImage = "nereid-agent-runtime:local"
+)
+
+// runnerImageForJob returns the runtime image that ships nereid-runner.
+func runnerImageForJob() string {
+	if image := strings.TrimSpace(os.Getenv("NEREID_AGENT_IMAGE")); image != "" {
+		return image
+	}
+	return defaultRunnerImage
+}
+
+// workExecutor resolves how a Work's Job runs. spec.executor wins;
+// otherwise kinds with a native executor use it unless the Grant provides
+// an agent key.
+func (c *Controller) workExecutor(ctx context.Context, work *unstructured.Unstructured, kind string, grant *unstructured.Unstructured) (string, error) {
+	executor, _, err := nestedStringAny(work.Object, "spec", "executor")
+	if err != nil {
+		return "", fmt.Errorf("failed to read spec.executor: %v", err)
+	}
+	switch strings.TrimSpace(executor) {
+	case executorAgent:
+		return executorAgent, nil
+	case executorNative:
+		if !supportsNative(kind) {
+			return "", fmt.Errorf("spec.executor=native is not supported for spec.kind=%q", kind)
+		}
+		return executorNative, nil
+	case "":
+		if !supportsNative(kind) || c.grantHasAgentKey(ctx, grant) {
+			return executorAgent, nil
+		}
+		return executorNative, nil
+	default:
+		return "", fmt.Errorf("unsupported spec.executor=%q", executor)
+	}
+}
+
+// supportsNative reports whether kind runs without the agent, either in
+// nereid-runner or as a generated toolchain script.
+func supportsNative(kind string) bool {
+	switch kind {
+	case "gdal.rastertile.v1", "laz.3dtiles.v1":
+		return true
+	}
+	return runner.Supports(kind)
+}
+
+// nativeScriptPrelude sets up the generated toolchain scripts: fail
+// records its message as the container's termination message so it shows
+// up in Work.status.message, and run names the step that failed.
+const nativeScriptPrelude = `set -eu
+OUT="${NEREID_ARTIFACT_DIR}"
+WORK_DIR="$(mktemp -d)"
+trap 'rm -rf "${WORK_DIR}"' EXIT
+
+fail() {
+  printf '%s' "$1" > /dev/termination-log 2>/dev/null || true
+  echo "$1" >&2
+  exit 1
+}
+
+run() {
+  step="$1"
+  shift
+  echo "+ $*"
+  if ! "$@" 2> "${WORK_DIR}/stderr"; then
+    cat "${WORK_DIR}/stderr" >&2
+    fail "${step} failed: $(tail -n 3 "${WORK_DIR}/stderr" | tr '\n' ' ')"
+  fi
+  cat "${WORK_DIR}/stderr" >&2
+}
+
+# fetch_input copies an http(s), file:// or local input to $2.
+fetch_input() {
+  case "$1" in
+    http://*|https://*) run download curl -fsSL --retry 4 --retry-delay 2 -o "$2" "$1" ;;
+    file://*) run copy cp "${1#file://}" "$2" ;;
+    *://*) fail "unsupported input uri scheme: ${1%%://*}" ;;
+    *) run copy cp "$1" "$2" ;;
+  esac
+}
+
+`
+
+// buildNativeScript returns the Job script for a native executor.
+func buildNativeScript(work *unstructured.Unstructured, kind string, spec map[string]interface{}) (string, error) {
+	switch kind {
+	case "gdal.rastertile.v1":
+		return buildRasterTileScript(work, spec)
+	case "laz.3dtiles.v1":
+		return buildPointcloudScript(work, spec)
+	}
+	return buildNativeRunnerScript(spec)
+}
+
+func (c *Controller) grantHasAgentKey(ctx context.Context, grant *unstructured.Unstructured) bool {
+	envVars, secretData, err := grantEnvVars(ctx, c.kube, grant, "")
+	if err != nil {
+		// applyGrantToJob reports the same error; keep the agent so the
+		// Work fails with the Grant problem rather than a silent fallback.
+		return true
+	}
+	if len(secretData[agentAPIKeyEnv]) > 0 {
+		return true
+	}
+	for _, env := range envVars {
+		if env.Name == agentAPIKeyEnv && env.ValueFrom == nil && strings.TrimSpace(env.Value) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// buildNativeRunnerScript decodes the Work spec inside the pod and runs
+// nereid-runner on it. buildAgentScript wraps it so logs and attempts are
+// recorded the same way as for agent runs.
+func buildNativeRunnerScript(spec map[string]interface{}) (string, error) {
+	raw, err := json.Marshal(spec)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode spec for native executor: %v", err)
+	}
+	return fmt.Sprintf(`set -eu
+SPEC_B64=%q
+SPEC_FILE=/tmp/nereid-work-spec.json
+printf '%%s' "${SPEC_B64}" | base64 -d > "${SPEC_FILE}"
+exec nereid-runner --spec "${SPEC_FILE}" --out "${NEREID_ARTIFACT_DIR}"
+`, base64.StdEncoding.EncodeToString(raw)), nil
+}
@@ -0,0 +1,274 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestWorkExecutorSelection(t *testing.T) {
+	c := &Controller{kube: fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "gemini", Namespace: "nereid"},
+		Data:       map[string][]byte{"api-key": []byte("key")},
+	})}
+	grantWithEnv := func(env ...interface{}) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"metadata": map[string]interface{}{"name": "demo", "namespace": "nereid"},
+			"spec":     map[string]interface{}{"env": env},
+		}}
+	}
+	secretKey := grantWithEnv(map[string]interface{}{
+		"name":         agentAPIKeyEnv,
+		"secretKeyRef": map[string]interface{}{"name": "gemini", "key": "api-key"},
+	})
+	missingOptionalKey := grantWithEnv(map[string]interface{}{
+		"name":         agentAPIKeyEnv,
+		"secretKeyRef": map[string]interface{}{"name": "absent", "key": "api-key", "optional": true},
+	})
+	valueKey := grantWithEnv(map[string]interface{}{"name": agentAPIKeyEnv, "value": "key"})
+
+	for _, tc := range []struct {
+		name     string
+		kind     string
+		executor string
+		grant    *unstructured.Unstructured
+		want     string
+		wantErr  string
+	}{
+		{name: "no grant", kind: "overpassql.map.v1", want: executorNative},
+		{name: "grant without key", kind: "overpassql.map.v1", grant: grantWithEnv(), want: executorNative},
+		{name: "missing optional key", kind: "overpassql.map.v1", grant: missingOptionalKey, want: executorNative},
+		{name: "secret key", kind: "overpassql.map.v1", grant: secretKey, want: executorAgent},
+		{name: "value key", kind: "overpassql.map.v1", grant: valueKey, want: executorAgent},
+		{name: "explicit native", kind: "overpassql.map.v1", executor: "native", grant: secretKey, want: executorNative},
+		{name: "explicit agent", kind: "overpassql.map.v1", executor: "agent", want: executorAgent},
+		{name: "kind without native executor", kind: "maplibre.style.v1", want: executorAgent},
+		{name: "generated native script", kind: "gdal.rastertile.v1", want: executorNative},
+		{name: "native for unsupported kind", kind: "agent.cli.v1", executor: "native", wantErr: `spec.executor=native is not supported for spec.kind="agent.cli.v1"`},
+		{name: "unknown executor", kind: "overpassql.map.v1", executor: "wasm", wantErr: `unsupported spec.executor="wasm"`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			spec := map[string]interface{}{"kind": tc.kind}
+			if tc.executor != "" {
+				spec["executor"] = tc.executor
+			}
+			work := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+			got, err := c.workExecutor(context.Background(), work, tc.kind, tc.grant)
+			if tc.wantErr != "" {
+				if err == nil || err.Error() != tc.wantErr {
+					t.Fatalf("workExecutor() error = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("workExecutor() error = %v", err)
+			}
+			if got != tc.want {
+				t.Fatalf("workExecutor() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestBuildJobNativeExecutorRunsRunner(t *testing.T) {
+	t.Setenv("NEREID_AGENT_IMAGE", "nereid-agent-runtime:test")
+	work := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "parks", "namespace": "nereid"},
+		"spec": map[string]interface{}{
+			"kind":     "overpassql.map.v1",
+			"title":    "parks",
+			"executor": "native",
+			"overpass": map[string]interface{}{
+				"endpoint": "https://overpass-api.de/api/interpreter",
+				"query":    "[out:json];way[leisure=park](35.6,139.7,35.7,139.8);out geom;",
+			},
+		},
+	}}
+	c := &Controller{cfg: Config{JobNamespace: "nereid-work", ArtifactsHostPath: "/var/lib/nereid/artifacts"}}
+
+	job, err := c.buildJob(work, "work-parks", "overpassql.map.v1", executorNative)
+	if err != nil {
+		t.Fatalf("buildJob() error = %v", err)
+	}
+	if got := job.Spec.Template.Spec.Containers[0].Image; got != "nereid-agent-runtime:test" {
+		t.Fatalf("image = %q, want the runtime image", got)
+	}
+	if got := job.Annotations[executorAnnotationKey]; got != executorNative {
+		t.Fatalf("executor annotation = %q", got)
+	}
+	embedded := decodeEmbeddedAgentScript(t, job.Spec.Template.Spec.Containers[0].Args[0])
+	if !strings.Contains(embedded, `exec nereid-runner --spec "${SPEC_FILE}" --out "${NEREID_ARTIFACT_DIR}"`) {
+		t.Fatalf("embedded script does not run nereid-runner:\n%s", embedded)
+	}
+	if strings.Contains(embedded, "gemini") {
+		t.Fatalf("native script must not call the agent:\n%s", embedded)
+	}
+	var spec map[string]interface{}
+	if err := json.Unmarshal([]byte(decodeEmbeddedB64Var(t, embedded, "SPEC_B64")), &spec); err != nil {
+		t.Fatalf("decode embedded spec: %v", err)
+	}
+	if q, _, _ := unstructured.NestedString(spec, "overpass", "query"); !strings.Contains(q, "leisure=park") {
+		t.Fatalf("embedded spec query = %q", q)
+	}
+}
+
+func TestBuildRasterTileScript(t *testing.T) {
+	work := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "geotiff", "namespace": "nereid"},
+		"spec": map[string]interface{}{
+			"kind":  "gdal.rastertile.v1",
+			"title": "DRG <sample>",
+			"raster": map[string]interface{}{
+				"input":        map[string]interface{}{"uri": "https://download.osgeo.org/geotiff/samples/usgs/o41078a5.tif"},
+				"nodata":       map[string]interface{}{"src": "", "dst": "0"},
+				"reprojection": map[string]interface{}{"targetEPSG": "3857", "resampling": "Bilinear"},
+				"tiles":        map[string]interface{}{"minZoom": int64(3), "maxZoom": int64(30)},
+				"output":       map[string]interface{}{"cog": true},
+			},
+		},
+	}}
+	c := &Controller{cfg: Config{JobNamespace: "nereid-work", ArtifactsHostPath: "/var/lib/nereid/artifacts"}}
+	job, err := c.buildJob(work, "work-geotiff", "gdal.rastertile.v1", executorNative)
+	if err != nil {
+		t.Fatalf("buildJob() error = %v", err)
+	}
+	script := decodeEmbeddedAgentScript(t, job.Spec.Template.Spec.Containers[0].Args[0])
+	for _, needle := range []string{
+		`INPUT_URI='https://download.osgeo.org/geotiff/samples/usgs/o41078a5.tif'`,
+		`TARGET_SRS='EPSG:3857'`,
+		`RESAMPLING='bilinear'`,
+		"MIN_ZOOM=3\nMAX_ZOOM=24\n",
+		`gdalinfo -json "${WORK_DIR}/input" > "${OUT}/gdalinfo.json"`,
+		`gdal_translate -q "$@" -of GTiff`,
+		`gdalwarp -q -overwrite -t_srs "${TARGET_SRS}" -r "${RESAMPLING}" -dstnodata '0' -of GTiff`,
+		`gdal2tiles.py --xyz --profile=mercator -z "${MIN_ZOOM}-${MAX_ZOOM}"`,
+		`gdal_translate -q -of COG -co COMPRESS=DEFLATE "${WORK_DIR}/warped.tif" "${OUT}/raster.cog.tif"`,
+	} {
+		if !strings.Contains(script, needle) {
+			t.Fatalf("script missing %q:\n%s", needle, script)
+		}
+	}
+	viewer := decodeEmbeddedB64Var(t, script, "VIEWER_B64")
+	for _, needle := range []string{
+		"<title>DRG &lt;sample&gt;</title>",
+		`"./tiles/{z}/{x}/{y}.png"`,
+		`fetch("./gdalinfo.json")`,
+		`minzoom:  3 , maxzoom:  24 `,
+		`href="./raster.cog.tif"`,
+	} {
+		if !strings.Contains(viewer, needle) {
+			t.Fatalf("viewer missing %q:\n%s", needle, viewer)
+		}
+	}
+
+	for field, value := range map[string]interface{}{"resampling": "gauss", "targetEPSG": "WGS84"} {
+		bad := work.DeepCopy()
+		if err := unstructured.SetNestedField(bad.Object, value, "spec", "raster", "reprojection", field); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.buildJob(bad, "work-geotiff", "gdal.rastertile.v1", executorNative); err == nil {
+			t.Fatalf("buildJob() with %s=%v succeeded", field, value)
+		}
+	}
+}
+
+func TestBuildPointcloudScript(t *testing.T) {
+	work := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "laz", "namespace": "nereid"},
+		"spec": map[string]interface{}{
+			"kind":  "laz.3dtiles.v1",
+			"title": "LAZ",
+			"pointcloud": map[string]interface{}{
+				"input": map[string]interface{}{"uri": "https://github.com/PDAL/data/raw/master/las/1.2-with-color.laz"},
+				"crs": map[string]interface{}{
+					"source": "EPSG:4326", "target": "EPSG:3857",
+					"inAxisOrdering": "2,1", "outAxisOrdering": "1,2",
+				},
+				"py3dtiles": map[string]interface{}{"jobs": int64(4), "pyprojAlwaysXY": true},
+			},
+		},
+	}}
+	c := &Controller{cfg: Config{JobNamespace: "nereid-work", ArtifactsHostPath: "/var/lib/nereid/artifacts"}}
+	job, err := c.buildJob(work, "work-laz", "laz.3dtiles.v1", executorNative)
+	if err != nil {
+		t.Fatalf("buildJob() error = %v", err)
+	}
+	script := decodeEmbeddedAgentScript(t, job.Spec.Template.Spec.Containers[0].Args[0])
+	for _, needle := range []string{
+		`INPUT="${WORK_DIR}/input.laz"`,
+		`pdal info --summary "${INPUT}" > "${OUT}/pdal-info.json"`,
+		`pdal translate "${INPUT}" "${WORK_DIR}/reprojected.las" reprojection --filters.reprojection.out_srs='EPSG:3857' --filters.reprojection.in_srs='EPSG:4326' --filters.reprojection.in_axis_ordering=2,1 --filters.reprojection.out_axis_ordering=1,2`,
+		`py3dtiles convert "${WORK_DIR}/reprojected.las" --out "${OUT}/3dtiles" --overwrite --jobs 4 --srs_in 3857 --srs_out 4978 --pyproj-always-xy`,
+		`> "${OUT}/position.json"`,
+	} {
+		if !strings.Contains(script, needle) {
+			t.Fatalf("script missing %q:\n%s", needle, script)
+		}
+	}
+	viewer := decodeEmbeddedB64Var(t, script, "VIEWER_B64")
+	for _, needle := range []string{`Cesium3DTileset.fromUrl("./3dtiles/tileset.json")`, `fetch("./position.json")`} {
+		if !strings.Contains(viewer, needle) {
+			t.Fatalf("viewer missing %q:\n%s", needle, viewer)
+		}
+	}
+
+	bad := work.DeepCopy()
+	_ = unstructured.SetNestedField(bad.Object, "lat,lon", "spec", "pointcloud", "crs", "inAxisOrdering")
+	if _, err := c.buildJob(bad, "work-laz", "laz.3dtiles.v1", executorNative); err == nil || !strings.Contains(err.Error(), "inAxisOrdering") {
+		t.Fatalf("buildJob() with bad axis ordering error = %v", err)
+	}
+}
+
+func TestScalePointcloudCPU(t *testing.T) {
+	newJob := func() *batchv1.Job {
+		return &batchv1.Job{Spec: batchv1.JobSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")},
+				Limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m")},
+			},
+		}}}}}}
+	}
+	grantLimit := func(cpu string) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"metadata": map[string]interface{}{"name": "g"},
+			"spec":     map[string]interface{}{"resources": map[string]interface{}{"limits": map[string]interface{}{"cpu": cpu}}},
+		}}
+	}
+	for _, tc := range []struct {
+		name           string
+		jobs           int
+		grant          *unstructured.Unstructured
+		request, limit string
+	}{
+		{name: "no grant", jobs: 4, request: "4", limit: "4"},
+		{name: "grant limit caps request", jobs: 4, grant: grantLimit("2"), request: "2", limit: "2"},
+		{name: "grant limit above jobs", jobs: 2, grant: grantLimit("8"), request: "2", limit: "8"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			job := newJob()
+			if tc.grant != nil {
+				// applyGrantToJob has already copied the Grant's limit.
+				cpu, _, _ := unstructured.NestedString(tc.grant.Object, "spec", "resources", "limits", "cpu")
+				job.Spec.Template.Spec.Containers[0].Resources.Limits[corev1.ResourceCPU] = resource.MustParse(cpu)
+			}
+			if err := scalePointcloudCPU(job, tc.jobs, tc.grant); err != nil {
+				t.Fatal(err)
+			}
+			res := job.Spec.Template.Spec.Containers[0].Resources
+			if got := res.Requests[corev1.ResourceCPU]; got.Cmp(resource.MustParse(tc.request)) != 0 {
+				t.Fatalf("cpu request = %s, want %s", got.String(), tc.request)
+			}
+			if got := res.Limits[corev1.ResourceCPU]; got.Cmp(resource.MustParse(tc.limit)) != 0 {
+				t.Fatalf("cpu limit = %s, want %s", got.String(), tc.limit)
+			}
+		})
+	}
+}
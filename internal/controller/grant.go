@@ -0,0 +1,571 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+)
+
+const grantLabelKey = "nereid.yuiseki.net/grant"
+
+const (
+	grantPhaseActive    = "Active"
+	grantPhaseExpired   = "Expired"
+	grantPhaseExhausted = "Exhausted"
+	grantPhaseDisabled  = "Disabled"
+)
+
+const usageRecordedAnnotationKey = "nereid.yuiseki.net/usage-recorded"
+
+// grantPendingRequeue is a fallback; Works Pending on a Grant are normally
+// requeued when one of the Grant's Jobs finishes.
+const grantPendingRequeue = time.Minute
+
+// pendingGrantJobTTL bounds how long a reservation is counted for a Job the
+// Job informer has not shown yet; it only has to cover informer lag.
+const pendingGrantJobTTL = 5 * time.Minute
+
+var (
+	errGrantExhausted   = errors.New("grant exhausted")
+	errGrantBudget      = errors.New("grant budget exceeded")
+	errGrantConcurrency = errors.New("grant concurrency limit reached")
+)
+
+// jobCost is compute time in CPU-seconds and memory GiB-seconds, derived from
+// container limits multiplied by run time.
+type jobCost struct {
+	cpuSeconds       int64
+	memoryGiBSeconds int64
+}
+
+// grantUsage mirrors the counters kept in Grant.status.
+type grantUsage struct {
+	used     int64
+	active   int64
+	consumed jobCost
+	reserved jobCost
+}
+
+func readGrantUsage(grant *unstructured.Unstructured) grantUsage {
+	get := func(field string) int64 {
+		v, _, _ := unstructured.NestedInt64(grant.Object, "status", field)
+		return v
+	}
+	return grantUsage{
+		used:     get("used"),
+		active:   get("active"),
+		consumed: jobCost{cpuSeconds: get("cpuSecondsUsed"), memoryGiBSeconds: get("memoryGiBSecondsUsed")},
+		reserved: jobCost{cpuSeconds: get("cpuSecondsReserved"), memoryGiBSeconds: get("memoryGiBSecondsReserved")},
+	}
+}
+
+// setGrantStatus writes u and the derived phase into a copy of the Grant's
+// status and returns it.
+func setGrantStatus(grant *unstructured.Unstructured, u grantUsage, now time.Time) map[string]interface{} {
+	status := grantStatusMap(grant)
+	status["used"] = u.used
+	status["active"] = u.active
+	status["cpuSecondsUsed"] = u.consumed.cpuSeconds
+	status["memoryGiBSecondsUsed"] = u.consumed.memoryGiBSeconds
+	status["cpuSecondsReserved"] = u.reserved.cpuSeconds
+	status["memoryGiBSecondsReserved"] = u.reserved.memoryGiBSeconds
+	status["phase"] = grantPhase(grant, u, now)
+	return status
+}
+
+// grantLimits holds the Grant's spec.maxUses and spec.limits; zero means
+// unlimited.
+type grantLimits struct {
+	maxUses          int64
+	maxConcurrent    int64
+	cpuSeconds       int64
+	memoryGiBSeconds int64
+}
+
+func readGrantLimits(grant *unstructured.Unstructured) grantLimits {
+	get := func(fields ...string) int64 {
+		v, _, _ := unstructured.NestedInt64(grant.Object, fields...)
+		return v
+	}
+	return grantLimits{
+		maxUses:          get("spec", "maxUses"),
+		maxConcurrent:    get("spec", "limits", "maxConcurrent"),
+		cpuSeconds:       get("spec", "limits", "cpuSecondsBudget"),
+		memoryGiBSeconds: get("spec", "limits", "memoryGiBSecondsBudget"),
+	}
+}
+
+// budgetExceeded reports which budget, if any, cost would push past its
+// limit. Limits of zero are unlimited.
+func (l grantLimits) budgetExceeded(cost jobCost) string {
+	if l.cpuSeconds > 0 && cost.cpuSeconds > l.cpuSeconds {
+		return fmt.Sprintf("cpuSecondsBudget=%d", l.cpuSeconds)
+	}
+	if l.memoryGiBSeconds > 0 && cost.memoryGiBSeconds > l.memoryGiBSeconds {
+		return fmt.Sprintf("memoryGiBSecondsBudget=%d", l.memoryGiBSeconds)
+	}
+	return ""
+}
+
+// grantReservation returns the Grant a Work has already consumed a use of.
+// Retry attempts of that Work do not consume another use.
+func grantReservation(work *unstructured.Unstructured) string {
+	name, _, _ := unstructured.NestedString(work.Object, "status", "grantReservation")
+	return name
+}
+
+// grantUsed returns Grant.status.used. Grants created before usage was tracked
+// in status are seeded from the Works that still have Jobs.
+func (c *Controller) grantUsed(ctx context.Context, grant *unstructured.Unstructured, excludeWork string) (int64, error) {
+	used, found, err := unstructured.NestedInt64(grant.Object, "status", "used")
+	if err != nil {
+		return 0, fmt.Errorf("failed to read grant %q status.used: %v", grant.GetName(), err)
+	}
+	if found {
+		return used, nil
+	}
+	jobs, err := c.kube.BatchV1().Jobs(c.cfg.JobNamespace).List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", grantLabelKey, grant.GetName()),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("list jobs for grant %q usage: %w", grant.GetName(), err)
+	}
+	works := map[string]bool{}
+	for _, job := range jobs.Items {
+		if name := job.Labels[workLabelKey]; name != "" && name != excludeWork {
+			works[name] = true
+		}
+	}
+	return int64(len(works)), nil
+}
+
+// reserveGrant admits job against the Grant's limits. consumeUse is set for
+// a Work's first Job and counts against maxUses. The Job's worst-case cost
+// (activeDeadlineSeconds times its limits) is reserved against the budgets
+// until the Job finishes; errGrantBudget means it does not fit what is left
+// of the budget, while errGrantConcurrency means it has to wait for running
+// Jobs. This is a single optimistic-concurrency status
+// update made before the Job is created, so concurrent Works cannot overrun
+// any limit.
+func (c *Controller) reserveGrant(ctx context.Context, work, grant *unstructured.Unstructured, job *batchv1.Job, consumeUse bool) error {
+	cost := jobMaxCost(job)
+	latest := grant.DeepCopy()
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		if latest == nil {
+			obj, err := c.dynamic.Resource(grantGVR).Namespace(grant.GetNamespace()).Get(ctx, grant.GetName(), metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			latest = obj
+		}
+
+		limits := readGrantLimits(latest)
+		usage := readGrantUsage(latest)
+		used, err := c.grantUsed(ctx, latest, work.GetName())
+		if err != nil {
+			return err
+		}
+		usage.used = used
+		if consumeUse && limits.maxUses > 0 && usage.used >= limits.maxUses {
+			return fmt.Errorf("%w: grant %q maxUses=%d used=%d", errGrantExhausted, grant.GetName(), limits.maxUses, usage.used)
+		}
+		spent := jobCost{
+			cpuSeconds:       usage.consumed.cpuSeconds + cost.cpuSeconds,
+			memoryGiBSeconds: usage.consumed.memoryGiBSeconds + cost.memoryGiBSeconds,
+		}
+		if exceeded := limits.budgetExceeded(spent); exceeded != "" {
+			return fmt.Errorf("%w: grant %q %s; used cpu=%ds memory=%dGiBs, this job may need cpu=%ds memory=%dGiBs",
+				errGrantBudget, grant.GetName(), exceeded,
+				usage.consumed.cpuSeconds, usage.consumed.memoryGiBSeconds,
+				cost.cpuSeconds, cost.memoryGiBSeconds)
+		}
+		// Budget still held by running Jobs may come back, so that case waits
+		// like the concurrency limit instead of failing.
+		committed := jobCost{
+			cpuSeconds:       spent.cpuSeconds + usage.reserved.cpuSeconds,
+			memoryGiBSeconds: spent.memoryGiBSeconds + usage.reserved.memoryGiBSeconds,
+		}
+		if exceeded := limits.budgetExceeded(committed); exceeded != "" {
+			return fmt.Errorf("%w: grant %q %s is reserved by %d running jobs", errGrantConcurrency, grant.GetName(), exceeded, usage.active)
+		}
+		if limits.maxConcurrent > 0 && usage.active >= limits.maxConcurrent {
+			return fmt.Errorf("%w: grant %q has %d/%d concurrent jobs", errGrantConcurrency, grant.GetName(), usage.active, limits.maxConcurrent)
+		}
+
+		if consumeUse {
+			usage.used++
+		}
+		usage.active++
+		usage.reserved.cpuSeconds += cost.cpuSeconds
+		usage.reserved.memoryGiBSeconds += cost.memoryGiBSeconds
+
+		updated := latest.DeepCopy()
+		now := c.nowFunc()
+		status := setGrantStatus(updated, usage, now)
+		status["lastUsedAt"] = formatStatusTime(now)
+		updated.Object["status"] = status
+		// Track the Job before the update lands so a concurrent
+		// syncGrantStatus never sees the reservation without it.
+		c.pendingGrantJobs.add(grant.GetNamespace(), grant.GetName(), job, now)
+		_, err = c.dynamic.Resource(grantGVR).Namespace(grant.GetNamespace()).UpdateStatus(ctx, updated, metav1.UpdateOptions{})
+		if err != nil {
+			c.pendingGrantJobs.remove(job.Name)
+		}
+		if apierrors.IsConflict(err) {
+			latest = nil
+		}
+		return err
+	})
+}
+
+// releaseGrant undoes reserveGrant for a Job that was never created.
+func (c *Controller) releaseGrant(ctx context.Context, namespace, name string, job *batchv1.Job, consumedUse bool) error {
+	cost := jobMaxCost(job)
+	c.pendingGrantJobs.remove(job.Name)
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		grant, err := c.dynamic.Resource(grantGVR).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		usage := readGrantUsage(grant)
+		if consumedUse {
+			usage.used = max(usage.used-1, 0)
+		}
+		usage.active = max(usage.active-1, 0)
+		usage.reserved.cpuSeconds = max(usage.reserved.cpuSeconds-cost.cpuSeconds, 0)
+		usage.reserved.memoryGiBSeconds = max(usage.reserved.memoryGiBSeconds-cost.memoryGiBSeconds, 0)
+		grant.Object["status"] = setGrantStatus(grant, usage, c.nowFunc())
+		_, err = c.dynamic.Resource(grantGVR).Namespace(namespace).UpdateStatus(ctx, grant, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// recordGrantJobUsage charges a finished (or deleted) Job's actual run time to
+// its Grant. The Job is annotated afterwards so it is charged only once.
+func (c *Controller) recordGrantJobUsage(ctx context.Context, grantNamespace, jobName string) error {
+	// Read the Job from the API server: a cached copy may not show the
+	// annotation yet, which would charge the Job twice.
+	job, err := c.kube.BatchV1().Jobs(c.cfg.JobNamespace).Get(ctx, jobName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("get job %s/%s for usage: %w", c.cfg.JobNamespace, jobName, err)
+	}
+	grantName := job.Labels[grantLabelKey]
+	if grantName == "" || job.Annotations[usageRecordedAnnotationKey] == "true" {
+		return nil
+	}
+	cost := jobActualCost(job, c.nowFunc())
+
+	err = retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		grant, err := c.dynamic.Resource(grantGVR).Namespace(grantNamespace).Get(ctx, grantName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		usage := readGrantUsage(grant)
+		usage.consumed.cpuSeconds += cost.cpuSeconds
+		usage.consumed.memoryGiBSeconds += cost.memoryGiBSeconds
+		grant.Object["status"] = setGrantStatus(grant, usage, c.nowFunc())
+		_, err = c.dynamic.Resource(grantGVR).Namespace(grantNamespace).UpdateStatus(ctx, grant, metav1.UpdateOptions{})
+		return err
+	})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("record usage of job %s on grant %s/%s: %w", jobName, grantNamespace, grantName, err)
+	}
+
+	patch := []byte(fmt.Sprintf(`{"metadata":{"annotations":{%q:"true"}}}`, usageRecordedAnnotationKey))
+	if _, err := c.kube.BatchV1().Jobs(c.cfg.JobNamespace).Patch(ctx, jobName, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("mark usage recorded on job %s/%s: %w", c.cfg.JobNamespace, jobName, err)
+	}
+	return nil
+}
+
+// syncGrantStatus recomputes status.active and the reserved budget from
+// unfinished Jobs, including reserved Jobs the informer has not shown yet,
+// and status.phase from the spec and current usage.
+func (c *Controller) syncGrantStatus(ctx context.Context, namespace, name string) error {
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		grant, err := c.dynamic.Resource(grantGVR).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		usage := readGrantUsage(grant)
+		if usage.used, err = c.grantUsed(ctx, grant, ""); err != nil {
+			return err
+		}
+		active, err := c.activeGrantJobs(ctx, namespace, name)
+		if err != nil {
+			return err
+		}
+		usage.active = int64(len(active))
+		usage.reserved = jobCost{}
+		for _, job := range active {
+			cost := jobMaxCost(job)
+			usage.reserved.cpuSeconds += cost.cpuSeconds
+			usage.reserved.memoryGiBSeconds += cost.memoryGiBSeconds
+		}
+
+		current, _, _ := unstructured.NestedMap(grant.Object, "status")
+		desired := setGrantStatus(grant, usage, c.nowFunc())
+		if equality.Semantic.DeepEqual(current, desired) {
+			return nil
+		}
+		grant.Object["status"] = desired
+		_, err = c.dynamic.Resource(grantGVR).Namespace(namespace).UpdateStatus(ctx, grant, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// setGrantArtifactBytes reports the size of the Grant's stored artifacts in
+// status.artifactBytes.
+func (c *Controller) setGrantArtifactBytes(ctx context.Context, grant *unstructured.Unstructured, bytes int64) error {
+	if current, found, _ := unstructured.NestedInt64(grant.Object, "status", "artifactBytes"); found && current == bytes {
+		return nil
+	}
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		latest, err := c.dynamic.Resource(grantGVR).Namespace(grant.GetNamespace()).Get(ctx, grant.GetName(), metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		status := grantStatusMap(latest)
+		if current, ok := status["artifactBytes"].(int64); ok && current == bytes {
+			return nil
+		}
+		status["artifactBytes"] = bytes
+		latest.Object["status"] = status
+		_, err = c.dynamic.Resource(grantGVR).Namespace(grant.GetNamespace()).UpdateStatus(ctx, latest, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// releaseGrantSlot refreshes the Grant's status after one of its Jobs stopped
+// and wakes Works that were Pending on its limits.
+func (c *Controller) releaseGrantSlot(ctx context.Context, namespace, name string) {
+	if err := c.syncGrantStatus(ctx, namespace, name); err != nil {
+		c.logger.Warn("failed to update grant status", "grant", name, "namespace", namespace, "error", err)
+	}
+	if c.workIndexer == nil {
+		return
+	}
+	grant := &unstructured.Unstructured{}
+	grant.SetNamespace(namespace)
+	grant.SetName(name)
+	c.enqueueWorksForGrant(grant)
+}
+
+// syncGrantStatuses refreshes every cached Grant; expiry and Job cleanup do
+// not produce Work events, so this runs on the safety resync.
+func (c *Controller) syncGrantStatuses(ctx context.Context) {
+	if c.grantLister == nil {
+		return
+	}
+	grants, err := c.grantLister.List(labels.Everything())
+	if err != nil {
+		c.logger.Warn("failed to list grants for status sync", "error", err)
+		return
+	}
+	for _, obj := range grants {
+		grant, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		if err := c.syncGrantStatus(ctx, grant.GetNamespace(), grant.GetName()); err != nil {
+			c.logger.Warn("failed to update grant status", "grant", grant.GetName(), "namespace", grant.GetNamespace(), "error", err)
+		}
+	}
+}
+
+func (c *Controller) activeGrantJobs(ctx context.Context, grantNamespace, grantName string) ([]*batchv1.Job, error) {
+	var jobs []*batchv1.Job
+	if c.jobLister != nil {
+		selector := labels.SelectorFromSet(labels.Set{grantLabelKey: grantName})
+		listed, err := c.jobLister.Jobs(c.cfg.JobNamespace).List(selector)
+		if err != nil {
+			return nil, err
+		}
+		seen := make(map[string]bool, len(listed))
+		for _, job := range listed {
+			seen[job.Name] = true
+		}
+		jobs = append(listed, c.pendingGrantJobs.unobserved(grantNamespace, grantName, seen, c.nowFunc())...)
+	} else {
+		list, err := c.kube.BatchV1().Jobs(c.cfg.JobNamespace).List(ctx, metav1.ListOptions{
+			LabelSelector: fmt.Sprintf("%s=%s", grantLabelKey, grantName),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("list jobs for grant %q: %w", grantName, err)
+		}
+		for i := range list.Items {
+			jobs = append(jobs, &list.Items[i])
+		}
+	}
+
+	active := make([]*batchv1.Job, 0, len(jobs))
+	for _, job := range jobs {
+		if job.DeletionTimestamp == nil && job.Status.Succeeded == 0 && job.Status.Failed == 0 {
+			active = append(active, job)
+		}
+	}
+	return active, nil
+}
+
+// pendingGrantJobs holds Jobs reserved against a Grant that the Job informer
+// may not have delivered yet, keyed by Job name. The zero value is ready to
+// use.
+type pendingGrantJobs struct {
+	mu   sync.Mutex
+	jobs map[string]pendingGrantJob
+}
+
+type pendingGrantJob struct {
+	grant      string
+	job        *batchv1.Job
+	reservedAt time.Time
+}
+
+func (p *pendingGrantJobs) add(namespace, grant string, job *batchv1.Job, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.jobs == nil {
+		p.jobs = map[string]pendingGrantJob{}
+	}
+	p.jobs[job.Name] = pendingGrantJob{grant: namespace + "/" + grant, job: job, reservedAt: now}
+}
+
+func (p *pendingGrantJobs) remove(jobName string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.jobs, jobName)
+}
+
+// unobserved returns the Grant's pending Jobs that are not in seen. Jobs in
+// seen, or pending for longer than pendingGrantJobTTL, are forgotten.
+func (p *pendingGrantJobs) unobserved(namespace, grant string, seen map[string]bool, now time.Time) []*batchv1.Job {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var out []*batchv1.Job
+	for name, pending := range p.jobs {
+		if pending.grant != namespace+"/"+grant {
+			continue
+		}
+		if seen[name] || now.Sub(pending.reservedAt) > pendingGrantJobTTL {
+			delete(p.jobs, name)
+			continue
+		}
+		out = append(out, pending.job)
+	}
+	return out
+}
+
+// jobLimitRates sums container limits (requests when no limit is set) as CPU
+// cores and memory GiB.
+func jobLimitRates(job *batchv1.Job) (cores, gib float64) {
+	for _, container := range job.Spec.Template.Spec.Containers {
+		cpu, ok := container.Resources.Limits[corev1.ResourceCPU]
+		if !ok {
+			cpu = container.Resources.Requests[corev1.ResourceCPU]
+		}
+		mem, ok := container.Resources.Limits[corev1.ResourceMemory]
+		if !ok {
+			mem = container.Resources.Requests[corev1.ResourceMemory]
+		}
+		cores += float64(cpu.MilliValue()) / 1000
+		gib += float64(mem.Value()) / (1 << 30)
+	}
+	return cores, gib
+}
+
+func costFor(job *batchv1.Job, seconds float64) jobCost {
+	if seconds <= 0 {
+		return jobCost{}
+	}
+	cores, gib := jobLimitRates(job)
+	return jobCost{
+		cpuSeconds:       int64(math.Ceil(seconds * cores)),
+		memoryGiBSeconds: int64(math.Ceil(seconds * gib)),
+	}
+}
+
+// jobMaxCost is the most a Job can spend before activeDeadlineSeconds stops it.
+func jobMaxCost(job *batchv1.Job) jobCost {
+	if job == nil || job.Spec.ActiveDeadlineSeconds == nil {
+		return jobCost{}
+	}
+	return costFor(job, float64(*job.Spec.ActiveDeadlineSeconds))
+}
+
+// jobActualCost is what a Job spent from its start until it finished, or
+// until now for a Job that is being stopped.
+func jobActualCost(job *batchv1.Job, now time.Time) jobCost {
+	if job.Status.StartTime == nil {
+		return jobCost{}
+	}
+	end := now
+	if job.Status.CompletionTime != nil {
+		end = job.Status.CompletionTime.Time
+	} else {
+		for _, cond := range job.Status.Conditions {
+			if cond.Type == batchv1.JobFailed && cond.Status == corev1.ConditionTrue && !cond.LastTransitionTime.IsZero() {
+				end = cond.LastTransitionTime.Time
+			}
+		}
+	}
+	return costFor(job, end.Sub(job.Status.StartTime.Time).Seconds())
+}
+
+func grantPhase(grant *unstructured.Unstructured, usage grantUsage, now time.Time) string {
+	if enabled, found, _ := unstructured.NestedBool(grant.Object, "spec", "enabled"); found && !enabled {
+		return grantPhaseDisabled
+	}
+	if expiresAt, _, _ := unstructured.NestedString(grant.Object, "spec", "expiresAt"); strings.TrimSpace(expiresAt) != "" {
+		if ts, err := time.Parse(time.RFC3339, strings.TrimSpace(expiresAt)); err == nil && now.After(ts) {
+			return grantPhaseExpired
+		}
+	}
+	limits := readGrantLimits(grant)
+	if limits.maxUses > 0 && usage.used >= limits.maxUses {
+		return grantPhaseExhausted
+	}
+	if (limits.cpuSeconds > 0 && usage.consumed.cpuSeconds >= limits.cpuSeconds) ||
+		(limits.memoryGiBSeconds > 0 && usage.consumed.memoryGiBSeconds >= limits.memoryGiBSeconds) {
+		return grantPhaseExhausted
+	}
+	return grantPhaseActive
+}
+
+func grantStatusMap(grant *unstructured.Unstructured) map[string]interface{} {
+	current, _, _ := unstructured.NestedMap(grant.Object, "status")
+	out := runtime.DeepCopyJSON(current)
+	if out == nil {
+		out = map[string]interface{}{}
+	}
+	return out
+}
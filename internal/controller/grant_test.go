@@ -0,0 +1,392 @@
+package controller
+
+import (
+	"context"
+	"log/slog"
+	"reflect"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes/fake"
+	batchv1listers "k8s.io/client-go/listers/batch/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestCreateWorkJobReservesGrantUsesUpToMaxUses(t *testing.T) {
+	grant := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "nereid.yuiseki.net/v1alpha1",
+		"kind":       "Grant",
+		"metadata":   map[string]interface{}{"name": "one-shot", "namespace": "nereid"},
+		"spec":       map[string]interface{}{"maxUses": int64(1)},
+	}}
+	first := grantTestWork("first", "one-shot")
+	second := grantTestWork("second", "one-shot")
+	dc := newFakeDynamicClient(grant, first, second)
+	kc := fake.NewSimpleClientset()
+	now := time.Date(2026, 2, 15, 12, 0, 0, 0, time.UTC)
+	c := &Controller{
+		dynamic: dc,
+		kube:    kc,
+		cfg:     Config{JobNamespace: "nereid-work"},
+		logger:  slog.Default(),
+		nowFunc: func() time.Time { return now },
+	}
+
+	ctx := context.Background()
+	if err := c.reconcileWork(ctx, first); err != nil {
+		t.Fatalf("reconcileWork(first) error = %v", err)
+	}
+	if got := workPhase(ctx, t, dc, "nereid", "first"); got != "Submitted" {
+		t.Fatalf("first phase got=%q want=%q", got, "Submitted")
+	}
+	latestGrant, err := dc.Resource(grantGVR).Namespace("nereid").Get(ctx, "one-shot", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get grant: %v", err)
+	}
+	status, _, _ := unstructured.NestedMap(latestGrant.Object, "status")
+	if status["used"] != int64(1) || status["active"] != int64(1) || status["phase"] != grantPhaseExhausted || status["lastUsedAt"] != "2026-02-15T12:00:00Z" {
+		t.Fatalf("grant status after reservation mismatch: %#v", status)
+	}
+
+	// The second Work is rejected from the recorded usage.
+	if err := c.reconcileWork(ctx, second); err != nil {
+		t.Fatalf("reconcileWork(second) error = %v", err)
+	}
+	if got := workPhase(ctx, t, dc, "nereid", "second"); got != "Error" {
+		t.Fatalf("second phase got=%q want=%q", got, "Error")
+	}
+	if _, err := kc.BatchV1().Jobs("nereid-work").Get(ctx, makeJobName("second"), metav1.GetOptions{}); err == nil {
+		t.Fatal("second work must not get a job once the grant is exhausted")
+	}
+
+	// Usage survives Job cleanup.
+	if err := kc.BatchV1().Jobs("nereid-work").Delete(ctx, makeJobName("first"), metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("delete job: %v", err)
+	}
+	if err := c.syncGrantStatus(ctx, "nereid", "one-shot"); err != nil {
+		t.Fatalf("syncGrantStatus() error = %v", err)
+	}
+	latestGrant, err = dc.Resource(grantGVR).Namespace("nereid").Get(ctx, "one-shot", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get grant: %v", err)
+	}
+	status, _, _ = unstructured.NestedMap(latestGrant.Object, "status")
+	if status["used"] != int64(1) || status["active"] != int64(0) || status["phase"] != grantPhaseExhausted {
+		t.Fatalf("grant status after job cleanup mismatch: %#v", status)
+	}
+}
+
+func TestGrantPhase(t *testing.T) {
+	now := time.Date(2026, 2, 15, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name string
+		spec map[string]interface{}
+		used grantUsage
+		want string
+	}{
+		{name: "active", spec: map[string]interface{}{"maxUses": int64(2)}, used: grantUsage{used: 1}, want: grantPhaseActive},
+		{name: "exhausted", spec: map[string]interface{}{"maxUses": int64(2)}, used: grantUsage{used: 2}, want: grantPhaseExhausted},
+		{name: "cpu budget spent", spec: map[string]interface{}{"limits": map[string]interface{}{"cpuSecondsBudget": int64(60)}}, used: grantUsage{consumed: jobCost{cpuSeconds: 60}}, want: grantPhaseExhausted},
+		{name: "expired", spec: map[string]interface{}{"expiresAt": "2026-02-01T00:00:00Z"}, want: grantPhaseExpired},
+		{name: "disabled", spec: map[string]interface{}{"enabled": false, "maxUses": int64(1)}, used: grantUsage{used: 1}, want: grantPhaseDisabled},
+	}
+	for _, tc := range cases {
+		grant := &unstructured.Unstructured{Object: map[string]interface{}{"spec": tc.spec}}
+		if got := grantPhase(grant, tc.used, now); got != tc.want {
+			t.Fatalf("%s: grantPhase() got=%q want=%q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestCreateWorkJobKeepsWorkPendingAtGrantConcurrencyLimit(t *testing.T) {
+	grant := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "nereid.yuiseki.net/v1alpha1",
+		"kind":       "Grant",
+		"metadata":   map[string]interface{}{"name": "narrow", "namespace": "nereid"},
+		"spec": map[string]interface{}{
+			"limits": map[string]interface{}{"maxConcurrent": int64(1)},
+		},
+	}}
+	first := grantTestWork("first", "narrow")
+	second := grantTestWork("second", "narrow")
+	dc := newFakeDynamicClient(grant, first, second)
+	kc := fake.NewSimpleClientset()
+	c := &Controller{
+		dynamic: dc,
+		kube:    kc,
+		cfg:     Config{JobNamespace: "nereid-work"},
+		logger:  slog.Default(),
+		nowFunc: time.Now,
+	}
+
+	ctx := context.Background()
+	if err := c.reconcileWork(ctx, first); err != nil {
+		t.Fatalf("reconcileWork(first) error = %v", err)
+	}
+	if err := c.reconcileWork(ctx, second); err != nil {
+		t.Fatalf("reconcileWork(second) error = %v", err)
+	}
+	if got := workPhase(ctx, t, dc, "nereid", "second"); got != "Pending" {
+		t.Fatalf("second phase got=%q want=%q", got, "Pending")
+	}
+	if _, err := kc.BatchV1().Jobs("nereid-work").Get(ctx, makeJobName("second"), metav1.GetOptions{}); err == nil {
+		t.Fatal("second work must wait for a concurrency slot")
+	}
+
+	// Once the first Job finishes, its usage is charged and the slot frees up.
+	job, err := kc.BatchV1().Jobs("nereid-work").Get(ctx, makeJobName("first"), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	started := metav1.NewTime(time.Now().Add(-100 * time.Second))
+	completed := metav1.NewTime(started.Add(100 * time.Second))
+	job.Status.StartTime = &started
+	job.Status.CompletionTime = &completed
+	job.Status.Succeeded = 1
+	if _, err := kc.BatchV1().Jobs("nereid-work").UpdateStatus(ctx, job, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("update job status: %v", err)
+	}
+	latest, err := dc.Resource(workGVR).Namespace("nereid").Get(ctx, "first", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get work: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := c.reconcileWork(ctx, latest); err != nil {
+			t.Fatalf("reconcileWork(first finished) error = %v", err)
+		}
+	}
+	latestGrant, err := dc.Resource(grantGVR).Namespace("nereid").Get(ctx, "narrow", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get grant: %v", err)
+	}
+	usage := readGrantUsage(latestGrant)
+	// 100s at the default 500m/512Mi limits, charged once.
+	if usage.active != 0 || usage.consumed.cpuSeconds != 50 || usage.consumed.memoryGiBSeconds != 50 || usage.reserved != (jobCost{}) {
+		t.Fatalf("grant usage after job finished mismatch: %+v", usage)
+	}
+
+	latest, err = dc.Resource(workGVR).Namespace("nereid").Get(ctx, "second", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get work: %v", err)
+	}
+	if err := c.reconcileWork(ctx, latest); err != nil {
+		t.Fatalf("reconcileWork(second retry) error = %v", err)
+	}
+	if _, err := kc.BatchV1().Jobs("nereid-work").Get(ctx, makeJobName("second"), metav1.GetOptions{}); err != nil {
+		t.Fatalf("second work should get a job after the slot freed: %v", err)
+	}
+}
+
+func TestCreateWorkJobKeepsWorkBeyondGrantBudgetPending(t *testing.T) {
+	grant := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "nereid.yuiseki.net/v1alpha1",
+		"kind":       "Grant",
+		"metadata":   map[string]interface{}{"name": "tight", "namespace": "nereid"},
+		"spec": map[string]interface{}{
+			// The default job may run 600s at 500m, i.e. 300 CPU-seconds.
+			"limits": map[string]interface{}{"cpuSecondsBudget": int64(400)},
+		},
+		"status": map[string]interface{}{"used": int64(3), "cpuSecondsUsed": int64(200)},
+	}}
+	work := grantTestWork("costly", "tight")
+	dc := newFakeDynamicClient(grant, work)
+	kc := fake.NewSimpleClientset()
+	c := &Controller{
+		dynamic: dc,
+		kube:    kc,
+		cfg:     Config{JobNamespace: "nereid-work"},
+		logger:  slog.Default(),
+		nowFunc: time.Now,
+	}
+
+	ctx := context.Background()
+	if err := c.reconcileWork(ctx, work); err != nil {
+		t.Fatalf("reconcileWork() error = %v", err)
+	}
+	pending, err := dc.Resource(workGVR).Namespace("nereid").Get(ctx, "costly", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get work: %v", err)
+	}
+	phase, _, _ := unstructured.NestedString(pending.Object, "status", "phase")
+	reason, _, _ := unstructured.NestedString(pending.Object, "status", "reason")
+	if phase != "Pending" || reason != reasonGrantLimited {
+		t.Fatalf("status got phase=%q reason=%q want Pending/%s", phase, reason, reasonGrantLimited)
+	}
+	if _, err := kc.BatchV1().Jobs("nereid-work").Get(ctx, makeJobName("costly"), metav1.GetOptions{}); err == nil {
+		t.Fatal("work beyond the cpu budget must not get a job")
+	}
+
+	// Raising the budget admits the waiting Work.
+	latestGrant, err := dc.Resource(grantGVR).Namespace("nereid").Get(ctx, "tight", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get grant: %v", err)
+	}
+	if err := unstructured.SetNestedField(latestGrant.Object, int64(600), "spec", "limits", "cpuSecondsBudget"); err != nil {
+		t.Fatalf("set budget: %v", err)
+	}
+	if _, err := dc.Resource(grantGVR).Namespace("nereid").Update(ctx, latestGrant, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("update grant: %v", err)
+	}
+	if err := c.reconcileWork(ctx, pending); err != nil {
+		t.Fatalf("reconcileWork(raised) error = %v", err)
+	}
+	if _, err := kc.BatchV1().Jobs("nereid-work").Get(ctx, makeJobName("costly"), metav1.GetOptions{}); err != nil {
+		t.Fatalf("work should get a job once the budget fits: %v", err)
+	}
+}
+
+func TestCreateWorkJobAdoptsExistingJobWithoutReservingGrant(t *testing.T) {
+	grant := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "nereid.yuiseki.net/v1alpha1",
+		"kind":       "Grant",
+		"metadata":   map[string]interface{}{"name": "narrow", "namespace": "nereid"},
+		"spec": map[string]interface{}{
+			"maxUses": int64(5),
+			"limits":  map[string]interface{}{"maxConcurrent": int64(1)},
+		},
+		"status": map[string]interface{}{
+			"used": int64(1), "active": int64(1), "cpuSecondsReserved": int64(300), "memoryGiBSecondsReserved": int64(300),
+		},
+	}}
+	// The cached Work predates the status update that records its Job.
+	work := grantTestWork("first", "narrow")
+	jobName := makeAttemptJobName("first", 1)
+	existing := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{
+		Name:      jobName,
+		Namespace: "nereid-work",
+		Labels:    map[string]string{workLabelKey: "first", grantLabelKey: "narrow"},
+	}}
+	dc := newFakeDynamicClient(grant, work)
+	kc := fake.NewSimpleClientset(existing)
+	c := &Controller{
+		dynamic: dc,
+		kube:    kc,
+		cfg:     Config{JobNamespace: "nereid-work"},
+		logger:  slog.Default(),
+		nowFunc: time.Now,
+	}
+
+	ctx := context.Background()
+	if err := c.createWorkJob(ctx, work, "agent.cli.v1", "narrow", 1); err != nil {
+		t.Fatalf("createWorkJob() error = %v", err)
+	}
+	latestGrant, err := dc.Resource(grantGVR).Namespace("nereid").Get(ctx, "narrow", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get grant: %v", err)
+	}
+	want, _, _ := unstructured.NestedMap(grant.Object, "status")
+	got, _, _ := unstructured.NestedMap(latestGrant.Object, "status")
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("grant status changed for an existing job: got %#v want %#v", got, want)
+	}
+	latest, err := dc.Resource(workGVR).Namespace("nereid").Get(ctx, "first", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get work: %v", err)
+	}
+	phase, _, _ := unstructured.NestedString(latest.Object, "status", "phase")
+	if phase != "Submitted" || grantReservation(latest) != "narrow" {
+		t.Fatalf("work status got phase=%q grantReservation=%q", phase, grantReservation(latest))
+	}
+}
+
+func TestSyncGrantStatusKeepsReservationsForUncachedJobs(t *testing.T) {
+	grant := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "nereid.yuiseki.net/v1alpha1",
+		"kind":       "Grant",
+		"metadata":   map[string]interface{}{"name": "narrow", "namespace": "nereid"},
+		"spec": map[string]interface{}{
+			"limits": map[string]interface{}{"maxConcurrent": int64(2)},
+		},
+	}}
+	work := grantTestWork("first", "narrow")
+	dc := newFakeDynamicClient(grant, work)
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	c := &Controller{
+		dynamic:   dc,
+		kube:      fake.NewSimpleClientset(),
+		cfg:       Config{JobNamespace: "nereid-work"},
+		logger:    slog.Default(),
+		nowFunc:   time.Now,
+		jobLister: batchv1listers.NewJobLister(indexer),
+	}
+	job, err := c.buildJob(work, makeAttemptJobName("first", 1), "agent.cli.v1", executorAgent)
+	if err != nil {
+		t.Fatalf("buildJob() error = %v", err)
+	}
+	job.Labels[grantLabelKey] = "narrow"
+	cost := jobMaxCost(job)
+
+	ctx := context.Background()
+	usage := func() grantUsage {
+		t.Helper()
+		latest, err := dc.Resource(grantGVR).Namespace("nereid").Get(ctx, "narrow", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("get grant: %v", err)
+		}
+		return readGrantUsage(latest)
+	}
+	sync := func() {
+		t.Helper()
+		if err := c.syncGrantStatus(ctx, "nereid", "narrow"); err != nil {
+			t.Fatalf("syncGrantStatus() error = %v", err)
+		}
+	}
+
+	if err := c.reserveGrant(ctx, work, grant, job, true); err != nil {
+		t.Fatalf("reserveGrant() error = %v", err)
+	}
+	// The informer has not delivered the Job yet.
+	sync()
+	if got := usage(); got.active != 1 || got.reserved != cost {
+		t.Fatalf("usage after sync with an uncached job: %+v, want active=1 reserved=%+v", got, cost)
+	}
+
+	// Once cached, the Job is counted from the lister alone.
+	if err := indexer.Add(job); err != nil {
+		t.Fatalf("add job: %v", err)
+	}
+	sync()
+	if got := usage(); got.active != 1 || got.reserved != cost {
+		t.Fatalf("usage after sync with a cached job: %+v, want active=1 reserved=%+v", got, cost)
+	}
+	finished := job.DeepCopy()
+	finished.Status.Succeeded = 1
+	if err := indexer.Update(finished); err != nil {
+		t.Fatalf("update job: %v", err)
+	}
+	sync()
+	if got := usage(); got.active != 0 || got.reserved != (jobCost{}) {
+		t.Fatalf("usage after the job finished: %+v", got)
+	}
+
+	// A reservation released before its Job was created is not counted.
+	second := job.DeepCopy()
+	second.Name = makeAttemptJobName("first", 2)
+	if err := c.reserveGrant(ctx, work, grant, second, false); err != nil {
+		t.Fatalf("reserveGrant(second) error = %v", err)
+	}
+	if err := c.releaseGrant(ctx, "nereid", "narrow", second, false); err != nil {
+		t.Fatalf("releaseGrant() error = %v", err)
+	}
+	sync()
+	if got := usage(); got.active != 0 || got.reserved != (jobCost{}) {
+		t.Fatalf("usage after release: %+v", got)
+	}
+}
+
+func grantTestWork(name, grantName string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "nereid.yuiseki.net/v1alpha1",
+		"kind":       "Work",
+		"metadata":   map[string]interface{}{"name": name, "namespace": "nereid"},
+		"spec": map[string]interface{}{
+			"kind":     "agent.cli.v1",
+			"title":    name,
+			"grantRef": map[string]interface{}{"name": grantName},
+			"agent":    map[string]interface{}{"image": "busybox", "script": "true"},
+		},
+	}}
+}
@@ -0,0 +1,124 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// livenessResyncPeriods is how many resync intervals may pass without a
+// completed resync, or without a successful reconcile while Works are queued,
+// before the controller reports itself unhealthy.
+const livenessResyncPeriods = 3
+
+// controllerHealth is what the probe endpoints report. Replicas that have
+// not started Run, such as leader election standbys, are live and only need
+// the API server to be ready.
+type controllerHealth struct {
+	mu            sync.Mutex
+	running       bool
+	synced        bool
+	lastResync    time.Time
+	lastReconcile time.Time
+}
+
+func (c *Controller) markRunning(now time.Time) {
+	c.health.mu.Lock()
+	defer c.health.mu.Unlock()
+	c.health.running = true
+	c.health.synced = false
+	c.health.lastResync = now
+	c.health.lastReconcile = now
+}
+
+func (c *Controller) markStopped() {
+	c.health.mu.Lock()
+	defer c.health.mu.Unlock()
+	c.health.running = false
+	c.health.synced = false
+}
+
+func (c *Controller) markSynced() {
+	c.health.mu.Lock()
+	defer c.health.mu.Unlock()
+	c.health.synced = true
+}
+
+func (c *Controller) markResynced(now time.Time) {
+	c.health.mu.Lock()
+	defer c.health.mu.Unlock()
+	c.health.lastResync = now
+}
+
+func (c *Controller) markReconciled(now time.Time) {
+	c.health.mu.Lock()
+	defer c.health.mu.Unlock()
+	c.health.lastReconcile = now
+}
+
+// checkLiveness fails when the resync loop or every worker appears stuck.
+func (c *Controller) checkLiveness(now time.Time) error {
+	c.health.mu.Lock()
+	running, lastResync, lastReconcile := c.health.running, c.health.lastResync, c.health.lastReconcile
+	c.health.mu.Unlock()
+	if !running {
+		return nil
+	}
+	window := livenessResyncPeriods * c.cfg.ResyncInterval
+	if window <= 0 {
+		return nil
+	}
+	if since := now.Sub(lastResync); since > window {
+		return fmt.Errorf("no resync completed in %s", since.Round(time.Second))
+	}
+	if c.queue != nil && c.queue.Len() > 0 {
+		if since := now.Sub(lastReconcile); since > window {
+			return fmt.Errorf("no successful reconcile in %s with %d Works queued", since.Round(time.Second), c.queue.Len())
+		}
+	}
+	return nil
+}
+
+// checkReadiness fails when the API server is unreachable or, once Run has
+// started, while the informer caches are not synced.
+func (c *Controller) checkReadiness(ctx context.Context) error {
+	// Listing one Job exercises the same client and RBAC the workers use.
+	if _, err := c.kube.BatchV1().Jobs(c.cfg.JobNamespace).List(ctx, metav1.ListOptions{Limit: 1}); err != nil {
+		return fmt.Errorf("api server: %w", err)
+	}
+	c.health.mu.Lock()
+	running, synced := c.health.running, c.health.synced
+	c.health.mu.Unlock()
+	if running && !synced {
+		return fmt.Errorf("informer caches are not synced")
+	}
+	return nil
+}
+
+// LivenessHandler serves /healthz.
+func (c *Controller) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeProbeResult(w, c.checkLiveness(c.nowFunc()))
+	})
+}
+
+// ReadinessHandler serves /readyz.
+func (c *Controller) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeProbeResult(w, c.checkReadiness(r.Context()))
+	})
+}
+
+func writeProbeResult(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, err)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
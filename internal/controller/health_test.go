@@ -0,0 +1,75 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/util/workqueue"
+)
+
+func TestHealthProbes(t *testing.T) {
+	now := time.Date(2026, 2, 15, 12, 0, 0, 0, time.UTC)
+	kc := fake.NewSimpleClientset()
+	c := &Controller{
+		kube:    kc,
+		cfg:     Config{JobNamespace: "nereid-work", ResyncInterval: 5 * time.Minute},
+		nowFunc: func() time.Time { return now },
+		queue:   workqueue.NewTypedRateLimitingQueue(workqueue.DefaultTypedControllerRateLimiter[string]()),
+	}
+	defer c.queue.ShutDown()
+	probe := func(h http.Handler) (int, string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rec.Code, strings.TrimSpace(rec.Body.String())
+	}
+
+	// A standby replica is live and ready while the API server answers.
+	if code, body := probe(c.LivenessHandler()); code != http.StatusOK {
+		t.Fatalf("standby /healthz = %d %q", code, body)
+	}
+	if code, body := probe(c.ReadinessHandler()); code != http.StatusOK {
+		t.Fatalf("standby /readyz = %d %q", code, body)
+	}
+
+	c.markRunning(now)
+	if code, body := probe(c.ReadinessHandler()); code != http.StatusServiceUnavailable || body != "informer caches are not synced" {
+		t.Fatalf("/readyz before sync = %d %q", code, body)
+	}
+	c.markSynced()
+	if code, body := probe(c.ReadinessHandler()); code != http.StatusOK {
+		t.Fatalf("/readyz after sync = %d %q", code, body)
+	}
+
+	// Queued Works with no successful reconcile for three resync intervals
+	// mean the workers are stuck.
+	c.queue.Add("nereid/stuck")
+	now = now.Add(16 * time.Minute)
+	c.markResynced(now)
+	if code, body := probe(c.LivenessHandler()); code != http.StatusServiceUnavailable || !strings.Contains(body, "no successful reconcile in 16m0s with 1 Works queued") {
+		t.Fatalf("/healthz with stuck workers = %d %q", code, body)
+	}
+	c.markReconciled(now)
+	if code, body := probe(c.LivenessHandler()); code != http.StatusOK {
+		t.Fatalf("/healthz after reconcile = %d %q", code, body)
+	}
+	now = now.Add(20 * time.Minute)
+	c.markReconciled(now)
+	if code, body := probe(c.LivenessHandler()); code != http.StatusServiceUnavailable || body != "no resync completed in 20m0s" {
+		t.Fatalf("/healthz with stuck resync = %d %q", code, body)
+	}
+
+	kc.PrependReactor("list", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("connection refused")
+	})
+	if code, body := probe(c.ReadinessHandler()); code != http.StatusServiceUnavailable || body != "api server: connection refused" {
+		t.Fatalf("/readyz without api server = %d %q", code, body)
+	}
+}
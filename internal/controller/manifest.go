@@ -0,0 +1,244 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/util/retry"
+)
+
+const artifactManifestName = "manifest.json"
+
+// maxManifestWriters bounds how many manifests are hashed at once. Hashing
+// reads every artifact, which on S3 means downloading it, so it runs outside
+// the reconcile workers.
+const maxManifestWriters = 2
+
+// artifactManifest is written to <work>/manifest.json once a Work succeeds so
+// that consumers can see what it produced without listing the directory.
+type artifactManifest struct {
+	Work        string                 `json:"work"`
+	GeneratedAt string                 `json:"generatedAt"`
+	FileCount   int                    `json:"fileCount"`
+	TotalBytes  int64                  `json:"totalBytes"`
+	Files       []artifactManifestFile `json:"files"`
+}
+
+// status summarizes m for status.artifacts.
+func (m *artifactManifest) status(artifactURL string) map[string]interface{} {
+	out := map[string]interface{}{
+		"fileCount":  int64(m.FileCount),
+		"totalBytes": m.TotalBytes,
+	}
+	if artifactURL != "" {
+		out["manifestUrl"] = artifactURL + artifactManifestName
+	}
+	return out
+}
+
+type artifactManifestFile struct {
+	Path        string `json:"path"`
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"`
+	ContentType string `json:"contentType"`
+}
+
+// Geospatial and other formats the standard MIME table does not know.
+var artifactContentTypes = map[string]string{
+	".geojson": "application/geo+json",
+	".pmtiles": "application/vnd.pmtiles",
+	".mbtiles": "application/vnd.sqlite3",
+	".pbf":     "application/x-protobuf",
+	".mvt":     "application/vnd.mapbox-vector-tile",
+	".las":     "application/vnd.las",
+	".laz":     "application/vnd.laszip",
+	".parquet": "application/vnd.apache.parquet",
+	".csv":     "text/csv; charset=utf-8",
+	".md":      "text/markdown; charset=utf-8",
+	".txt":     "text/plain; charset=utf-8",
+	".log":     "text/plain; charset=utf-8",
+}
+
+// artifactContentType detects a type from the file extension, falling back to
+// sniffing head (up to the first 512 bytes).
+func artifactContentType(name string, head []byte) string {
+	ext := strings.ToLower(path.Ext(name))
+	if t, ok := artifactContentTypes[ext]; ok {
+		return t
+	}
+	if t := mime.TypeByExtension(ext); t != "" {
+		return t
+	}
+	return http.DetectContentType(head)
+}
+
+// manifestWriters runs writeArtifactManifest in the background, once per
+// Work at a time. The zero value is ready to use.
+type manifestWriters struct {
+	mu       sync.Mutex
+	slots    chan struct{}
+	inflight map[string]bool
+	wg       sync.WaitGroup
+}
+
+// start runs fn for key unless it is already running, holding one of
+// maxManifestWriters slots while it does.
+func (m *manifestWriters) start(ctx context.Context, key string, fn func(context.Context)) {
+	m.mu.Lock()
+	if m.inflight[key] {
+		m.mu.Unlock()
+		return
+	}
+	if m.inflight == nil {
+		m.inflight = map[string]bool{}
+		m.slots = make(chan struct{}, maxManifestWriters)
+	}
+	m.inflight[key] = true
+	slots := m.slots
+	m.wg.Add(1)
+	m.mu.Unlock()
+
+	go func() {
+		defer m.wg.Done()
+		defer func() {
+			m.mu.Lock()
+			delete(m.inflight, key)
+			m.mu.Unlock()
+		}()
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		defer func() { <-slots }()
+		fn(ctx)
+	}()
+}
+
+// wait blocks until every started write has finished.
+func (m *manifestWriters) wait() {
+	m.wg.Wait()
+}
+
+// needsArtifactManifest reports whether work succeeded without a recorded
+// manifest, e.g. because the controller restarted while writing it.
+func needsArtifactManifest(work *unstructured.Unstructured) bool {
+	phase, _, _ := unstructured.NestedString(work.Object, "status", "phase")
+	if phase != "Succeeded" {
+		return false
+	}
+	_, found, _ := unstructured.NestedFieldNoCopy(work.Object, "status", "artifacts")
+	return !found
+}
+
+// writeArtifactManifestAsync writes the manifest of a succeeded Work in the
+// background and then records it in status.artifacts.
+func (c *Controller) writeArtifactManifestAsync(ctx context.Context, work *unstructured.Unstructured) {
+	namespace, name := work.GetNamespace(), work.GetName()
+	c.manifestWriters.start(ctx, namespace+"/"+name, func(ctx context.Context) {
+		m, err := c.writeArtifactManifest(ctx, name)
+		if err != nil {
+			c.logger.Warn("failed to write artifact manifest", "work", name, "error", err)
+			return
+		}
+		if m == nil {
+			return
+		}
+		artifacts := m.status(artifactURL(c.cfg.ArtifactBaseURL, name))
+		err = retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+			latest, err := c.dynamic.Resource(workGVR).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			if err := unstructured.SetNestedMap(latest.Object, artifacts, "status", "artifacts"); err != nil {
+				return err
+			}
+			_, err = c.dynamic.Resource(workGVR).Namespace(namespace).UpdateStatus(ctx, latest, metav1.UpdateOptions{})
+			return err
+		})
+		if err != nil && !apierrors.IsNotFound(err) {
+			c.logger.Warn("failed to record artifact manifest", "work", name, "error", err)
+		}
+	})
+}
+
+// writeArtifactManifest hashes every artifact of the Work and stores the
+// result as manifest.json. It returns nil when the store is not configured.
+func (c *Controller) writeArtifactManifest(ctx context.Context, workName string) (*artifactManifest, error) {
+	store := c.artifacts()
+	if local, ok := store.(*localArtifactStore); ok && local.root == "" {
+		return nil, nil
+	}
+	files, err := store.files(ctx, workName)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].name < files[j].name })
+
+	m := &artifactManifest{
+		Work:        workName,
+		GeneratedAt: c.nowFunc().UTC().Format(time.RFC3339),
+		Files:       make([]artifactManifestFile, 0, len(files)),
+	}
+	for _, f := range files {
+		if f.name == artifactManifestName {
+			continue
+		}
+		entry, err := hashArtifact(ctx, store, workName, f.name)
+		if err != nil {
+			return nil, err
+		}
+		m.Files = append(m.Files, entry)
+		m.TotalBytes += entry.Size
+	}
+	m.FileCount = len(m.Files)
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := store.writeFile(ctx, workName, artifactManifestName, append(data, '\n')); err != nil {
+		return nil, fmt.Errorf("write %s of %q: %w", artifactManifestName, workName, err)
+	}
+	return m, nil
+}
+
+func hashArtifact(ctx context.Context, store artifactStore, workName, name string) (artifactManifestFile, error) {
+	entry := artifactManifestFile{Path: name}
+	r, err := store.open(ctx, workName, name)
+	if err != nil {
+		return entry, fmt.Errorf("open %q of %q: %w", name, workName, err)
+	}
+	defer r.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return entry, fmt.Errorf("read %q of %q: %w", name, workName, err)
+	}
+	head = head[:n]
+	h := sha256.New()
+	h.Write(head)
+	rest, err := io.Copy(h, r)
+	if err != nil {
+		return entry, fmt.Errorf("read %q of %q: %w", name, workName, err)
+	}
+	entry.Size = int64(n) + rest
+	entry.SHA256 = hex.EncodeToString(h.Sum(nil))
+	entry.ContentType = artifactContentType(name, head)
+	return entry, nil
+}
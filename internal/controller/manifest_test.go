@@ -0,0 +1,215 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestReconcileWorkWritesArtifactManifestOnSuccess(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"index.html":          "<html><body>map</body></html>",
+		"data/roads.geojson":  `{"type":"FeatureCollection","features":[]}`,
+		"logs/agent.log":      "done\n",
+		"tiles/0/0/0.unknown": "\x89PNG\r\n\x1a\n",
+	}
+	for name, body := range files {
+		p := filepath.Join(root, "mapped", filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(p, []byte(body), 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+
+	work := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "nereid.yuiseki.net/v1alpha1",
+		"kind":       "Work",
+		"metadata":   map[string]interface{}{"name": "mapped", "namespace": "nereid"},
+		"spec": map[string]interface{}{
+			"kind":  "agent.cli.v1",
+			"title": "mapped",
+			"agent": map[string]interface{}{"image": "busybox", "script": "true"},
+		},
+	}}
+	dc := newFakeDynamicClient(work)
+	kc := fake.NewSimpleClientset()
+	c := &Controller{
+		dynamic: dc,
+		kube:    kc,
+		cfg:     Config{JobNamespace: "nereid-work", ArtifactsHostPath: root, ArtifactBaseURL: "https://artifacts.example"},
+		logger:  slog.Default(),
+		nowFunc: time.Now,
+	}
+
+	ctx := context.Background()
+	if err := c.reconcileWork(ctx, work); err != nil {
+		t.Fatalf("reconcileWork(create) error = %v", err)
+	}
+	job, err := kc.BatchV1().Jobs("nereid-work").Get(ctx, "work-mapped", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	job.Status.Succeeded = 1
+	if _, err := kc.BatchV1().Jobs("nereid-work").UpdateStatus(ctx, job, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("update job status: %v", err)
+	}
+	latest, err := dc.Resource(workGVR).Namespace("nereid").Get(ctx, "mapped", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get work: %v", err)
+	}
+	if err := c.reconcileWork(ctx, latest); err != nil {
+		t.Fatalf("reconcileWork(finished) error = %v", err)
+	}
+	// The manifest is written off the reconcile path.
+	c.manifestWriters.wait()
+
+	raw, err := os.ReadFile(filepath.Join(root, "mapped", artifactManifestName))
+	if err != nil {
+		t.Fatalf("read manifest: %v", err)
+	}
+	var m artifactManifest
+	if err := json.Unmarshal(raw, &m); err != nil {
+		t.Fatalf("decode manifest: %v", err)
+	}
+	var total int64
+	for _, body := range files {
+		total += int64(len(body))
+	}
+	if m.Work != "mapped" || m.FileCount != 4 || m.TotalBytes != total {
+		t.Fatalf("manifest totals mismatch: %+v", m)
+	}
+	want := map[string]string{
+		"data/roads.geojson":  "application/geo+json",
+		"index.html":          "text/html; charset=utf-8",
+		"logs/agent.log":      "text/plain; charset=utf-8",
+		"tiles/0/0/0.unknown": "image/png",
+	}
+	for _, f := range m.Files {
+		if f.ContentType != want[f.Path] {
+			t.Fatalf("%s content type got=%q want=%q", f.Path, f.ContentType, want[f.Path])
+		}
+		if f.Size != int64(len(files[f.Path])) || f.SHA256 != sha256Hex([]byte(files[f.Path])) {
+			t.Fatalf("%s size/hash mismatch: %+v", f.Path, f)
+		}
+	}
+	if m.Files[0].Path != "data/roads.geojson" {
+		t.Fatalf("files should be sorted by path: %+v", m.Files)
+	}
+
+	updated, err := dc.Resource(workGVR).Namespace("nereid").Get(ctx, "mapped", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get work: %v", err)
+	}
+	artifacts, _, _ := unstructured.NestedMap(updated.Object, "status", "artifacts")
+	if artifacts["manifestUrl"] != "https://artifacts.example/mapped/manifest.json" || artifacts["fileCount"] != int64(4) || artifacts["totalBytes"] != total {
+		t.Fatalf("status.artifacts mismatch: %#v", artifacts)
+	}
+}
+
+func TestWriteArtifactManifestToS3(t *testing.T) {
+	bucket := newFakeS3Bucket(map[string]fakeS3Object{
+		"works/done/index.html":    {body: "<html>ok</html>", modified: time.Now()},
+		"works/done/manifest.json": {body: "stale", modified: time.Now()},
+	})
+	srv := httptest.NewServer(bucket)
+	defer srv.Close()
+	store, err := newArtifactStore(Config{ArtifactStorage: ArtifactStorageS3, ArtifactS3: S3Config{
+		Endpoint: srv.URL, Bucket: "artifacts", Prefix: "works/", AccessKeyID: "minio", SecretAccessKey: "minio123",
+	}})
+	if err != nil {
+		t.Fatalf("newArtifactStore() error = %v", err)
+	}
+	c := &Controller{logger: slog.Default(), nowFunc: time.Now, store: store}
+
+	m, err := c.writeArtifactManifest(context.Background(), "done")
+	if err != nil {
+		t.Fatalf("writeArtifactManifest() error = %v", err)
+	}
+	if m.FileCount != 1 || m.Files[0].Path != "index.html" {
+		t.Fatalf("manifest should list index.html only: %+v", m)
+	}
+	var stored artifactManifest
+	if err := json.Unmarshal([]byte(bucket.objects["works/done/manifest.json"].body), &stored); err != nil || stored.TotalBytes != m.TotalBytes {
+		t.Fatalf("stored manifest mismatch: %+v err=%v", stored, err)
+	}
+	if !bucket.allSigned {
+		t.Fatal("manifest upload must be signed with its payload hash")
+	}
+}
+
+func TestSyncWorkWritesMissingManifestForSucceededWork(t *testing.T) {
+	root := t.TempDir()
+	outside := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(outside, []byte("secret-token"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	workDir := filepath.Join(root, "restarted")
+	if err := os.MkdirAll(workDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(workDir, "index.html"), []byte("<html>ok</html>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(workDir, "token.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	work := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "nereid.yuiseki.net/v1alpha1",
+		"kind":       "Work",
+		"metadata":   map[string]interface{}{"name": "restarted", "namespace": "nereid"},
+		"spec":       map[string]interface{}{"kind": "agent.cli.v1"},
+		"status":     map[string]interface{}{"phase": "Succeeded"},
+	}}
+	dc := newFakeDynamicClient(work)
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	if err := indexer.Add(work); err != nil {
+		t.Fatal(err)
+	}
+	c := &Controller{
+		dynamic:     dc,
+		kube:        fake.NewSimpleClientset(),
+		cfg:         Config{JobNamespace: "nereid-work", ArtifactsHostPath: root, ArtifactBaseURL: "https://artifacts.example"},
+		logger:      slog.Default(),
+		nowFunc:     time.Now,
+		workIndexer: indexer,
+	}
+
+	ctx := context.Background()
+	if err := c.syncWork(ctx, "nereid/restarted"); err != nil {
+		t.Fatalf("syncWork() error = %v", err)
+	}
+	c.manifestWriters.wait()
+
+	raw, err := os.ReadFile(filepath.Join(workDir, artifactManifestName))
+	if err != nil {
+		t.Fatalf("read manifest: %v", err)
+	}
+	var m artifactManifest
+	if err := json.Unmarshal(raw, &m); err != nil {
+		t.Fatalf("decode manifest: %v", err)
+	}
+	if m.FileCount != 1 || m.Files[0].Path != "index.html" {
+		t.Fatalf("manifest should only list regular files: %+v", m)
+	}
+	updated, err := dc.Resource(workGVR).Namespace("nereid").Get(ctx, "restarted", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get work: %v", err)
+	}
+	if needsArtifactManifest(updated) {
+		t.Fatalf("status.artifacts should be recorded: %#v", updated.Object["status"])
+	}
+}
@@ -0,0 +1,285 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+)
+
+const metricsNamespace = "nereid"
+
+// controllerMetrics holds the controller's Prometheus collectors. A nil
+// *controllerMetrics records nothing, so Controllers built in tests need none.
+type controllerMetrics struct {
+	registry *prometheus.Registry
+
+	reconcileDuration  prometheus.Histogram
+	reconcileErrors    *prometheus.CounterVec
+	jobCreationLatency *prometheus.HistogramVec
+	queueWait          *prometheus.HistogramVec
+	runDuration        *prometheus.HistogramVec
+	validationFailures *prometheus.CounterVec
+	prunedBytes        *prometheus.CounterVec
+}
+
+func newControllerMetrics() *controllerMetrics {
+	m := &controllerMetrics{
+		registry: prometheus.NewRegistry(),
+		reconcileDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "reconcile_duration_seconds",
+			Help:      "Time spent reconciling one Work.",
+			Buckets:   prometheus.ExponentialBuckets(0.005, 2, 14),
+		}),
+		reconcileErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "reconcile_errors_total",
+			Help:      "Work reconciles that returned an error, by API error reason.",
+		}, []string{"type"}),
+		jobCreationLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "job_creation_latency_seconds",
+			Help:      "Time from Work creation until the Job of its first attempt was created.",
+			Buckets:   prometheus.ExponentialBuckets(0.1, 2, 12),
+		}, []string{"kind"}),
+		queueWait: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "work_queue_wait_seconds",
+			Help:      "Time from Work creation until its Job was admitted and started.",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 15),
+		}, []string{"kind"}),
+		runDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "work_run_duration_seconds",
+			Help:      "Time from Job start until the Work finished, by final phase.",
+			Buckets:   prometheus.ExponentialBuckets(5, 2, 13),
+		}, []string{"kind", "phase"}),
+		validationFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "artifact_validation_failures_total",
+			Help:      "Succeeded Jobs whose artifacts failed validation, by finding reason.",
+		}, []string{"kind", "reason"}),
+		prunedBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "artifacts_pruned_bytes_total",
+			Help:      "Bytes of Work artifacts removed by pruning, by prune reason.",
+		}, []string{"reason"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.reconcileDuration,
+		m.reconcileErrors,
+		m.jobCreationLatency,
+		m.queueWait,
+		m.runDuration,
+		m.validationFailures,
+		m.prunedBytes,
+	)
+	return m
+}
+
+// MetricsHandler serves the controller's metrics in the Prometheus exposition
+// format.
+func (c *Controller) MetricsHandler() http.Handler {
+	if c.metrics == nil {
+		return http.NotFoundHandler()
+	}
+	return promhttp.HandlerFor(c.metrics.registry, promhttp.HandlerOpts{})
+}
+
+// registerCacheMetrics exports gauges computed from the informer caches on
+// every scrape. It runs once the caches are synced.
+func (m *controllerMetrics) registerCacheMetrics(workIndexer cache.Indexer, grantLister cache.GenericLister) error {
+	if m == nil {
+		return nil
+	}
+	err := m.registry.Register(newCacheCollector(workIndexer, grantLister))
+	var already prometheus.AlreadyRegisteredError
+	if errors.As(err, &already) {
+		return nil
+	}
+	return err
+}
+
+func (m *controllerMetrics) observeReconcile(d time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.reconcileDuration.Observe(d.Seconds())
+	if err != nil {
+		m.reconcileErrors.WithLabelValues(reconcileErrorType(err)).Inc()
+	}
+}
+
+// reconcileErrorType is the Kubernetes API status reason of err, e.g.
+// Conflict or Forbidden, or Other for errors that did not come from the API.
+func reconcileErrorType(err error) string {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return string(metav1.StatusReasonTimeout)
+	case errors.Is(err, context.Canceled):
+		return "Canceled"
+	}
+	if reason := apierrors.ReasonForError(err); reason != metav1.StatusReasonUnknown {
+		return string(reason)
+	}
+	return "Other"
+}
+
+func (m *controllerMetrics) observeJobCreated(work *unstructured.Unstructured, kind string, attempt int, now time.Time) {
+	if m == nil || attempt > 1 {
+		return
+	}
+	m.jobCreationLatency.WithLabelValues(kind).Observe(sinceSeconds(work.GetCreationTimestamp().Time, now))
+}
+
+func (m *controllerMetrics) observeArtifactsPruned(reason string, bytes int64) {
+	if m == nil {
+		return
+	}
+	m.prunedBytes.WithLabelValues(reason).Add(float64(bytes))
+}
+
+// observeWorkStatus records the durations and outcomes that a stored status
+// update made known for the first time: startTime, completionTime and a
+// failed artifact validation.
+func (m *controllerMetrics) observeWorkStatus(work *unstructured.Unstructured, st workStatus, before, after map[string]interface{}) {
+	if m == nil {
+		return
+	}
+	kind, _, _ := unstructured.NestedString(work.Object, "spec", "kind")
+	startTime, started := parseStatusTime(after["startTime"])
+	if _, wasStarted := parseStatusTime(before["startTime"]); started && !wasStarted {
+		m.queueWait.WithLabelValues(kind).Observe(sinceSeconds(work.GetCreationTimestamp().Time, startTime))
+	}
+	completionTime, completed := parseStatusTime(after["completionTime"])
+	if _, wasCompleted := parseStatusTime(before["completionTime"]); started && completed && !wasCompleted {
+		m.runDuration.WithLabelValues(kind, st.phase).Observe(sinceSeconds(startTime, completionTime))
+	}
+	if st.validation != nil && before["phase"] != after["phase"] {
+		if failure := st.validation.failure(); failure != nil {
+			m.validationFailures.WithLabelValues(kind, failure.Reason).Inc()
+		}
+	}
+}
+
+func sinceSeconds(from, to time.Time) float64 {
+	if from.IsZero() || to.Before(from) {
+		return 0
+	}
+	return to.Sub(from).Seconds()
+}
+
+var (
+	worksDesc = prometheus.NewDesc(metricsNamespace+"_works",
+		"Works in the informer cache by phase, kind and Grant.",
+		[]string{"namespace", "phase", "kind", "grant"}, nil)
+	grantUsesDesc = prometheus.NewDesc(metricsNamespace+"_grant_uses",
+		"Uses consumed from the Grant (status.used).",
+		[]string{"namespace", "grant"}, nil)
+	grantActiveDesc = prometheus.NewDesc(metricsNamespace+"_grant_active_jobs",
+		"Unfinished Jobs reserved against the Grant (status.active).",
+		[]string{"namespace", "grant"}, nil)
+	grantCPUSecondsDesc = prometheus.NewDesc(metricsNamespace+"_grant_cpu_seconds_used",
+		"CPU-seconds charged to the Grant by finished Jobs.",
+		[]string{"namespace", "grant"}, nil)
+	grantMemoryDesc = prometheus.NewDesc(metricsNamespace+"_grant_memory_gib_seconds_used",
+		"Memory GiB-seconds charged to the Grant by finished Jobs.",
+		[]string{"namespace", "grant"}, nil)
+	grantArtifactBytesDesc = prometheus.NewDesc(metricsNamespace+"_grant_artifact_bytes",
+		"Bytes of stored artifacts of the Grant's Works.",
+		[]string{"namespace", "grant"}, nil)
+	grantLimitDesc = prometheus.NewDesc(metricsNamespace+"_grant_limit",
+		"Configured Grant limits; unlimited ones are not exported.",
+		[]string{"namespace", "grant", "limit"}, nil)
+)
+
+// cacheCollector reads Works and Grants from the informer caches at scrape
+// time, so deleted objects disappear from the gauges without bookkeeping.
+type cacheCollector struct {
+	works  cache.Indexer
+	grants cache.GenericLister
+}
+
+func newCacheCollector(works cache.Indexer, grants cache.GenericLister) *cacheCollector {
+	return &cacheCollector{works: works, grants: grants}
+}
+
+func (cc *cacheCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{worksDesc, grantUsesDesc, grantActiveDesc, grantCPUSecondsDesc, grantMemoryDesc, grantArtifactBytesDesc, grantLimitDesc} {
+		ch <- d
+	}
+}
+
+func (cc *cacheCollector) Collect(ch chan<- prometheus.Metric) {
+	if cc.works != nil {
+		type worksKey struct{ namespace, phase, kind, grant string }
+		counts := map[worksKey]int{}
+		for _, obj := range cc.works.List() {
+			work, ok := obj.(*unstructured.Unstructured)
+			if !ok {
+				continue
+			}
+			key := worksKey{namespace: work.GetNamespace()}
+			key.phase, _, _ = unstructured.NestedString(work.Object, "status", "phase")
+			key.kind, _, _ = unstructured.NestedString(work.Object, "spec", "kind")
+			key.grant, _, _ = unstructured.NestedString(work.Object, "spec", "grantRef", "name")
+			counts[key]++
+		}
+		for key, n := range counts {
+			ch <- prometheus.MustNewConstMetric(worksDesc, prometheus.GaugeValue, float64(n), key.namespace, key.phase, key.kind, key.grant)
+		}
+	}
+
+	if cc.grants == nil {
+		return
+	}
+	grants, err := cc.grants.List(labels.Everything())
+	if err != nil {
+		return
+	}
+	for _, obj := range grants {
+		grant, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		ns, name := grant.GetNamespace(), grant.GetName()
+		gauge := func(desc *prometheus.Desc, v int64) {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(v), ns, name)
+		}
+		usage := readGrantUsage(grant)
+		gauge(grantUsesDesc, usage.used)
+		gauge(grantActiveDesc, usage.active)
+		gauge(grantCPUSecondsDesc, usage.consumed.cpuSeconds)
+		gauge(grantMemoryDesc, usage.consumed.memoryGiBSeconds)
+		if artifactBytes, found, _ := unstructured.NestedInt64(grant.Object, "status", "artifactBytes"); found {
+			gauge(grantArtifactBytesDesc, artifactBytes)
+		}
+
+		limits := readGrantLimits(grant)
+		for _, l := range []struct {
+			name  string
+			value int64
+		}{
+			{"maxUses", limits.maxUses},
+			{"maxConcurrent", limits.maxConcurrent},
+			{"cpuSeconds", limits.cpuSeconds},
+			{"memoryGiBSeconds", limits.memoryGiBSeconds},
+		} {
+			if l.value > 0 {
+				ch <- prometheus.MustNewConstMetric(grantLimitDesc, prometheus.GaugeValue, float64(l.value), ns, name, l.name)
+			}
+		}
+	}
+}
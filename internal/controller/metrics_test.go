@@ -0,0 +1,167 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	batchv1 "k8s.io/api/batch/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/cache"
+)
+
+func histogramSamples(t *testing.T, m *controllerMetrics, name string) (count uint64, sum float64) {
+	t.Helper()
+	families, err := m.registry.Gather()
+	if err != nil {
+		t.Fatalf("gather: %v", err)
+	}
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+		for _, metric := range f.GetMetric() {
+			count += metric.GetHistogram().GetSampleCount()
+			sum += metric.GetHistogram().GetSampleSum()
+		}
+	}
+	return count, sum
+}
+
+func TestWorkStatusMetrics(t *testing.T) {
+	created := time.Date(2026, 2, 15, 12, 0, 0, 0, time.UTC)
+	work := artifactTestWork("metrics", "overpassql.map.v1")
+	work.SetCreationTimestamp(metav1.NewTime(created))
+	dc := newFakeDynamicClient(work)
+	c := &Controller{
+		dynamic: dc,
+		logger:  slog.Default(),
+		nowFunc: func() time.Time { return created.Add(10 * time.Minute) },
+		metrics: newControllerMetrics(),
+	}
+	ctx := context.Background()
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "work-metrics"}}
+	job.Status.StartTime = &metav1.Time{Time: created.Add(90 * time.Second)}
+	job.Status.Active = 1
+	update := func(st workStatus) {
+		t.Helper()
+		latest, err := dc.Resource(workGVR).Namespace("nereid").Get(ctx, "metrics", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("get work: %v", err)
+		}
+		if err := c.updateWorkStatus(ctx, latest, st); err != nil {
+			t.Fatalf("updateWorkStatus() error = %v", err)
+		}
+	}
+
+	c.metrics.observeJobCreated(work, "overpassql.map.v1", 1, created.Add(2*time.Second))
+	c.metrics.observeJobCreated(work, "overpassql.map.v1", 2, created.Add(time.Hour))
+	if count, sum := histogramSamples(t, c.metrics, "nereid_job_creation_latency_seconds"); count != 1 || sum != 2 {
+		t.Fatalf("job creation latency count=%d sum=%v, want one 2s sample", count, sum)
+	}
+
+	update(workStatus{phase: "Running", reason: reasonJobRunning, job: job})
+	update(workStatus{phase: "Running", reason: reasonJobRunning, message: "still running", job: job})
+	if count, sum := histogramSamples(t, c.metrics, "nereid_work_queue_wait_seconds"); count != 1 || sum != 90 {
+		t.Fatalf("queue wait count=%d sum=%v, want one 90s sample", count, sum)
+	}
+
+	finished := job.DeepCopy()
+	finished.Status.Active = 0
+	finished.Status.Succeeded = 1
+	finished.Status.CompletionTime = &metav1.Time{Time: created.Add(150 * time.Second)}
+	validation := artifactValidation{findings: []validationFinding{{Validator: "geojson", Severity: severityError, Reason: reasonGeoJSONEmpty, Message: "no features"}}}
+	failed := workStatus{phase: "Failed", reason: reasonGeoJSONEmpty, job: finished, validated: boolPtr(false), validation: &validation}
+	update(failed)
+	update(failed)
+	if count, sum := histogramSamples(t, c.metrics, "nereid_work_run_duration_seconds"); count != 1 || sum != 60 {
+		t.Fatalf("run duration count=%d sum=%v, want one 60s sample", count, sum)
+	}
+	if got := testutil.ToFloat64(c.metrics.validationFailures.WithLabelValues("overpassql.map.v1", reasonGeoJSONEmpty)); got != 1 {
+		t.Fatalf("validation failures = %v, want 1", got)
+	}
+
+	c.metrics.observeReconcile(time.Second, fmt.Errorf("update work: %w", apierrors.NewConflict(schema.GroupResource{Resource: "works"}, "metrics", nil)))
+	c.metrics.observeReconcile(time.Second, context.DeadlineExceeded)
+	c.metrics.observeReconcile(time.Second, nil)
+	if got := testutil.ToFloat64(c.metrics.reconcileErrors.WithLabelValues("Conflict")); got != 1 {
+		t.Fatalf("conflict errors = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(c.metrics.reconcileErrors.WithLabelValues("Timeout")); got != 1 {
+		t.Fatalf("timeout errors = %v, want 1", got)
+	}
+	if count, _ := histogramSamples(t, c.metrics, "nereid_reconcile_duration_seconds"); count != 3 {
+		t.Fatalf("reconcile duration count=%d, want 3", count)
+	}
+}
+
+func TestCacheCollector(t *testing.T) {
+	works := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for i, w := range []struct{ phase, kind, grant string }{
+		{"Running", "overpassql.map.v1", "demo"},
+		{"Running", "overpassql.map.v1", "demo"},
+		{"Succeeded", "gdal.rastertile.v1", ""},
+	} {
+		work := &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "nereid.yuiseki.net/v1alpha1",
+			"kind":       "Work",
+			"metadata":   map[string]interface{}{"name": fmt.Sprintf("w%d", i), "namespace": "nereid"},
+			"spec":       map[string]interface{}{"kind": w.kind},
+			"status":     map[string]interface{}{"phase": w.phase},
+		}}
+		if w.grant != "" {
+			_ = unstructured.SetNestedField(work.Object, w.grant, "spec", "grantRef", "name")
+		}
+		if err := works.Add(work); err != nil {
+			t.Fatalf("add work: %v", err)
+		}
+	}
+	grants := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	if err := grants.Add(&unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "nereid.yuiseki.net/v1alpha1",
+		"kind":       "Grant",
+		"metadata":   map[string]interface{}{"name": "demo", "namespace": "nereid"},
+		"spec": map[string]interface{}{
+			"maxUses": int64(10),
+			"limits":  map[string]interface{}{"maxConcurrent": int64(2)},
+		},
+		"status": map[string]interface{}{"used": int64(3), "active": int64(2), "cpuSecondsUsed": int64(120), "artifactBytes": int64(4096)},
+	}}); err != nil {
+		t.Fatalf("add grant: %v", err)
+	}
+
+	collector := newCacheCollector(works, cache.NewGenericLister(grants, grantGVR.GroupResource()))
+	want := `
+# HELP nereid_works Works in the informer cache by phase, kind and Grant.
+# TYPE nereid_works gauge
+nereid_works{grant="",kind="gdal.rastertile.v1",namespace="nereid",phase="Succeeded"} 1
+nereid_works{grant="demo",kind="overpassql.map.v1",namespace="nereid",phase="Running"} 2
+# HELP nereid_grant_uses Uses consumed from the Grant (status.used).
+# TYPE nereid_grant_uses gauge
+nereid_grant_uses{grant="demo",namespace="nereid"} 3
+# HELP nereid_grant_active_jobs Unfinished Jobs reserved against the Grant (status.active).
+# TYPE nereid_grant_active_jobs gauge
+nereid_grant_active_jobs{grant="demo",namespace="nereid"} 2
+# HELP nereid_grant_cpu_seconds_used CPU-seconds charged to the Grant by finished Jobs.
+# TYPE nereid_grant_cpu_seconds_used gauge
+nereid_grant_cpu_seconds_used{grant="demo",namespace="nereid"} 120
+# HELP nereid_grant_artifact_bytes Bytes of stored artifacts of the Grant's Works.
+# TYPE nereid_grant_artifact_bytes gauge
+nereid_grant_artifact_bytes{grant="demo",namespace="nereid"} 4096
+# HELP nereid_grant_limit Configured Grant limits; unlimited ones are not exported.
+# TYPE nereid_grant_limit gauge
+nereid_grant_limit{grant="demo",limit="maxConcurrent",namespace="nereid"} 2
+nereid_grant_limit{grant="demo",limit="maxUses",namespace="nereid"} 10
+`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(want),
+		"nereid_works", "nereid_grant_uses", "nereid_grant_active_jobs", "nereid_grant_cpu_seconds_used", "nereid_grant_artifact_bytes", "nereid_grant_limit"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,196 @@
+package controller
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/yuiseki/NEREID/internal/runner"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	// defaultPointcloudTargetSRS is ECEF, the frame Cesium expects 3D Tiles in.
+	defaultPointcloudTargetSRS = "EPSG:4978"
+	pointcloudTileDir          = "3dtiles"
+)
+
+var axisOrderingPattern = regexp.MustCompile(`^[1-3],[1-3](,[1-3])?$`)
+
+// pointcloudOptions is spec.pointcloud resolved into pdal/py3dtiles
+// arguments.
+type pointcloudOptions struct {
+	inputURI       string
+	inputExt       string
+	sourceSRS      string
+	targetSRS      string
+	inAxisOrdering string
+	outAxis        string
+	jobs           int
+	alwaysXY       bool
+}
+
+func pointcloudOptionsFromWork(work *unstructured.Unstructured) (pointcloudOptions, error) {
+	get := func(fields ...string) (string, error) {
+		v, _, err := nestedStringAny(work.Object, append([]string{"spec", "pointcloud"}, fields...)...)
+		if err != nil {
+			return "", fmt.Errorf("failed to read spec.pointcloud.%s: %v", strings.Join(fields, "."), err)
+		}
+		return strings.TrimSpace(v), nil
+	}
+	var o pointcloudOptions
+	var err error
+	if o.inputURI, err = get("input", "uri"); err != nil {
+		return o, err
+	}
+	if o.inputURI == "" {
+		return o, fmt.Errorf("spec.pointcloud.input.uri is required")
+	}
+	// pdal picks its reader from the file extension.
+	o.inputExt = ".laz"
+	if u, err := url.Parse(o.inputURI); err == nil && strings.EqualFold(path.Ext(u.Path), ".las") {
+		o.inputExt = ".las"
+	}
+	if o.sourceSRS, err = get("crs", "source"); err != nil {
+		return o, err
+	}
+	if o.targetSRS, err = get("crs", "target"); err != nil {
+		return o, err
+	}
+	if o.targetSRS == "" {
+		o.targetSRS = defaultPointcloudTargetSRS
+	}
+	for _, ax := range []struct {
+		field string
+		dst   *string
+	}{{"inAxisOrdering", &o.inAxisOrdering}, {"outAxisOrdering", &o.outAxis}} {
+		if *ax.dst, err = get("crs", ax.field); err != nil {
+			return o, err
+		}
+		*ax.dst = strings.ReplaceAll(*ax.dst, " ", "")
+		if *ax.dst != "" && !axisOrderingPattern.MatchString(*ax.dst) {
+			return o, fmt.Errorf("spec.pointcloud.crs.%s=%q must look like \"2,1\"", ax.field, *ax.dst)
+		}
+	}
+	if o.targetSRS != defaultPointcloudTargetSRS && epsgCodePattern.FindStringSubmatch(o.targetSRS) == nil {
+		return o, fmt.Errorf("spec.pointcloud.crs.target=%q must be an EPSG code so py3dtiles can convert it to %s", o.targetSRS, defaultPointcloudTargetSRS)
+	}
+	o.jobs = extractPointcloudJobs(work)
+	o.alwaysXY, _, _ = unstructured.NestedBool(work.Object, "spec", "pointcloud", "py3dtiles", "pyprojAlwaysXY")
+	return o, nil
+}
+
+// buildPointcloudScript turns a laz.3dtiles.v1 spec into a fixed pipeline:
+// pdal info --summary, a pdal reprojection to crs.target with the requested
+// axis orderings, py3dtiles convert --jobs N and a Cesium viewer.
+func buildPointcloudScript(work *unstructured.Unstructured, spec map[string]interface{}) (string, error) {
+	o, err := pointcloudOptionsFromWork(work)
+	if err != nil {
+		return "", err
+	}
+	viewer, err := nativeViewerSpec(spec)
+	if err != nil {
+		return "", err
+	}
+	html, err := runner.PointcloudViewerHTML(viewer, "./"+pointcloudTileDir+"/tileset.json")
+	if err != nil {
+		return "", fmt.Errorf("failed to render pointcloud viewer: %v", err)
+	}
+
+	reprojectArgs := " --filters.reprojection.out_srs=" + shellQuote(o.targetSRS)
+	if o.sourceSRS != "" {
+		reprojectArgs += " --filters.reprojection.in_srs=" + shellQuote(o.sourceSRS)
+	}
+	if o.inAxisOrdering != "" {
+		reprojectArgs += " --filters.reprojection.in_axis_ordering=" + o.inAxisOrdering
+	}
+	if o.outAxis != "" {
+		reprojectArgs += " --filters.reprojection.out_axis_ordering=" + o.outAxis
+	}
+	convertArgs := fmt.Sprintf(" --jobs %d", o.jobs)
+	if m := epsgCodePattern.FindStringSubmatch(o.targetSRS); m != nil && "EPSG:"+m[1] != defaultPointcloudTargetSRS {
+		convertArgs += " --srs_in " + m[1] + " --srs_out 4978"
+	}
+	if o.alwaysXY {
+		convertArgs += " --pyproj-always-xy"
+	}
+
+	return nativeScriptPrelude + fmt.Sprintf(`INPUT_URI=%s
+INPUT="${WORK_DIR}/input%s"
+SOURCE_SRS=%s
+IN_AXIS_ORDERING=%s
+VIEWER_B64=%q
+
+fetch_input "${INPUT_URI}" "${INPUT}"
+pdal info --summary "${INPUT}" > "${OUT}/pdal-info.json" 2> "${WORK_DIR}/stderr" \
+  || fail "pdal info failed: $(tail -n 3 "${WORK_DIR}/stderr" | tr '\n' ' ')"
+run pdal_translate pdal translate "${INPUT}" "${WORK_DIR}/reprojected.las" reprojection%s
+run py3dtiles py3dtiles convert "${WORK_DIR}/reprojected.las" --out "${OUT}/%s" --overwrite%s
+
+# Centre the viewer on the pdal bounds, transformed to WGS84.
+cat > "${WORK_DIR}/position.py" <<'PY'
+import json, math, sys
+from pyproj import CRS, Transformer
+
+info = json.load(open(sys.argv[1]))
+summary = info.get("summary", info)
+b = summary["bounds"]
+srs = sys.argv[2] or summary.get("srs", {}).get("compoundwkt") or summary.get("srs", {}).get("wkt")
+if not srs:
+    sys.exit("the point cloud has no CRS; set spec.pointcloud.crs.source")
+xs, ys = [b["minx"], b["maxx"]], [b["miny"], b["maxy"]]
+if sys.argv[3].startswith("2,1"):
+    xs, ys = ys, xs
+to_wgs84 = Transformer.from_crs(CRS.from_user_input(srs), "EPSG:4326", always_xy=True)
+lons, lats = to_wgs84.transform(xs, ys)
+lat = (lats[0] + lats[1]) / 2
+radius = math.hypot((lons[1] - lons[0]) * 111320 * math.cos(math.radians(lat)), (lats[1] - lats[0]) * 110540) / 2
+json.dump({"longitude": (lons[0] + lons[1]) / 2, "latitude": lat, "height": (b["minz"] + b["maxz"]) / 2,
+           "radius": max(radius, 50)}, sys.stdout)
+PY
+python3 "${WORK_DIR}/position.py" "${OUT}/pdal-info.json" "${SOURCE_SRS}" "${IN_AXIS_ORDERING}" > "${OUT}/position.json" 2> "${WORK_DIR}/stderr" \
+  || fail "locate point cloud failed: $(tail -n 3 "${WORK_DIR}/stderr" | tr '\n' ' ')"
+printf '%%s' "${VIEWER_B64}" | base64 -d > "${OUT}/index.html"
+`,
+		shellQuote(o.inputURI), o.inputExt, shellQuote(o.sourceSRS), shellQuote(o.inAxisOrdering),
+		base64.StdEncoding.EncodeToString([]byte(html)),
+		reprojectArgs, pointcloudTileDir, convertArgs,
+	), nil
+}
+
+// scalePointcloudCPU requests one CPU per py3dtiles job. A CPU limit set by
+// the Grant caps the request; otherwise the limit is raised to match.
+func scalePointcloudCPU(job *batchv1.Job, jobs int, grant *unstructured.Unstructured) error {
+	if len(job.Spec.Template.Spec.Containers) == 0 {
+		return fmt.Errorf("job has no containers")
+	}
+	container := &job.Spec.Template.Spec.Containers[0]
+	want := *resource.NewQuantity(int64(jobs), resource.DecimalSI)
+	grantLimit := ""
+	if grant != nil {
+		v, _, err := nestedStringAny(grant.Object, "spec", "resources", "limits", "cpu")
+		if err != nil {
+			return fmt.Errorf("failed to read grant %q spec.resources.limits.cpu: %v", grant.GetName(), err)
+		}
+		grantLimit = strings.TrimSpace(v)
+	}
+	limit, hasLimit := container.Resources.Limits[corev1.ResourceCPU]
+	switch {
+	case grantLimit != "":
+		if want.Cmp(limit) > 0 {
+			want = limit.DeepCopy()
+		}
+	case !hasLimit || limit.Cmp(want) < 0:
+		container.Resources.Limits[corev1.ResourceCPU] = want.DeepCopy()
+	}
+	if current, ok := container.Resources.Requests[corev1.ResourceCPU]; !ok || current.Cmp(want) < 0 {
+		container.Resources.Requests[corev1.ResourceCPU] = want
+	}
+	return nil
+}
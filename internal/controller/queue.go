@@ -0,0 +1,339 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/cache"
+)
+
+var (
+	kueueWorkloadGVR = schema.GroupVersionResource{
+		Group:    "kueue.x-k8s.io",
+		Version:  "v1beta1",
+		Resource: "workloads",
+	}
+	kueueLocalQueueGVR = schema.GroupVersionResource{
+		Group:    "kueue.x-k8s.io",
+		Version:  "v1beta1",
+		Resource: "localqueues",
+	}
+	// kueueVisibilityLocalQueueGVR serves the pendingworkloads subresource
+	// of the on-demand visibility API.
+	kueueVisibilityLocalQueueGVR = schema.GroupVersionResource{
+		Group:    "visibility.kueue.x-k8s.io",
+		Version:  "v1beta1",
+		Resource: "localqueues",
+	}
+)
+
+const (
+	kueueJobUIDLabelKey = "kueue.x-k8s.io/job-uid"
+	workloadJobUIDIndex = "byJobUID"
+
+	// queuedRecheckInterval refreshes the position of a queued Work. Other
+	// Workloads moving ahead do not produce events for it, so positions are
+	// read from the visibility API at most once per LocalQueue per interval.
+	queuedRecheckInterval = 30 * time.Second
+)
+
+// localQueueSnapshot is what was last read about one LocalQueue.
+type localQueueSnapshot struct {
+	fetchedAt    time.Time
+	clusterQueue string
+	// positions maps pending Workload names to their one-based position in
+	// the ClusterQueue; nil when the visibility API is not served.
+	positions map[string]int64
+}
+
+// localQueueCache shares LocalQueue reads between the Works queued on it,
+// keyed by "namespace/name". The zero value is ready to use.
+type localQueueCache struct {
+	mu     sync.Mutex
+	queues map[string]*localQueueSnapshot
+}
+
+func (l *localQueueCache) get(key string, now time.Time) *localQueueSnapshot {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if s := l.queues[key]; s != nil && now.Sub(s.fetchedAt) < queuedRecheckInterval {
+		return s
+	}
+	return nil
+}
+
+func (l *localQueueCache) put(key string, s *localQueueSnapshot) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.queues == nil {
+		l.queues = map[string]*localQueueSnapshot{}
+	}
+	l.queues[key] = s
+}
+
+// queueStatus is the Kueue view of a Work's Job, written to status.queue.
+type queueStatus struct {
+	workload      string
+	localQueue    string
+	clusterQueue  string
+	position      int64 // 0 when unknown
+	pendingReason string
+	pendingMsg    string
+	admissionTime time.Time
+	flavors       map[string]string
+}
+
+func (q *queueStatus) status() map[string]interface{} {
+	out := map[string]interface{}{"workload": q.workload}
+	setOrDeleteString(out, "localQueue", q.localQueue)
+	setOrDeleteString(out, "clusterQueue", q.clusterQueue)
+	setOrDeleteString(out, "pendingReason", q.pendingReason)
+	setOrDeleteString(out, "pendingMessage", q.pendingMsg)
+	if q.position > 0 {
+		out["position"] = q.position
+	}
+	if !q.admissionTime.IsZero() {
+		out["admissionTime"] = formatStatusTime(q.admissionTime)
+	}
+	if len(q.flavors) > 0 {
+		flavors := make(map[string]interface{}, len(q.flavors))
+		for resource, flavor := range q.flavors {
+			flavors[resource] = flavor
+		}
+		out["flavors"] = flavors
+	}
+	return out
+}
+
+// summary describes why a queued Work is not running yet.
+func (q *queueStatus) summary() string {
+	msg := "waiting for kueue admission"
+	if q.clusterQueue != "" {
+		msg += " in ClusterQueue " + q.clusterQueue
+	}
+	if q.position > 0 {
+		msg += fmt.Sprintf(" (position %d)", q.position)
+	}
+	if q.pendingMsg != "" {
+		msg += ": " + q.pendingMsg
+	}
+	return msg
+}
+
+// jobQueueStatus looks up the Kueue Workload owned by job. It returns nil when
+// Kueue has not created one yet or Kueue is not installed.
+func (c *Controller) jobQueueStatus(ctx context.Context, job *batchv1.Job) (*queueStatus, error) {
+	workloads, err := c.jobWorkloads(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+	var wl *unstructured.Unstructured
+	for _, candidate := range workloads {
+		if ownedByJob(candidate, job) {
+			wl = candidate
+			break
+		}
+	}
+	if wl == nil {
+		return nil, nil
+	}
+
+	q := &queueStatus{workload: wl.GetName()}
+	q.localQueue, _, _ = unstructured.NestedString(wl.Object, "spec", "queueName")
+	q.clusterQueue, _, _ = unstructured.NestedString(wl.Object, "status", "admission", "clusterQueue")
+	assignments, _, _ := unstructured.NestedSlice(wl.Object, "status", "admission", "podSetAssignments")
+	for _, a := range assignments {
+		m, _ := a.(map[string]interface{})
+		flavors, _, _ := unstructured.NestedStringMap(m, "flavors")
+		for resource, flavor := range flavors {
+			if q.flavors == nil {
+				q.flavors = map[string]string{}
+			}
+			q.flavors[resource] = flavor
+		}
+	}
+
+	conditions, _, _ := unstructured.NestedSlice(wl.Object, "status", "conditions")
+	for _, raw := range conditions {
+		cond, _ := raw.(map[string]interface{})
+		condType, _ := cond["type"].(string)
+		status, _ := cond["status"].(string)
+		switch {
+		case condType == "Admitted" && status == "True":
+			if t, ok := parseStatusTime(cond["lastTransitionTime"]); ok {
+				q.admissionTime = t
+			}
+		case (condType == "QuotaReserved" || condType == "Admitted") && status == "False" && q.pendingMsg == "":
+			q.pendingReason, _ = cond["reason"].(string)
+			q.pendingMsg, _ = cond["message"].(string)
+		}
+	}
+	if !q.admissionTime.IsZero() {
+		q.pendingReason, q.pendingMsg = "", ""
+		return q, nil
+	}
+
+	if q.localQueue != "" {
+		lq := c.localQueue(ctx, job.Namespace, q.localQueue)
+		if q.clusterQueue == "" {
+			q.clusterQueue = lq.clusterQueue
+		}
+		q.position = lq.positions[q.workload]
+	}
+	return q, nil
+}
+
+// jobWorkloads returns the Workloads labelled with the Job's UID, from the
+// Workload informer when Kueue is installed.
+func (c *Controller) jobWorkloads(ctx context.Context, job *batchv1.Job) ([]*unstructured.Unstructured, error) {
+	if c.workloadIndexer != nil && job.UID != "" {
+		objs, err := c.workloadIndexer.ByIndex(workloadJobUIDIndex, string(job.UID))
+		if err != nil {
+			return nil, err
+		}
+		out := make([]*unstructured.Unstructured, 0, len(objs))
+		for _, obj := range objs {
+			if wl, ok := obj.(*unstructured.Unstructured); ok {
+				out = append(out, wl)
+			}
+		}
+		return out, nil
+	}
+	opts := metav1.ListOptions{}
+	if job.UID != "" {
+		opts.LabelSelector = kueueJobUIDLabelKey + "=" + string(job.UID)
+	}
+	list, err := c.dynamic.Resource(kueueWorkloadGVR).Namespace(job.Namespace).List(ctx, opts)
+	if apierrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("list kueue workloads in %s: %w", job.Namespace, err)
+	}
+	out := make([]*unstructured.Unstructured, 0, len(list.Items))
+	for i := range list.Items {
+		out = append(out, &list.Items[i])
+	}
+	return out, nil
+}
+
+// kueueWorkloadsServed reports whether the API server serves Kueue
+// Workloads.
+func (c *Controller) kueueWorkloadsServed() bool {
+	resources, err := c.kube.Discovery().ServerResourcesForGroupVersion(kueueWorkloadGVR.GroupVersion().String())
+	if err != nil {
+		return false
+	}
+	for _, r := range resources.APIResources {
+		if r.Name == kueueWorkloadGVR.Resource {
+			return true
+		}
+	}
+	return false
+}
+
+func indexWorkloadByJobUID(obj interface{}) ([]string, error) {
+	wl, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, nil
+	}
+	if uid := wl.GetLabels()[kueueJobUIDLabelKey]; uid != "" {
+		return []string{uid}, nil
+	}
+	return nil, nil
+}
+
+// enqueueWorkForWorkload maps a Workload to its Work through the owning Job.
+func (c *Controller) enqueueWorkForWorkload(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	wl, ok := obj.(*unstructured.Unstructured)
+	if !ok || c.jobLister == nil {
+		return
+	}
+	for _, ref := range wl.GetOwnerReferences() {
+		if ref.Kind != "Job" {
+			continue
+		}
+		if job, err := c.jobLister.Jobs(wl.GetNamespace()).Get(ref.Name); err == nil {
+			c.enqueueWorkForJob(job)
+		}
+	}
+}
+
+func ownedByJob(obj *unstructured.Unstructured, job *batchv1.Job) bool {
+	for _, ref := range obj.GetOwnerReferences() {
+		if ref.Kind != "Job" || ref.Name != job.Name {
+			continue
+		}
+		if job.UID == "" || ref.UID == job.UID {
+			return true
+		}
+	}
+	return false
+}
+
+// localQueue returns the LocalQueue's ClusterQueue and the positions of its
+// pending Workloads from the Kueue visibility API, reading them at most once
+// per queuedRecheckInterval for all Works queued on it.
+func (c *Controller) localQueue(ctx context.Context, namespace, name string) *localQueueSnapshot {
+	key := namespace + "/" + name
+	now := c.nowFunc()
+	if s := c.localQueues.get(key, now); s != nil {
+		return s
+	}
+	s := &localQueueSnapshot{fetchedAt: now}
+	if lq, err := c.dynamic.Resource(kueueLocalQueueGVR).Namespace(namespace).Get(ctx, name, metav1.GetOptions{}); err == nil {
+		s.clusterQueue, _, _ = unstructured.NestedString(lq.Object, "spec", "clusterQueue")
+	}
+	if summary, err := c.dynamic.Resource(kueueVisibilityLocalQueueGVR).Namespace(namespace).Get(ctx, name, metav1.GetOptions{}, "pendingworkloads"); err == nil {
+		items, _, _ := unstructured.NestedSlice(summary.Object, "items")
+		s.positions = make(map[string]int64, len(items))
+		for _, raw := range items {
+			item, _ := raw.(map[string]interface{})
+			workload, _, _ := unstructured.NestedString(item, "metadata", "name")
+			// positionInClusterQueue is zero-based.
+			if pos, ok, _ := unstructured.NestedInt64(item, "positionInClusterQueue"); ok && workload != "" {
+				s.positions[workload] = pos + 1
+			}
+		}
+	}
+	c.localQueues.put(key, s)
+	return s
+}
+
+// updateQueueStatus fills st.queue while the Job waits for admission and
+// once when it is admitted.
+func (c *Controller) updateQueueStatus(ctx context.Context, work *unstructured.Unstructured, job *batchv1.Job, st *workStatus) {
+	switch st.phase {
+	case "Queued":
+		c.enqueueWorkAfter(work, queuedRecheckInterval)
+	case "Running":
+		if recorded, _, _ := unstructured.NestedString(work.Object, "status", "queue", "admissionTime"); recorded != "" {
+			return
+		}
+	default:
+		return
+	}
+	q, err := c.jobQueueStatus(ctx, job)
+	if err != nil {
+		c.logger.Warn("failed to read kueue workload", "work", work.GetName(), "job", job.Name, "error", err)
+		return
+	}
+	if q == nil {
+		return
+	}
+	st.queue = q
+	if st.phase == "Queued" {
+		st.message = q.summary()
+	}
+}
@@ -0,0 +1,220 @@
+package controller
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestReconcileWorkReportsKueueQueueState(t *testing.T) {
+	work := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "nereid.yuiseki.net/v1alpha1",
+		"kind":       "Work",
+		"metadata":   map[string]interface{}{"name": "queued", "namespace": "nereid"},
+		"spec": map[string]interface{}{
+			"kind":  "agent.cli.v1",
+			"title": "queued",
+			"agent": map[string]interface{}{"image": "busybox", "script": "true"},
+		},
+	}}
+	localQueue := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "kueue.x-k8s.io/v1beta1",
+		"kind":       "LocalQueue",
+		"metadata":   map[string]interface{}{"name": "nereid-localq", "namespace": "nereid-work"},
+		"spec":       map[string]interface{}{"clusterQueue": "nereid-cq"},
+	}}
+	workload := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "kueue.x-k8s.io/v1beta1",
+		"kind":       "Workload",
+		"metadata": map[string]interface{}{
+			"name":      "job-work-queued-1a2b3",
+			"namespace": "nereid-work",
+			"ownerReferences": []interface{}{
+				map[string]interface{}{"apiVersion": "batch/v1", "kind": "Job", "name": "work-queued", "uid": ""},
+			},
+		},
+		"spec": map[string]interface{}{"queueName": "nereid-localq"},
+		"status": map[string]interface{}{
+			"conditions": []interface{}{
+				map[string]interface{}{
+					"type":    "QuotaReserved",
+					"status":  "False",
+					"reason":  "Pending",
+					"message": "couldn't assign flavors to pod set main: insufficient unused quota for cpu in flavor default, 1 more needed",
+				},
+			},
+		},
+	}}
+	dc := newFakeDynamicClient(work, localQueue, workload)
+	dc.PrependReactor("get", "localqueues", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		get := action.(k8stesting.GetAction)
+		if get.GetResource().Group != kueueVisibilityLocalQueueGVR.Group || get.GetSubresource() != "pendingworkloads" {
+			return false, nil, nil
+		}
+		return true, &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "visibility.kueue.x-k8s.io/v1beta1",
+			"kind":       "PendingWorkloadsSummary",
+			"items": []interface{}{
+				map[string]interface{}{"metadata": map[string]interface{}{"name": "other"}, "positionInClusterQueue": int64(0)},
+				map[string]interface{}{"metadata": map[string]interface{}{"name": "job-work-queued-1a2b3"}, "positionInClusterQueue": int64(2)},
+			},
+		}}, nil
+	})
+	kc := fake.NewSimpleClientset()
+	c := &Controller{
+		dynamic: dc,
+		kube:    kc,
+		cfg:     Config{JobNamespace: "nereid-work", LocalQueueName: "nereid-localq"},
+		logger:  slog.Default(),
+		nowFunc: time.Now,
+	}
+	ctx := context.Background()
+	reconcile := func() map[string]interface{} {
+		t.Helper()
+		latest, err := dc.Resource(workGVR).Namespace("nereid").Get(ctx, "queued", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("get work: %v", err)
+		}
+		if err := c.reconcileWork(ctx, latest); err != nil {
+			t.Fatalf("reconcileWork() error = %v", err)
+		}
+		updated, err := dc.Resource(workGVR).Namespace("nereid").Get(ctx, "queued", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("get work: %v", err)
+		}
+		status, _, _ := unstructured.NestedMap(updated.Object, "status")
+		return status
+	}
+
+	reconcile() // creates the suspended Job
+	status := reconcile()
+	queue, _ := status["queue"].(map[string]interface{})
+	if queue["clusterQueue"] != "nereid-cq" || queue["localQueue"] != "nereid-localq" || queue["position"] != int64(3) || queue["pendingReason"] != "Pending" {
+		t.Fatalf("status.queue while pending = %#v", queue)
+	}
+	if want := "waiting for kueue admission in ClusterQueue nereid-cq (position 3): couldn't assign flavors to pod set main: insufficient unused quota for cpu in flavor default, 1 more needed"; status["message"] != want {
+		t.Fatalf("message got=%q want=%q", status["message"], want)
+	}
+
+	admitted := "2026-02-15T12:00:00Z"
+	if err := unstructured.SetNestedField(workload.Object, map[string]interface{}{
+		"admission": map[string]interface{}{
+			"clusterQueue": "nereid-cq",
+			"podSetAssignments": []interface{}{
+				map[string]interface{}{"name": "main", "flavors": map[string]interface{}{"cpu": "default", "memory": "default"}},
+			},
+		},
+		"conditions": []interface{}{
+			map[string]interface{}{"type": "QuotaReserved", "status": "True", "reason": "QuotaReserved", "lastTransitionTime": admitted},
+			map[string]interface{}{"type": "Admitted", "status": "True", "reason": "Admitted", "lastTransitionTime": admitted},
+		},
+	}, "status"); err != nil {
+		t.Fatalf("set workload status: %v", err)
+	}
+	if _, err := dc.Resource(kueueWorkloadGVR).Namespace("nereid-work").Update(ctx, workload, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("update workload: %v", err)
+	}
+	job, err := kc.BatchV1().Jobs("nereid-work").Get(ctx, "work-queued", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	job.Spec.Suspend = boolPtr(false)
+	job.Status.Active = 1
+	if _, err := kc.BatchV1().Jobs("nereid-work").Update(ctx, job, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("update job: %v", err)
+	}
+
+	status = reconcile()
+	queue, _ = status["queue"].(map[string]interface{})
+	flavors, _ := queue["flavors"].(map[string]interface{})
+	if status["phase"] != "Running" || queue["admissionTime"] != admitted || flavors["cpu"] != "default" || queue["clusterQueue"] != "nereid-cq" {
+		t.Fatalf("status.queue once admitted = %#v (phase %v)", queue, status["phase"])
+	}
+	if _, ok := queue["pendingReason"]; ok {
+		t.Fatalf("pendingReason should be cleared once admitted: %#v", queue)
+	}
+}
+
+func TestLocalQueueReadsAreSharedBetweenQueuedWorks(t *testing.T) {
+	localQueue := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "kueue.x-k8s.io/v1beta1",
+		"kind":       "LocalQueue",
+		"metadata":   map[string]interface{}{"name": "nereid-localq", "namespace": "nereid-work"},
+		"spec":       map[string]interface{}{"clusterQueue": "nereid-cq"},
+	}}
+	dc := newFakeDynamicClient(localQueue)
+	visibilityGets := 0
+	dc.PrependReactor("get", "localqueues", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		get := action.(k8stesting.GetAction)
+		if get.GetSubresource() != "pendingworkloads" {
+			return false, nil, nil
+		}
+		visibilityGets++
+		return true, &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "visibility.kueue.x-k8s.io/v1beta1",
+			"kind":       "PendingWorkloadsSummary",
+			"items": []interface{}{
+				map[string]interface{}{"metadata": map[string]interface{}{"name": "wl-a"}, "positionInClusterQueue": int64(0)},
+				map[string]interface{}{"metadata": map[string]interface{}{"name": "wl-b"}, "positionInClusterQueue": int64(1)},
+			},
+		}}, nil
+	})
+
+	workloads := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{workloadJobUIDIndex: indexWorkloadByJobUID})
+	now := time.Date(2026, 2, 15, 12, 0, 0, 0, time.UTC)
+	c := &Controller{
+		dynamic:         dc,
+		kube:            fake.NewSimpleClientset(),
+		cfg:             Config{JobNamespace: "nereid-work"},
+		logger:          slog.Default(),
+		nowFunc:         func() time.Time { return now },
+		workloadIndexer: workloads,
+	}
+	ctx := context.Background()
+	positions := map[string]int64{}
+	for _, name := range []string{"a", "b"} {
+		job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "work-" + name, Namespace: "nereid-work", UID: types.UID("uid-" + name)}}
+		if err := workloads.Add(&unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "kueue.x-k8s.io/v1beta1",
+			"kind":       "Workload",
+			"metadata": map[string]interface{}{
+				"name":      "wl-" + name,
+				"namespace": "nereid-work",
+				"labels":    map[string]interface{}{kueueJobUIDLabelKey: "uid-" + name},
+				"ownerReferences": []interface{}{
+					map[string]interface{}{"apiVersion": "batch/v1", "kind": "Job", "name": job.Name, "uid": string(job.UID)},
+				},
+			},
+			"spec": map[string]interface{}{"queueName": "nereid-localq"},
+		}}); err != nil {
+			t.Fatalf("add workload: %v", err)
+		}
+		q, err := c.jobQueueStatus(ctx, job)
+		if err != nil || q == nil {
+			t.Fatalf("jobQueueStatus(%s) = %+v, %v", name, q, err)
+		}
+		if q.clusterQueue != "nereid-cq" {
+			t.Fatalf("clusterQueue got=%q", q.clusterQueue)
+		}
+		positions[name] = q.position
+	}
+	if positions["a"] != 1 || positions["b"] != 2 || visibilityGets != 1 {
+		t.Fatalf("positions=%v visibility reads=%d, want a=1 b=2 from one read", positions, visibilityGets)
+	}
+
+	now = now.Add(queuedRecheckInterval)
+	c.localQueue(ctx, "nereid-work", "nereid-localq")
+	if visibilityGets != 2 {
+		t.Fatalf("visibility reads after the interval = %d, want 2", visibilityGets)
+	}
+}
@@ -0,0 +1,190 @@
+package controller
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/yuiseki/NEREID/internal/runner"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	defaultRasterTargetSRS  = "EPSG:3857"
+	defaultRasterResampling = "near"
+	rasterTileDir           = "tiles"
+	rasterCOGFile           = "raster.cog.tif"
+)
+
+// rasterResamplingMethods are the -r values both gdalwarp and gdal2tiles.py
+// accept.
+var rasterResamplingMethods = map[string]bool{
+	"near": true, "bilinear": true, "cubic": true, "cubicspline": true, "lanczos": true, "average": true,
+	"mode": true, "max": true, "min": true, "med": true, "q1": true, "q3": true,
+}
+
+var epsgCodePattern = regexp.MustCompile(`^(?i:epsg:)?([0-9]{4,6})$`)
+
+// rasterTileOptions is spec.raster resolved into gdal arguments.
+type rasterTileOptions struct {
+	inputURI         string
+	srcNodata        string
+	dstNodata        string
+	targetSRS        string
+	resampling       string
+	minZoom, maxZoom int
+	cog              bool
+}
+
+func rasterTileOptionsFromWork(work *unstructured.Unstructured) (rasterTileOptions, error) {
+	get := func(fields ...string) (string, error) {
+		v, _, err := nestedStringAny(work.Object, append([]string{"spec", "raster"}, fields...)...)
+		if err != nil {
+			return "", fmt.Errorf("failed to read spec.raster.%s: %v", strings.Join(fields, "."), err)
+		}
+		return strings.TrimSpace(v), nil
+	}
+	var o rasterTileOptions
+	var err error
+	if o.inputURI, err = get("input", "uri"); err != nil {
+		return o, err
+	}
+	if o.inputURI == "" {
+		return o, fmt.Errorf("spec.raster.input.uri is required")
+	}
+	for _, nd := range []struct {
+		field string
+		dst   *string
+	}{{"src", &o.srcNodata}, {"dst", &o.dstNodata}} {
+		if *nd.dst, err = get("nodata", nd.field); err != nil {
+			return o, err
+		}
+		if v := *nd.dst; v != "" && !strings.EqualFold(v, "nan") {
+			if _, err := strconv.ParseFloat(v, 64); err != nil {
+				return o, fmt.Errorf("spec.raster.nodata.%s=%q is not a number", nd.field, v)
+			}
+		}
+	}
+
+	targetSRS, err := get("reprojection", "targetSRS")
+	if err != nil {
+		return o, err
+	}
+	targetEPSG, err := get("reprojection", "targetEPSG")
+	if err != nil {
+		return o, err
+	}
+	switch {
+	case targetSRS != "":
+		o.targetSRS = targetSRS
+	case targetEPSG != "":
+		m := epsgCodePattern.FindStringSubmatch(targetEPSG)
+		if m == nil {
+			return o, fmt.Errorf("spec.raster.reprojection.targetEPSG=%q is not an EPSG code", targetEPSG)
+		}
+		o.targetSRS = "EPSG:" + m[1]
+	default:
+		o.targetSRS = defaultRasterTargetSRS
+	}
+
+	if o.resampling, err = get("reprojection", "resampling"); err != nil {
+		return o, err
+	}
+	o.resampling = strings.ToLower(o.resampling)
+	if o.resampling == "" {
+		o.resampling = defaultRasterResampling
+	}
+	if !rasterResamplingMethods[o.resampling] {
+		methods := make([]string, 0, len(rasterResamplingMethods))
+		for m := range rasterResamplingMethods {
+			methods = append(methods, m)
+		}
+		sort.Strings(methods)
+		return o, fmt.Errorf("unsupported spec.raster.reprojection.resampling=%q (use one of %s)", o.resampling, strings.Join(methods, ", "))
+	}
+
+	o.minZoom, o.maxZoom = extractTileZoomRange(work)
+	o.cog, _, _ = unstructured.NestedBool(work.Object, "spec", "raster", "output", "cog")
+	return o, nil
+}
+
+// buildRasterTileScript turns a gdal.rastertile.v1 spec into a fixed GDAL
+// pipeline: gdalinfo -json, gdal_translate (nodata), gdalwarp and
+// gdal2tiles.py, plus an optional Cloud-Optimized GeoTIFF and a viewer.
+func buildRasterTileScript(work *unstructured.Unstructured, spec map[string]interface{}) (string, error) {
+	o, err := rasterTileOptionsFromWork(work)
+	if err != nil {
+		return "", err
+	}
+	viewer, err := nativeViewerSpec(spec)
+	if err != nil {
+		return "", err
+	}
+	cog := ""
+	if o.cog {
+		cog = "./" + rasterCOGFile
+	}
+	html, err := runner.RasterViewerHTML(viewer, rasterTileDir, o.minZoom, o.maxZoom, cog)
+	if err != nil {
+		return "", fmt.Errorf("failed to render raster viewer: %v", err)
+	}
+
+	translateArgs := ""
+	if o.srcNodata != "" {
+		translateArgs = " -a_nodata " + shellQuote(o.srcNodata)
+	}
+	warpArgs := ""
+	if o.srcNodata != "" {
+		warpArgs += " -srcnodata " + shellQuote(o.srcNodata)
+	}
+	if o.dstNodata != "" {
+		warpArgs += " -dstnodata " + shellQuote(o.dstNodata)
+	}
+	cogStep := ""
+	if o.cog {
+		cogStep = `run gdal_translate gdal_translate -q -of COG -co COMPRESS=DEFLATE "${WORK_DIR}/warped.tif" "${OUT}/` + rasterCOGFile + `"
+`
+	}
+
+	return nativeScriptPrelude + fmt.Sprintf(`INPUT_URI=%s
+TARGET_SRS=%s
+RESAMPLING=%s
+MIN_ZOOM=%d
+MAX_ZOOM=%d
+VIEWER_B64=%q
+
+fetch_input "${INPUT_URI}" "${WORK_DIR}/input"
+gdalinfo -json "${WORK_DIR}/input" > "${OUT}/gdalinfo.json" 2> "${WORK_DIR}/stderr" \
+  || fail "gdalinfo failed: $(tail -n 3 "${WORK_DIR}/stderr" | tr '\n' ' ')"
+# gdal2tiles.py needs RGB(A); expand paletted rasters first.
+set --
+if grep -q '"colorTable"' "${OUT}/gdalinfo.json"; then
+  set -- -expand rgba
+fi
+run gdal_translate gdal_translate -q "$@"%s -of GTiff "${WORK_DIR}/input" "${WORK_DIR}/translated.tif"
+run gdalwarp gdalwarp -q -overwrite -t_srs "${TARGET_SRS}" -r "${RESAMPLING}"%s -of GTiff "${WORK_DIR}/translated.tif" "${WORK_DIR}/warped.tif"
+run gdal2tiles gdal2tiles.py --xyz --profile=mercator -z "${MIN_ZOOM}-${MAX_ZOOM}" -r "${RESAMPLING}" -w none "${WORK_DIR}/warped.tif" "${OUT}/%s"
+%sprintf '%%s' "${VIEWER_B64}" | base64 -d > "${OUT}/index.html"
+`,
+		shellQuote(o.inputURI), shellQuote(o.targetSRS), shellQuote(o.resampling), o.minZoom, o.maxZoom,
+		base64.StdEncoding.EncodeToString([]byte(html)),
+		translateArgs, warpArgs, rasterTileDir, cogStep,
+	), nil
+}
+
+// nativeViewerSpec extracts the fields viewers read from a Work spec.
+func nativeViewerSpec(spec map[string]interface{}) (*runner.Spec, error) {
+	raw, err := json.Marshal(spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode spec for viewer: %v", err)
+	}
+	var out runner.Spec
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return nil, fmt.Errorf("failed to decode spec for viewer: %v", err)
+	}
+	return &out, nil
+}
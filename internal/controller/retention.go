@@ -0,0 +1,301 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	// pinAnnotationKey set to "true" exempts a Work's artifacts from retention,
+	// quota and disk budget eviction.
+	pinAnnotationKey = "nereid.yuiseki.net/pin"
+
+	pruneReasonRetention       = "RetentionExpired"
+	pruneReasonGrantQuota      = "GrantQuotaExceeded"
+	pruneReasonDiskBudget      = "DiskBudgetExceeded"
+	eventReasonArtifactsPruned = "ArtifactsPruned"
+)
+
+// storedArtifacts is one Work's artifact entry together with what pruning
+// needs to know about the Work. work is nil when the Work no longer exists.
+type storedArtifacts struct {
+	artifactEntry
+	work      *unstructured.Unstructured
+	grantKey  string
+	retention time.Duration
+	removed   bool
+}
+
+// evictable reports whether the entry may be pruned: it is not pinned and its
+// Work, if any, has finished.
+func (s *storedArtifacts) evictable() bool {
+	if s.removed {
+		return false
+	}
+	if s.work == nil {
+		return true
+	}
+	if strings.EqualFold(strings.TrimSpace(s.work.GetAnnotations()[pinAnnotationKey]), "true") {
+		return false
+	}
+	phase, _, _ := unstructured.NestedString(s.work.Object, "status", "phase")
+	return isTerminalWorkPhase(phase)
+}
+
+// parseRetention accepts Go durations ("36h") and whole days ("7d").
+func parseRetention(v string) (time.Duration, error) {
+	v = strings.TrimSpace(v)
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid retention %q", v)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid retention %q", v)
+	}
+	return d, nil
+}
+
+// minRetention returns the shorter of a and b; zero means unlimited.
+func minRetention(a, b time.Duration) time.Duration {
+	if a <= 0 {
+		return b
+	}
+	if b <= 0 || a < b {
+		return a
+	}
+	return b
+}
+
+// workArtifactRetention is spec.artifacts.retention, or ArtifactRetention when
+// unset, capped by the Grant's spec.limits.artifactRetention. Failed Works are
+// further capped by FailedArtifactRetention.
+func (c *Controller) workArtifactRetention(work, grant *unstructured.Unstructured) time.Duration {
+	retention := c.cfg.ArtifactRetention
+	if v, _, _ := nestedStringAny(work.Object, "spec", "artifacts", "retention"); strings.TrimSpace(v) != "" {
+		d, err := parseRetention(v)
+		if err != nil {
+			c.logger.Warn("ignoring spec.artifacts.retention", "work", work.GetName(), "error", err)
+		} else {
+			retention = d
+		}
+	}
+	if grant != nil {
+		if v, _, _ := nestedStringAny(grant.Object, "spec", "limits", "artifactRetention"); strings.TrimSpace(v) != "" {
+			d, err := parseRetention(v)
+			if err != nil {
+				c.logger.Warn("ignoring grant spec.limits.artifactRetention", "grant", grant.GetName(), "error", err)
+			} else {
+				retention = minRetention(retention, d)
+			}
+		}
+	}
+	switch phase, _, _ := unstructured.NestedString(work.Object, "status", "phase"); phase {
+	case "Failed", "Error":
+		retention = minRetention(retention, c.cfg.FailedArtifactRetention)
+	}
+	return retention
+}
+
+// grantArtifactQuota returns spec.limits.artifactQuota in bytes; zero means
+// unlimited.
+func grantArtifactQuota(grant *unstructured.Unstructured) (int64, error) {
+	v, _, _ := nestedStringAny(grant.Object, "spec", "limits", "artifactQuota")
+	if strings.TrimSpace(v) == "" {
+		return 0, nil
+	}
+	q, err := resource.ParseQuantity(strings.TrimSpace(v))
+	if err != nil {
+		return 0, fmt.Errorf("invalid spec.limits.artifactQuota %q: %v", v, err)
+	}
+	return q.Value(), nil
+}
+
+func workGrantKey(work *unstructured.Unstructured) string {
+	keys, _ := indexWorkByGrant(work)
+	if len(keys) == 0 {
+		return ""
+	}
+	return keys[0]
+}
+
+func (c *Controller) listWorks(ctx context.Context) ([]*unstructured.Unstructured, error) {
+	var out []*unstructured.Unstructured
+	if c.workIndexer != nil {
+		for _, obj := range c.workIndexer.List() {
+			if work, ok := obj.(*unstructured.Unstructured); ok {
+				out = append(out, work)
+			}
+		}
+		return out, nil
+	}
+	if c.dynamic == nil {
+		return nil, nil
+	}
+	list, err := c.dynamic.Resource(workGVR).Namespace(c.cfg.WorkNamespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("list works: %w", err)
+	}
+	for i := range list.Items {
+		out = append(out, &list.Items[i])
+	}
+	return out, nil
+}
+
+// pruneArtifacts removes artifact entries past their retention, then the
+// oldest evictable entries of each Grant over its artifactQuota, then the
+// oldest evictable entries while the store exceeds ArtifactDiskBudget.
+// Finally each Grant's remaining usage is written to status.artifactBytes.
+func (c *Controller) pruneArtifacts(ctx context.Context) error {
+	store := c.artifacts()
+	entries, err := store.list(ctx)
+	if err != nil {
+		return err
+	}
+	works, err := c.listWorks(ctx)
+	if err != nil {
+		return err
+	}
+
+	byName := make(map[string]*unstructured.Unstructured, len(works))
+	grants := map[string]*unstructured.Unstructured{}
+	for _, work := range works {
+		byName[work.GetName()] = work
+		key := workGrantKey(work)
+		if _, seen := grants[key]; key == "" || seen {
+			continue
+		}
+		grantName, _, _ := unstructured.NestedString(work.Object, "spec", "grantRef", "name")
+		grant, getErr := c.getGrant(ctx, work.GetNamespace(), strings.TrimSpace(grantName))
+		if apierrors.IsNotFound(getErr) {
+			grant = nil
+		} else if getErr != nil {
+			return fmt.Errorf("get grant %s: %w", key, getErr)
+		}
+		grants[key] = grant
+	}
+
+	stored := make([]*storedArtifacts, 0, len(entries))
+	for _, entry := range entries {
+		s := &storedArtifacts{artifactEntry: entry, work: byName[entry.workName], retention: c.cfg.ArtifactRetention}
+		if s.work != nil {
+			s.grantKey = workGrantKey(s.work)
+			s.retention = c.workArtifactRetention(s.work, grants[s.grantKey])
+		}
+		stored = append(stored, s)
+	}
+	sort.SliceStable(stored, func(i, j int) bool {
+		return stored[i].modTime.Before(stored[j].modTime)
+	})
+
+	now := c.nowFunc()
+	for _, s := range stored {
+		if s.retention > 0 && !s.modTime.After(now.Add(-s.retention)) && s.evictable() {
+			c.evictArtifacts(ctx, store, s, pruneReasonRetention, "older than retention "+s.retention.String())
+		}
+	}
+
+	grantKeys := make([]string, 0, len(grants))
+	for key := range grants {
+		grantKeys = append(grantKeys, key)
+	}
+	sort.Strings(grantKeys)
+	for _, key := range grantKeys {
+		grant := grants[key]
+		if grant == nil {
+			continue
+		}
+		quota, quotaErr := grantArtifactQuota(grant)
+		if quotaErr != nil {
+			c.logger.Warn("ignoring grant artifact quota", "grant", key, "error", quotaErr)
+			continue
+		}
+		if quota > 0 {
+			limit := resource.NewQuantity(quota, resource.BinarySI)
+			removed, freed := c.evictOverBudget(ctx, store, stored, quota, key, pruneReasonGrantQuota,
+				fmt.Sprintf("grant %s artifactQuota %s", grant.GetName(), limit))
+			if removed > 0 {
+				c.recordEvent(grant, corev1.EventTypeNormal, eventReasonArtifactsPruned, "Pruned artifacts of %d Works (%s) to fit artifactQuota %s",
+					removed, resource.NewQuantity(freed, resource.BinarySI), limit)
+			}
+		}
+	}
+	if budget := c.cfg.ArtifactDiskBudget; budget > 0 {
+		c.evictOverBudget(ctx, store, stored, budget, "", pruneReasonDiskBudget,
+			"disk budget "+resource.NewQuantity(budget, resource.BinarySI).String())
+	}
+
+	for _, key := range grantKeys {
+		grant := grants[key]
+		if grant == nil {
+			continue
+		}
+		var used int64
+		for _, s := range stored {
+			if !s.removed && s.grantKey == key {
+				used += s.size
+			}
+		}
+		if err := c.setGrantArtifactBytes(ctx, grant, used); err != nil {
+			c.logger.Warn("failed to update grant artifact usage", "grant", key, "error", err)
+		}
+	}
+	return nil
+}
+
+// evictOverBudget removes the oldest evictable entries, limited to one Grant
+// unless grantKey is empty, until their total size fits in budget. It returns
+// how many entries it removed and their size.
+func (c *Controller) evictOverBudget(ctx context.Context, store artifactStore, stored []*storedArtifacts, budget int64, grantKey, reason, detail string) (removed int, freed int64) {
+	var total int64
+	for _, s := range stored {
+		if !s.removed && (grantKey == "" || s.grantKey == grantKey) {
+			total += s.size
+		}
+	}
+	for _, s := range stored {
+		if total <= budget {
+			return removed, freed
+		}
+		if grantKey != "" && s.grantKey != grantKey || !s.evictable() {
+			continue
+		}
+		if c.evictArtifacts(ctx, store, s, reason, detail) {
+			total -= s.size
+			removed++
+			freed += s.size
+		}
+	}
+	if total > budget {
+		c.logger.Warn("artifacts exceed budget after pruning; remaining entries are pinned or in use",
+			"reason", reason, "grant", grantKey, "bytes", total, "budget", budget)
+	}
+	return removed, freed
+}
+
+func (c *Controller) evictArtifacts(ctx context.Context, store artifactStore, s *storedArtifacts, reason, detail string) bool {
+	if err := store.remove(ctx, s.workName); err != nil {
+		c.logger.Warn("failed to prune artifact entry", "work", s.workName, "reason", reason, "error", err)
+		return false
+	}
+	s.removed = true
+	c.metrics.observeArtifactsPruned(reason, s.size)
+	c.logger.Info("pruned artifact entry", "work", s.workName, "reason", reason, "detail", detail, "bytes", s.size, "modTime", s.modTime)
+	if s.work != nil {
+		c.recordEvent(s.work, corev1.EventTypeNormal, eventReasonArtifactsPruned, "Artifacts pruned (%s): %s", reason, detail)
+	}
+	return true
+}
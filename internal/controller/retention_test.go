@@ -0,0 +1,171 @@
+package controller
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestParseRetention(t *testing.T) {
+	cases := map[string]time.Duration{"7d": 7 * 24 * time.Hour, "36h": 36 * time.Hour, " 1d ": 24 * time.Hour}
+	for in, want := range cases {
+		if got, err := parseRetention(in); err != nil || got != want {
+			t.Fatalf("parseRetention(%q) got=%v err=%v want=%v", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "0d", "-1h", "soon"} {
+		if _, err := parseRetention(in); err == nil {
+			t.Fatalf("parseRetention(%q) should fail", in)
+		}
+	}
+}
+
+func TestPruneArtifactsRetentionPinsAndQuotas(t *testing.T) {
+	now := time.Date(2026, 2, 15, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	root := t.TempDir()
+	writeArtifacts := func(name string, size int, age time.Duration) {
+		t.Helper()
+		dir := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Join(dir, "logs"), 0o755); err != nil {
+			t.Fatalf("mkdir %s: %v", name, err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "logs", "agent.log"), make([]byte, size), 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+		mtime := now.Add(-age)
+		if err := os.Chtimes(dir, mtime, mtime); err != nil {
+			t.Fatalf("chtimes %s: %v", name, err)
+		}
+	}
+	newWork := func(name, phase, grant, retention string, pinned bool) *unstructured.Unstructured {
+		spec := map[string]interface{}{"kind": "agent.cli.v1"}
+		if grant != "" {
+			spec["grantRef"] = map[string]interface{}{"name": grant}
+		}
+		if retention != "" {
+			spec["artifacts"] = map[string]interface{}{"retention": retention}
+		}
+		work := &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "nereid.yuiseki.net/v1alpha1",
+			"kind":       "Work",
+			"metadata":   map[string]interface{}{"name": name, "namespace": "nereid"},
+			"spec":       spec,
+			"status":     map[string]interface{}{"phase": phase},
+		}}
+		if pinned {
+			work.SetAnnotations(map[string]string{pinAnnotationKey: "true"})
+		}
+		return work
+	}
+
+	writeArtifacts("expired", 100, 31*day)
+	writeArtifacts("pinned", 100, 40*day)
+	writeArtifacts("failed", 100, 8*day)
+	writeArtifacts("override", 100, 60*day)
+	writeArtifacts("capped", 100, 12*day)
+	writeArtifacts("grant-old", 2048, 2*day)
+	writeArtifacts("grant-new", 2048, 1*day)
+	writeArtifacts("running", 4096, 50*day)
+	writeArtifacts("orphan", 1024, 3*day)
+
+	grant := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "nereid.yuiseki.net/v1alpha1",
+		"kind":       "Grant",
+		"metadata":   map[string]interface{}{"name": "g1", "namespace": "nereid"},
+		"spec": map[string]interface{}{
+			"limits": map[string]interface{}{"artifactRetention": "10d", "artifactQuota": "3Ki"},
+		},
+	}}
+	dc := newFakeDynamicClient(
+		grant,
+		newWork("expired", "Succeeded", "", "", false),
+		newWork("pinned", "Succeeded", "", "", true),
+		newWork("failed", "Failed", "", "", false),
+		newWork("override", "Succeeded", "", "90d", false),
+		newWork("capped", "Succeeded", "g1", "90d", false),
+		newWork("grant-old", "Succeeded", "g1", "", false),
+		newWork("grant-new", "Succeeded", "g1", "", false),
+		newWork("running", "Running", "", "1h", false),
+	)
+	recorder := record.NewFakeRecorder(20)
+	c := &Controller{
+		dynamic: dc,
+		cfg: Config{
+			WorkNamespace:           "nereid",
+			ArtifactsHostPath:       root,
+			ArtifactRetention:       30 * day,
+			FailedArtifactRetention: 7 * day,
+		},
+		logger:   slog.Default(),
+		nowFunc:  func() time.Time { return now },
+		recorder: recorder,
+	}
+	ctx := context.Background()
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(root, name))
+		return err == nil
+	}
+
+	if err := c.pruneArtifacts(ctx); err != nil {
+		t.Fatalf("pruneArtifacts() error = %v", err)
+	}
+	for name, want := range map[string]bool{
+		"expired":   false,
+		"pinned":    true,
+		"failed":    false,
+		"override":  true,
+		"capped":    false,
+		"grant-old": false,
+		"grant-new": true,
+		"running":   true,
+		"orphan":    true,
+	} {
+		if got := exists(name); got != want {
+			t.Fatalf("%s exists=%v want=%v", name, got, want)
+		}
+	}
+
+	var events []string
+	for len(recorder.Events) > 0 {
+		events = append(events, <-recorder.Events)
+	}
+	// Four Works pruned, plus a summary on the Grant over its quota.
+	if len(events) != 5 {
+		t.Fatalf("events got=%d want=5: %v", len(events), events)
+	}
+	joined := strings.Join(events, "\n")
+	for _, want := range []string{"(RetentionExpired): older than retention 168h0m0s", "(RetentionExpired): older than retention 240h0m0s", "(GrantQuotaExceeded): grant g1 artifactQuota 3Ki", "Pruned artifacts of 1 Works (2Ki) to fit artifactQuota 3Ki"} {
+		if !strings.Contains(joined, want) {
+			t.Fatalf("events missing %q: %v", want, events)
+		}
+	}
+
+	stored, err := dc.Resource(grantGVR).Namespace("nereid").Get(ctx, "g1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get grant: %v", err)
+	}
+	if got, _, _ := unstructured.NestedInt64(stored.Object, "status", "artifactBytes"); got != 2048 {
+		t.Fatalf("grant artifactBytes got=%d want=2048", got)
+	}
+
+	// 7368 bytes remain; the oldest evictable entries go first and pinned or
+	// unfinished Works are never evicted.
+	c.cfg.ArtifactDiskBudget = 6500
+	if err := c.pruneArtifacts(ctx); err != nil {
+		t.Fatalf("pruneArtifacts() with budget error = %v", err)
+	}
+	for name, want := range map[string]bool{"override": false, "orphan": false, "grant-new": true, "pinned": true, "running": true} {
+		if got := exists(name); got != want {
+			t.Fatalf("with budget: %s exists=%v want=%v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package controller
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	attemptLabelKey = "nereid.yuiseki.net/attempt"
+	attemptEnvName  = "NEREID_ATTEMPT"
+
+	maxWorkRetries             = 10
+	defaultRetryInitialBackoff = 30 * time.Second
+	defaultRetryMaxBackoff     = 10 * time.Minute
+)
+
+// attemptResult describes how one attempt Job ended. It is recorded in
+// Work.status.attempts[].
+type attemptResult struct {
+	attempt        int
+	jobName        string
+	startTime      time.Time
+	completionTime time.Time
+	reason         string
+	message        string
+}
+
+// makeAttemptJobName returns the Job name for the given attempt. The first
+// attempt keeps the historical name so existing Works are picked up unchanged.
+func makeAttemptJobName(workName string, attempt int) string {
+	name := makeJobName(workName)
+	if attempt <= 1 {
+		return name
+	}
+	suffix := fmt.Sprintf("-a%d", attempt)
+	if len(name)+len(suffix) > 63 {
+		name = strings.TrimRight(name[:63-len(suffix)], "-")
+	}
+	return name + suffix
+}
+
+// workAttempt returns the attempt the Work is currently on, starting at 1.
+func workAttempt(work *unstructured.Unstructured) int {
+	attempt, found, err := unstructured.NestedInt64(work.Object, "status", "attempt")
+	if err != nil || !found || attempt < 1 {
+		return 1
+	}
+	return int(attempt)
+}
+
+// maxWorkAttempts is 1 + spec.constraints.retries, capped by the Grant's
+// spec.maxRetries when set.
+func maxWorkAttempts(work, grant *unstructured.Unstructured) int {
+	retries, found, err := unstructured.NestedInt64(work.Object, "spec", "constraints", "retries")
+	if err != nil || !found || retries < 0 {
+		retries = 0
+	}
+	if retries > maxWorkRetries {
+		retries = maxWorkRetries
+	}
+	if grant != nil {
+		if limit, found, err := unstructured.NestedInt64(grant.Object, "spec", "maxRetries"); err == nil && found && limit >= 0 && limit < retries {
+			retries = limit
+		}
+	}
+	return int(retries) + 1
+}
+
+// retryBackoff returns the delay before starting the attempt after the given
+// one. It doubles per attempt from spec.constraints.retryBackoff.initialSeconds
+// up to maxSeconds.
+func retryBackoff(work *unstructured.Unstructured, attempt int) time.Duration {
+	initial := defaultRetryInitialBackoff
+	if v, found, err := unstructured.NestedInt64(work.Object, "spec", "constraints", "retryBackoff", "initialSeconds"); err == nil && found && v >= 0 {
+		initial = time.Duration(v) * time.Second
+	}
+	max := defaultRetryMaxBackoff
+	if v, found, err := unstructured.NestedInt64(work.Object, "spec", "constraints", "retryBackoff", "maxSeconds"); err == nil && found && v >= 0 {
+		max = time.Duration(v) * time.Second
+	}
+
+	d := initial
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// setJobAttempt labels the Job with its attempt number and exposes it to the
+// task container so scripts can keep per-attempt logs.
+func setJobAttempt(job *batchv1.Job, attempt int) {
+	value := strconv.Itoa(attempt)
+	if job.Labels == nil {
+		job.Labels = map[string]string{}
+	}
+	job.Labels[attemptLabelKey] = value
+	if job.Spec.Template.Labels == nil {
+		job.Spec.Template.Labels = map[string]string{}
+	}
+	job.Spec.Template.Labels[attemptLabelKey] = value
+	for i := range job.Spec.Template.Spec.Containers {
+		job.Spec.Template.Spec.Containers[i].Env = append(job.Spec.Template.Spec.Containers[i].Env, corev1.EnvVar{Name: attemptEnvName, Value: value})
+	}
+}
+
+// finishedAttempt summarizes a terminal attempt Job. reason and message are
+// the outcome computed for the Work; a more specific reason from the Job's
+// Failed condition (e.g. DeadlineExceeded) wins when present.
+func finishedAttempt(job *batchv1.Job, attempt int, reason, message string, now time.Time) *attemptResult {
+	res := &attemptResult{
+		attempt:        attempt,
+		jobName:        job.Name,
+		completionTime: now,
+		reason:         reason,
+		message:        message,
+	}
+	if job.Status.StartTime != nil {
+		res.startTime = job.Status.StartTime.Time
+	}
+	if job.Status.CompletionTime != nil {
+		res.completionTime = job.Status.CompletionTime.Time
+	}
+	for _, cond := range job.Status.Conditions {
+		if cond.Type != batchv1.JobFailed || cond.Status != corev1.ConditionTrue {
+			continue
+		}
+		if !cond.LastTransitionTime.IsZero() {
+			res.completionTime = cond.LastTransitionTime.Time
+		}
+		if reason == reasonJobFailed && cond.Reason != "" {
+			res.reason = cond.Reason
+			if cond.Message != "" {
+				res.message = cond.Message
+			}
+		}
+	}
+	return res
+}
+
+// upsertAttempt records res in status.attempts, replacing an earlier record
+// for the same attempt. Times already recorded are kept.
+func upsertAttempt(status map[string]interface{}, res *attemptResult) {
+	raw, _ := status["attempts"].([]interface{})
+	entry := map[string]interface{}{
+		"attempt": int64(res.attempt),
+		"jobName": res.jobName,
+	}
+	if !res.startTime.IsZero() {
+		entry["startTime"] = formatStatusTime(res.startTime)
+	}
+	entry["completionTime"] = formatStatusTime(res.completionTime)
+	setOrDeleteString(entry, "reason", res.reason)
+	setOrDeleteString(entry, "message", res.message)
+
+	for i, item := range raw {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if n, ok := m["attempt"].(int64); !ok || int(n) != res.attempt {
+			continue
+		}
+		for _, key := range []string{"startTime", "completionTime"} {
+			if v, ok := m[key]; ok {
+				entry[key] = v
+			}
+		}
+		raw[i] = entry
+		status["attempts"] = raw
+		return
+	}
+	status["attempts"] = append(raw, entry)
+}
+
+func lastAttemptCompletion(status map[string]interface{}, attempt int) (time.Time, bool) {
+	raw, _ := status["attempts"].([]interface{})
+	for _, item := range raw {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if n, ok := m["attempt"].(int64); ok && int(n) == attempt {
+			return parseStatusTime(m["completionTime"])
+		}
+	}
+	return time.Time{}, false
+}
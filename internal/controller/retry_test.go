@@ -0,0 +1,190 @@
+package controller
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestMakeAttemptJobNameBounded(t *testing.T) {
+	if got := makeAttemptJobName("sample", 1); got != "work-sample" {
+		t.Fatalf("first attempt name got=%q", got)
+	}
+	if got := makeAttemptJobName("sample", 2); got != "work-sample-a2" {
+		t.Fatalf("second attempt name got=%q", got)
+	}
+	long := makeAttemptJobName(strings.Repeat("x", 120), 10)
+	if len(long) > 63 || !strings.HasSuffix(long, "-a10") {
+		t.Fatalf("long attempt name got=%q (len=%d)", long, len(long))
+	}
+}
+
+func TestRetryBackoffDoublesUpToMax(t *testing.T) {
+	work := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"constraints": map[string]interface{}{
+				"retryBackoff": map[string]interface{}{
+					"initialSeconds": int64(10),
+					"maxSeconds":     int64(30),
+				},
+			},
+		},
+	}}
+	for attempt, want := range map[int]time.Duration{1: 10 * time.Second, 2: 20 * time.Second, 3: 30 * time.Second, 8: 30 * time.Second} {
+		if got := retryBackoff(work, attempt); got != want {
+			t.Fatalf("retryBackoff(attempt=%d) got=%s want=%s", attempt, got, want)
+		}
+	}
+}
+
+func TestReconcileWorkRetriesFailedAttemptAfterBackoff(t *testing.T) {
+	work := retryTestWork("flaky", int64(2))
+	failedAt := time.Date(2026, 2, 15, 12, 0, 0, 0, time.UTC)
+	dc := newFakeDynamicClient(work)
+	kc := fake.NewSimpleClientset(failedJob(makeJobName("flaky"), "flaky", failedAt))
+	now := failedAt.Add(10 * time.Second)
+	c := &Controller{
+		dynamic: dc,
+		kube:    kc,
+		cfg:     Config{JobNamespace: "nereid-work", ArtifactsHostPath: "/var/lib/nereid/artifacts"},
+		logger:  slog.Default(),
+		nowFunc: func() time.Time { return now },
+	}
+
+	ctx := context.Background()
+	if err := c.reconcileWork(ctx, work); err != nil {
+		t.Fatalf("reconcileWork(backoff) error = %v", err)
+	}
+	if got := workPhase(ctx, t, dc, "nereid", "flaky"); got != "Retrying" {
+		t.Fatalf("phase during backoff got=%q want=%q", got, "Retrying")
+	}
+	if _, err := kc.BatchV1().Jobs("nereid-work").Get(ctx, "work-flaky-a2", metav1.GetOptions{}); err == nil {
+		t.Fatal("second attempt job should not be created before backoff elapsed")
+	}
+
+	now = failedAt.Add(time.Minute)
+	latest, err := dc.Resource(workGVR).Namespace("nereid").Get(ctx, "flaky", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get work: %v", err)
+	}
+	if err := c.reconcileWork(ctx, latest); err != nil {
+		t.Fatalf("reconcileWork(retry) error = %v", err)
+	}
+	job, err := kc.BatchV1().Jobs("nereid-work").Get(ctx, "work-flaky-a2", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("second attempt job not created: %v", err)
+	}
+	if job.Labels[attemptLabelKey] != "2" {
+		t.Fatalf("attempt label got=%q want=2", job.Labels[attemptLabelKey])
+	}
+	foundEnv := false
+	for _, ev := range job.Spec.Template.Spec.Containers[0].Env {
+		if ev.Name == attemptEnvName && ev.Value == "2" {
+			foundEnv = true
+		}
+	}
+	if !foundEnv {
+		t.Fatalf("%s=2 env missing: %#v", attemptEnvName, job.Spec.Template.Spec.Containers[0].Env)
+	}
+
+	latest, err = dc.Resource(workGVR).Namespace("nereid").Get(ctx, "flaky", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get work: %v", err)
+	}
+	if attempt, _, _ := unstructured.NestedInt64(latest.Object, "status", "attempt"); attempt != 2 {
+		t.Fatalf("status.attempt got=%d want=2", attempt)
+	}
+	if jobName, _, _ := unstructured.NestedString(latest.Object, "status", "jobName"); jobName != "work-flaky-a2" {
+		t.Fatalf("status.jobName got=%q", jobName)
+	}
+	attempts, _, _ := unstructured.NestedSlice(latest.Object, "status", "attempts")
+	if len(attempts) != 1 {
+		t.Fatalf("status.attempts len got=%d want=1: %#v", len(attempts), attempts)
+	}
+	first := attempts[0].(map[string]interface{})
+	if first["reason"] != "DeadlineExceeded" || first["jobName"] != "work-flaky" || first["completionTime"] != "2026-02-15T12:00:00Z" {
+		t.Fatalf("attempt record mismatch: %#v", first)
+	}
+}
+
+func TestReconcileWorkGrantCapsRetries(t *testing.T) {
+	work := retryTestWork("capped", int64(3))
+	if err := unstructured.SetNestedField(work.Object, "g1", "spec", "grantRef", "name"); err != nil {
+		t.Fatalf("set grantRef: %v", err)
+	}
+	grant := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "nereid.yuiseki.net/v1alpha1",
+		"kind":       "Grant",
+		"metadata":   map[string]interface{}{"name": "g1", "namespace": "nereid"},
+		"spec":       map[string]interface{}{"maxRetries": int64(0)},
+	}}
+	failedAt := time.Date(2026, 2, 15, 12, 0, 0, 0, time.UTC)
+	dc := newFakeDynamicClient(work, grant)
+	kc := fake.NewSimpleClientset(failedJob(makeJobName("capped"), "capped", failedAt))
+	c := &Controller{
+		dynamic: dc,
+		kube:    kc,
+		cfg:     Config{JobNamespace: "nereid-work"},
+		logger:  slog.Default(),
+		nowFunc: func() time.Time { return failedAt.Add(time.Hour) },
+	}
+
+	ctx := context.Background()
+	if err := c.reconcileWork(ctx, work); err != nil {
+		t.Fatalf("reconcileWork() error = %v", err)
+	}
+	if got := workPhase(ctx, t, dc, "nereid", "capped"); got != "Failed" {
+		t.Fatalf("phase got=%q want=%q", got, "Failed")
+	}
+	if _, err := kc.BatchV1().Jobs("nereid-work").Get(ctx, "work-capped-a2", metav1.GetOptions{}); err == nil {
+		t.Fatal("grant maxRetries=0 should prevent a second attempt")
+	}
+}
+
+func retryTestWork(name string, retries int64) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "nereid.yuiseki.net/v1alpha1",
+		"kind":       "Work",
+		"metadata":   map[string]interface{}{"name": name, "namespace": "nereid"},
+		"spec": map[string]interface{}{
+			"kind":  "agent.cli.v1",
+			"title": "retry",
+			"agent": map[string]interface{}{
+				"image":  "node:22-bookworm-slim",
+				"script": "exit 1",
+			},
+			"constraints": map[string]interface{}{
+				"retries":      retries,
+				"retryBackoff": map[string]interface{}{"initialSeconds": int64(60)},
+			},
+		},
+	}}
+}
+
+func failedJob(name, workName string, failedAt time.Time) *batchv1.Job {
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "nereid-work",
+			Labels:    map[string]string{workLabelKey: workName},
+		},
+		Status: batchv1.JobStatus{
+			Failed: 1,
+			Conditions: []batchv1.JobCondition{{
+				Type:               batchv1.JobFailed,
+				Status:             corev1.ConditionTrue,
+				Reason:             "DeadlineExceeded",
+				Message:            "Job was active longer than specified deadline",
+				LastTransitionTime: metav1.NewTime(failedAt),
+			}},
+		},
+	}
+}
@@ -0,0 +1,221 @@
+package controller
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// signatureRulesKey is the ConfigMap data key holding the rule list.
+const signatureRulesKey = "rules.yaml"
+
+// defaultSignatureFiles are scanned by rules that do not list files.
+var defaultSignatureFiles = []string{
+	"agent.log",
+	"gemini-output.txt",
+	"dialogue.txt",
+	"logs/agent.log",
+	"logs/dialogue.txt",
+}
+
+// signatureRule flags a known runtime failure in agent output. Files are
+// artifact paths or path.Match patterns relative to the Work directory.
+type signatureRule struct {
+	Name     string   `json:"name"`
+	Regex    string   `json:"regex"`
+	Files    []string `json:"files,omitempty"`
+	Severity string   `json:"severity,omitempty"`
+	Message  string   `json:"message,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// defaultSignatureRules apply until a rules ConfigMap is loaded.
+var defaultSignatureRules = mustCompileSignatureRules([]signatureRule{
+	{Name: "undefined-lon", Regex: `(?i)cannot read properties of undefined \(reading 'lon'\)`, Message: "Cannot read properties of undefined (reading 'lon')"},
+	{Name: "undefined-lat", Regex: `(?i)cannot read properties of undefined \(reading 'lat'\)`, Message: "Cannot read properties of undefined (reading 'lat')"},
+	{Name: "undefined-property", Regex: `(?i)typeerror: cannot read properties of undefined`, Message: "TypeError: cannot read properties of undefined"},
+})
+
+func mustCompileSignatureRules(rules []signatureRule) []signatureRule {
+	out, err := compileSignatureRules(rules)
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
+
+func compileSignatureRules(rules []signatureRule) ([]signatureRule, error) {
+	out := make([]signatureRule, 0, len(rules))
+	seen := map[string]bool{}
+	for i, r := range rules {
+		r.Name = strings.TrimSpace(r.Name)
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule-%d", i)
+		}
+		if seen[r.Name] {
+			return nil, fmt.Errorf("rule %q: duplicate name", r.Name)
+		}
+		seen[r.Name] = true
+		if strings.TrimSpace(r.Regex) == "" {
+			return nil, fmt.Errorf("rule %q: regex is required", r.Name)
+		}
+		re, err := regexp.Compile(r.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %w", r.Name, err)
+		}
+		r.re = re
+		switch r.Severity = strings.ToLower(strings.TrimSpace(r.Severity)); r.Severity {
+		case "":
+			r.Severity = severityError
+		case severityError, severityWarning:
+		default:
+			return nil, fmt.Errorf("rule %q: severity must be %q or %q", r.Name, severityError, severityWarning)
+		}
+		for _, f := range r.Files {
+			if _, err := path.Match(f, ""); err != nil {
+				return nil, fmt.Errorf("rule %q: file pattern %q: %w", r.Name, f, err)
+			}
+		}
+		if len(r.Files) == 0 {
+			r.Files = defaultSignatureFiles
+		}
+		if strings.TrimSpace(r.Message) == "" {
+			r.Message = "log matches " + r.Name
+		}
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+// parseSignatureRulesConfigMap reads the rules from cm.Data["rules.yaml"].
+func parseSignatureRulesConfigMap(cm *corev1.ConfigMap) ([]signatureRule, error) {
+	raw, ok := cm.Data[signatureRulesKey]
+	if !ok {
+		return nil, fmt.Errorf("configmap %s/%s has no %s key", cm.Namespace, cm.Name, signatureRulesKey)
+	}
+	var rules []signatureRule
+	if err := yaml.UnmarshalStrict([]byte(raw), &rules); err != nil {
+		return nil, fmt.Errorf("configmap %s/%s: %w", cm.Namespace, cm.Name, err)
+	}
+	return compileSignatureRules(rules)
+}
+
+// setSignatureRulesFromConfigMap installs the ConfigMap's rules. A nil
+// ConfigMap restores the defaults; an invalid one keeps the current rules.
+func (c *Controller) setSignatureRulesFromConfigMap(cm *corev1.ConfigMap) {
+	if cm == nil {
+		c.signatureMu.Lock()
+		c.signatureRules = nil
+		c.signatureMu.Unlock()
+		c.logger.Info("signature rules configmap removed, using built-in rules")
+		return
+	}
+	rules, err := parseSignatureRulesConfigMap(cm)
+	if err != nil {
+		c.logger.Error("invalid signature rules, keeping previous rules", "configmap", cm.Namespace+"/"+cm.Name, "error", err)
+		return
+	}
+	c.signatureMu.Lock()
+	c.signatureRules = rules
+	c.signatureMu.Unlock()
+	c.logger.Info("signature rules loaded", "configmap", cm.Namespace+"/"+cm.Name, "rules", len(rules))
+}
+
+func (c *Controller) currentSignatureRules() []signatureRule {
+	c.signatureMu.RLock()
+	defer c.signatureMu.RUnlock()
+	if c.signatureRules == nil {
+		return defaultSignatureRules
+	}
+	return c.signatureRules
+}
+
+// signatureMatch is the first match of a rule in one artifact file.
+type signatureMatch struct {
+	rule signatureRule
+	file string
+	line int
+	text string
+}
+
+// matchSignatureRules returns the first match of every rule, in rule order.
+// Each file is scanned once for all rules that include it.
+func matchSignatureRules(ctx context.Context, a *workArtifacts, rules []signatureRule) ([]signatureMatch, error) {
+	files, err := a.files(ctx)
+	if err != nil {
+		return nil, err
+	}
+	targets := map[string][]int{}
+	var order []string
+	for i, r := range rules {
+		for _, f := range files {
+			if !signatureRuleCovers(r, f.name) {
+				continue
+			}
+			if _, ok := targets[f.name]; !ok {
+				order = append(order, f.name)
+			}
+			targets[f.name] = append(targets[f.name], i)
+		}
+	}
+
+	found := make([]*signatureMatch, len(rules))
+	for _, name := range order {
+		if err := scanSignatureFile(ctx, a, name, rules, targets[name], found); err != nil {
+			return nil, err
+		}
+	}
+	var out []signatureMatch
+	for _, m := range found {
+		if m != nil {
+			out = append(out, *m)
+		}
+	}
+	return out, nil
+}
+
+func signatureRuleCovers(r signatureRule, name string) bool {
+	for _, pattern := range r.Files {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func scanSignatureFile(ctx context.Context, a *workArtifacts, name string, rules []signatureRule, ruleIdx []int, found []*signatureMatch) error {
+	rc, err := a.store.open(ctx, a.workName, name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("open %q of %q: %w", name, a.workName, err)
+	}
+	defer rc.Close()
+
+	scanner := bufio.NewScanner(rc)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		for _, i := range ruleIdx {
+			if found[i] == nil && rules[i].re.MatchString(text) {
+				found[i] = &signatureMatch{rule: rules[i], file: name, line: line, text: strings.TrimSpace(text)}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil && !errors.Is(err, bufio.ErrTooLong) {
+		return fmt.Errorf("read %q of %q: %w", name, a.workName, err)
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package controller
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yuiseki/NEREID/internal/runner"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func signatureRulesConfigMap(rules string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "nereid-signature-rules", Namespace: "nereid"},
+		Data:       map[string]string{signatureRulesKey: rules},
+	}
+}
+
+func TestSignatureRulesFromConfigMap(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"index.html":        "<html>ok</html>",
+		"logs/agent.log":    "fetching\nnpm ERR! code E404\nHTTP 429 Too Many Requests from overpass\n",
+		"gemini-output.txt": "TypeError: Cannot read properties of undefined (reading 'lon')",
+	}
+	for name, body := range files {
+		p := filepath.Join(root, "w", filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(p, []byte(body), 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	c := &Controller{cfg: Config{ArtifactsHostPath: root}, logger: slog.Default()}
+	ctx := context.Background()
+	validate := func() artifactValidation {
+		t.Helper()
+		v, err := c.validateSucceededWorkArtifacts(ctx, artifactTestWork("w", "agent.cli.v1"))
+		if err != nil {
+			t.Fatalf("validateSucceededWorkArtifacts() error = %v", err)
+		}
+		return v
+	}
+
+	// Built-in rules only cover the TypeError signatures.
+	if f := validate().failure(); f == nil || f.Rule != "undefined-lon" || f.Path != "gemini-output.txt" || f.Line != 1 {
+		t.Fatalf("default rules failure = %+v", f)
+	}
+
+	c.setSignatureRulesFromConfigMap(signatureRulesConfigMap(`
+- name: npx-install-failed
+  regex: 'npm (ERR!|error) code E[A-Z0-9]+'
+  severity: warning
+  message: npx failed to install a package
+- name: overpass-rate-limited
+  regex: '(?i)429 too many requests'
+  files: ["logs/*.log"]
+  message: Overpass API rejected the query with 429 Too Many Requests
+`))
+	v := validate()
+	f := v.failure()
+	if f == nil || f.Rule != "overpass-rate-limited" || f.Path != "logs/agent.log" || f.Line != 3 {
+		t.Fatalf("configmap rules failure = %+v (findings %+v)", f, v.findings)
+	}
+	if want := "artifact runtime validation failed: Overpass API rejected the query with 429 Too Many Requests (rule overpass-rate-limited at logs/agent.log:3)"; v.message() != want {
+		t.Fatalf("message got=%q want=%q", v.message(), want)
+	}
+	if w := v.findings[0]; w.Rule != "npx-install-failed" || w.Severity != severityWarning || w.Line != 2 {
+		t.Fatalf("warning finding = %+v", w)
+	}
+
+	// An invalid edit keeps the loaded rules.
+	for _, bad := range []string{"- name: broken\n  regex: '('\n", "- name: x\n  regex: a\n  severity: fatal\n", "- name: x\n  pattern: a\n"} {
+		c.setSignatureRulesFromConfigMap(signatureRulesConfigMap(bad))
+		if got := c.currentSignatureRules(); len(got) != 2 || got[1].Name != "overpass-rate-limited" {
+			t.Fatalf("rules after invalid %q = %+v", bad, got)
+		}
+	}
+
+	// Deleting the ConfigMap restores the built-in rules.
+	c.setSignatureRulesFromConfigMap(nil)
+	if f := validate().failure(); f == nil || !strings.Contains(f.Message, "reading 'lon'") {
+		t.Fatalf("failure after delete = %+v", f)
+	}
+}
+
+func TestOverpassRetryLogPassesSignatureRules(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			http.Error(w, "429 Too Many Requests: rate_limited", http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`{"elements":[{"type":"node","id":1,"lat":35.6,"lon":139.7,"tags":{"name":"cafe"}}]}`))
+	}))
+	defer srv.Close()
+
+	root := t.TempDir()
+	out := filepath.Join(root, "w")
+	if err := os.MkdirAll(out, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	// The Job script sends the runner's stdout to agent.log.
+	agentLog, err := os.Create(filepath.Join(out, "agent.log"))
+	if err != nil {
+		t.Fatalf("create agent.log: %v", err)
+	}
+	defer agentLog.Close()
+	r := &runner.Runner{OutDir: out, Backoff: time.Millisecond, Logger: slog.New(slog.NewTextHandler(agentLog, nil))}
+	spec := &runner.Spec{
+		Kind:     "overpassql.map.v1",
+		Title:    "cafes",
+		Overpass: &runner.OverpassSpec{Endpoint: srv.URL, Query: "[out:json];node(35,139,36,140);out;"},
+	}
+	if err := r.Run(context.Background(), spec); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if calls != 2 {
+		t.Fatalf("overpass calls = %d, want 2", calls)
+	}
+	logged, err := os.ReadFile(agentLog.Name())
+	if err != nil {
+		t.Fatalf("read agent.log: %v", err)
+	}
+	if !strings.Contains(string(logged), "status=429") {
+		t.Fatalf("agent.log does not record the retry:\n%s", logged)
+	}
+
+	// The overpass rules shipped in charts/nereid/values.yaml.
+	c := &Controller{cfg: Config{ArtifactsHostPath: root}, logger: slog.Default()}
+	c.setSignatureRulesFromConfigMap(signatureRulesConfigMap(`
+- name: overpass-rate-limited
+  regex: "(?i)(429 Too Many Requests|rate_limited)"
+  files: ["*.log", "*.txt", "logs/*"]
+  message: "Overpass API rejected the query with 429 Too Many Requests"
+- name: overpass-timeout
+  regex: "(?i)(504 Gateway Time-?out|runtime error: Query timed out)"
+  files: ["*.log", "*.txt", "logs/*"]
+  message: "Overpass API timed out (504)"
+`))
+	v, err := c.validateSucceededWorkArtifacts(context.Background(), artifactTestWork("w", "overpassql.map.v1"))
+	if err != nil {
+		t.Fatalf("validateSucceededWorkArtifacts() error = %v", err)
+	}
+	if f := v.failure(); f != nil || len(v.findings) != 0 {
+		t.Fatalf("a recovered retry must not match signature rules: failure=%+v findings=%+v", f, v.findings)
+	}
+}
@@ -0,0 +1,318 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	conditionAdmitted           = "Admitted"
+	conditionRunning            = "Running"
+	conditionArtifactsValidated = "ArtifactsValidated"
+	conditionSucceeded          = "Succeeded"
+	conditionFailed             = "Failed"
+)
+
+const (
+	reasonInvalidSpec         = "InvalidSpec"
+	reasonGrantNotFound       = "GrantNotFound"
+	reasonGrantRejected       = "GrantRejected"
+	reasonJobCreateFailed     = "JobCreateFailed"
+	reasonJobCreated          = "JobCreated"
+	reasonJobSubmitted        = "JobSubmitted"
+	reasonWaitingForAdmission = "WaitingForAdmission"
+	reasonJobRunning          = "JobRunning"
+	reasonJobSucceeded        = "JobSucceeded"
+	reasonJobFailed           = "JobFailed"
+	reasonValidated           = "Validated"
+	reasonValidationFailed    = "ValidationFailed"
+	reasonCanceled            = "Canceled"
+	reasonRetryScheduled      = "RetryScheduled"
+	reasonGrantLimited        = "GrantLimited"
+)
+
+// workStatus is the controller's desired view of Work.status. Fields left
+// empty are removed from the stored status, except timestamps which are kept
+// once recorded.
+type workStatus struct {
+	phase    string
+	reason   string
+	message  string
+	artifact string
+	job      *batchv1.Job
+
+	// validated is nil until artifact validation ran for a succeeded Job.
+	validated *bool
+	// validation holds the validator findings summarized in status.validation.
+	validation *artifactValidation
+	// queue, when set, replaces status.queue.
+	queue *queueStatus
+
+	// attempt is the attempt the Job belongs to; zero leaves status.attempt
+	// untouched. ended is set once that attempt's Job has finished.
+	attempt int
+	ended   *attemptResult
+
+	// grantReservation names the Grant whose use this Work consumed. It is
+	// kept once recorded.
+	grantReservation string
+}
+
+func (c *Controller) updateWorkStatus(ctx context.Context, work *unstructured.Unstructured, st workStatus) error {
+	// Start from the cached copy and only re-read the Work after a conflict.
+	latest := work.DeepCopy()
+	var stored, replaced map[string]interface{}
+	err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		if latest == nil {
+			obj, err := c.dynamic.Resource(workGVR).Namespace(work.GetNamespace()).Get(ctx, work.GetName(), metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			latest = obj
+		}
+
+		current, _, err := unstructured.NestedMap(latest.Object, "status")
+		if err != nil {
+			return fmt.Errorf("read work status: %w", err)
+		}
+		desired, err := c.desiredWorkStatus(latest, current, st)
+		if err != nil {
+			return err
+		}
+		if equality.Semantic.DeepEqual(current, desired) {
+			return nil
+		}
+
+		updated := latest.DeepCopy()
+		if err := unstructured.SetNestedMap(updated.Object, desired, "status"); err != nil {
+			return err
+		}
+		_, err = c.dynamic.Resource(workGVR).Namespace(work.GetNamespace()).UpdateStatus(ctx, updated, metav1.UpdateOptions{})
+		if apierrors.IsConflict(err) {
+			latest = nil
+		}
+		if err == nil {
+			replaced, stored = current, desired
+		}
+		return err
+	})
+	if err == nil && stored != nil {
+		c.metrics.observeWorkStatus(work, st, replaced, stored)
+		c.recordWorkTransition(work, st, replaced, stored)
+	}
+	return err
+}
+
+func (c *Controller) desiredWorkStatus(work *unstructured.Unstructured, current map[string]interface{}, st workStatus) (map[string]interface{}, error) {
+	now := c.nowFunc()
+	out := runtime.DeepCopyJSON(current)
+	if out == nil {
+		out = map[string]interface{}{}
+	}
+
+	out["phase"] = st.phase
+	setOrDeleteString(out, "reason", st.reason)
+	setOrDeleteString(out, "message", st.message)
+	setOrDeleteString(out, "artifactUrl", st.artifact)
+	out["observedGeneration"] = work.GetGeneration()
+	if st.attempt > 0 {
+		out["attempt"] = int64(st.attempt)
+	}
+	if st.ended != nil {
+		upsertAttempt(out, st.ended)
+	}
+	if st.grantReservation != "" {
+		out["grantReservation"] = st.grantReservation
+	}
+	if st.validation != nil {
+		out["validation"] = st.validation.status()
+	}
+	if st.queue != nil {
+		out["queue"] = st.queue.status()
+	}
+
+	if st.job != nil {
+		out["jobName"] = st.job.Name
+		if st.job.Status.StartTime != nil {
+			if _, ok := out["startTime"]; !ok {
+				out["startTime"] = formatStatusTime(st.job.Status.StartTime.Time)
+			}
+		}
+	}
+	if isTerminalWorkPhase(st.phase) {
+		if _, ok := out["completionTime"]; !ok {
+			completed := now
+			if st.job != nil && st.job.Status.CompletionTime != nil {
+				completed = st.job.Status.CompletionTime.Time
+			}
+			out["completionTime"] = formatStatusTime(completed)
+		}
+	}
+
+	if startTime, ok := parseStatusTime(out["startTime"]); ok {
+		queued := startTime.Sub(work.GetCreationTimestamp().Time)
+		if queued < 0 {
+			queued = 0
+		}
+		out["queuedDuration"] = formatStatusDuration(queued)
+		if completionTime, ok := parseStatusTime(out["completionTime"]); ok {
+			out["runDuration"] = formatStatusDuration(completionTime.Sub(startTime))
+		}
+	}
+
+	conditions, err := statusConditions(out)
+	if err != nil {
+		return nil, err
+	}
+	for _, cond := range workConditions(st) {
+		cond.ObservedGeneration = work.GetGeneration()
+		cond.LastTransitionTime = metav1.NewTime(now)
+		meta.SetStatusCondition(&conditions, cond)
+	}
+	encoded := make([]interface{}, 0, len(conditions))
+	for i := range conditions {
+		m, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&conditions[i])
+		if err != nil {
+			return nil, fmt.Errorf("encode condition %q: %w", conditions[i].Type, err)
+		}
+		encoded = append(encoded, m)
+	}
+	if len(encoded) > 0 {
+		out["conditions"] = encoded
+	}
+	return out, nil
+}
+
+// workConditions derives the condition set implied by a status update.
+// Conditions that the update says nothing about keep their previous value.
+func workConditions(st workStatus) []metav1.Condition {
+	var out []metav1.Condition
+
+	if st.job == nil {
+		switch st.phase {
+		case "Error", "Failed":
+			out = append(out,
+				metav1.Condition{Type: conditionAdmitted, Status: metav1.ConditionFalse, Reason: st.reason, Message: st.message},
+				metav1.Condition{Type: conditionFailed, Status: metav1.ConditionTrue, Reason: st.reason, Message: st.message},
+			)
+		case "Canceled":
+			out = append(out, metav1.Condition{Type: conditionRunning, Status: metav1.ConditionFalse, Reason: st.reason, Message: st.message})
+		case "Pending":
+			out = append(out, metav1.Condition{Type: conditionAdmitted, Status: metav1.ConditionFalse, Reason: st.reason, Message: st.message})
+		}
+		return out
+	}
+
+	if st.job.Spec.Suspend != nil && *st.job.Spec.Suspend {
+		out = append(out, metav1.Condition{Type: conditionAdmitted, Status: metav1.ConditionFalse, Reason: reasonWaitingForAdmission, Message: st.message})
+	} else {
+		out = append(out, metav1.Condition{Type: conditionAdmitted, Status: metav1.ConditionTrue, Reason: "Admitted", Message: "job admitted by kueue"})
+	}
+
+	running := metav1.ConditionFalse
+	if st.phase == "Running" {
+		running = metav1.ConditionTrue
+	}
+	out = append(out, metav1.Condition{Type: conditionRunning, Status: running, Reason: st.reason, Message: st.message})
+
+	if st.validated != nil {
+		if *st.validated {
+			out = append(out, metav1.Condition{Type: conditionArtifactsValidated, Status: metav1.ConditionTrue, Reason: reasonValidated, Message: "artifacts passed validation"})
+		} else {
+			reason := reasonValidationFailed
+			if st.validation != nil && st.validation.failure() != nil {
+				reason = st.validation.failure().Reason
+			}
+			out = append(out, metav1.Condition{Type: conditionArtifactsValidated, Status: metav1.ConditionFalse, Reason: reason, Message: st.message})
+		}
+	}
+
+	switch st.phase {
+	case "Succeeded":
+		out = append(out, metav1.Condition{Type: conditionSucceeded, Status: metav1.ConditionTrue, Reason: st.reason, Message: st.message})
+	case "Failed", "Error":
+		out = append(out, metav1.Condition{Type: conditionFailed, Status: metav1.ConditionTrue, Reason: st.reason, Message: st.message})
+	}
+	return out
+}
+
+func statusConditions(status map[string]interface{}) ([]metav1.Condition, error) {
+	raw, ok := status["conditions"].([]interface{})
+	if !ok {
+		return nil, nil
+	}
+	out := make([]metav1.Condition, 0, len(raw))
+	for i, item := range raw {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("status.conditions[%d] must be an object", i)
+		}
+		var cond metav1.Condition
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(m, &cond); err != nil {
+			return nil, fmt.Errorf("decode status.conditions[%d]: %w", i, err)
+		}
+		out = append(out, cond)
+	}
+	return out, nil
+}
+
+func phaseFromJob(job *batchv1.Job) (phase, reason, message string) {
+	if job.Status.Succeeded > 0 {
+		return "Succeeded", reasonJobSucceeded, "job completed"
+	}
+	if job.Status.Failed > 0 {
+		return "Failed", reasonJobFailed, "job failed"
+	}
+	if job.Spec.Suspend != nil && *job.Spec.Suspend {
+		return "Queued", reasonWaitingForAdmission, "waiting for kueue admission"
+	}
+	if job.Status.Active > 0 {
+		return "Running", reasonJobRunning, "job is running"
+	}
+	return "Submitted", reasonJobSubmitted, "job submitted"
+}
+
+func setOrDeleteString(m map[string]interface{}, key, value string) {
+	if value == "" {
+		delete(m, key)
+		return
+	}
+	m[key] = value
+}
+
+func formatStatusTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+func parseStatusTime(v interface{}) (time.Time, bool) {
+	s, ok := v.(string)
+	if !ok || s == "" {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+func formatStatusDuration(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+	return d.Round(time.Second).String()
+}
+
+func boolPtr(v bool) *bool {
+	return &v
+}
@@ -0,0 +1,123 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestDesiredWorkStatusRecordsTimesAndConditions(t *testing.T) {
+	created := time.Date(2026, 2, 15, 12, 0, 0, 0, time.UTC)
+	started := created.Add(90 * time.Second)
+	completed := started.Add(5 * time.Minute)
+	now := completed.Add(time.Second)
+
+	work := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name":              "timed-work",
+			"namespace":         "nereid",
+			"generation":        int64(3),
+			"creationTimestamp": created.Format(time.RFC3339),
+		},
+	}}
+	suspend := false
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: "work-timed-work"},
+		Spec:       batchv1.JobSpec{Suspend: &suspend},
+		Status: batchv1.JobStatus{
+			Succeeded:      1,
+			StartTime:      &metav1.Time{Time: started},
+			CompletionTime: &metav1.Time{Time: completed},
+		},
+	}
+
+	c := &Controller{nowFunc: func() time.Time { return now }}
+	st := workStatus{job: job, artifact: "https://artifacts.example/timed-work/", validated: boolPtr(true)}
+	st.phase, st.reason, st.message = phaseFromJob(job)
+
+	status, err := c.desiredWorkStatus(work, nil, st)
+	if err != nil {
+		t.Fatalf("desiredWorkStatus() error = %v", err)
+	}
+
+	for field, want := range map[string]interface{}{
+		"phase":              "Succeeded",
+		"reason":             reasonJobSucceeded,
+		"jobName":            "work-timed-work",
+		"observedGeneration": int64(3),
+		"startTime":          "2026-02-15T12:01:30Z",
+		"completionTime":     "2026-02-15T12:06:30Z",
+		"queuedDuration":     "1m30s",
+		"runDuration":        "5m0s",
+	} {
+		if got := status[field]; got != want {
+			t.Fatalf("status.%s got=%v want=%v", field, got, want)
+		}
+	}
+
+	conditions, err := statusConditions(status)
+	if err != nil {
+		t.Fatalf("statusConditions() error = %v", err)
+	}
+	for condType, want := range map[string]metav1.ConditionStatus{
+		conditionAdmitted:           metav1.ConditionTrue,
+		conditionRunning:            metav1.ConditionFalse,
+		conditionArtifactsValidated: metav1.ConditionTrue,
+		conditionSucceeded:          metav1.ConditionTrue,
+	} {
+		cond := meta.FindStatusCondition(conditions, condType)
+		if cond == nil {
+			t.Fatalf("condition %s missing: %+v", condType, conditions)
+		}
+		if cond.Status != want {
+			t.Fatalf("condition %s status got=%s want=%s", condType, cond.Status, want)
+		}
+		if cond.ObservedGeneration != 3 {
+			t.Fatalf("condition %s observedGeneration got=%d want=3", condType, cond.ObservedGeneration)
+		}
+	}
+	if meta.FindStatusCondition(conditions, conditionFailed) != nil {
+		t.Fatal("Failed condition should not be set for a succeeded work")
+	}
+}
+
+func TestDesiredWorkStatusKeepsTransitionTimeWhenConditionUnchanged(t *testing.T) {
+	first := time.Date(2026, 2, 15, 12, 0, 0, 0, time.UTC)
+	work := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "queued-work", "namespace": "nereid"},
+	}}
+	suspend := true
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "work-queued-work"}, Spec: batchv1.JobSpec{Suspend: &suspend}}
+	st := workStatus{job: job}
+	st.phase, st.reason, st.message = phaseFromJob(job)
+
+	c := &Controller{nowFunc: func() time.Time { return first }}
+	initial, err := c.desiredWorkStatus(work, nil, st)
+	if err != nil {
+		t.Fatalf("desiredWorkStatus(initial) error = %v", err)
+	}
+
+	c.nowFunc = func() time.Time { return first.Add(time.Minute) }
+	again, err := c.desiredWorkStatus(work, initial, st)
+	if err != nil {
+		t.Fatalf("desiredWorkStatus(again) error = %v", err)
+	}
+	conditions, err := statusConditions(again)
+	if err != nil {
+		t.Fatalf("statusConditions() error = %v", err)
+	}
+	admitted := meta.FindStatusCondition(conditions, conditionAdmitted)
+	if admitted == nil || admitted.Status != metav1.ConditionFalse || admitted.Reason != reasonWaitingForAdmission {
+		t.Fatalf("Admitted condition mismatch: %+v", admitted)
+	}
+	if !admitted.LastTransitionTime.Time.Equal(first) {
+		t.Fatalf("Admitted lastTransitionTime changed got=%s want=%s", admitted.LastTransitionTime.Time, first)
+	}
+	if _, ok := again["startTime"]; ok {
+		t.Fatalf("startTime should not be set before admission: %v", again["startTime"])
+	}
+}
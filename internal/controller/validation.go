@@ -0,0 +1,600 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/yuiseki/NEREID/internal/mapstyle"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	severityError   = "error"
+	severityWarning = "warning"
+)
+
+// Reasons reported by artifact validators. A failing Work uses the reason of
+// its first error finding as status.reason.
+const (
+	reasonIndexMissing          = "IndexMissing"
+	reasonIndexEmpty            = "IndexEmpty"
+	reasonRuntimeErrorSignature = "RuntimeErrorSignature"
+	reasonGeoJSONMissing        = "GeoJSONMissing"
+	reasonGeoJSONInvalid        = "GeoJSONInvalid"
+	reasonGeoJSONEmpty          = "GeoJSONEmpty"
+	reasonStyleMissing          = "StyleMissing"
+	reasonStyleInvalid          = "StyleInvalid"
+	reasonTilesetMissing        = "TilesetMissing"
+	reasonTilesetInvalid        = "TilesetInvalid"
+	reasonTilePyramidMissing    = "TilePyramidMissing"
+	reasonTilePyramidIncomplete = "TilePyramidIncomplete"
+	reasonArtifactTooLarge      = "ArtifactTooLarge"
+)
+
+// maxValidatedFileBytes bounds how much of a single artifact a validator
+// reads; larger files are reported as a warning and skipped.
+const maxValidatedFileBytes = 64 << 20
+
+// validationFinding is one result of an artifact validator, written to
+// status.validation.findings.
+type validationFinding struct {
+	Validator string `json:"validator"`
+	Severity  string `json:"severity"`
+	Reason    string `json:"reason"`
+	Rule      string `json:"rule,omitempty"`
+	Path      string `json:"path,omitempty"`
+	Line      int    `json:"line,omitempty"`
+	Message   string `json:"message"`
+}
+
+type artifactValidation struct {
+	findings []validationFinding
+}
+
+// failure returns the first error finding, or nil when validation passed.
+func (v artifactValidation) failure() *validationFinding {
+	for i := range v.findings {
+		if v.findings[i].Severity == severityError {
+			return &v.findings[i]
+		}
+	}
+	return nil
+}
+
+// message is the Work status message for a failed validation.
+func (v artifactValidation) message() string {
+	f := v.failure()
+	if f == nil {
+		return ""
+	}
+	if f.Reason == reasonRuntimeErrorSignature {
+		return "artifact runtime validation failed: " + f.Message
+	}
+	return "artifact validation failed: " + f.Message
+}
+
+func (v artifactValidation) status() map[string]interface{} {
+	findings := make([]interface{}, 0, len(v.findings))
+	for _, f := range v.findings {
+		m := map[string]interface{}{
+			"validator": f.Validator,
+			"severity":  f.Severity,
+			"reason":    f.Reason,
+			"message":   f.Message,
+		}
+		if f.Rule != "" {
+			m["rule"] = f.Rule
+		}
+		if f.Path != "" {
+			m["path"] = f.Path
+		}
+		if f.Line > 0 {
+			m["line"] = int64(f.Line)
+		}
+		findings = append(findings, m)
+	}
+	return map[string]interface{}{
+		"passed":   v.failure() == nil,
+		"findings": findings,
+	}
+}
+
+// artifactValidator checks the artifacts of a succeeded Work.
+type artifactValidator interface {
+	name() string
+	validate(ctx context.Context, a *workArtifacts) ([]validationFinding, error)
+}
+
+// commonArtifactValidators run for every kind; kindArtifactValidators are
+// added by spec.kind.
+var (
+	commonArtifactValidators = []artifactValidator{indexValidator{}, runtimeSignatureValidator{}}
+	kindArtifactValidators   = map[string][]artifactValidator{
+		"overpassql.map.v1":  {geoJSONValidator{}},
+		"duckdb.map.v1":      {geoJSONValidator{}},
+		"maplibre.style.v1":  {mapLibreStyleValidator{}},
+		"laz.3dtiles.v1":     {tilesetValidator{}},
+		"gdal.rastertile.v1": {tilePyramidValidator{}},
+	}
+)
+
+func artifactValidatorsFor(kind string) []artifactValidator {
+	out := append([]artifactValidator{}, commonArtifactValidators...)
+	return append(out, kindArtifactValidators[strings.TrimSpace(kind)]...)
+}
+
+// workArtifacts gives validators read access to one Work's artifacts.
+type workArtifacts struct {
+	store      artifactStore
+	workName   string
+	work       *unstructured.Unstructured
+	signatures []signatureRule
+	listed     []artifactFile
+	listErr    error
+	didList    bool
+}
+
+func (a *workArtifacts) read(ctx context.Context, name string) ([]byte, error) {
+	return a.store.readFile(ctx, a.workName, name)
+}
+
+// files lists the Work's files, skipping hidden directories and
+// node_modules that agents leave behind in the workspace.
+func (a *workArtifacts) files(ctx context.Context) ([]artifactFile, error) {
+	if !a.didList {
+		a.didList = true
+		all, err := a.store.files(ctx, a.workName)
+		a.listErr = err
+		for _, f := range all {
+			if !isWorkspaceNoise(f.name) {
+				a.listed = append(a.listed, f)
+			}
+		}
+		sort.Slice(a.listed, func(i, j int) bool { return a.listed[i].name < a.listed[j].name })
+	}
+	return a.listed, a.listErr
+}
+
+func isWorkspaceNoise(name string) bool {
+	for _, part := range strings.Split(path.Dir(name), "/") {
+		if part == "node_modules" || (strings.HasPrefix(part, ".") && part != ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// readJSON decodes a bounded artifact. It returns a finding instead of an
+// error for content problems.
+func (a *workArtifacts) readJSON(ctx context.Context, v artifactValidator, f artifactFile, reason string, out interface{}) (*validationFinding, error) {
+	if f.size > maxValidatedFileBytes {
+		return &validationFinding{Validator: v.name(), Severity: severityWarning, Reason: reasonArtifactTooLarge, Path: f.name,
+			Message: fmt.Sprintf("%s is larger than %d MiB and was not validated", f.name, maxValidatedFileBytes>>20)}, nil
+	}
+	b, err := a.read(ctx, f.name)
+	if err != nil {
+		return nil, fmt.Errorf("read %q of %q: %w", f.name, a.workName, err)
+	}
+	if err := json.Unmarshal(b, out); err != nil {
+		return &validationFinding{Validator: v.name(), Severity: severityError, Reason: reason, Path: f.name,
+			Message: fmt.Sprintf("%s is not valid JSON: %v", f.name, err)}, nil
+	}
+	return nil, nil
+}
+
+func (c *Controller) validateSucceededWorkArtifacts(ctx context.Context, work *unstructured.Unstructured) (artifactValidation, error) {
+	var out artifactValidation
+	store := c.artifacts()
+	if local, ok := store.(*localArtifactStore); ok && local.root == "" {
+		return out, nil
+	}
+	kind, _, _ := unstructured.NestedString(work.Object, "spec", "kind")
+	a := &workArtifacts{store: store, workName: work.GetName(), work: work, signatures: c.currentSignatureRules()}
+	for _, v := range artifactValidatorsFor(kind) {
+		findings, err := v.validate(ctx, a)
+		if err != nil {
+			return out, fmt.Errorf("%s validator: %w", v.name(), err)
+		}
+		out.findings = append(out.findings, findings...)
+	}
+	return out, nil
+}
+
+type indexValidator struct{}
+
+func (indexValidator) name() string { return "index" }
+
+func (v indexValidator) validate(ctx context.Context, a *workArtifacts) ([]validationFinding, error) {
+	index, err := a.read(ctx, "index.html")
+	if errors.Is(err, fs.ErrNotExist) {
+		return []validationFinding{{Validator: v.name(), Severity: severityError, Reason: reasonIndexMissing, Path: "index.html", Message: "index.html not found"}}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read index.html of %q: %w", a.workName, err)
+	}
+	if len(index) == 0 {
+		return []validationFinding{{Validator: v.name(), Severity: severityError, Reason: reasonIndexEmpty, Path: "index.html", Message: "index.html is empty"}}, nil
+	}
+	return nil, nil
+}
+
+// runtimeSignatureValidator reports the signature rules that match agent
+// output, with the file and line of the first match of each rule.
+type runtimeSignatureValidator struct{}
+
+func (runtimeSignatureValidator) name() string { return "runtimeSignature" }
+
+func (v runtimeSignatureValidator) validate(ctx context.Context, a *workArtifacts) ([]validationFinding, error) {
+	matches, err := matchSignatureRules(ctx, a, a.signatures)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]validationFinding, 0, len(matches))
+	for _, m := range matches {
+		out = append(out, validationFinding{
+			Validator: v.name(),
+			Severity:  m.rule.Severity,
+			Reason:    reasonRuntimeErrorSignature,
+			Rule:      m.rule.Name,
+			Path:      m.file,
+			Line:      m.line,
+			Message:   fmt.Sprintf("%s (rule %s at %s:%d)", m.rule.Message, m.rule.Name, m.file, m.line),
+		})
+	}
+	return out, nil
+}
+
+var geoJSONGeometryTypes = map[string]bool{
+	"Point": true, "MultiPoint": true, "LineString": true, "MultiLineString": true,
+	"Polygon": true, "MultiPolygon": true, "GeometryCollection": true,
+}
+
+// geoJSONValidator requires every *.geojson artifact to be a valid Feature or
+// non-empty FeatureCollection.
+type geoJSONValidator struct{}
+
+func (geoJSONValidator) name() string { return "geojson" }
+
+func (v geoJSONValidator) validate(ctx context.Context, a *workArtifacts) ([]validationFinding, error) {
+	files, err := a.files(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var out []validationFinding
+	seen := 0
+	for _, f := range files {
+		if !strings.EqualFold(path.Ext(f.name), ".geojson") {
+			continue
+		}
+		seen++
+		var doc struct {
+			Type     string            `json:"type"`
+			Features []json.RawMessage `json:"features"`
+			Geometry json.RawMessage   `json:"geometry"`
+		}
+		finding, err := a.readJSON(ctx, v, f, reasonGeoJSONInvalid, &doc)
+		if err != nil {
+			return nil, err
+		}
+		if finding != nil {
+			out = append(out, *finding)
+			continue
+		}
+		invalid := func(format string, args ...interface{}) {
+			out = append(out, validationFinding{Validator: v.name(), Severity: severityError, Reason: reasonGeoJSONInvalid, Path: f.name,
+				Message: f.name + ": " + fmt.Sprintf(format, args...)})
+		}
+		switch doc.Type {
+		case "FeatureCollection":
+			if len(doc.Features) == 0 {
+				out = append(out, validationFinding{Validator: v.name(), Severity: severityError, Reason: reasonGeoJSONEmpty, Path: f.name,
+					Message: f.name + " has no features"})
+				continue
+			}
+			for i, raw := range doc.Features {
+				var feature struct {
+					Type     string          `json:"type"`
+					Geometry json.RawMessage `json:"geometry"`
+				}
+				if err := json.Unmarshal(raw, &feature); err != nil || feature.Type != "Feature" {
+					invalid("features[%d] is not a Feature", i)
+					break
+				}
+				if msg := geoJSONGeometryProblem(feature.Geometry); msg != "" {
+					invalid("features[%d]: %s", i, msg)
+					break
+				}
+			}
+		case "Feature":
+			if msg := geoJSONGeometryProblem(doc.Geometry); msg != "" {
+				invalid("%s", msg)
+			}
+		default:
+			invalid("type %q is not a Feature or FeatureCollection", doc.Type)
+		}
+	}
+	if seen == 0 {
+		out = append(out, validationFinding{Validator: v.name(), Severity: severityWarning, Reason: reasonGeoJSONMissing,
+			Message: "no .geojson artifact found"})
+	}
+	return out, nil
+}
+
+// geoJSONGeometryProblem describes what is wrong with a geometry member, or
+// returns "" when it is null or well-formed.
+func geoJSONGeometryProblem(raw json.RawMessage) string {
+	if len(raw) == 0 || string(raw) == "null" {
+		return ""
+	}
+	var g struct {
+		Type        string            `json:"type"`
+		Coordinates json.RawMessage   `json:"coordinates"`
+		Geometries  []json.RawMessage `json:"geometries"`
+	}
+	if err := json.Unmarshal(raw, &g); err != nil {
+		return "geometry is not an object"
+	}
+	if !geoJSONGeometryTypes[g.Type] {
+		return fmt.Sprintf("unknown geometry type %q", g.Type)
+	}
+	if g.Type == "GeometryCollection" {
+		for _, member := range g.Geometries {
+			if msg := geoJSONGeometryProblem(member); msg != "" {
+				return msg
+			}
+		}
+		return ""
+	}
+	var coords []interface{}
+	if err := json.Unmarshal(g.Coordinates, &coords); err != nil {
+		return g.Type + " coordinates must be an array"
+	}
+	if len(coords) == 0 {
+		return g.Type + " has no coordinates"
+	}
+	return ""
+}
+
+// mapLibreStyleValidator checks the structure of style.json artifacts.
+type mapLibreStyleValidator struct{}
+
+func (mapLibreStyleValidator) name() string { return "maplibreStyle" }
+
+func (v mapLibreStyleValidator) validate(ctx context.Context, a *workArtifacts) ([]validationFinding, error) {
+	files, err := a.files(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var out []validationFinding
+	seen := 0
+	for _, f := range files {
+		base := path.Base(f.name)
+		if base != "style.json" && !strings.HasSuffix(base, ".style.json") {
+			continue
+		}
+		seen++
+		var style json.RawMessage
+		finding, err := a.readJSON(ctx, v, f, reasonStyleInvalid, &style)
+		if err != nil {
+			return nil, err
+		}
+		if finding != nil {
+			out = append(out, *finding)
+			continue
+		}
+		problems := mapstyle.Validate(style)
+		if len(problems) > 0 {
+			out = append(out, validationFinding{Validator: v.name(), Severity: severityError, Reason: reasonStyleInvalid, Path: f.name,
+				Message: f.name + ": " + strings.Join(problems, "; ")})
+		}
+	}
+	if seen == 0 {
+		out = append(out, validationFinding{Validator: v.name(), Severity: severityWarning, Reason: reasonStyleMissing,
+			Message: "no style.json artifact found"})
+	}
+	return out, nil
+}
+
+// validateWorkStyle checks the inline source style of a maplibre.style.v1
+// Work that sets spec.style.validate, so a broken style fails the Work
+// before a Job is created.
+func validateWorkStyle(work *unstructured.Unstructured, kind string) error {
+	if kind != "maplibre.style.v1" {
+		return nil
+	}
+	validate, _, _ := unstructured.NestedBool(work.Object, "spec", "style", "validate")
+	mode, _, _ := unstructured.NestedString(work.Object, "spec", "style", "sourceStyle", "mode")
+	if !validate || mode != "inline" {
+		return nil
+	}
+	raw, _, _ := unstructured.NestedString(work.Object, "spec", "style", "sourceStyle", "json")
+	if err := mapstyle.Check([]byte(raw)); err != nil {
+		return fmt.Errorf("spec.style.sourceStyle.json: %v", err)
+	}
+	return nil
+}
+
+// tilesetValidator checks the 3D Tiles tileset.json written by py3dtiles.
+type tilesetValidator struct{}
+
+func (tilesetValidator) name() string { return "3dtiles" }
+
+type tilesetTile struct {
+	BoundingVolume map[string][]float64 `json:"boundingVolume"`
+	GeometricError *float64             `json:"geometricError"`
+	Content        *struct {
+		URI string `json:"uri"`
+		URL string `json:"url"`
+	} `json:"content"`
+}
+
+func (v tilesetValidator) validate(ctx context.Context, a *workArtifacts) ([]validationFinding, error) {
+	files, err := a.files(ctx)
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	var tilesets []artifactFile
+	for _, f := range files {
+		names[f.name] = true
+		if path.Base(f.name) == "tileset.json" {
+			tilesets = append(tilesets, f)
+		}
+	}
+	if len(tilesets) == 0 {
+		return []validationFinding{{Validator: v.name(), Severity: severityError, Reason: reasonTilesetMissing, Message: "tileset.json not found"}}, nil
+	}
+	// Nested tilesets are referenced from the outermost one.
+	f := tilesets[0]
+	for _, t := range tilesets[1:] {
+		if strings.Count(t.name, "/") < strings.Count(f.name, "/") {
+			f = t
+		}
+	}
+
+	var tileset struct {
+		Asset *struct {
+			Version string `json:"version"`
+		} `json:"asset"`
+		GeometricError *float64     `json:"geometricError"`
+		Root           *tilesetTile `json:"root"`
+	}
+	finding, err := a.readJSON(ctx, v, f, reasonTilesetInvalid, &tileset)
+	if err != nil || finding != nil {
+		if finding != nil {
+			return []validationFinding{*finding}, nil
+		}
+		return nil, err
+	}
+	var problems []string
+	if tileset.Asset == nil || tileset.Asset.Version == "" {
+		problems = append(problems, "asset.version is required")
+	}
+	if tileset.GeometricError == nil {
+		problems = append(problems, "geometricError is required")
+	}
+	if tileset.Root == nil {
+		problems = append(problems, "root is required")
+	} else {
+		bv := tileset.Root.BoundingVolume
+		switch {
+		case bv["box"] != nil:
+			if len(bv["box"]) != 12 {
+				problems = append(problems, "root.boundingVolume.box must have 12 numbers")
+			}
+		case bv["region"] != nil:
+			if len(bv["region"]) != 6 {
+				problems = append(problems, "root.boundingVolume.region must have 6 numbers")
+			}
+		case bv["sphere"] != nil:
+			if len(bv["sphere"]) != 4 {
+				problems = append(problems, "root.boundingVolume.sphere must have 4 numbers")
+			}
+		default:
+			problems = append(problems, "root.boundingVolume must be a box, region or sphere")
+		}
+		if tileset.Root.GeometricError == nil {
+			problems = append(problems, "root.geometricError is required")
+		}
+		if content := tileset.Root.Content; content != nil {
+			uri := content.URI
+			if uri == "" {
+				uri = content.URL
+			}
+			if ref := path.Join(path.Dir(f.name), uri); uri != "" && !strings.Contains(uri, "://") && !names[ref] {
+				problems = append(problems, fmt.Sprintf("root content %q not found", uri))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return []validationFinding{{Validator: v.name(), Severity: severityError, Reason: reasonTilesetInvalid, Path: f.name,
+			Message: f.name + ": " + strings.Join(problems, "; ")}}, nil
+	}
+	return nil, nil
+}
+
+// tilePyramidValidator requires z/x/y image tiles at every zoom level of
+// spec.raster.tiles.
+type tilePyramidValidator struct{}
+
+func (tilePyramidValidator) name() string { return "tilePyramid" }
+
+func (v tilePyramidValidator) validate(ctx context.Context, a *workArtifacts) ([]validationFinding, error) {
+	files, err := a.files(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Group tiles by the directory holding the zoom levels; gdal2tiles writes
+	// one pyramid, so the largest group is the one to check.
+	zoomsByRoot := map[string]map[int]int{}
+	for _, f := range files {
+		root, z, ok := parseTilePath(f.name)
+		if !ok {
+			continue
+		}
+		if zoomsByRoot[root] == nil {
+			zoomsByRoot[root] = map[int]int{}
+		}
+		zoomsByRoot[root][z]++
+	}
+	best, bestCount := "", 0
+	for root, zooms := range zoomsByRoot {
+		count := 0
+		for _, n := range zooms {
+			count += n
+		}
+		if count > bestCount || (count == bestCount && root < best) {
+			best, bestCount = root, count
+		}
+	}
+	if bestCount == 0 {
+		return []validationFinding{{Validator: v.name(), Severity: severityError, Reason: reasonTilePyramidMissing,
+			Message: "no z/x/y image tiles found"}}, nil
+	}
+
+	minZoom, maxZoom := extractTileZoomRange(a.work)
+	var missing []string
+	for z := minZoom; z <= maxZoom; z++ {
+		if zoomsByRoot[best][z] == 0 {
+			missing = append(missing, strconv.Itoa(z))
+		}
+	}
+	if len(missing) > 0 {
+		display := best
+		if display == "" {
+			display = "."
+		}
+		return []validationFinding{{Validator: v.name(), Severity: severityError, Reason: reasonTilePyramidIncomplete, Path: display,
+			Message: fmt.Sprintf("tile pyramid %s has no tiles at zoom %s (expected %d-%d)", display, strings.Join(missing, ","), minZoom, maxZoom)}}, nil
+	}
+	return nil, nil
+}
+
+// parseTilePath splits "<root>/<z>/<x>/<y>.<png|jpg|jpeg|webp>".
+func parseTilePath(name string) (root string, z int, ok bool) {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".png", ".jpg", ".jpeg", ".webp":
+	default:
+		return "", 0, false
+	}
+	parts := strings.Split(name, "/")
+	if len(parts) < 3 {
+		return "", 0, false
+	}
+	n := len(parts)
+	y := strings.TrimSuffix(parts[n-1], path.Ext(parts[n-1]))
+	for _, s := range []string{parts[n-3], parts[n-2], y} {
+		if _, err := strconv.Atoi(s); err != nil {
+			return "", 0, false
+		}
+	}
+	z, _ = strconv.Atoi(parts[n-3])
+	return strings.Join(parts[:n-3], "/"), z, true
+}
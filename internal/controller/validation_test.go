@@ -0,0 +1,205 @@
+package controller
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func artifactTestWork(name, kind string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "nereid.yuiseki.net/v1alpha1",
+		"kind":       "Work",
+		"metadata":   map[string]interface{}{"name": name, "namespace": "nereid"},
+		"spec":       map[string]interface{}{"kind": kind},
+	}}
+}
+
+func TestKindArtifactValidators(t *testing.T) {
+	const index = "<html><body>map</body></html>"
+	const point = `{"type":"Feature","geometry":{"type":"Point","coordinates":[139.7,35.6]},"properties":{}}`
+	cases := []struct {
+		name       string
+		kind       string
+		files      map[string]string
+		raster     map[string]interface{}
+		wantReason string
+		wantWarn   string
+	}{
+		{
+			name:  "overpass ok",
+			kind:  "overpassql.map.v1",
+			files: map[string]string{"index.html": index, "data.geojson": `{"type":"FeatureCollection","features":[` + point + `]}`},
+		},
+		{
+			name:       "overpass empty features",
+			kind:       "overpassql.map.v1",
+			files:      map[string]string{"index.html": index, "data.geojson": `{"type":"FeatureCollection","features":[]}`},
+			wantReason: reasonGeoJSONEmpty,
+		},
+		{
+			name:       "duckdb bad geometry",
+			kind:       "duckdb.map.v1",
+			files:      map[string]string{"index.html": index, "out/result.geojson": `{"type":"FeatureCollection","features":[{"type":"Feature","geometry":{"type":"Pointy","coordinates":[0,0]}}]}`},
+			wantReason: reasonGeoJSONInvalid,
+		},
+		{
+			name:     "duckdb missing geojson only warns",
+			kind:     "duckdb.map.v1",
+			files:    map[string]string{"index.html": index, "node_modules/x/a.geojson": "{"},
+			wantWarn: reasonGeoJSONMissing,
+		},
+		{
+			name:  "style ok",
+			kind:  "maplibre.style.v1",
+			files: map[string]string{"index.html": index, "style.json": `{"version":8,"sources":{"osm":{"type":"raster","tiles":["https://t/{z}/{x}/{y}.png"]}},"layers":[{"id":"bg","type":"background"},{"id":"osm","type":"raster","source":"osm"}]}`},
+		},
+		{
+			name:       "style missing source",
+			kind:       "maplibre.style.v1",
+			files:      map[string]string{"index.html": index, "style.json": `{"version":8,"sources":{},"layers":[{"id":"roads","type":"line","source":"osm"}]}`},
+			wantReason: reasonStyleInvalid,
+		},
+		{
+			name:  "tileset ok",
+			kind:  "laz.3dtiles.v1",
+			files: map[string]string{"index.html": index, "3dtiles/tileset.json": `{"asset":{"version":"1.0"},"geometricError":10,"root":{"boundingVolume":{"box":[0,0,0,1,0,0,0,1,0,0,0,1]},"geometricError":5,"content":{"uri":"r.pnts"}}}`, "3dtiles/r.pnts": "pnts"},
+		},
+		{
+			name:       "tileset content missing",
+			kind:       "laz.3dtiles.v1",
+			files:      map[string]string{"index.html": index, "3dtiles/tileset.json": `{"asset":{"version":"1.0"},"geometricError":10,"root":{"boundingVolume":{"region":[0,0,1,1,0,10]},"geometricError":5,"content":{"uri":"r.pnts"}}}`},
+			wantReason: reasonTilesetInvalid,
+		},
+		{
+			name:       "tileset missing",
+			kind:       "laz.3dtiles.v1",
+			files:      map[string]string{"index.html": index},
+			wantReason: reasonTilesetMissing,
+		},
+		{
+			name:   "pyramid ok",
+			kind:   "gdal.rastertile.v1",
+			files:  map[string]string{"index.html": index, "tiles/0/0/0.png": "p", "tiles/1/1/0.png": "p"},
+			raster: map[string]interface{}{"tiles": map[string]interface{}{"minZoom": int64(0), "maxZoom": int64(1)}},
+		},
+		{
+			name:       "pyramid incomplete",
+			kind:       "gdal.rastertile.v1",
+			files:      map[string]string{"index.html": index, "tiles/0/0/0.png": "p", "tiles/2/1/1.png": "p"},
+			raster:     map[string]interface{}{"tiles": map[string]interface{}{"minZoom": int64(0), "maxZoom": int64(2)}},
+			wantReason: reasonTilePyramidIncomplete,
+		},
+		{
+			name:       "pyramid missing",
+			kind:       "gdal.rastertile.v1",
+			files:      map[string]string{"index.html": index, "input.tif": "tif"},
+			wantReason: reasonTilePyramidMissing,
+		},
+		{
+			name:       "index checked for every kind",
+			kind:       "overpassql.map.v1",
+			files:      map[string]string{"index.html": "", "data.geojson": `{"type":"FeatureCollection","features":[` + point + `]}`},
+			wantReason: reasonIndexEmpty,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			for name, body := range tc.files {
+				p := filepath.Join(root, "w", filepath.FromSlash(name))
+				if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+					t.Fatalf("mkdir: %v", err)
+				}
+				if err := os.WriteFile(p, []byte(body), 0o644); err != nil {
+					t.Fatalf("write %s: %v", name, err)
+				}
+			}
+			work := artifactTestWork("w", tc.kind)
+			if tc.raster != nil {
+				if err := unstructured.SetNestedMap(work.Object, tc.raster, "spec", "raster"); err != nil {
+					t.Fatalf("set raster: %v", err)
+				}
+			}
+			c := &Controller{cfg: Config{ArtifactsHostPath: root}}
+
+			v, err := c.validateSucceededWorkArtifacts(context.Background(), work)
+			if err != nil {
+				t.Fatalf("validateSucceededWorkArtifacts() error = %v", err)
+			}
+			failure := v.failure()
+			if tc.wantReason == "" {
+				if failure != nil {
+					t.Fatalf("unexpected failure: %+v", *failure)
+				}
+			} else {
+				if failure == nil || failure.Reason != tc.wantReason {
+					t.Fatalf("failure got=%+v want reason %s (findings %+v)", failure, tc.wantReason, v.findings)
+				}
+				if !strings.HasPrefix(v.message(), "artifact validation failed: ") || failure.Message == "" {
+					t.Fatalf("message got=%q", v.message())
+				}
+			}
+			if tc.wantWarn != "" {
+				found := false
+				for _, f := range v.findings {
+					found = found || (f.Severity == severityWarning && f.Reason == tc.wantWarn)
+				}
+				if !found {
+					t.Fatalf("warning %s not found in %+v", tc.wantWarn, v.findings)
+				}
+			}
+			status := v.status()
+			if status["passed"] != (tc.wantReason == "") {
+				t.Fatalf("status.passed got=%v", status["passed"])
+			}
+		})
+	}
+}
+
+func TestInvalidInlineStyleFailsBeforeJob(t *testing.T) {
+	work := artifactTestWork("style", "maplibre.style.v1")
+	if err := unstructured.SetNestedMap(work.Object, map[string]interface{}{
+		"sourceStyle": map[string]interface{}{
+			"mode": "inline",
+			"json": `{"version":8,"sources":{"osm":{"type":"raster","tiles":["https://t/{z}/{x}/{y}.png"]}},"layers":[{"id":"osm","type":"fill","source":"osm"}]}`,
+		},
+		"validate": true,
+	}, "spec", "style"); err != nil {
+		t.Fatalf("set style: %v", err)
+	}
+	_ = unstructured.SetNestedField(work.Object, "style", "spec", "title")
+	dc := newFakeDynamicClient(work)
+	kc := fake.NewSimpleClientset()
+	c := &Controller{dynamic: dc, kube: kc, cfg: Config{JobNamespace: "nereid-work"}, logger: slog.Default(), nowFunc: time.Now}
+
+	ctx := context.Background()
+	if err := c.reconcileWork(ctx, work); err != nil {
+		t.Fatalf("reconcileWork() error = %v", err)
+	}
+	got, err := dc.Resource(workGVR).Namespace("nereid").Get(ctx, "style", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get work: %v", err)
+	}
+	phase, _, _ := unstructured.NestedString(got.Object, "status", "phase")
+	reason, _, _ := unstructured.NestedString(got.Object, "status", "reason")
+	message, _, _ := unstructured.NestedString(got.Object, "status", "message")
+	if phase != "Error" || reason != reasonStyleInvalid || message != `spec.style.sourceStyle.json: layer "osm" of type fill cannot use raster source "osm"` {
+		t.Fatalf("status phase=%q reason=%q message=%q", phase, reason, message)
+	}
+	jobs, err := kc.BatchV1().Jobs("nereid-work").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("list jobs: %v", err)
+	}
+	if len(jobs.Items) != 0 {
+		t.Fatalf("created %d Jobs for an invalid style", len(jobs.Items))
+	}
+}
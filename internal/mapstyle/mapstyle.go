@@ -0,0 +1,243 @@
+// Package mapstyle validates MapLibre GL style documents (style spec v8)
+// without a browser, so broken styles are rejected before a Job runs.
+package mapstyle
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Validate checks a style.json document and returns one message per
+// problem, in document order. A nil result means the style is valid.
+func Validate(raw []byte) []string {
+	var style map[string]interface{}
+	if err := json.Unmarshal(raw, &style); err != nil {
+		return []string{fmt.Sprintf("style is not valid JSON: %v", err)}
+	}
+	if style == nil {
+		return []string{"style must be a JSON object"}
+	}
+	v := &validator{}
+	v.validate(style)
+	return v.problems
+}
+
+// Check is Validate as an error.
+func Check(raw []byte) error {
+	problems := Validate(raw)
+	if len(problems) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(problems, "; "))
+}
+
+type validator struct {
+	problems []string
+}
+
+func (v *validator) addf(format string, args ...interface{}) {
+	v.problems = append(v.problems, fmt.Sprintf(format, args...))
+}
+
+func (v *validator) validate(style map[string]interface{}) {
+	if version, ok := style["version"].(float64); !ok || version != 8 {
+		v.addf("version must be 8")
+	}
+
+	sources := map[string]string{}
+	rawSources, ok := style["sources"].(map[string]interface{})
+	if !ok {
+		v.addf("sources must be an object")
+	}
+	for _, name := range sortedKeys(rawSources) {
+		src, ok := rawSources[name].(map[string]interface{})
+		if !ok {
+			v.addf("source %q must be an object", name)
+			continue
+		}
+		typ, _ := src["type"].(string)
+		sources[name] = typ
+		required, known := sourceRequirements[typ]
+		if !known {
+			v.addf("source %q has unknown type %q", name, typ)
+			continue
+		}
+		if !hasAny(src, required...) {
+			v.addf("source %q of type %s needs %s", name, typ, strings.Join(required, " or "))
+		}
+		if (typ == "image" || typ == "video") && !hasAny(src, "coordinates") {
+			v.addf("source %q of type %s needs coordinates", name, typ)
+		}
+	}
+
+	layers, ok := style["layers"].([]interface{})
+	if !ok || len(layers) == 0 {
+		v.addf("layers is empty")
+		return
+	}
+	ids := map[string]bool{}
+	needsGlyphs := false
+	for i, raw := range layers {
+		layer, ok := raw.(map[string]interface{})
+		if !ok {
+			v.addf("layers[%d] must be an object", i)
+			continue
+		}
+		id, _ := layer["id"].(string)
+		switch {
+		case id == "":
+			v.addf("layers[%d] has no id", i)
+			id = fmt.Sprintf("layers[%d]", i)
+		case ids[id]:
+			v.addf("duplicate layer id %q", id)
+		}
+		ids[id] = true
+
+		typ, _ := layer["type"].(string)
+		spec, known := layerTypes[typ]
+		if !known {
+			v.addf("layer %q has unknown type %q", id, typ)
+			continue
+		}
+		v.validateLayerSource(id, typ, spec, layer, sources)
+		if layout, ok := layer["layout"].(map[string]interface{}); ok && typ == "symbol" && layout["text-field"] != nil {
+			needsGlyphs = true
+		}
+		v.validateProperties(id, "layout", layer["layout"], spec.layout)
+		v.validateProperties(id, "paint", layer["paint"], spec.paint)
+		if filter, ok := layer["filter"]; ok {
+			v.validateExpression(fmt.Sprintf("layer %q filter", id), filter)
+		}
+	}
+	if glyphs, _ := style["glyphs"].(string); needsGlyphs && strings.TrimSpace(glyphs) == "" {
+		v.addf("glyphs is required when a symbol layer sets text-field")
+	}
+}
+
+func (v *validator) validateLayerSource(id, typ string, spec layerSpec, layer map[string]interface{}, sources map[string]string) {
+	source, _ := layer["source"].(string)
+	if spec.sourceTypes == nil {
+		return
+	}
+	if source == "" {
+		v.addf("layer %q of type %s needs a source", id, typ)
+		return
+	}
+	sourceType, ok := sources[source]
+	if !ok {
+		v.addf("layer %q references missing source %q", id, source)
+		return
+	}
+	if !spec.sourceTypes[sourceType] {
+		v.addf("layer %q of type %s cannot use %s source %q", id, typ, sourceType, source)
+	}
+	sourceLayer, hasSourceLayer := layer["source-layer"].(string)
+	switch {
+	case sourceType == "vector" && strings.TrimSpace(sourceLayer) == "":
+		v.addf("layer %q needs source-layer for vector source %q", id, source)
+	case sourceType != "vector" && hasSourceLayer:
+		v.addf("layer %q sets source-layer but source %q is %s", id, source, sourceType)
+	}
+}
+
+func (v *validator) validateProperties(id, group string, raw interface{}, allowed map[string]bool) {
+	if raw == nil {
+		return
+	}
+	props, ok := raw.(map[string]interface{})
+	if !ok {
+		v.addf("layer %q %s must be an object", id, group)
+		return
+	}
+	for _, name := range sortedKeys(props) {
+		key := name
+		if group == "paint" {
+			key = strings.TrimSuffix(name, "-transition")
+		}
+		if !allowed[key] {
+			v.addf("layer %q has unknown %s property %q", id, group, name)
+			continue
+		}
+		if stringArrayProperties[name] {
+			continue
+		}
+		v.validateExpression(fmt.Sprintf("layer %q %s", id, name), props[name])
+	}
+}
+
+// validateExpression checks the shape of an expression or legacy filter:
+// known operators, minimum arity and the pairing rules of case, match and
+// step. Argument types are not checked.
+func (v *validator) validateExpression(where string, value interface{}) {
+	expr, ok := value.([]interface{})
+	if !ok || len(expr) == 0 {
+		return
+	}
+	op, ok := expr[0].(string)
+	if !ok {
+		// Literal arrays such as line-dasharray or text-offset.
+		return
+	}
+	arity, known := expressionOperators[op]
+	if !known {
+		v.addf("%s: unknown expression operator %q", where, op)
+		return
+	}
+	args := expr[1:]
+	if len(args) < arity {
+		v.addf("%s: %q has %d arguments, needs at least %d", where, op, len(args), arity)
+		return
+	}
+	switch op {
+	case "literal":
+		return
+	case "case":
+		if len(args)%2 == 0 {
+			v.addf("%s: \"case\" expects condition/output pairs and a fallback", where)
+		}
+	case "match":
+		if len(args)%2 != 0 {
+			v.addf("%s: \"match\" expects an input, label/output pairs and a fallback", where)
+		}
+		// Labels (args[i]) are literals or arrays of literals; only the
+		// outputs are expressions.
+		for i := 1; i < len(args)-1; i += 2 {
+			v.validateExpression(where, args[i+1])
+		}
+		v.validateExpression(where, args[0])
+		v.validateExpression(where, args[len(args)-1])
+		return
+	case "step":
+		if len(args)%2 != 0 {
+			v.addf("%s: \"step\" expects an input, an output and stop/output pairs", where)
+		}
+	case "interpolate", "interpolate-hcl", "interpolate-lab":
+		if len(args)%2 != 0 {
+			v.addf("%s: %q expects an interpolation type, an input and stop/output pairs", where, op)
+		}
+	}
+	for _, arg := range args {
+		v.validateExpression(where, arg)
+	}
+}
+
+func hasAny(m map[string]interface{}, keys ...string) bool {
+	for _, k := range keys {
+		if _, ok := m[k]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
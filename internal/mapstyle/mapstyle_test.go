@@ -0,0 +1,87 @@
+package mapstyle
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidateAcceptsValidStyle(t *testing.T) {
+	style := `{
+  "version": 8,
+  "glyphs": "https://demotiles.maplibre.org/font/{fontstack}/{range}.pbf",
+  "sources": {
+    "maplibre": {"type": "vector", "url": "https://demotiles.maplibre.org/tiles/tiles.json"},
+    "osm": {"type": "raster", "tiles": ["https://tile.openstreetmap.org/{z}/{x}/{y}.png"], "tileSize": 256},
+    "points": {"type": "geojson", "data": "./data.geojson"}
+  },
+  "layers": [
+    {"id": "background", "type": "background", "paint": {"background-color": "#efe9dc"}},
+    {"id": "osm", "type": "raster", "source": "osm", "paint": {"raster-opacity": 0.5, "raster-opacity-transition": {"duration": 300}}},
+    {"id": "countries", "type": "fill", "source": "maplibre", "source-layer": "countries",
+     "filter": ["==", ["coalesce", ["get", "name_en"], ["get", "name"]], "Japan"],
+     "paint": {"fill-color": ["match", ["get", "continent"], ["Asia", "Oceania"], "#e74c3c", "Europe", "#2980b9", "#cccccc"]}},
+    {"id": "legacy-filter", "type": "line", "source": "maplibre", "source-layer": "geolines",
+     "filter": ["all", ["in", "class", "a", "b"], ["!has", "name"]],
+     "paint": {"line-dasharray": [2, 1], "line-width": ["interpolate", ["linear"], ["zoom"], 2, 0.5, 8, 2]}},
+    {"id": "labels", "type": "symbol", "source": "maplibre", "source-layer": "centroids",
+     "layout": {"text-field": ["get", "name"], "text-font": ["Open Sans Semibold"], "text-offset": [0, 1]},
+     "paint": {"text-color": ["step", ["zoom"], "#222", 5, "#000"]}},
+    {"id": "points", "type": "circle", "source": "points", "paint": {"circle-radius": ["case", ["has", "big"], 8, 4]}}
+  ]
+}`
+	if problems := Validate([]byte(style)); problems != nil {
+		t.Fatalf("Validate() = %q, want no problems", problems)
+	}
+}
+
+func TestValidateReportsProblems(t *testing.T) {
+	style := `{
+  "version": 7,
+  "sources": {
+    "tiles": {"type": "vector"},
+    "wms": {"type": "wms", "url": "https://example.com"},
+    "points": {"type": "geojson", "data": {"type": "FeatureCollection", "features": []}}
+  },
+  "layers": [
+    {"id": "roads", "type": "line", "source": "tiles", "paint": {"line-colour": "#f00"}},
+    {"id": "roads", "type": "fill", "source": "tiles", "source-layer": "roads"},
+    {"id": "water", "type": "fill", "source": "ocean", "source-layer": "water"},
+    {"id": "dots", "type": "circle", "source": "points", "source-layer": "points"},
+    {"id": "img", "type": "raster", "source": "points"},
+    {"id": "sky", "type": "sky"},
+    {"id": "labels", "type": "symbol", "source": "points", "layout": {"text-field": ["get"], "text-size": ["interpolate", ["linear"], ["zoom"], 5]}},
+    {"id": "pick", "type": "circle", "source": "points", "filter": ["matches", ["get", "class"], "x"],
+     "paint": {"circle-color": ["match", ["get", "k"], "a", "#f00", "b", "#0f0"], "circle-radius": ["case", ["has", "x"], 4, ["has", "y"], 6]}}
+  ]
+}`
+	want := []string{
+		"version must be 8",
+		"source \"tiles\" of type vector needs url or tiles",
+		"source \"wms\" has unknown type \"wms\"",
+		"layer \"roads\" needs source-layer for vector source \"tiles\"",
+		"layer \"roads\" has unknown paint property \"line-colour\"",
+		"duplicate layer id \"roads\"",
+		"layer \"water\" references missing source \"ocean\"",
+		"layer \"dots\" sets source-layer but source \"points\" is geojson",
+		"layer \"img\" of type raster cannot use geojson source \"points\"",
+		"layer \"sky\" has unknown type \"sky\"",
+		"layer \"labels\" text-field: \"get\" has 0 arguments, needs at least 1",
+		"layer \"labels\" text-size: \"interpolate\" has 3 arguments, needs at least 4",
+		"layer \"pick\" circle-color: \"match\" expects an input, label/output pairs and a fallback",
+		"layer \"pick\" circle-radius: \"case\" expects condition/output pairs and a fallback",
+		"layer \"pick\" filter: unknown expression operator \"matches\"",
+		"glyphs is required when a symbol layer sets text-field",
+	}
+	if got := Validate([]byte(style)); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Validate() problems:\n got  %q\n want %q", got, want)
+	}
+}
+
+func TestValidateRejectsMalformedJSON(t *testing.T) {
+	if got := Validate([]byte(`{"version": 8,`)); len(got) != 1 || got[0] != "style is not valid JSON: unexpected end of JSON input" {
+		t.Fatalf("Validate() = %q", got)
+	}
+	if got := Validate([]byte(`{"version": 8, "sources": {}, "layers": []}`)); !reflect.DeepEqual(got, []string{"layers is empty"}) {
+		t.Fatalf("Validate() = %q", got)
+	}
+}
@@ -0,0 +1,144 @@
+package mapstyle
+
+// Tables below follow the MapLibre GL style specification v8.
+
+// sourceRequirements lists, per source type, the keys one of which must be
+// set.
+var sourceRequirements = map[string][]string{
+	"vector":     {"url", "tiles"},
+	"raster":     {"url", "tiles"},
+	"raster-dem": {"url", "tiles"},
+	"geojson":    {"data"},
+	"image":      {"url"},
+	"video":      {"urls"},
+}
+
+type layerSpec struct {
+	// sourceTypes is nil for layers that take no source.
+	sourceTypes map[string]bool
+	layout      map[string]bool
+	paint       map[string]bool
+}
+
+func set(keys ...string) map[string]bool {
+	m := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		m[k] = true
+	}
+	return m
+}
+
+var (
+	featureSources = set("vector", "geojson")
+	rasterSources  = set("raster", "image", "video")
+	demSources     = set("raster-dem")
+)
+
+var layerTypes = map[string]layerSpec{
+	"background": {
+		layout: set("visibility"),
+		paint:  set("background-color", "background-pattern", "background-opacity"),
+	},
+	"fill": {
+		sourceTypes: featureSources,
+		layout:      set("visibility", "fill-sort-key"),
+		paint: set("fill-antialias", "fill-opacity", "fill-color", "fill-outline-color",
+			"fill-translate", "fill-translate-anchor", "fill-pattern"),
+	},
+	"line": {
+		sourceTypes: featureSources,
+		layout:      set("visibility", "line-cap", "line-join", "line-miter-limit", "line-round-limit", "line-sort-key"),
+		paint: set("line-opacity", "line-color", "line-translate", "line-translate-anchor", "line-width",
+			"line-gap-width", "line-offset", "line-blur", "line-dasharray", "line-pattern", "line-gradient"),
+	},
+	"symbol": {
+		sourceTypes: featureSources,
+		layout: set("visibility", "symbol-placement", "symbol-spacing", "symbol-avoid-edges", "symbol-sort-key",
+			"symbol-z-order", "icon-allow-overlap", "icon-overlap", "icon-ignore-placement", "icon-optional",
+			"icon-rotation-alignment", "icon-size", "icon-text-fit", "icon-text-fit-padding", "icon-image",
+			"icon-rotate", "icon-padding", "icon-keep-upright", "icon-offset", "icon-anchor",
+			"icon-pitch-alignment", "text-pitch-alignment", "text-rotation-alignment", "text-field",
+			"text-font", "text-size", "text-max-width", "text-line-height", "text-letter-spacing",
+			"text-justify", "text-radial-offset", "text-variable-anchor", "text-variable-anchor-offset",
+			"text-anchor", "text-max-angle", "text-writing-mode", "text-rotate", "text-padding",
+			"text-keep-upright", "text-transform", "text-offset", "text-allow-overlap", "text-overlap",
+			"text-ignore-placement", "text-optional"),
+		paint: set("icon-opacity", "icon-color", "icon-halo-color", "icon-halo-width", "icon-halo-blur",
+			"icon-translate", "icon-translate-anchor", "text-opacity", "text-color", "text-halo-color",
+			"text-halo-width", "text-halo-blur", "text-translate", "text-translate-anchor"),
+	},
+	"raster": {
+		sourceTypes: rasterSources,
+		layout:      set("visibility"),
+		paint: set("raster-opacity", "raster-hue-rotate", "raster-brightness-min", "raster-brightness-max",
+			"raster-saturation", "raster-contrast", "raster-resampling", "raster-fade-duration"),
+	},
+	"circle": {
+		sourceTypes: featureSources,
+		layout:      set("visibility", "circle-sort-key"),
+		paint: set("circle-radius", "circle-color", "circle-blur", "circle-opacity", "circle-translate",
+			"circle-translate-anchor", "circle-pitch-scale", "circle-pitch-alignment", "circle-stroke-width",
+			"circle-stroke-color", "circle-stroke-opacity"),
+	},
+	"fill-extrusion": {
+		sourceTypes: featureSources,
+		layout:      set("visibility"),
+		paint: set("fill-extrusion-opacity", "fill-extrusion-color", "fill-extrusion-translate",
+			"fill-extrusion-translate-anchor", "fill-extrusion-pattern", "fill-extrusion-height",
+			"fill-extrusion-base", "fill-extrusion-vertical-gradient"),
+	},
+	"heatmap": {
+		sourceTypes: featureSources,
+		layout:      set("visibility"),
+		paint:       set("heatmap-radius", "heatmap-weight", "heatmap-intensity", "heatmap-color", "heatmap-opacity"),
+	},
+	"hillshade": {
+		sourceTypes: demSources,
+		layout:      set("visibility"),
+		paint: set("hillshade-illumination-direction", "hillshade-illumination-anchor", "hillshade-exaggeration",
+			"hillshade-shadow-color", "hillshade-highlight-color", "hillshade-accent-color", "hillshade-method",
+			"hillshade-illumination-altitude"),
+	},
+	"color-relief": {
+		sourceTypes: demSources,
+		layout:      set("visibility"),
+		paint:       set("color-relief-opacity", "color-relief-color"),
+	},
+}
+
+// stringArrayProperties take plain arrays starting with a string, which
+// would otherwise look like expressions.
+var stringArrayProperties = set("text-font", "text-variable-anchor", "text-variable-anchor-offset",
+	"text-writing-mode", "hillshade-shadow-color", "hillshade-highlight-color")
+
+// expressionOperators maps each operator, including legacy filter
+// operators, to its minimum argument count.
+var expressionOperators = map[string]int{
+	// Types
+	"array": 1, "boolean": 1, "collator": 1, "format": 1, "image": 1, "literal": 1, "number": 1,
+	"number-format": 2, "object": 1, "string": 1, "to-boolean": 1, "to-color": 1, "to-number": 1,
+	"to-string": 1, "typeof": 1,
+	// Feature data
+	"accumulated": 0, "feature-state": 1, "geometry-type": 0, "id": 0, "line-progress": 0,
+	"properties": 0, "elevation": 0, "heatmap-density": 0, "zoom": 0,
+	// Lookup
+	"at": 2, "get": 1, "has": 1, "in": 2, "index-of": 2, "length": 1, "slice": 2, "global-state": 1,
+	// Decision
+	"!": 1, "!=": 2, "<": 2, "<=": 2, "==": 2, ">": 2, ">=": 2, "all": 0, "any": 0, "none": 0,
+	"case": 3, "coalesce": 1, "match": 4, "within": 1, "distance": 1,
+	// Ramps, scales, curves
+	"interpolate": 4, "interpolate-hcl": 4, "interpolate-lab": 4, "step": 2,
+	"linear": 0, "exponential": 1, "cubic-bezier": 4,
+	// Variable binding
+	"let": 3, "var": 1,
+	// String
+	"concat": 1, "downcase": 1, "upcase": 1, "is-supported-script": 1, "resolved-locale": 1,
+	// Color
+	"rgb": 3, "rgba": 4, "to-rgba": 1,
+	// Math
+	"-": 1, "*": 2, "/": 2, "%": 2, "^": 2, "+": 2, "abs": 1, "acos": 1, "asin": 1, "atan": 1,
+	"ceil": 1, "cos": 1, "e": 0, "floor": 1, "ln": 1, "ln2": 0, "log10": 1, "log2": 1, "max": 1,
+	"min": 1, "pi": 0, "round": 1, "sin": 1, "sqrt": 1, "tan": 1,
+	// Legacy filters
+	"!has": 1, "!in": 1,
+}
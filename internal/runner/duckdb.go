@@ -0,0 +1,286 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	geometryModeLonLat = "lonlat"
+	geometryModeWKT    = "wkt"
+	geometryModeWKB    = "wkb"
+)
+
+var inputPlaceholder = regexp.MustCompile(`\$\{INPUT(\d+)\}`)
+
+type duckdbColumn struct {
+	Name string `json:"column_name"`
+	Type string `json:"column_type"`
+}
+
+func (r *Runner) runDuckDB(ctx context.Context, spec *Spec) error {
+	d := spec.DuckDB
+	if d == nil || strings.TrimSpace(d.SQL) == "" {
+		return fmt.Errorf("spec.duckdb.sql is required")
+	}
+	geom, err := geometryOutput(d.Output.Geometry)
+	if err != nil {
+		return err
+	}
+
+	work, err := os.MkdirTemp("", "nereid-duckdb-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(work)
+	input, err := r.stageInput(ctx, 0, d.Input, work)
+	if err != nil {
+		return fmt.Errorf("spec.duckdb.input: %w", err)
+	}
+	query, err := substituteInputs(d.SQL, []string{input})
+	if err != nil {
+		return err
+	}
+
+	columns, err := r.describeQuery(ctx, work, query)
+	if err != nil {
+		return err
+	}
+	selectSQL, err := geometrySelect(query, geom, columns)
+	if err != nil {
+		return err
+	}
+	out, err := r.duckdb(ctx, work, selectSQL)
+	if err != nil {
+		return err
+	}
+	var rows []map[string]interface{}
+	if len(bytes.TrimSpace(out)) > 0 {
+		dec := json.NewDecoder(bytes.NewReader(out))
+		dec.UseNumber()
+		if err := dec.Decode(&rows); err != nil {
+			return fmt.Errorf("decode duckdb JSON output: %w", err)
+		}
+	}
+
+	fc, skipped, firstErr := rowsToGeoJSON(rows, geom)
+	r.logger().Info("converted duckdb result",
+		"rows", len(rows),
+		"features", len(fc.Features),
+		"skippedRows", skipped,
+		"geometryMode", geom.Mode,
+	)
+	if err := r.writeMapArtifacts(spec, fc); err != nil {
+		return err
+	}
+	switch {
+	case len(rows) == 0:
+		return fmt.Errorf("duckdb query returned no rows")
+	case len(fc.Features) == 0:
+		return fmt.Errorf("none of the %d rows has a valid geometry: %v", len(rows), firstErr)
+	}
+	return nil
+}
+
+// geometryOutput fills in defaults for spec.duckdb.output.geometry.
+func geometryOutput(g GeometryOutput) (GeometryOutput, error) {
+	g.Mode = strings.ToLower(strings.TrimSpace(g.Mode))
+	switch g.Mode {
+	case "", geometryModeLonLat:
+		g.Mode = geometryModeLonLat
+		if g.LonColumn == "" {
+			g.LonColumn = "lon"
+		}
+		if g.LatColumn == "" {
+			g.LatColumn = "lat"
+		}
+	case geometryModeWKT, geometryModeWKB:
+		if g.Column == "" {
+			g.Column = "geom"
+		}
+	default:
+		return g, fmt.Errorf("unsupported spec.duckdb.output.geometry.mode=%q (use lonlat, wkt or wkb)", g.Mode)
+	}
+	return g, nil
+}
+
+// substituteInputs replaces ${INPUTn} with the staged path of input n,
+// escaped for use inside a single-quoted SQL string.
+func substituteInputs(sql string, inputs []string) (string, error) {
+	var err error
+	out := inputPlaceholder.ReplaceAllStringFunc(sql, func(m string) string {
+		i, convErr := strconv.Atoi(inputPlaceholder.FindStringSubmatch(m)[1])
+		if convErr != nil || i >= len(inputs) {
+			if err == nil {
+				err = fmt.Errorf("spec.duckdb.sql references %s but only %d input(s) are staged", m, len(inputs))
+			}
+			return m
+		}
+		return strings.ReplaceAll(inputs[i], "'", "''")
+	})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(strings.TrimSpace(out), "; \t\r\n"), nil
+}
+
+func (r *Runner) describeQuery(ctx context.Context, dir, query string) ([]duckdbColumn, error) {
+	out, err := r.duckdb(ctx, dir, "DESCRIBE SELECT * FROM (\n"+query+"\n) AS q;")
+	if err != nil {
+		return nil, err
+	}
+	var columns []duckdbColumn
+	if err := json.Unmarshal(out, &columns); err != nil {
+		return nil, fmt.Errorf("decode duckdb DESCRIBE output: %w", err)
+	}
+	return columns, nil
+}
+
+// geometrySelect wraps query so the geometry column comes back as text:
+// spatial GEOMETRY values are serialised as WKT or hex WKB and BLOBs as hex.
+func geometrySelect(query string, g GeometryOutput, columns []duckdbColumn) (string, error) {
+	types := map[string]string{}
+	var names []string
+	for _, c := range columns {
+		types[c.Name] = strings.ToUpper(c.Type)
+		names = append(names, c.Name)
+	}
+	missing := func(field, column string) error {
+		return fmt.Errorf("spec.duckdb.output.geometry.%s %q is not a column of the query result (columns: %s)",
+			field, column, strings.Join(names, ", "))
+	}
+
+	var replace string
+	switch g.Mode {
+	case geometryModeLonLat:
+		if _, ok := types[g.LonColumn]; !ok {
+			return "", missing("lonColumn", g.LonColumn)
+		}
+		if _, ok := types[g.LatColumn]; !ok {
+			return "", missing("latColumn", g.LatColumn)
+		}
+	default:
+		typ, ok := types[g.Column]
+		if !ok {
+			return "", missing("column", g.Column)
+		}
+		col := quoteIdent(g.Column)
+		switch {
+		case typ == "GEOMETRY" && g.Mode == geometryModeWKT:
+			replace = "ST_AsText(" + col + ")"
+		case typ == "GEOMETRY":
+			replace = "hex(ST_AsWKB(" + col + "))"
+		case typ == "BLOB" || typ == "WKB_BLOB":
+			replace = "hex(" + col + ")"
+		}
+		if replace != "" {
+			replace = " REPLACE (" + replace + " AS " + col + ")"
+		}
+	}
+	return "SELECT *" + replace + " FROM (\n" + query + "\n) AS q;", nil
+}
+
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// duckdb runs sql with the duckdb CLI in dir and returns its JSON output.
+func (r *Runner) duckdb(ctx context.Context, dir, sql string) ([]byte, error) {
+	bin := r.DuckDB
+	if bin == "" {
+		bin = "duckdb"
+	}
+	cmd := exec.CommandContext(ctx, bin, "-bail", "-json", ":memory:")
+	cmd.Dir = dir
+	cmd.Stdin = strings.NewReader(sql)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("duckdb failed: %s", msg)
+		}
+		return nil, fmt.Errorf("duckdb failed: %w", err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// rowsToGeoJSON turns result rows into features. Rows without a usable
+// geometry are skipped; the first reason is returned for error reporting.
+func rowsToGeoJSON(rows []map[string]interface{}, g GeometryOutput) (featureCollection, int, error) {
+	fc := featureCollection{Type: "FeatureCollection", Features: []feature{}}
+	skipped := 0
+	var firstErr error
+	for i, row := range rows {
+		geom, err := rowGeometry(row, g)
+		if err != nil {
+			skipped++
+			if firstErr == nil {
+				firstErr = fmt.Errorf("row %d: %w", i+1, err)
+			}
+			continue
+		}
+		props := map[string]interface{}{}
+		for k, v := range row {
+			if k == g.LonColumn || k == g.LatColumn || k == g.Column {
+				continue
+			}
+			props[k] = v
+		}
+		fc.Features = append(fc.Features, feature{Type: "Feature", Properties: props, Geometry: geom})
+	}
+	return fc, skipped, firstErr
+}
+
+func rowGeometry(row map[string]interface{}, g GeometryOutput) (geometry, error) {
+	switch g.Mode {
+	case geometryModeLonLat:
+		lon, err := coordinate(row[g.LonColumn], g.LonColumn, 180)
+		if err != nil {
+			return geometry{}, err
+		}
+		lat, err := coordinate(row[g.LatColumn], g.LatColumn, 90)
+		if err != nil {
+			return geometry{}, err
+		}
+		return geometry{Type: "Point", Coordinates: position{lon, lat}}, nil
+	default:
+		s, ok := row[g.Column].(string)
+		if !ok || strings.TrimSpace(s) == "" {
+			return geometry{}, fmt.Errorf("%s is null", g.Column)
+		}
+		if g.Mode == geometryModeWKT {
+			return parseWKT(s)
+		}
+		return parseWKBHex(s)
+	}
+}
+
+func coordinate(v interface{}, column string, limit float64) (float64, error) {
+	var f float64
+	var err error
+	switch n := v.(type) {
+	case nil:
+		return 0, fmt.Errorf("%s is null", column)
+	case json.Number:
+		f, err = n.Float64()
+	case string:
+		f, err = strconv.ParseFloat(strings.TrimSpace(n), 64)
+	default:
+		return 0, fmt.Errorf("%s is not a number: %v", column, v)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%s is not a number: %v", column, v)
+	}
+	if math.IsNaN(f) || math.Abs(f) > limit {
+		return 0, fmt.Errorf("%s=%v is out of range", column, f)
+	}
+	return f, nil
+}
@@ -0,0 +1,178 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// fakeDuckDB answers DESCRIBE with describe.json and any other query with
+// rows.json, logging every query to queries.sql.
+const fakeDuckDB = `#!/bin/sh
+sql=$(cat)
+printf '%s\n' "$sql" >> "$FAKE_DUCKDB_DIR/queries.sql"
+case "$sql" in
+DESCRIBE*) exec cat "$FAKE_DUCKDB_DIR/describe.json" ;;
+esac
+exec cat "$FAKE_DUCKDB_DIR/rows.json"
+`
+
+func TestRunDuckDB(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/sample.parquet" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("PAR1"))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name      string
+		geometry  GeometryOutput
+		describe  string
+		rows      string
+		wantErr   string
+		wantQuery string
+		wantGeoms []geometry
+		wantProps []map[string]interface{}
+	}{
+		{
+			name:     "lonlat",
+			geometry: GeometryOutput{Mode: "lonlat", LonColumn: "x", LatColumn: "y"},
+			describe: `[{"column_name":"x","column_type":"DOUBLE"},{"column_name":"y","column_type":"DOUBLE"},{"column_name":"category","column_type":"VARCHAR"}]`,
+			rows:     `[{"x":139.7,"y":35.6,"category":"cafe"},{"x":139.8,"y":null,"category":"shop"},{"x":"139.9","y":"35.7","category":"bar"}]`,
+			wantGeoms: []geometry{
+				{Type: "Point", Coordinates: []interface{}{139.7, 35.6}},
+				{Type: "Point", Coordinates: []interface{}{139.9, 35.7}},
+			},
+			wantProps: []map[string]interface{}{{"category": "cafe"}, {"category": "bar"}},
+		},
+		{
+			name:      "wkt from spatial geometry",
+			geometry:  GeometryOutput{Mode: "wkt"},
+			describe:  `[{"column_name":"name","column_type":"VARCHAR"},{"column_name":"geom","column_type":"GEOMETRY"}]`,
+			rows:      `[{"name":"park","geom":"SRID=4326;POLYGON Z ((0 0 1, 2 0 1, 2 2 1, 0 0 1))"},{"name":"trees","geom":"MULTIPOINT ((1 2), (3 4))"}]`,
+			wantQuery: `SELECT * REPLACE (ST_AsText("geom") AS "geom") FROM (`,
+			wantGeoms: []geometry{
+				{Type: "Polygon", Coordinates: []interface{}{[]interface{}{[]interface{}{0.0, 0.0}, []interface{}{2.0, 0.0}, []interface{}{2.0, 2.0}, []interface{}{0.0, 0.0}}}},
+				{Type: "MultiPoint", Coordinates: []interface{}{[]interface{}{1.0, 2.0}, []interface{}{3.0, 4.0}}},
+			},
+			wantProps: []map[string]interface{}{{"name": "park"}, {"name": "trees"}},
+		},
+		{
+			name:      "wkb blob",
+			geometry:  GeometryOutput{Mode: "wkb", Column: "shape"},
+			describe:  `[{"column_name":"id","column_type":"BIGINT"},{"column_name":"shape","column_type":"BLOB"}]`,
+			rows:      `[{"id":1,"shape":"01010000006666666666766140CDCCCCCCCCCC4140"},{"id":2,"shape":"00A0000002000010E60000000240616000000000004041800000000000401400000000000040617000000000004041C000000000004018000000000000"},{"id":3,"shape":"0101"}]`,
+			wantQuery: `SELECT * REPLACE (hex("shape") AS "shape") FROM (`,
+			wantGeoms: []geometry{
+				{Type: "Point", Coordinates: []interface{}{139.7, 35.6}},
+				{Type: "LineString", Coordinates: []interface{}{[]interface{}{139.0, 35.0}, []interface{}{139.5, 35.5}}},
+			},
+			wantProps: []map[string]interface{}{{"id": 1.0}, {"id": 2.0}},
+		},
+		{
+			name:     "missing column",
+			geometry: GeometryOutput{Mode: "lonlat"},
+			describe: `[{"column_name":"x","column_type":"DOUBLE"},{"column_name":"y","column_type":"DOUBLE"}]`,
+			wantErr:  `spec.duckdb.output.geometry.lonColumn "lon" is not a column of the query result (columns: x, y)`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			t.Setenv("FAKE_DUCKDB_DIR", dir)
+			bin := filepath.Join(dir, "duckdb")
+			for name, content := range map[string]string{"duckdb": fakeDuckDB, "describe.json": tt.describe, "rows.json": tt.rows} {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			out := t.TempDir()
+			r := &Runner{OutDir: out, DuckDB: bin}
+			spec := &Spec{
+				Kind:  "duckdb.map.v1",
+				Title: "duckdb",
+				DuckDB: &DuckDBSpec{
+					Input:  InputSpec{URI: srv.URL + "/sample.parquet", Format: "parquet"},
+					SQL:    "SELECT * FROM read_parquet('${INPUT0}') WHERE value > 10;\n",
+					Output: DuckDBOutput{Geometry: tt.geometry},
+				},
+				Render: RenderSpec{BaseStyle: &BaseStyle{Type: "raster", Tiles: []string{"https://{a,b,c}.tile.openstreetmap.org/{z}/{x}/{y}.png"}}},
+			}
+			err := r.Run(context.Background(), spec)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("Run() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+
+			queries, err := os.ReadFile(filepath.Join(dir, "queries.sql"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !regexp.MustCompile(`read_parquet\('/[^']*/input0\.parquet'\) WHERE value > 10\n\) AS q;`).Match(queries) {
+				t.Fatalf("queries do not read the staged input:\n%s", queries)
+			}
+			if !strings.Contains(string(queries), tt.wantQuery) {
+				t.Fatalf("queries do not contain %q:\n%s", tt.wantQuery, queries)
+			}
+
+			raw, err := os.ReadFile(filepath.Join(out, dataFileName))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var fc struct {
+				Features []struct {
+					Properties map[string]interface{} `json:"properties"`
+					Geometry   geometry               `json:"geometry"`
+				} `json:"features"`
+			}
+			if err := json.Unmarshal(raw, &fc); err != nil {
+				t.Fatal(err)
+			}
+			var geoms []geometry
+			var props []map[string]interface{}
+			for _, f := range fc.Features {
+				geoms = append(geoms, f.Geometry)
+				props = append(props, f.Properties)
+			}
+			if !reflect.DeepEqual(geoms, tt.wantGeoms) {
+				t.Fatalf("geometries = %#v, want %#v", geoms, tt.wantGeoms)
+			}
+			if !reflect.DeepEqual(props, tt.wantProps) {
+				t.Fatalf("properties = %#v, want %#v", props, tt.wantProps)
+			}
+			html, err := os.ReadFile(filepath.Join(out, "index.html"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(html), `https://c.tile.openstreetmap.org/{z}/{x}/{y}.png`) {
+				t.Fatalf("index.html does not expand {a,b,c} subdomains:\n%s", html)
+			}
+		})
+	}
+}
+
+func TestSubstituteInputsRejectsUnknownIndex(t *testing.T) {
+	got, err := substituteInputs("SELECT * FROM '${INPUT0}' UNION ALL SELECT * FROM '${INPUT1}';", []string{"/tmp/it's/input0.csv"})
+	if err == nil || err.Error() != "spec.duckdb.sql references ${INPUT1} but only 1 input(s) are staged" {
+		t.Fatalf("substituteInputs() = %q, %v", got, err)
+	}
+	got, err = substituteInputs("SELECT * FROM '${INPUT0}';", []string{"/tmp/it's/input0.csv"})
+	if err != nil || got != "SELECT * FROM '/tmp/it''s/input0.csv'" {
+		t.Fatalf("substituteInputs() = %q, %v", got, err)
+	}
+}
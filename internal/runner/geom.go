@@ -0,0 +1,465 @@
+package runner
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// parseWKT decodes a WKT or EWKT (SRID=n;...) geometry into GeoJSON
+// coordinates. Z and M values are dropped.
+func parseWKT(s string) (geometry, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(strings.ToUpper(s), "SRID=") {
+		i := strings.IndexByte(s, ';')
+		if i < 0 {
+			return geometry{}, fmt.Errorf("EWKT is missing ';' after SRID")
+		}
+		s = s[i+1:]
+	}
+	p := &wktParser{s: s}
+	g, err := p.geometry()
+	if err != nil {
+		return geometry{}, err
+	}
+	if p.skipSpace(); p.pos != len(p.s) {
+		return geometry{}, fmt.Errorf("unexpected %q after geometry", p.rest())
+	}
+	return g, nil
+}
+
+type wktParser struct {
+	s   string
+	pos int
+}
+
+func (p *wktParser) skipSpace() {
+	for p.pos < len(p.s) && strings.ContainsRune(" \t\r\n", rune(p.s[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *wktParser) rest() string {
+	r := p.s[p.pos:]
+	if len(r) > 20 {
+		r = r[:20] + "..."
+	}
+	return r
+}
+
+func (p *wktParser) word() string {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		if (c < 'A' || c > 'Z') && (c < 'a' || c > 'z') {
+			break
+		}
+		p.pos++
+	}
+	return strings.ToUpper(p.s[start:p.pos])
+}
+
+func (p *wktParser) peek(c byte) bool {
+	p.skipSpace()
+	return p.pos < len(p.s) && p.s[p.pos] == c
+}
+
+func (p *wktParser) expect(c byte) error {
+	if !p.peek(c) {
+		return fmt.Errorf("expected %q at %q", c, p.rest())
+	}
+	p.pos++
+	return nil
+}
+
+func (p *wktParser) geometry() (geometry, error) {
+	typ := p.word()
+	var name string
+	var parse func() (interface{}, error)
+	switch typ {
+	case "POINT":
+		name, parse = "Point", func() (interface{}, error) {
+			if err := p.expect('('); err != nil {
+				return nil, err
+			}
+			pt, err := p.position()
+			if err != nil {
+				return nil, err
+			}
+			return pt, p.expect(')')
+		}
+	case "LINESTRING":
+		name, parse = "LineString", func() (interface{}, error) { return p.positions() }
+	case "POLYGON":
+		name, parse = "Polygon", func() (interface{}, error) { return p.rings() }
+	case "MULTIPOINT":
+		name, parse = "MultiPoint", p.multiPoint
+	case "MULTILINESTRING":
+		name, parse = "MultiLineString", func() (interface{}, error) { return p.rings() }
+	case "MULTIPOLYGON":
+		name, parse = "MultiPolygon", p.multiPolygon
+	case "":
+		return geometry{}, fmt.Errorf("expected a geometry type at %q", p.rest())
+	default:
+		return geometry{}, fmt.Errorf("unsupported WKT geometry type %q", typ)
+	}
+	save := p.pos
+	switch p.word() {
+	case "Z", "M", "ZM":
+		save = p.pos
+		if p.word() != "EMPTY" {
+			p.pos = save
+			break
+		}
+		fallthrough
+	case "EMPTY":
+		return geometry{}, fmt.Errorf("%s is empty", name)
+	default:
+		p.pos = save
+	}
+	coords, err := parse()
+	if err != nil {
+		return geometry{}, fmt.Errorf("%s: %w", name, err)
+	}
+	return geometry{Type: name, Coordinates: coords}, nil
+}
+
+func (p *wktParser) number() (float64, error) {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.s) && strings.IndexByte("+-.0123456789eE", p.s[p.pos]) >= 0 {
+		p.pos++
+	}
+	v, err := strconv.ParseFloat(p.s[start:p.pos], 64)
+	if err != nil {
+		p.pos = start
+		return 0, fmt.Errorf("expected a number at %q", p.rest())
+	}
+	return v, nil
+}
+
+func (p *wktParser) position() (position, error) {
+	x, err := p.number()
+	if err != nil {
+		return position{}, err
+	}
+	y, err := p.number()
+	if err != nil {
+		return position{}, err
+	}
+	// Skip Z and M.
+	for !p.peek(',') && !p.peek(')') && p.pos < len(p.s) {
+		if _, err := p.number(); err != nil {
+			return position{}, err
+		}
+	}
+	return position{x, y}, nil
+}
+
+func (p *wktParser) positions() ([]position, error) {
+	if err := p.expect('('); err != nil {
+		return nil, err
+	}
+	var out []position
+	for {
+		pt, err := p.position()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, pt)
+		if !p.peek(',') {
+			break
+		}
+		p.pos++
+	}
+	return out, p.expect(')')
+}
+
+func (p *wktParser) rings() ([][]position, error) {
+	if err := p.expect('('); err != nil {
+		return nil, err
+	}
+	var out [][]position
+	for {
+		ring, err := p.positions()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, ring)
+		if !p.peek(',') {
+			break
+		}
+		p.pos++
+	}
+	return out, p.expect(')')
+}
+
+// multiPoint accepts both MULTIPOINT ((1 2), (3 4)) and MULTIPOINT (1 2, 3 4).
+func (p *wktParser) multiPoint() (interface{}, error) {
+	if err := p.expect('('); err != nil {
+		return nil, err
+	}
+	var out []position
+	for {
+		nested := p.peek('(')
+		if nested {
+			p.pos++
+		}
+		pt, err := p.position()
+		if err != nil {
+			return nil, err
+		}
+		if nested {
+			if err := p.expect(')'); err != nil {
+				return nil, err
+			}
+		}
+		out = append(out, pt)
+		if !p.peek(',') {
+			break
+		}
+		p.pos++
+	}
+	return out, p.expect(')')
+}
+
+func (p *wktParser) multiPolygon() (interface{}, error) {
+	if err := p.expect('('); err != nil {
+		return nil, err
+	}
+	var out [][][]position
+	for {
+		poly, err := p.rings()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, poly)
+		if !p.peek(',') {
+			break
+		}
+		p.pos++
+	}
+	return out, p.expect(')')
+}
+
+// parseWKBHex decodes hex-encoded WKB, including PostGIS EWKB and ISO Z/M
+// variants, into GeoJSON coordinates.
+func parseWKBHex(s string) (geometry, error) {
+	raw, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(s), "\\x"))
+	if err != nil {
+		return geometry{}, fmt.Errorf("WKB is not valid hex: %v", err)
+	}
+	r := &wkbReader{b: raw}
+	g, err := r.geometry()
+	if err != nil {
+		return geometry{}, err
+	}
+	if r.pos != len(r.b) {
+		return geometry{}, fmt.Errorf("%d trailing bytes after WKB geometry", len(r.b)-r.pos)
+	}
+	return g, nil
+}
+
+type wkbReader struct {
+	b     []byte
+	pos   int
+	order binary.ByteOrder
+}
+
+var errShortWKB = fmt.Errorf("WKB is truncated")
+
+func (r *wkbReader) uint32() (uint32, error) {
+	if r.pos+4 > len(r.b) {
+		return 0, errShortWKB
+	}
+	v := r.order.Uint32(r.b[r.pos:])
+	r.pos += 4
+	return v, nil
+}
+
+func (r *wkbReader) float64() (float64, error) {
+	if r.pos+8 > len(r.b) {
+		return 0, errShortWKB
+	}
+	v := math.Float64frombits(r.order.Uint64(r.b[r.pos:]))
+	r.pos += 8
+	return v, nil
+}
+
+// header reads the byte order and type word and returns the base type and
+// the number of ordinates per position.
+func (r *wkbReader) header() (uint32, int, error) {
+	if r.pos >= len(r.b) {
+		return 0, 0, errShortWKB
+	}
+	switch r.b[r.pos] {
+	case 0:
+		r.order = binary.BigEndian
+	case 1:
+		r.order = binary.LittleEndian
+	default:
+		return 0, 0, fmt.Errorf("WKB has invalid byte order %d", r.b[r.pos])
+	}
+	r.pos++
+	typ, err := r.uint32()
+	if err != nil {
+		return 0, 0, err
+	}
+	dims := 2
+	if typ&0x80000000 != 0 {
+		dims++
+	}
+	if typ&0x40000000 != 0 {
+		dims++
+	}
+	if typ&0x20000000 != 0 {
+		if _, err := r.uint32(); err != nil {
+			return 0, 0, err
+		}
+	}
+	typ &= 0x0fffffff
+	switch typ / 1000 {
+	case 1, 2:
+		dims++
+	case 3:
+		dims += 2
+	}
+	return typ % 1000, dims, nil
+}
+
+func (r *wkbReader) position(dims int) (position, error) {
+	var pt position
+	for i := 0; i < dims; i++ {
+		v, err := r.float64()
+		if err != nil {
+			return position{}, err
+		}
+		if i < 2 {
+			pt[i] = v
+		}
+	}
+	return pt, nil
+}
+
+func (r *wkbReader) positions(dims int) ([]position, error) {
+	n, err := r.uint32()
+	if err != nil {
+		return nil, err
+	}
+	if int(n) > (len(r.b)-r.pos)/(8*dims) {
+		return nil, errShortWKB
+	}
+	out := make([]position, 0, n)
+	for i := uint32(0); i < n; i++ {
+		pt, err := r.position(dims)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, pt)
+	}
+	return out, nil
+}
+
+func (r *wkbReader) rings(dims int) ([][]position, error) {
+	n, err := r.uint32()
+	if err != nil {
+		return nil, err
+	}
+	var out [][]position
+	for i := uint32(0); i < n; i++ {
+		ring, err := r.positions(dims)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, ring)
+	}
+	return out, nil
+}
+
+// parts reads the member geometries of a Multi* geometry, which must all
+// have type want.
+func (r *wkbReader) parts(want uint32) ([]interface{}, error) {
+	n, err := r.uint32()
+	if err != nil {
+		return nil, err
+	}
+	var out []interface{}
+	for i := uint32(0); i < n; i++ {
+		g, err := r.geometry()
+		if err != nil {
+			return nil, err
+		}
+		if wkbTypes[want] != g.Type {
+			return nil, fmt.Errorf("WKB %s member is a %s", wkbTypes[want+3], g.Type)
+		}
+		out = append(out, g.Coordinates)
+	}
+	return out, nil
+}
+
+var wkbTypes = map[uint32]string{
+	1: "Point", 2: "LineString", 3: "Polygon",
+	4: "MultiPoint", 5: "MultiLineString", 6: "MultiPolygon",
+}
+
+func (r *wkbReader) geometry() (geometry, error) {
+	typ, dims, err := r.header()
+	if err != nil {
+		return geometry{}, err
+	}
+	name, ok := wkbTypes[typ]
+	if !ok {
+		return geometry{}, fmt.Errorf("unsupported WKB geometry type %d", typ)
+	}
+	var coords interface{}
+	switch typ {
+	case 1:
+		pt, err := r.position(dims)
+		if err != nil {
+			return geometry{}, err
+		}
+		if math.IsNaN(pt[0]) || math.IsNaN(pt[1]) {
+			return geometry{}, fmt.Errorf("Point is empty")
+		}
+		coords = pt
+	case 2:
+		coords, err = r.positions(dims)
+	case 3:
+		coords, err = r.rings(dims)
+	case 4:
+		var parts []interface{}
+		if parts, err = r.parts(1); err == nil {
+			pts := make([]position, len(parts))
+			for i, p := range parts {
+				pts[i] = p.(position)
+			}
+			coords = pts
+		}
+	case 5:
+		var parts []interface{}
+		if parts, err = r.parts(2); err == nil {
+			lines := make([][]position, len(parts))
+			for i, p := range parts {
+				lines[i] = p.([]position)
+			}
+			coords = lines
+		}
+	case 6:
+		var parts []interface{}
+		if parts, err = r.parts(3); err == nil {
+			polys := make([][][]position, len(parts))
+			for i, p := range parts {
+				polys[i] = p.([][]position)
+			}
+			coords = polys
+		}
+	}
+	if err != nil {
+		return geometry{}, fmt.Errorf("%s: %w", name, err)
+	}
+	return geometry{Type: name, Coordinates: coords}, nil
+}
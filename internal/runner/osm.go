@@ -0,0 +1,380 @@
+package runner
+
+import "fmt"
+
+const dataFileName = "data.geojson"
+
+type osmResponse struct {
+	Remark   string       `json:"remark,omitempty"`
+	Elements []osmElement `json:"elements"`
+}
+
+type osmElement struct {
+	Type     string            `json:"type"`
+	ID       int64             `json:"id"`
+	Lat      *float64          `json:"lat,omitempty"`
+	Lon      *float64          `json:"lon,omitempty"`
+	Tags     map[string]string `json:"tags,omitempty"`
+	Nodes    []int64           `json:"nodes,omitempty"`
+	Geometry []*osmLatLon      `json:"geometry,omitempty"`
+	Members  []osmMember       `json:"members,omitempty"`
+	Center   *osmLatLon        `json:"center,omitempty"`
+}
+
+// osmLatLon is nil inside "out geom" geometries for nodes outside the bbox.
+type osmLatLon struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+type osmMember struct {
+	Type     string       `json:"type"`
+	Ref      int64        `json:"ref"`
+	Role     string       `json:"role"`
+	Geometry []*osmLatLon `json:"geometry,omitempty"`
+}
+
+type featureCollection struct {
+	Type     string    `json:"type"`
+	Features []feature `json:"features"`
+}
+
+type feature struct {
+	Type       string                 `json:"type"`
+	ID         string                 `json:"id,omitempty"`
+	Properties map[string]interface{} `json:"properties"`
+	Geometry   geometry               `json:"geometry"`
+}
+
+type geometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+type position = [2]float64
+
+type conversionStats struct {
+	points, lines, polygons, skippedRelations int
+}
+
+// osmToGeoJSON converts an Overpass JSON response into an RFC 7946
+// FeatureCollection. Ways are assembled from inline geometry ("out geom")
+// or from referenced nodes; multipolygon and boundary relations are
+// assembled from their member ways into (Multi)Polygons.
+func osmToGeoJSON(resp *osmResponse) (featureCollection, conversionStats) {
+	nodes := map[int64]position{}
+	ways := map[int64][]position{}
+	referenced := map[string]bool{}
+	for _, el := range resp.Elements {
+		if el.Type == "node" && el.Lat != nil && el.Lon != nil {
+			nodes[el.ID] = position{*el.Lon, *el.Lat}
+		}
+	}
+	for _, el := range resp.Elements {
+		switch el.Type {
+		case "way":
+			for _, ref := range el.Nodes {
+				referenced[fmt.Sprintf("node/%d", ref)] = true
+			}
+			if coords := wayCoords(el, nodes); len(coords) >= 2 {
+				ways[el.ID] = coords
+			}
+		case "relation":
+			for _, m := range el.Members {
+				if m.Type == "way" && isAreaRelation(el.Tags) {
+					referenced[fmt.Sprintf("way/%d", m.Ref)] = true
+				}
+			}
+		}
+	}
+
+	fc := featureCollection{Type: "FeatureCollection", Features: []feature{}}
+	var stats conversionStats
+	add := func(el osmElement, g geometry) {
+		switch g.Type {
+		case "Point":
+			stats.points++
+		case "LineString":
+			stats.lines++
+		default:
+			stats.polygons++
+		}
+		props := map[string]interface{}{}
+		for k, v := range el.Tags {
+			props[k] = v
+		}
+		fc.Features = append(fc.Features, feature{
+			Type:       "Feature",
+			ID:         fmt.Sprintf("%s/%d", el.Type, el.ID),
+			Properties: props,
+			Geometry:   g,
+		})
+	}
+
+	for _, el := range resp.Elements {
+		id := fmt.Sprintf("%s/%d", el.Type, el.ID)
+		switch el.Type {
+		case "node":
+			// Untagged nodes that only shape a way are not features.
+			if el.Lat == nil || el.Lon == nil || (len(el.Tags) == 0 && referenced[id]) {
+				continue
+			}
+			add(el, geometry{Type: "Point", Coordinates: position{*el.Lon, *el.Lat}})
+		case "way":
+			coords, ok := ways[el.ID]
+			if !ok {
+				if el.Center != nil {
+					add(el, geometry{Type: "Point", Coordinates: position{el.Center.Lon, el.Center.Lat}})
+				}
+				continue
+			}
+			// Untagged outer/inner ways are drawn by their relation.
+			if len(el.Tags) == 0 && referenced[id] {
+				continue
+			}
+			if isClosed(coords) && len(coords) >= 4 && isAreaWay(el.Tags) {
+				add(el, geometry{Type: "Polygon", Coordinates: [][]position{orientRing(coords, true)}})
+				continue
+			}
+			add(el, geometry{Type: "LineString", Coordinates: coords})
+		case "relation":
+			if isAreaRelation(el.Tags) {
+				if g, ok := relationPolygon(el, ways); ok {
+					add(el, g)
+					continue
+				}
+			}
+			if el.Center != nil {
+				add(el, geometry{Type: "Point", Coordinates: position{el.Center.Lon, el.Center.Lat}})
+				continue
+			}
+			stats.skippedRelations++
+		}
+	}
+	return fc, stats
+}
+
+func wayCoords(el osmElement, nodes map[int64]position) []position {
+	if len(el.Geometry) > 0 {
+		return latLonCoords(el.Geometry)
+	}
+	coords := make([]position, 0, len(el.Nodes))
+	for _, ref := range el.Nodes {
+		if p, ok := nodes[ref]; ok {
+			coords = append(coords, p)
+		}
+	}
+	return coords
+}
+
+func latLonCoords(points []*osmLatLon) []position {
+	coords := make([]position, 0, len(points))
+	for _, p := range points {
+		if p != nil {
+			coords = append(coords, position{p.Lon, p.Lat})
+		}
+	}
+	return coords
+}
+
+func isClosed(coords []position) bool {
+	return len(coords) > 1 && coords[0] == coords[len(coords)-1]
+}
+
+func isAreaRelation(tags map[string]string) bool {
+	t := tags["type"]
+	return t == "multipolygon" || t == "boundary"
+}
+
+// areaKeys are keys whose closed ways are areas unless area=no, after the
+// osm2pgsql/iD conventions.
+var areaKeys = map[string]bool{
+	"amenity": true, "building": true, "building:part": true, "landuse": true,
+	"leisure": true, "natural": true, "place": true, "shop": true,
+	"tourism": true, "historic": true, "man_made": true, "military": true,
+	"aeroway": true, "office": true, "craft": true, "boundary": true,
+	"water": true, "wetland": true, "area:highway": true, "public_transport": true,
+}
+
+// linearValues are tag values that stay lines even on area keys.
+var linearValues = map[string]bool{
+	"natural=coastline": true, "natural=cliff": true, "natural=ridge": true,
+	"natural=tree_row": true, "man_made=pipeline": true, "man_made=embankment": true,
+	"leisure=track": true, "leisure=slipway": true, "barrier=fence": true,
+}
+
+func isAreaWay(tags map[string]string) bool {
+	switch tags["area"] {
+	case "yes":
+		return true
+	case "no":
+		return false
+	}
+	for k, v := range tags {
+		if linearValues[k+"="+v] {
+			return false
+		}
+	}
+	for k, v := range tags {
+		if areaKeys[k] && v != "no" {
+			return true
+		}
+	}
+	return false
+}
+
+// relationPolygon assembles the outer and inner member ways of a
+// multipolygon relation into rings, then assigns each inner ring to the
+// outer ring that contains it.
+func relationPolygon(el osmElement, ways map[int64][]position) (geometry, bool) {
+	var outerParts, innerParts [][]position
+	for _, m := range el.Members {
+		if m.Type != "way" {
+			continue
+		}
+		coords := latLonCoords(m.Geometry)
+		if len(coords) < 2 {
+			coords = ways[m.Ref]
+		}
+		if len(coords) < 2 {
+			continue
+		}
+		if m.Role == "inner" {
+			innerParts = append(innerParts, coords)
+		} else {
+			outerParts = append(outerParts, coords)
+		}
+	}
+	outers := assembleRings(outerParts)
+	if len(outers) == 0 {
+		return geometry{}, false
+	}
+	inners := assembleRings(innerParts)
+
+	polygons := make([][][]position, len(outers))
+	for i, outer := range outers {
+		polygons[i] = [][]position{orientRing(outer, true)}
+	}
+	for _, inner := range inners {
+		for i, outer := range outers {
+			if pointInRing(inner[0], outer) {
+				polygons[i] = append(polygons[i], orientRing(inner, false))
+				break
+			}
+		}
+	}
+	if len(polygons) == 1 {
+		return geometry{Type: "Polygon", Coordinates: polygons[0]}, true
+	}
+	return geometry{Type: "MultiPolygon", Coordinates: polygons}, true
+}
+
+// assembleRings joins way segments end to end into closed rings. Segments
+// that never close are dropped.
+func assembleRings(parts [][]position) [][]position {
+	remaining := make([][]position, 0, len(parts))
+	for _, p := range parts {
+		remaining = append(remaining, append([]position(nil), p...))
+	}
+	var rings [][]position
+	for len(remaining) > 0 {
+		ring := remaining[0]
+		remaining = remaining[1:]
+		for !isClosed(ring) {
+			joined := false
+			for i, part := range remaining {
+				head, tail := ring[0], ring[len(ring)-1]
+				switch {
+				case part[0] == tail:
+					ring = append(ring, part[1:]...)
+				case part[len(part)-1] == tail:
+					ring = append(ring, reversed(part)[1:]...)
+				case part[len(part)-1] == head:
+					ring = append(append([]position(nil), part...), ring[1:]...)
+				case part[0] == head:
+					ring = append(reversed(part), ring[1:]...)
+				default:
+					continue
+				}
+				remaining = append(remaining[:i], remaining[i+1:]...)
+				joined = true
+				break
+			}
+			if !joined {
+				break
+			}
+		}
+		if isClosed(ring) && len(ring) >= 4 {
+			rings = append(rings, ring)
+		}
+	}
+	return rings
+}
+
+func reversed(coords []position) []position {
+	out := make([]position, len(coords))
+	for i, p := range coords {
+		out[len(coords)-1-i] = p
+	}
+	return out
+}
+
+// signedArea is positive for counterclockwise rings.
+func signedArea(ring []position) float64 {
+	var sum float64
+	for i := 0; i+1 < len(ring); i++ {
+		sum += ring[i][0]*ring[i+1][1] - ring[i+1][0]*ring[i][1]
+	}
+	return sum / 2
+}
+
+// orientRing follows the RFC 7946 right-hand rule: exterior rings are
+// counterclockwise and holes are clockwise.
+func orientRing(ring []position, exterior bool) []position {
+	if (signedArea(ring) > 0) == exterior {
+		return ring
+	}
+	return reversed(ring)
+}
+
+func pointInRing(p position, ring []position) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		if (a[1] > p[1]) != (b[1] > p[1]) && p[0] < (b[0]-a[0])*(p[1]-a[1])/(b[1]-a[1])+a[0] {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// bounds returns [west, south, east, north] of every coordinate in fc.
+func (fc featureCollection) bounds() ([4]float64, bool) {
+	b := [4]float64{180, 90, -180, -90}
+	found := false
+	var visit func(v interface{})
+	visit = func(v interface{}) {
+		switch c := v.(type) {
+		case position:
+			b[0], b[1] = min(b[0], c[0]), min(b[1], c[1])
+			b[2], b[3] = max(b[2], c[0]), max(b[3], c[1])
+			found = true
+		case []position:
+			for _, p := range c {
+				visit(p)
+			}
+		case [][]position:
+			for _, r := range c {
+				visit(r)
+			}
+		case [][][]position:
+			for _, poly := range c {
+				visit(poly)
+			}
+		}
+	}
+	for _, f := range fc.Features {
+		visit(f.Geometry.Coordinates)
+	}
+	return b, found
+}
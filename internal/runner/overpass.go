@@ -0,0 +1,88 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+func (r *Runner) runOverpass(ctx context.Context, spec *Spec) error {
+	if spec.Overpass == nil || strings.TrimSpace(spec.Overpass.Endpoint) == "" || strings.TrimSpace(spec.Overpass.Query) == "" {
+		return fmt.Errorf("spec.overpass.endpoint and spec.overpass.query are required")
+	}
+	resp, err := r.fetchOverpass(ctx, spec.Overpass.Endpoint, spec.Overpass.Query)
+	if err != nil {
+		return err
+	}
+	fc, stats := osmToGeoJSON(resp)
+	r.logger().Info("converted overpass response",
+		"elements", len(resp.Elements),
+		"features", len(fc.Features),
+		"points", stats.points,
+		"lines", stats.lines,
+		"polygons", stats.polygons,
+		"skippedRelations", stats.skippedRelations,
+	)
+	if err := r.writeMapArtifacts(spec, fc); err != nil {
+		return err
+	}
+	if len(fc.Features) == 0 {
+		return fmt.Errorf("overpass query returned no features")
+	}
+	return nil
+}
+
+// fetchOverpass posts query to endpoint, retrying rate limits, gateway
+// errors, network errors and Overpass runtime errors.
+func (r *Runner) fetchOverpass(ctx context.Context, endpoint, query string) (*osmResponse, error) {
+	var resp *osmResponse
+	err := r.retry(ctx, "overpass request", func() error {
+		var err error
+		resp, err = r.fetchOverpassOnce(ctx, endpoint, query)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("overpass request to %s failed: %w", endpoint, err)
+	}
+	return resp, nil
+}
+
+func (r *Runner) fetchOverpassOnce(ctx context.Context, endpoint, query string) (*osmResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(url.Values{"data": {query}}.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", "nereid-runner")
+	res, err := r.client().Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		return nil, &retryableError{err: err}
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, &retryableError{err: fmt.Errorf("read response: %w", err)}
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil, httpStatusError(res, body)
+	}
+
+	var out osmResponse
+	if err := json.Unmarshal(body, &out); err != nil {
+		return nil, fmt.Errorf("decode overpass JSON (is the query missing [out:json]?): %w", err)
+	}
+	// Overpass reports timeouts and memory exhaustion in "remark" with a
+	// 200 status and a truncated result.
+	if strings.Contains(out.Remark, "runtime error") {
+		return nil, &retryableError{err: fmt.Errorf("overpass %s", out.Remark), summary: "overpass reported a runtime error in remark"}
+	}
+	return &out, nil
+}
@@ -0,0 +1,165 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const overpassFixture = `{
+  "version": 0.6,
+  "elements": [
+    {"type": "node", "id": 1, "lat": 35.6, "lon": 139.7, "tags": {"amenity": "cafe", "name": "Cafe"}},
+    {"type": "node", "id": 2, "lat": 35.0, "lon": 139.0},
+    {"type": "node", "id": 3, "lat": 35.0, "lon": 139.1},
+    {"type": "node", "id": 4, "lat": 35.1, "lon": 139.1},
+    {"type": "node", "id": 5, "lat": 35.1, "lon": 139.0},
+    {"type": "way", "id": 10, "nodes": [2, 5, 4, 3, 2], "tags": {"leisure": "park"}},
+    {"type": "way", "id": 20, "tags": {"highway": "primary"},
+     "geometry": [{"lat": 35.0, "lon": 139.5}, {"lat": 35.1, "lon": 139.6}, {"lat": 35.2, "lon": 139.6}]},
+    {"type": "way", "id": 31, "geometry": [{"lat": 0, "lon": 0}, {"lat": 0, "lon": 2}, {"lat": 2, "lon": 2}]},
+    {"type": "relation", "id": 30, "tags": {"type": "multipolygon", "natural": "water"}, "members": [
+      {"type": "way", "ref": 31, "role": "outer",
+       "geometry": [{"lat": 0, "lon": 0}, {"lat": 0, "lon": 2}, {"lat": 2, "lon": 2}]},
+      {"type": "way", "ref": 32, "role": "outer",
+       "geometry": [{"lat": 0, "lon": 0}, {"lat": 2, "lon": 0}, {"lat": 2, "lon": 2}]},
+      {"type": "way", "ref": 33, "role": "inner",
+       "geometry": [{"lat": 0.5, "lon": 0.5}, {"lat": 0.5, "lon": 1}, {"lat": 1, "lon": 1}, {"lat": 1, "lon": 0.5}, {"lat": 0.5, "lon": 0.5}]}
+    ]}
+  ]
+}`
+
+func TestRunOverpassRetriesAndWritesGeoJSON(t *testing.T) {
+	const query = "[out:json];nwr(35,139,36,140);out geom;"
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if got := r.FormValue("data"); got != query {
+			t.Errorf("data = %q, want %q", got, query)
+		}
+		switch calls {
+		case 1:
+			w.Header().Set("Retry-After", "7")
+			http.Error(w, "rate limited", http.StatusTooManyRequests)
+		case 2:
+			w.Write([]byte(`{"elements": [], "remark": "runtime error: Query timed out in \"query\" at line 1 after 25 seconds."}`))
+		default:
+			w.Write([]byte(overpassFixture))
+		}
+	}))
+	defer srv.Close()
+
+	var delays []time.Duration
+	out := t.TempDir()
+	r := &Runner{
+		OutDir:  out,
+		Backoff: time.Second,
+		sleep: func(ctx context.Context, d time.Duration) error {
+			delays = append(delays, d)
+			return nil
+		},
+	}
+	spec := &Spec{
+		Kind:     "overpassql.map.v1",
+		Title:    "parks",
+		Overpass: &OverpassSpec{Endpoint: srv.URL, Query: query},
+		Render:   RenderSpec{Viewport: &Viewport{Center: []float64{139.7, 35.6}, Zoom: 12}},
+	}
+	if err := r.Run(context.Background(), spec); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if calls != 3 {
+		t.Fatalf("overpass calls = %d, want 3", calls)
+	}
+	if want := []time.Duration{7 * time.Second, 2 * time.Second}; !reflect.DeepEqual(delays, want) {
+		t.Fatalf("retry delays = %v, want %v", delays, want)
+	}
+
+	raw, err := os.ReadFile(filepath.Join(out, "data.geojson"))
+	if err != nil {
+		t.Fatalf("read data.geojson: %v", err)
+	}
+	var fc struct {
+		Type     string `json:"type"`
+		Features []struct {
+			ID       string            `json:"id"`
+			Props    map[string]string `json:"properties"`
+			Geometry struct {
+				Type        string          `json:"type"`
+				Coordinates json.RawMessage `json:"coordinates"`
+			} `json:"geometry"`
+		} `json:"features"`
+	}
+	if err := json.Unmarshal(raw, &fc); err != nil {
+		t.Fatalf("decode data.geojson: %v", err)
+	}
+	got := map[string]string{}
+	coords := map[string]string{}
+	for _, f := range fc.Features {
+		got[f.ID] = f.Geometry.Type
+		coords[f.ID] = string(f.Geometry.Coordinates)
+	}
+	want := map[string]string{
+		"node/1":      "Point",
+		"way/10":      "Polygon",
+		"way/20":      "LineString",
+		"relation/30": "Polygon",
+	}
+	if fc.Type != "FeatureCollection" || !reflect.DeepEqual(got, want) {
+		t.Fatalf("features = %v, want %v", got, want)
+	}
+	// Exterior rings are counterclockwise and holes clockwise.
+	if want := `[[[139,35],[139.1,35],[139.1,35.1],[139,35.1],[139,35]]]`; coords["way/10"] != want {
+		t.Fatalf("way/10 coordinates = %s, want %s", coords["way/10"], want)
+	}
+	if want := `[[[0,0],[2,0],[2,2],[0,2],[0,0]],[[0.5,0.5],[0.5,1],[1,1],[1,0.5],[0.5,0.5]]]`; coords["relation/30"] != want {
+		t.Fatalf("relation/30 coordinates = %s, want %s", coords["relation/30"], want)
+	}
+
+	html, err := os.ReadFile(filepath.Join(out, "index.html"))
+	if err != nil {
+		t.Fatalf("read index.html: %v", err)
+	}
+	for _, needle := range []string{
+		"maplibre-gl@5/dist/maplibre-gl.js",
+		`center: [139.7,35.6]`,
+		`zoom:  12 `,
+		`data: "./data.geojson"`,
+		`"https://tile.yuiseki.net/styles/osm-bright/style.json"`,
+	} {
+		if !strings.Contains(string(html), needle) {
+			t.Fatalf("index.html missing %q:\n%s", needle, html)
+		}
+	}
+	if strings.Contains(string(html), "fitBounds") {
+		t.Fatalf("index.html should keep spec.render.viewport instead of fitting the data")
+	}
+}
+
+func TestRunOverpassDoesNotRetryBadQuery(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		http.Error(w, "Error: line 1: parse error", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	r := &Runner{OutDir: t.TempDir(), sleep: func(context.Context, time.Duration) error { return nil }}
+	err := r.Run(context.Background(), &Spec{
+		Kind:     "overpassql.map.v1",
+		Overpass: &OverpassSpec{Endpoint: srv.URL, Query: "nonsense"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "HTTP 400 Bad Request: Error: line 1: parse error") {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("overpass calls = %d, want 1", calls)
+	}
+}
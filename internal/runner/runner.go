@@ -0,0 +1,216 @@
+// Package runner implements nereid-runner, the deterministic executors that
+// turn a legacy Work spec into artifacts without an LLM agent.
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Spec is the subset of Work.spec the native executors read.
+type Spec struct {
+	Kind     string        `json:"kind"`
+	Title    string        `json:"title"`
+	Overpass *OverpassSpec `json:"overpass,omitempty"`
+	DuckDB   *DuckDBSpec   `json:"duckdb,omitempty"`
+	Render   RenderSpec    `json:"render"`
+}
+
+type OverpassSpec struct {
+	Endpoint string `json:"endpoint"`
+	Query    string `json:"query"`
+}
+
+type DuckDBSpec struct {
+	Input  InputSpec    `json:"input"`
+	SQL    string       `json:"sql"`
+	Output DuckDBOutput `json:"output"`
+}
+
+type InputSpec struct {
+	URI    string `json:"uri"`
+	Format string `json:"format"`
+}
+
+type DuckDBOutput struct {
+	Geometry GeometryOutput `json:"geometry"`
+}
+
+// GeometryOutput says how result rows become geometries: mode lonlat reads
+// LonColumn/LatColumn, wkt and wkb read Column.
+type GeometryOutput struct {
+	Mode      string `json:"mode"`
+	LonColumn string `json:"lonColumn"`
+	LatColumn string `json:"latColumn"`
+	Column    string `json:"column"`
+}
+
+type RenderSpec struct {
+	Viewport  *Viewport  `json:"viewport,omitempty"`
+	BaseStyle *BaseStyle `json:"baseStyle,omitempty"`
+}
+
+type Viewport struct {
+	Center []float64 `json:"center"`
+	Zoom   float64   `json:"zoom"`
+}
+
+type BaseStyle struct {
+	Type     string   `json:"type"`
+	Tiles    []string `json:"tiles"`
+	TileSize int      `json:"tileSize"`
+}
+
+// Runner executes one Work spec and writes its artifacts into OutDir.
+type Runner struct {
+	OutDir string
+	Client *http.Client
+	Logger *slog.Logger
+
+	// MaxAttempts and Backoff control retries of remote fetches; Backoff
+	// doubles per attempt.
+	MaxAttempts int
+	Backoff     time.Duration
+
+	// DuckDB is the duckdb CLI; empty means "duckdb" on PATH.
+	DuckDB string
+	// sleep waits between retries; tests replace it.
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+const (
+	defaultMaxAttempts = 4
+	defaultBackoff     = 2 * time.Second
+	maxBackoff         = time.Minute
+)
+
+// ReadSpec loads a Work spec written as JSON by the controller.
+func ReadSpec(path string) (*Spec, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var spec Spec
+	if err := json.Unmarshal(raw, &spec); err != nil {
+		return nil, fmt.Errorf("decode spec %s: %w", path, err)
+	}
+	return &spec, nil
+}
+
+// Supports reports whether kind has a native executor.
+func Supports(kind string) bool {
+	switch kind {
+	case "overpassql.map.v1", "duckdb.map.v1":
+		return true
+	default:
+		return false
+	}
+}
+
+// Run dispatches spec to the executor for its kind.
+func (r *Runner) Run(ctx context.Context, spec *Spec) error {
+	if r.OutDir == "" {
+		return fmt.Errorf("output directory is required")
+	}
+	if err := os.MkdirAll(r.OutDir, 0o755); err != nil {
+		return err
+	}
+	switch spec.Kind {
+	case "overpassql.map.v1":
+		return r.runOverpass(ctx, spec)
+	case "duckdb.map.v1":
+		return r.runDuckDB(ctx, spec)
+	default:
+		return fmt.Errorf("no native executor for spec.kind=%q", spec.Kind)
+	}
+}
+
+func (r *Runner) logger() *slog.Logger {
+	if r.Logger == nil {
+		return slog.Default()
+	}
+	return r.Logger
+}
+
+func (r *Runner) client() *http.Client {
+	if r.Client == nil {
+		return &http.Client{Timeout: 5 * time.Minute}
+	}
+	return r.Client
+}
+
+// retryDelay returns the wait before the attempt after the given one.
+func (r *Runner) retryDelay(attempt int) time.Duration {
+	d := r.Backoff
+	if d <= 0 {
+		d = defaultBackoff
+	}
+	for i := 1; i < attempt && d < maxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, maxBackoff)
+}
+
+func (r *Runner) maxAttempts() int {
+	if r.MaxAttempts <= 0 {
+		return defaultMaxAttempts
+	}
+	return r.MaxAttempts
+}
+
+// retry calls fn until it succeeds, fails with an error that is not a
+// *retryableError, or runs out of attempts. Delays grow exponentially and
+// honour a longer Retry-After.
+func (r *Runner) retry(ctx context.Context, what string, fn func() error) error {
+	attempts := r.maxAttempts()
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		var retryable *retryableError
+		if !errors.As(err, &retryable) || attempt == attempts {
+			break
+		}
+		delay := r.retryDelay(attempt)
+		if retryable.retryAfter > delay {
+			delay = min(retryable.retryAfter, maxBackoff)
+		}
+		attrs := append([]interface{}{"attempt", attempt, "maxAttempts", attempts, "delay", delay.String()}, retryable.logAttrs()...)
+		r.logger().Warn(what+" failed; retrying", attrs...)
+		if waitErr := r.wait(ctx, delay); waitErr != nil {
+			return waitErr
+		}
+	}
+	return err
+}
+
+func (r *Runner) wait(ctx context.Context, d time.Duration) error {
+	if r.sleep != nil {
+		return r.sleep(ctx, d)
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// writeJSON writes v as compact JSON to name in the output directory.
+func (r *Runner) writeJSON(name string, v interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(r.OutDir, name), append(raw, '\n'), 0o644)
+}
@@ -0,0 +1,339 @@
+package runner
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	defaultStyleURL  = "https://tile.yuiseki.net/styles/osm-bright/style.json"
+	maplibreVersion  = "5"
+	cesiumVersion    = "1.123.0"
+	defaultImagery   = "https://tile.openstreetmap.org/{z}/{x}/{y}.png"
+	defaultTileSize  = 256
+	fitBoundsPadding = 40
+)
+
+var subdomainList = regexp.MustCompile(`\{[^{}/]*,[^{}/]*\}`)
+
+var mapViewerTemplate = template.Must(template.New("index.html").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<link rel="stylesheet" href="https://unpkg.com/maplibre-gl@{{.Version}}/dist/maplibre-gl.css">
+<script src="https://unpkg.com/maplibre-gl@{{.Version}}/dist/maplibre-gl.js"></script>
+<style>html,body,#map{margin:0;height:100%;width:100%}</style>
+</head>
+<body>
+<div id="map"></div>
+<script>
+const map = new maplibregl.Map({
+  container: "map",
+  style: {{.Style}},
+  center: {{.Center}},
+  zoom: {{.Zoom}},
+  hash: true,
+});
+map.addControl(new maplibregl.NavigationControl());
+map.on("load", () => {
+  map.addSource("data", {type: "geojson", data: {{.Data}}});
+  map.addLayer({id: "data-fill", type: "fill", source: "data",
+    filter: ["in", ["geometry-type"], ["literal", ["Polygon", "MultiPolygon"]]],
+    paint: {"fill-color": "#e63946", "fill-opacity": 0.3}});
+  map.addLayer({id: "data-line", type: "line", source: "data",
+    filter: ["in", ["geometry-type"], ["literal", ["LineString", "MultiLineString", "Polygon", "MultiPolygon"]]],
+    paint: {"line-color": "#e63946", "line-width": 2}});
+  map.addLayer({id: "data-point", type: "circle", source: "data",
+    filter: ["in", ["geometry-type"], ["literal", ["Point", "MultiPoint"]]],
+    paint: {"circle-color": "#e63946", "circle-radius": 5, "circle-stroke-color": "#fff", "circle-stroke-width": 1}});
+{{- if .Bounds}}
+  map.fitBounds({{.Bounds}}, {padding: {{.Padding}}, maxZoom: 17, animate: false});
+{{- end}}
+  for (const layer of ["data-fill", "data-line", "data-point"]) {
+    map.on("click", layer, (e) => {
+      const f = e.features[0];
+      const rows = Object.entries(f.properties).map(([k, v]) => {
+        const tr = document.createElement("tr");
+        for (const text of [k, v]) {
+          const td = document.createElement("td");
+          td.textContent = text;
+          tr.appendChild(td);
+        }
+        return tr;
+      });
+      const table = document.createElement("table");
+      table.append(...rows);
+      new maplibregl.Popup().setLngLat(e.lngLat).setDOMContent(table).addTo(map);
+    });
+    map.on("mouseenter", layer, () => { map.getCanvas().style.cursor = "pointer"; });
+    map.on("mouseleave", layer, () => { map.getCanvas().style.cursor = ""; });
+  }
+});
+</script>
+</body>
+</html>
+`))
+
+type mapViewer struct {
+	Title   string
+	Version string
+	Style   interface{}
+	Center  []float64
+	Zoom    float64
+	Data    string
+	// Bounds is set only when spec.render.viewport is absent, so the map
+	// frames the fetched data instead.
+	Bounds  []float64
+	Padding int
+}
+
+// writeMapArtifacts writes fc as data.geojson together with its viewer.
+func (r *Runner) writeMapArtifacts(spec *Spec, fc featureCollection) error {
+	if err := r.writeJSON(dataFileName, fc); err != nil {
+		return fmt.Errorf("write %s: %w", dataFileName, err)
+	}
+	if err := r.writeMapViewer(spec, fc); err != nil {
+		return fmt.Errorf("write index.html: %w", err)
+	}
+	return nil
+}
+
+// writeMapViewer writes a MapLibre index.html that draws data.geojson on
+// spec.render.baseStyle at spec.render.viewport.
+func (r *Runner) writeMapViewer(spec *Spec, fc featureCollection) error {
+	v := mapViewer{
+		Title:   spec.Title,
+		Version: maplibreVersion,
+		Style:   baseStyle(spec.Render.BaseStyle),
+		Center:  []float64{0, 0},
+		Zoom:    1,
+		Data:    "./" + dataFileName,
+		Padding: fitBoundsPadding,
+	}
+	if v.Title == "" {
+		v.Title = spec.Kind
+	}
+	if vp := spec.Render.Viewport; vp != nil && len(vp.Center) == 2 {
+		v.Center, v.Zoom = vp.Center, vp.Zoom
+	} else if b, ok := fc.bounds(); ok {
+		v.Bounds = b[:]
+		v.Center = []float64{(b[0] + b[2]) / 2, (b[1] + b[3]) / 2}
+	}
+
+	f, err := os.Create(filepath.Join(r.OutDir, "index.html"))
+	if err != nil {
+		return err
+	}
+	if err := mapViewerTemplate.Execute(f, v); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+var rasterViewerTemplate = template.Must(template.New("index.html").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<link rel="stylesheet" href="https://unpkg.com/maplibre-gl@{{.Version}}/dist/maplibre-gl.css">
+<script src="https://unpkg.com/maplibre-gl@{{.Version}}/dist/maplibre-gl.js"></script>
+<style>html,body,#map{margin:0;height:100%;width:100%}#cog{position:absolute;left:10px;bottom:30px;background:#fff;padding:4px 8px;font:12px sans-serif}</style>
+</head>
+<body>
+<div id="map"></div>
+{{- if .COG}}
+<a id="cog" href="{{.COG}}" download>Cloud-Optimized GeoTIFF</a>
+{{- end}}
+<script>
+const map = new maplibregl.Map({
+  container: "map",
+  style: {{.Style}},
+  center: {{.Center}},
+  zoom: {{.Zoom}},
+  hash: true,
+});
+map.addControl(new maplibregl.NavigationControl());
+map.on("load", async () => {
+  map.addSource("raster", {type: "raster", tiles: [{{.Tiles}}], tileSize: 256, scheme: "xyz",
+    minzoom: {{.MinZoom}}, maxzoom: {{.MaxZoom}}});
+  map.addLayer({id: "raster", type: "raster", source: "raster"});
+  // Frame the raster with the WGS84 footprint gdalinfo reported.
+  const info = await fetch({{.Info}}).then((r) => r.json()).catch(() => null);
+  const ring = info && info.wgs84Extent && info.wgs84Extent.coordinates[0];
+  if (ring) {
+    const xs = ring.map((p) => p[0]), ys = ring.map((p) => p[1]);
+    map.fitBounds([[Math.min(...xs), Math.min(...ys)], [Math.max(...xs), Math.max(...ys)]],
+      {padding: {{.Padding}}, maxZoom: {{.MaxZoom}}, animate: false});
+  }
+});
+</script>
+</body>
+</html>
+`))
+
+type rasterViewer struct {
+	Title            string
+	Version          string
+	Style            interface{}
+	Center           []float64
+	Zoom             float64
+	Tiles            string
+	MinZoom, MaxZoom int
+	Info             string
+	COG              string
+	Padding          int
+}
+
+// RasterViewerHTML renders a MapLibre page for a z/x/y tile pyramid in
+// tileDir, fitted to the wgs84Extent of gdalinfo.json. cog, when set, is
+// linked for download.
+func RasterViewerHTML(spec *Spec, tileDir string, minZoom, maxZoom int, cog string) (string, error) {
+	v := rasterViewer{
+		Title:   spec.Title,
+		Version: maplibreVersion,
+		Style:   baseStyle(spec.Render.BaseStyle),
+		Center:  []float64{0, 0},
+		Zoom:    float64(minZoom),
+		Tiles:   "./" + tileDir + "/{z}/{x}/{y}.png",
+		MinZoom: minZoom,
+		MaxZoom: maxZoom,
+		Info:    "./gdalinfo.json",
+		COG:     cog,
+		Padding: fitBoundsPadding,
+	}
+	if v.Title == "" {
+		v.Title = spec.Kind
+	}
+	if vp := spec.Render.Viewport; vp != nil && len(vp.Center) == 2 {
+		v.Center, v.Zoom = vp.Center, vp.Zoom
+	}
+	var b strings.Builder
+	if err := rasterViewerTemplate.Execute(&b, v); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+var pointcloudViewerTemplate = template.Must(template.New("index.html").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<link rel="stylesheet" href="https://unpkg.com/cesium@{{.Version}}/Build/Cesium/Widgets/widgets.css">
+<script>window.CESIUM_BASE_URL = "https://unpkg.com/cesium@{{.Version}}/Build/Cesium/";</script>
+<script src="https://unpkg.com/cesium@{{.Version}}/Build/Cesium/Cesium.js"></script>
+<style>html,body,#viewer{margin:0;height:100%;width:100%}</style>
+</head>
+<body>
+<div id="viewer"></div>
+<script>
+const viewer = new Cesium.Viewer("viewer", {
+  baseLayer: new Cesium.ImageryLayer(new Cesium.UrlTemplateImageryProvider({url: {{.Imagery}}})),
+  baseLayerPicker: false,
+  geocoder: false,
+  animation: false,
+  timeline: false,
+});
+(async () => {
+  const tileset = await Cesium.Cesium3DTileset.fromUrl({{.Tileset}});
+  viewer.scene.primitives.add(tileset);
+  // position.json holds the centre of the pdal bounds in WGS84.
+  const pos = await fetch({{.Position}}).then((r) => r.json()).catch(() => null);
+  if (pos) {
+    const center = Cesium.Cartesian3.fromDegrees(pos.longitude, pos.latitude, pos.height);
+    viewer.camera.flyToBoundingSphere(new Cesium.BoundingSphere(center, pos.radius), {duration: 0});
+  } else {
+    viewer.zoomTo(tileset);
+  }
+})();
+</script>
+</body>
+</html>
+`))
+
+type pointcloudViewer struct {
+	Title    string
+	Version  string
+	Imagery  string
+	Tileset  string
+	Position string
+}
+
+// PointcloudViewerHTML renders a Cesium page for the 3D Tiles tileset at
+// tileset, positioned from position.json.
+func PointcloudViewerHTML(spec *Spec, tileset string) (string, error) {
+	v := pointcloudViewer{
+		Title:    spec.Title,
+		Version:  cesiumVersion,
+		Imagery:  defaultImagery,
+		Tileset:  tileset,
+		Position: "./position.json",
+	}
+	if v.Title == "" {
+		v.Title = spec.Kind
+	}
+	if bs := spec.Render.BaseStyle; bs != nil && bs.Type == "raster" && len(bs.Tiles) > 0 {
+		v.Imagery = expandSubdomains(bs.Tiles)[0]
+	}
+	var b strings.Builder
+	if err := pointcloudViewerTemplate.Execute(&b, v); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// baseStyle returns a MapLibre style URL or inline style for the Work's
+// base map.
+func baseStyle(bs *BaseStyle) interface{} {
+	if bs == nil || bs.Type != "raster" || len(bs.Tiles) == 0 {
+		return defaultStyleURL
+	}
+	tileSize := bs.TileSize
+	if tileSize <= 0 {
+		tileSize = defaultTileSize
+	}
+	return map[string]interface{}{
+		"version": 8,
+		"sources": map[string]interface{}{
+			"base": map[string]interface{}{
+				"type":     "raster",
+				"tiles":    expandSubdomains(bs.Tiles),
+				"tileSize": tileSize,
+			},
+		},
+		"layers": []interface{}{
+			map[string]interface{}{"id": "base", "type": "raster", "source": "base"},
+		},
+	}
+}
+
+// expandSubdomains rewrites Leaflet-style {s} and {a,b,c} templates, which
+// MapLibre does not understand, into one URL per subdomain.
+func expandSubdomains(tiles []string) []string {
+	var out []string
+	for _, t := range tiles {
+		if m := subdomainList.FindStringIndex(t); m != nil {
+			for _, s := range strings.Split(t[m[0]+1:m[1]-1], ",") {
+				out = append(out, t[:m[0]]+strings.TrimSpace(s)+t[m[1]:])
+			}
+			continue
+		}
+		if !strings.Contains(t, "{s}") {
+			out = append(out, t)
+			continue
+		}
+		for _, s := range []string{"a", "b", "c"} {
+			out = append(out, strings.ReplaceAll(t, "{s}", s))
+		}
+	}
+	return out
+}